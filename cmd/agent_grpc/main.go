@@ -3,15 +3,18 @@ package main
 
 import (
 	"context"
-	"log"
+	stdlog "log"
 	"os"
 	"os/signal"
 	"syscall"
 	"text/template"
 	"time"
 
+	"go.uber.org/zap"
+
 	conf "github.com/vllvll/devops/internal/config"
 	"github.com/vllvll/devops/internal/dictionaries"
+	"github.com/vllvll/devops/internal/logger"
 	"github.com/vllvll/devops/internal/repositories"
 	"github.com/vllvll/devops/internal/services"
 	"github.com/vllvll/devops/internal/types"
@@ -37,14 +40,24 @@ func main() {
 		"commit":  buildCommit,
 	})
 	if err != nil {
-		log.Fatalf("Error with config: %v", err)
+		stdlog.Fatalf("Error with config: %v", err)
 	}
 
 	config, err := conf.CreateAgentConfig()
 	if err != nil {
-		log.Fatalf("Error with config: %v", err)
+		stdlog.Fatalf("Error with config: %v", err)
 	}
 
+	zapLogger, err := logger.New(config.LogLevel, config.LogFormat, config.LogSampling)
+	if err != nil {
+		stdlog.Fatalf("Error with logger: %v", err)
+	}
+	zapLogger = zapLogger.With(zap.String("agent_id", config.AgentID))
+	defer zapLogger.Sync()
+
+	zap.ReplaceGlobals(zapLogger)
+	log := zapLogger.Sugar()
+
 	var pollCount types.Counter
 
 	crypt, err := services.NewMetricEncrypt(config.CryptoKey)
@@ -59,6 +72,7 @@ func main() {
 	}
 	constants := dictionaries.NewMemConstants()
 	memRepository := repositories.NewMemRepository(constants)
+	statsDRepository := repositories.NewStatsDRepository(config.StatsDAddress)
 
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
 	defer stop()
@@ -75,11 +89,19 @@ func main() {
 	go sender.Prepare(ctx, gaugesCh, counterCh, metricCh, errCh)
 	go sender.Send(ctx, metricCh, reportTick, errCh)
 
+	if config.StatsDAddress != "" {
+		go statsDRepository.Listen(ctx, errCh)
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
 			close(gaugesCh)
 			close(counterCh)
+
+			if config.StatsDAddress != "" {
+				<-statsDRepository.Done()
+			}
 			close(errCh)
 
 			return
@@ -95,8 +117,12 @@ func main() {
 
 			pollCount = 0
 
-		case <-errCh:
-			log.Printf("Error: %v\n", err)
+			if config.StatsDAddress != "" {
+				statsDRepository.Flush(ctx, gaugesCh, counterCh)
+			}
+
+		case err := <-errCh:
+			log.Errorf("Error: %v", err)
 		}
 	}
 }
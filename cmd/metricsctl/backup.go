@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/vllvll/devops/internal/backup"
+)
+
+// runBackup Резервное копирование всех метрик в архив output
+func runBackup(conn connection, output string) error {
+	source, name, closeStore, err := conn.open()
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	if output == "-" {
+		count, err := backup.Backup(os.Stdout, source, name)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, "backed up %d metrics from %s\n", count, name)
+
+		return nil
+	}
+
+	// Запись во временный файл и переименование, чтобы не оставить неполный архив при ошибке
+	file, err := os.CreateTemp(filepath.Dir(output), filepath.Base(output)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	count, err := backup.Backup(file, source, name)
+	if err != nil {
+		file.Close()
+
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(file.Name(), output); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "backed up %d metrics from %s to %s\n", count, name, output)
+
+	return nil
+}
+
+// runRestore Восстановление метрик из архива input
+func runRestore(conn connection, input string, mode string) error {
+	target, name, closeStore, err := conn.open()
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	var reader io.Reader = os.Stdin
+	if input != "-" {
+		file, err := os.Open(input)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		reader = file
+	}
+
+	header, count, err := backup.Restore(reader, target, mode)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "restored %d metrics (%s, created %s from %s) to %s in %s mode\n",
+		count, header.Format, header.CreatedAt.Format("2006-01-02 15:04:05"), header.Source, name, mode)
+
+	return nil
+}
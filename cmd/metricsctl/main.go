@@ -0,0 +1,187 @@
+// Модуль metricsctl управляет данными метрик: чтение и запись метрик запущенного сервера, резервное копирование,
+// восстановление и перенос между хранилищами
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	flag "github.com/spf13/pflag"
+
+	"github.com/vllvll/devops/internal/backup"
+	"github.com/vllvll/devops/internal/client"
+	"github.com/vllvll/devops/internal/repositories"
+	"github.com/vllvll/devops/internal/services"
+	"github.com/vllvll/devops/pkg/postgres"
+)
+
+const usage = `Usage:
+  metricsctl get   (gauge|counter) NAME [--format table|json|csv] --server ADDRESS [--grpc]
+  metricsctl list  [--type gauge|counter] [--pattern REGEXP] [--format table|json|csv] --server ADDRESS [--grpc]
+  metricsctl push  (gauge|counter) NAME VALUE --server ADDRESS [--grpc]
+  metricsctl watch (gauge|counter) NAME [--interval 1s] [--format table|json|csv] --server ADDRESS [--grpc]
+  metricsctl backup  [--output FILE] (--database-dsn DSN | --server ADDRESS [--tenant TENANT]...)
+  metricsctl restore [--input FILE] [--mode merge|replace] (--database-dsn DSN | --server ADDRESS)
+  metricsctl migrate (--from-file FILE | --from-dsn DSN) (--to-file FILE | --to-dsn DSN) [--dry-run]
+
+Query flags:
+      --format string         Output format: table, json or csv (default "table")
+      --type string           Only list metrics of this type
+      --pattern string        Only list metrics with names matching this regular expression
+      --interval duration     Watch polling interval (default 1s)
+
+Archive flags:
+  -o, --output string         Backup archive, "-" for stdout (default "-")
+  -i, --input string          Backup archive, "-" for stdin (default "-")
+      --mode string           Counter restore mode: merge adds values, replace overwrites them (default "merge")
+
+Migration flags:
+      --from-file string      Server store file to read metrics from
+      --from-dsn string       Database dsn to read metrics from
+      --to-file string        Server store file to write metrics to
+      --to-dsn string         Database dsn to write metrics to
+      --dry-run               Only report what would change in the target
+
+Connection flags (or DATABASE_DSN / ADDRESS / TOKEN / KEY / CRYPTO_KEY environment variables):
+  -d, --database-dsn string   Database dsn
+  -s, --server string         Running server address. Format: ip:port or URL
+      --grpc                  Connect to the gRPC server instead of HTTP (without --crypto-key)
+      --token string          Access token or admin key (replace mode needs admin rights)
+  -k, --key string            Metric signing key
+  -y, --crypto-key string     Server public key
+      --tenant strings        Tenants to back up from the server, the first one is used by other commands
+                              (default: default tenant)
+      --batch-size int        Metrics per request to the server (default 500, 1 with --crypto-key)
+`
+
+// store Хранилище метрик, из которого можно сделать резервную копию и в которое можно ее восстановить
+type store interface {
+	backup.Source
+	backup.Target
+}
+
+// connection Параметры подключения к хранилищу метрик
+type connection struct {
+	dsn       string
+	server    string
+	grpc      bool
+	token     string
+	key       string
+	cryptoKey string
+	tenants   []string
+	batchSize int
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var conn connection
+
+	flags := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	flags.StringVarP(&conn.dsn, "database-dsn", "d", os.Getenv("DATABASE_DSN"), "Database dsn")
+	flags.StringVarP(&conn.server, "server", "s", os.Getenv("ADDRESS"), "Running server address")
+	flags.BoolVar(&conn.grpc, "grpc", false, "Connect to the gRPC server")
+	flags.StringVar(&conn.token, "token", os.Getenv("TOKEN"), "Access token or admin key")
+	flags.StringVarP(&conn.key, "key", "k", os.Getenv("KEY"), "Metric signing key")
+	flags.StringVarP(&conn.cryptoKey, "crypto-key", "y", os.Getenv("CRYPTO_KEY"), "Server public key")
+	flags.StringSliceVar(&conn.tenants, "tenant", nil, "Tenants to back up from the server")
+	flags.IntVar(&conn.batchSize, "batch-size", 500, "Metrics per request to the server")
+	output := flags.StringP("output", "o", "-", "Backup archive")
+	input := flags.StringP("input", "i", "-", "Backup archive")
+	mode := flags.String("mode", backup.ModeMerge, "Counter restore mode")
+	format := flags.String("format", FormatTable, "Output format")
+	mType := flags.String("type", "", "Only list metrics of this type")
+	pattern := flags.String("pattern", "", "Only list metrics with names matching this regular expression")
+	interval := flags.Duration("interval", time.Second, "Watch polling interval")
+
+	var from, to backend
+	flags.StringVar(&from.file, "from-file", "", "Server store file to read metrics from")
+	flags.StringVar(&from.dsn, "from-dsn", "", "Database dsn to read metrics from")
+	flags.StringVar(&to.file, "to-file", "", "Server store file to write metrics to")
+	flags.StringVar(&to.dsn, "to-dsn", "", "Database dsn to write metrics to")
+	dryRun := flags.Bool("dry-run", false, "Only report what would change in the target")
+
+	if err := flags.Parse(os.Args[2:]); err != nil {
+		log.Fatal(err)
+	}
+
+	out, err := newPrinter(os.Stdout, *format)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch os.Args[1] {
+	case "get":
+		err = runGet(conn, out, flags.Args())
+	case "list":
+		err = runList(conn, out, *mType, *pattern)
+	case "push":
+		err = runPush(conn, flags.Args())
+	case "watch":
+		err = runWatch(conn, out, flags.Args(), *interval)
+	case "backup":
+		err = runBackup(conn, *output)
+	case "restore":
+		err = runRestore(conn, *input, *mode)
+	case "migrate":
+		err = runMigrate(from, to, *dryRun)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// open Подключение к бд или к запущенному серверу. Возвращает хранилище, его название и функцию закрытия
+func (c connection) open() (store, string, func(), error) {
+	switch {
+	case c.dsn != "" && c.server != "":
+		return nil, "", nil, fmt.Errorf("only one of --database-dsn and --server is allowed")
+	case c.dsn != "":
+		db, err := postgres.ConnectDatabase(c.dsn)
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		if err := db.Ping(); err != nil {
+			db.Close()
+
+			return nil, "", nil, fmt.Errorf("database: %w", err)
+		}
+
+		return backup.NewRepositoryStore(repositories.NewTenantDatabaseRepository(db)), "postgres", closer(db), nil
+	case c.server != "":
+		encrypt, err := services.NewMetricEncrypt(c.cryptoKey)
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		batchSize := c.batchSize
+		if encrypt != nil {
+			// Размер данных для шифрования RSA ограничен размером ключа
+			batchSize = 1
+		}
+
+		api := client.New(c.server, c.token, services.NewMetricSigner(c.key), encrypt)
+
+		return backup.NewAPIStore(api, c.tenants, batchSize), "server " + c.server, func() {}, nil
+	}
+
+	return nil, "", nil, fmt.Errorf("--database-dsn or --server is required")
+}
+
+// closer Функция закрытия подключения к бд
+func closer(db *sql.DB) func() {
+	return func() {
+		db.Close()
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/vllvll/devops/internal/client"
+	"github.com/vllvll/devops/internal/dictionaries"
+	"github.com/vllvll/devops/internal/services"
+	"github.com/vllvll/devops/internal/types"
+)
+
+// runGet Вывод значения метрики. Аргументы: тип и имя метрики
+func runGet(conn connection, out *printer, args []string) error {
+	mType, id, err := metricArgs(args, 2)
+	if err != nil {
+		return err
+	}
+
+	api, closeAPI, err := conn.api()
+	if err != nil {
+		return err
+	}
+	defer closeAPI()
+
+	metric, err := api.Metric(conn.tenant(), mType, id)
+	if err != nil {
+		return err
+	}
+
+	return out.print([]types.Metrics{metric})
+}
+
+// runList Вывод метрик, отфильтрованных по типу и регулярному выражению для имени
+func runList(conn connection, out *printer, mType string, pattern string) error {
+	if mType != "" && mType != dictionaries.GaugeType && mType != dictionaries.CounterType {
+		return fmt.Errorf("unknown metric type %q", mType)
+	}
+
+	api, closeAPI, err := conn.api()
+	if err != nil {
+		return err
+	}
+	defer closeAPI()
+
+	metrics, err := api.List(conn.tenant(), mType, pattern)
+	if err != nil {
+		return err
+	}
+
+	return out.print(metrics)
+}
+
+// runPush Запись метрики. Аргументы: тип, имя и значение метрики. Значение Counter прибавляется к текущему
+func runPush(conn connection, args []string) error {
+	mType, id, err := metricArgs(args, 3)
+	if err != nil {
+		return err
+	}
+
+	metric := types.Metrics{ID: id, MType: mType}
+
+	switch mType {
+	case dictionaries.GaugeType:
+		value, err := strconv.ParseFloat(args[2], 64)
+		if err != nil {
+			return fmt.Errorf("invalid gauge value %q", args[2])
+		}
+
+		metric.Value = &value
+	case dictionaries.CounterType:
+		delta, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid counter value %q", args[2])
+		}
+
+		metric.Delta = &delta
+	}
+
+	api, closeAPI, err := conn.api()
+	if err != nil {
+		return err
+	}
+	defer closeAPI()
+
+	if err := api.Push(conn.tenant(), []types.Metrics{metric}); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "pushed %s %s = %s\n", mType, id, args[2])
+
+	return nil
+}
+
+// runWatch Вывод значения метрики при каждом изменении до прерывания. Аргументы: тип и имя метрики
+func runWatch(conn connection, out *printer, args []string, interval time.Duration) error {
+	mType, id, err := metricArgs(args, 2)
+	if err != nil {
+		return err
+	}
+
+	if interval <= 0 {
+		return fmt.Errorf("interval must be positive")
+	}
+
+	api, closeAPI, err := conn.api()
+	if err != nil {
+		return err
+	}
+	defer closeAPI()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last string
+
+	for {
+		metric, err := api.Metric(conn.tenant(), mType, id)
+		if err != nil {
+			return err
+		}
+
+		if value := formatValue(metric); value != last {
+			if err := out.printAt(time.Now(), metric); err != nil {
+				return err
+			}
+
+			last = value
+		}
+
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// metricArgs Проверка количества аргументов и типа метрики. Возвращает тип и имя метрики
+func metricArgs(args []string, count int) (string, string, error) {
+	if len(args) != count {
+		return "", "", fmt.Errorf("expected %d arguments, got %d", count, len(args))
+	}
+
+	if args[0] != dictionaries.GaugeType && args[0] != dictionaries.CounterType {
+		return "", "", fmt.Errorf("unknown metric type %q", args[0])
+	}
+
+	return args[0], args[1], nil
+}
+
+// api Клиент запущенного сервера: HTTP или gRPC. Возвращает клиент и функцию закрытия
+func (c connection) api() (client.API, func(), error) {
+	if c.server == "" {
+		return nil, nil, fmt.Errorf("--server is required")
+	}
+
+	signer := services.NewMetricSigner(c.key)
+
+	if c.grpc {
+		if c.cryptoKey != "" {
+			return nil, nil, fmt.Errorf("--crypto-key is not supported with --grpc")
+		}
+
+		api, err := client.NewGRPC(c.server, c.token, signer)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return api, func() { api.Close() }, nil
+	}
+
+	encrypt, err := services.NewMetricEncrypt(c.cryptoKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return client.New(c.server, c.token, signer, encrypt), func() {}, nil
+}
+
+// tenant Арендатор для запросов к серверу: первый из --tenant или арендатор по умолчанию
+func (c connection) tenant() string {
+	if len(c.tenants) > 0 {
+		return c.tenants[0]
+	}
+
+	return ""
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/vllvll/devops/internal/backup"
+	"github.com/vllvll/devops/internal/migrate"
+	"github.com/vllvll/devops/internal/repositories"
+	"github.com/vllvll/devops/pkg/postgres"
+)
+
+// backend Хранилище сервера, участвующее в переносе: файл или бд
+type backend struct {
+	file string
+	dsn  string
+}
+
+// runMigrate Перенос метрик из хранилища from в хранилище to с проверкой результата
+func runMigrate(from backend, to backend, dryRun bool) error {
+	if from == to {
+		return fmt.Errorf("source and target are the same")
+	}
+
+	source, sourceName, closeSource, err := from.open()
+	if err != nil {
+		return fmt.Errorf("source: %w", err)
+	}
+	defer closeSource()
+
+	target, targetName, closeTarget, err := to.open()
+	if err != nil {
+		return fmt.Errorf("target: %w", err)
+	}
+	defer closeTarget()
+
+	plan, err := migrate.Migrate(source, target, dryRun)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "%s -> %s: %d tenants, %d gauges, %d counters (created %d, changed %d, unchanged %d)\n",
+		sourceName, targetName, plan.Source.Tenants, plan.Source.Gauges, plan.Source.Counters,
+		plan.Created, plan.Changed, plan.Unchanged)
+
+	if dryRun {
+		fmt.Fprintln(os.Stderr, "dry run: target is not changed")
+
+		return nil
+	}
+
+	// Файл проверяется после повторного чтения с диска, а не по копии в памяти
+	written := backup.Source(target)
+	if file, ok := target.(*migrate.FileStore); ok {
+		if err := file.Flush(); err != nil {
+			return err
+		}
+
+		written, err = migrate.OpenFile(to.file)
+		if err != nil {
+			return fmt.Errorf("target: %w", err)
+		}
+	}
+
+	summary, err := migrate.Verify(source, written)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "verified: gauge sum %g, counter sum %d\n", summary.GaugeSum, summary.CounterSum)
+
+	return nil
+}
+
+// open Открытие файла или подключение к бд. Возвращает хранилище, его название и функцию закрытия
+func (b backend) open() (migrate.Store, string, func(), error) {
+	switch {
+	case b.file != "" && b.dsn != "":
+		return nil, "", nil, fmt.Errorf("only one of file and database dsn is allowed")
+	case b.file != "":
+		store, err := migrate.OpenFile(b.file)
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		return store, "file " + b.file, func() {}, nil
+	case b.dsn != "":
+		db, err := postgres.ConnectDatabase(b.dsn)
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		if err := db.Ping(); err != nil {
+			db.Close()
+
+			return nil, "", nil, fmt.Errorf("database: %w", err)
+		}
+
+		return backup.NewRepositoryStore(repositories.NewTenantDatabaseRepository(db)), "postgres", closer(db), nil
+	}
+
+	return nil, "", nil, fmt.Errorf("file or database dsn is required")
+}
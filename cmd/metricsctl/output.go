@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/vllvll/devops/internal/types"
+)
+
+// Форматы вывода метрик
+const (
+	FormatTable = "table"
+	FormatJSON  = "json"
+	FormatCSV   = "csv"
+)
+
+// printer Вывод метрик в формате table, json или csv. Для watch перед метрикой выводится время получения
+type printer struct {
+	w      io.Writer
+	format string
+	header bool // Заголовок таблицы уже выведен
+}
+
+// newPrinter Создание сервиса для вывода метрик в w
+func newPrinter(w io.Writer, format string) (*printer, error) {
+	switch format {
+	case FormatTable, FormatJSON, FormatCSV:
+		return &printer{w: w, format: format}, nil
+	}
+
+	return nil, fmt.Errorf("unknown format %q, expected %s, %s or %s", format, FormatTable, FormatJSON, FormatCSV)
+}
+
+// print Вывод списка метрик
+func (p *printer) print(metrics []types.Metrics) error {
+	if p.format == FormatJSON {
+		rows := make([]types.Metrics, 0, len(metrics))
+		for _, metric := range metrics {
+			metric.Hash = ""
+			rows = append(rows, metric)
+		}
+
+		encoder := json.NewEncoder(p.w)
+		encoder.SetIndent("", "  ")
+
+		return encoder.Encode(rows)
+	}
+
+	rows := [][]string{{"ID", "TYPE", "VALUE"}}
+	for _, metric := range metrics {
+		rows = append(rows, []string{metric.ID, metric.MType, formatValue(metric)})
+	}
+
+	return p.write(rows)
+}
+
+// printAt Вывод значения метрики, полученного в момент at. JSON выводится построчно
+func (p *printer) printAt(at time.Time, metric types.Metrics) error {
+	if p.format == FormatJSON {
+		metric.Hash = ""
+
+		return json.NewEncoder(p.w).Encode(struct {
+			Time time.Time `json:"time"`
+			types.Metrics
+		}{at, metric})
+	}
+
+	var rows [][]string
+	if !p.header {
+		rows = append(rows, []string{"TIME", "ID", "TYPE", "VALUE"})
+		p.header = true
+	}
+
+	rows = append(rows, []string{at.Format(time.RFC3339), metric.ID, metric.MType, formatValue(metric)})
+
+	return p.write(rows)
+}
+
+// write Вывод строк таблицы или CSV
+func (p *printer) write(rows [][]string) error {
+	if p.format == FormatCSV {
+		writer := csv.NewWriter(p.w)
+		writer.WriteAll(rows)
+
+		return writer.Error()
+	}
+
+	writer := tabwriter.NewWriter(p.w, 0, 0, 2, ' ', 0)
+	for _, row := range rows {
+		for i, column := range row {
+			if i > 0 {
+				fmt.Fprint(writer, "\t")
+			}
+			fmt.Fprint(writer, column)
+		}
+		fmt.Fprintln(writer)
+	}
+
+	return writer.Flush()
+}
+
+// formatValue Значение метрики в виде строки
+func formatValue(metric types.Metrics) string {
+	switch {
+	case metric.Value != nil:
+		return strconv.FormatFloat(*metric.Value, 'f', -1, 64)
+	case metric.Delta != nil:
+		return strconv.FormatInt(*metric.Delta, 10)
+	}
+
+	return ""
+}
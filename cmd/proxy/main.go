@@ -0,0 +1,125 @@
+// Модуль proxy распределяет метрики между несколькими серверами согласованным хешированием имени серии
+package main
+
+import (
+	"context"
+	stdlog "log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/template"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+
+	conf "github.com/vllvll/devops/internal/config"
+	"github.com/vllvll/devops/internal/logger"
+	"github.com/vllvll/devops/internal/proxy"
+	"github.com/vllvll/devops/internal/services"
+	pb "github.com/vllvll/devops/proto"
+)
+
+var (
+	buildVersion = "N/A"
+	buildDate    = "N/A"
+	buildCommit  = "N/A"
+)
+
+const BuildTemplate = `
+Build version: {{ .version }}
+Build date: {{ .date }}
+Build commit: {{ .commit }}
+`
+
+func main() {
+	t := template.Must(template.New("build").Parse(BuildTemplate))
+	err := t.Execute(os.Stdout, map[string]string{
+		"version": buildVersion,
+		"date":    buildDate,
+		"commit":  buildCommit,
+	})
+	if err != nil {
+		stdlog.Fatalf("Error with config: %v", err)
+	}
+
+	config, err := conf.CreateProxyConfig()
+	if err != nil {
+		stdlog.Fatalf("Error with config: %v", err)
+	}
+
+	zapLogger, err := logger.New(config.LogLevel, config.LogFormat, config.LogSampling)
+	if err != nil {
+		stdlog.Fatalf("Error with logger: %v", err)
+	}
+	defer zapLogger.Sync()
+
+	zap.ReplaceGlobals(zapLogger)
+	log := zapLogger.Sugar()
+
+	if len(config.Backends) == 0 {
+		log.Fatalf("Error with backends: at least one backend is required")
+	}
+
+	decrypt, err := services.NewMetricDecrypt(config.CryptoKey)
+	if err != nil {
+		log.Fatalf("Ошибка с инициализацией сервиса шифрования: %v", err)
+	}
+
+	pool := proxy.NewPool(config.Backends, config.Replicas)
+
+	// Недоступные серверы исключаются из кольца, их серии записываются на следующие по кольцу серверы
+	healthCtx, stopHealth := context.WithCancel(context.Background())
+	defer stopHealth()
+
+	if config.HealthInterval > 0 {
+		go pool.Run(healthCtx, config.HealthInterval)
+	}
+
+	p := proxy.NewProxy(pool, services.NewMetricSigner(config.Key), decrypt)
+
+	httpServer := &http.Server{
+		Addr:    config.Address,
+		Handler: proxy.NewRouter(p, zapLogger),
+	}
+
+	go func() {
+		if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatalf("Error with HTTP server ListenAndServe: %v", err)
+		}
+	}()
+
+	var grpcServer *grpc.Server
+	if config.GRPCAddress != "" {
+		grpcServer = grpc.NewServer()
+		pb.RegisterMetricsServer(grpcServer, proxy.NewGRPCServer(p))
+
+		go func() {
+			listen, err := net.Listen("tcp", config.GRPCAddress)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			if err := grpcServer.Serve(listen); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
+	<-c
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := httpServer.Shutdown(ctx); err != nil {
+		log.Error(err)
+	}
+
+	if grpcServer != nil {
+		grpcServer.GracefulStop()
+	}
+}
@@ -3,7 +3,7 @@ package main
 
 import (
 	"context"
-	"log"
+	stdlog "log"
 	"net/http"
 	"os"
 	"os/signal"
@@ -11,13 +11,26 @@ import (
 	"text/template"
 	"time"
 
+	"go.uber.org/zap"
+
+	"github.com/vllvll/devops/internal/auth"
+	"github.com/vllvll/devops/internal/batch"
 	conf "github.com/vllvll/devops/internal/config"
+	"github.com/vllvll/devops/internal/federation"
 	"github.com/vllvll/devops/internal/handlers"
+	"github.com/vllvll/devops/internal/health"
+	"github.com/vllvll/devops/internal/leader"
+	"github.com/vllvll/devops/internal/limits"
+	"github.com/vllvll/devops/internal/logger"
 	"github.com/vllvll/devops/internal/repositories"
 	"github.com/vllvll/devops/internal/routes"
+	"github.com/vllvll/devops/internal/rules"
 	"github.com/vllvll/devops/internal/services"
 	"github.com/vllvll/devops/internal/storage"
 	"github.com/vllvll/devops/internal/storage/file"
+	"github.com/vllvll/devops/internal/telemetry"
+	"github.com/vllvll/devops/internal/tenant"
+	"github.com/vllvll/devops/internal/validation"
 	"github.com/vllvll/devops/pkg/postgres"
 )
 
@@ -41,13 +54,22 @@ func main() {
 		"commit":  buildCommit,
 	})
 	if err != nil {
-		log.Fatalf("Error with config: %v", err)
+		stdlog.Fatalf("Error with config: %v", err)
 	}
 
 	config, err := conf.CreateServerConfig()
 	if err != nil {
-		log.Fatalf("Error with config: %v", err)
+		stdlog.Fatalf("Error with config: %v", err)
+	}
+
+	zapLogger, err := logger.New(config.LogLevel, config.LogFormat, config.LogSampling)
+	if err != nil {
+		stdlog.Fatalf("Error with logger: %v", err)
 	}
+	defer zapLogger.Sync()
+
+	zap.ReplaceGlobals(zapLogger)
+	log := zapLogger.Sugar()
 
 	db, err := postgres.ConnectDatabase(config.DatabaseDsn)
 	if err != nil {
@@ -55,20 +77,59 @@ func main() {
 	}
 	defer db.Close()
 
-	statsRepository := repositories.NewStatsDatabaseRepository(db)
-	if config.DatabaseDsn == "" {
-		statsRepository = repositories.NewStatsMemoryRepository()
-	}
+	registry := telemetry.NewRegistry()
 
-	decrypt, err := services.NewMetricDecrypt(config.CryptoKey)
-	if err != nil {
-		log.Fatalf("Ошибка с инициализацией сервиса шифрования: %v", err)
+	var tenantRepository repositories.TenantRepository = repositories.NewTenantMemoryRepository()
+	if config.DatabaseDsn != "" {
+		databaseRepository := repositories.NewTenantDatabaseRepository(db)
+		tenantRepository = databaseRepository
+
+		// Изменения накапливаются в памяти и записываются в бд одним пакетом
+		if config.WriteInterval > 0 {
+			writeBuffer, err := repositories.NewTenantBufferedRepository(databaseRepository, config.WriteInterval, config.WriteSize, config.WriteMaxPending, config.WriteDurability)
+			if err != nil {
+				log.Fatalf("Error with write buffer: %v", err)
+			}
+			defer writeBuffer.Close()
+
+			tenantRepository = writeBuffer
+		}
+
+		// Чтение из копии в памяти, изменения других экземпляров сервера сбрасывают копию
+		if config.Cache {
+			// Оповещение отправляется после ответа буфера, поэтому с async другие экземпляры загрузили бы копию до записи
+			if config.WriteInterval > 0 && config.WriteDurability == repositories.DurabilityAsync {
+				log.Fatalf("Error with cache: write durability %s is not supported, use %s", repositories.DurabilityAsync, repositories.DurabilitySync)
+			}
+
+			notifier := repositories.NewDatabaseNotifier(db)
+			cache := repositories.NewTenantCachedRepository(tenantRepository, notifier, config.CacheTTL)
+
+			// Копия загружается при запуске после подписки на оповещения и заново после каждого переподключения
+			listenCtx, stopListen := context.WithCancel(context.Background())
+			defer stopListen()
+
+			go notifier.Run(listenCtx, cache)
+
+			tenantRepository = cache
+		}
 	}
+	tenantRepository = telemetry.NewTenantRepository(tenantRepository, registry)
 
-	signer := services.NewMetricSigner(config.Key)
-	handler := handlers.NewHandler(statsRepository, signer, db, decrypt)
-	router := routes.NewRouter(*handler, config.TrustedSubnet)
-	router.RegisterHandlers()
+	// Фоновые задачи нескольких экземпляров с общей бд выполняет только ведущий
+	var elector *leader.Elector
+	if config.LeaderElection {
+		if config.DatabaseDsn == "" {
+			log.Fatalf("Error with leader election: database dsn is required")
+		}
+
+		elector = leader.NewElector(leader.NewDatabaseLocker(db, leader.LockKey), config.LeaderInterval)
+
+		electionCtx, stopElection := context.WithCancel(context.Background())
+		defer stopElection()
+
+		go elector.Run(electionCtx)
+	}
 
 	consumer, err := file.NewFileConsumer(config.StoreFile)
 	if err != nil {
@@ -81,15 +142,100 @@ func main() {
 	}
 	defer producer.Close()
 
-	fileStorage := storage.NewStatsStorage(config, consumer, producer)
+	fileStorage := storage.NewStatsStorage(config, consumer, producer, registry)
 
-	defer fileStorage.Save(statsRepository)
+	defer func() {
+		if err := fileStorage.Save(tenantRepository); err != nil {
+			log.Errorf("Error with store file: %v", err)
+		}
+	}()
 
-	statsRepository, err = fileStorage.Start(statsRepository)
+	tenantRepository, err = fileStorage.Start(tenantRepository)
 	if err != nil {
 		log.Fatalf("Error with file file storage: %v", err)
 	}
 
+	decrypt, err := services.NewMetricDecrypt(config.CryptoKey)
+	if err != nil {
+		log.Fatalf("Ошибка с инициализацией сервиса шифрования: %v", err)
+	}
+
+	tiers, err := repositories.ParseTiers(config.HistoryTiers, config.HistoryRetention)
+	if err != nil {
+		log.Fatalf("Error with history tiers: %v", err)
+	}
+
+	historyRepository := repositories.NewTenantHistoryDatabaseRepository(db, tiers)
+	if config.DatabaseDsn == "" {
+		historyRepository = repositories.NewTenantHistoryRepository(tiers)
+	}
+
+	historyStorage := storage.NewHistoryStorage(config.HistoryFile)
+	if err := historyStorage.Restore(historyRepository); err != nil {
+		log.Fatalf("Error with history file: %v", err)
+	}
+
+	// Последние накопленные значения счетчиков агентов, которые присылают их вместо приращений
+	totalsRepository := repositories.NewTenantTotalsDatabaseRepository(db)
+	if config.DatabaseDsn == "" {
+		totalsRepository = repositories.NewTenantTotalsRepository()
+	}
+
+	totalsStorage := storage.NewTotalsStorage(config.TotalsFile)
+	if err := totalsStorage.Restore(totalsRepository); err != nil {
+		log.Fatalf("Error with totals file: %v", err)
+	}
+
+	// Вычисляемые метрики: результаты правил записываются в хранилище по расписанию и при записи исходных метрик
+	evaluator, err := rules.NewFromConfig(config, tenantRepository, historyRepository, elector)
+	if err != nil {
+		log.Fatalf("Error with recording rules: %v", err)
+	}
+
+	if evaluator != nil && config.RulesOnWrite {
+		tenantRepository = rules.NewTenantRepository(tenantRepository, evaluator)
+	}
+
+	limiter, err := limits.NewFromConfig(config, tenantRepository, registry)
+	if err != nil {
+		log.Fatalf("Error with limits: %v", err)
+	}
+
+	signer := services.NewMetricSigner(config.Key)
+	handler := handlers.NewHandler(tenantRepository, signer, db, decrypt, handlers.HandlerOptions{
+		History:   historyRepository,
+		Totals:    totalsRepository,
+		Limits:    limiter,
+		Rules:     validation.NewFromConfig(config),
+		Batches:   batch.NewFromConfig(config, db),
+		Telemetry: registry,
+	})
+
+	audit, err := services.NewAuditLogger(config.AuditFile)
+	if err != nil {
+		log.Fatalf("Error with audit log: %v", err)
+	}
+	defer audit.Close()
+
+	var tokens auth.Store
+	if config.Auth {
+		if config.TokensFile == "" && config.DatabaseDsn == "" {
+			log.Fatalf("Error with tokens store: tokens file or database dsn is required")
+		}
+
+		tokens, err = auth.NewStore(config.TokensFile, db)
+		if err != nil {
+			log.Fatalf("Error with tokens store: %v", err)
+		}
+	}
+
+	adminHandler := handlers.NewAdminHandler(services.NewMetricAdmin(tenantRepository, audit, limiter))
+	healthHandler := handlers.NewHealthHandler(health.NewServerChecker(config, db, fileStorage.LastSave, elector))
+	router := routes.NewRouter(*handler, *adminHandler, *healthHandler, config.TrustedSubnet, config.AdminKey, tokens, registry, zapLogger)
+	// Запросы, вернувшиеся через петлю пересылки между датацентрами, отклоняются
+	router.Router.Use(federation.RejectLoop(config.FederationDC))
+	router.RegisterHandlers()
+
 	httpServer := &http.Server{
 		Addr:    config.Address,
 		Handler: router.Router,
@@ -101,9 +247,48 @@ func main() {
 		}
 	}()
 
+	if config.TelemetryAddress != "" {
+		go serveTelemetry(config.TelemetryAddress, registry)
+	}
+
+	compactCtx, stopCompact := context.WithCancel(context.Background())
+	defer stopCompact()
+
+	if config.CompactInterval > 0 {
+		go storage.NewCompactor(tenantRepository, historyRepository, elector).Run(compactCtx, config.CompactInterval)
+	}
+
+	// Пересылка метрик на вышестоящий сервер
+	forwarder, err := federation.NewFromConfig(config, tenantRepository, db, elector)
+	if err != nil {
+		log.Fatalf("Error with federation: %v", err)
+	}
+
+	if forwarder != nil {
+		defer forwarder.Close()
+
+		federationCtx, stopFederation := context.WithCancel(context.Background())
+		defer stopFederation()
+
+		go forwarder.Run(federationCtx)
+	}
+
+	if evaluator != nil && config.RulesInterval > 0 {
+		rulesCtx, stopRules := context.WithCancel(context.Background())
+		defer stopRules()
+
+		go evaluator.Run(rulesCtx, config.RulesInterval)
+	}
+
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
 	var storeTick = time.Tick(config.StoreInterval)
+	var historyTick = time.Tick(config.HistoryInterval)
+
+	var telemetryTick <-chan time.Time
+	if config.TelemetrySelf {
+		telemetryTick = time.Tick(config.TelemetryInterval)
+	}
 
 	for {
 		select {
@@ -111,16 +296,75 @@ func main() {
 			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 
 			if err := httpServer.Shutdown(ctx); err != nil {
-				log.Println(err)
+				log.Error(err)
 			}
 
 			cancel()
 
-			fileStorage.Save(statsRepository)
+			if err := fileStorage.Save(tenantRepository); err != nil {
+				log.Errorf("Error with store file: %v", err)
+			}
+
+			if err := historyStorage.Save(historyRepository); err != nil {
+				log.Errorf("Error with history file: %v", err)
+			}
+
+			if err := totalsStorage.Save(totalsRepository); err != nil {
+				log.Errorf("Error with totals file: %v", err)
+			}
 
 			return
 		case <-storeTick:
-			fileStorage.Save(statsRepository)
+			if !elector.IsLeader() {
+				continue
+			}
+
+			if err := fileStorage.Save(tenantRepository); err != nil {
+				log.Errorf("Error with store file: %v", err)
+			}
+
+			if err := historyStorage.Save(historyRepository); err != nil {
+				log.Errorf("Error with history file: %v", err)
+			}
+
+			if err := totalsStorage.Save(totalsRepository); err != nil {
+				log.Errorf("Error with totals file: %v", err)
+			}
+		case at := <-historyTick:
+			if !elector.IsLeader() {
+				continue
+			}
+
+			tenants, err := tenantRepository.Tenants()
+			if err != nil {
+				log.Errorf("Error with history: %v", err)
+
+				continue
+			}
+
+			for _, name := range tenants {
+				gauges, counters := tenantRepository.Tenant(name).GetAll()
+				historyRepository.Tenant(name).Record(at, gauges, counters)
+			}
+		case <-telemetryTick:
+			// Метрики сервера записывает только ведущий, иначе экземпляры перезаписывают значения друг друга
+			if !elector.IsLeader() {
+				continue
+			}
+
+			if err := registry.Export(tenantRepository.Tenant(tenant.Default)); err != nil {
+				log.Errorf("Error with telemetry: %v", err)
+			}
 		}
 	}
 }
+
+// serveTelemetry Запуск внутреннего HTTP-сервера с метриками самого сервера
+func serveTelemetry(address string, registry *telemetry.Registry) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", registry.Handler())
+
+	if err := http.ListenAndServe(address, mux); err != nil {
+		zap.S().Errorf("Error with telemetry server: %v", err)
+	}
+}
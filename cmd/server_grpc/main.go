@@ -2,28 +2,51 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"database/sql"
-	"log"
+	"errors"
+	"fmt"
+	stdlog "log"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"regexp"
+	"sort"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/gofrs/uuid"
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/protobuf/types/known/emptypb"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 
+	"github.com/vllvll/devops/internal/auth"
+	"github.com/vllvll/devops/internal/batch"
 	conf "github.com/vllvll/devops/internal/config"
 	"github.com/vllvll/devops/internal/dictionaries"
+	"github.com/vllvll/devops/internal/federation"
+	"github.com/vllvll/devops/internal/health"
+	"github.com/vllvll/devops/internal/leader"
+	"github.com/vllvll/devops/internal/limits"
+	"github.com/vllvll/devops/internal/logger"
 	"github.com/vllvll/devops/internal/repositories"
+	"github.com/vllvll/devops/internal/rules"
 	"github.com/vllvll/devops/internal/services"
 	"github.com/vllvll/devops/internal/storage"
 	"github.com/vllvll/devops/internal/storage/file"
+	"github.com/vllvll/devops/internal/telemetry"
+	"github.com/vllvll/devops/internal/tenant"
 	"github.com/vllvll/devops/internal/types"
+	"github.com/vllvll/devops/internal/validation"
 	"github.com/vllvll/devops/pkg/postgres"
 
 	// Импортируем пакет со сгенерированными protobuf-файлами
@@ -34,69 +57,617 @@ import (
 type MetricsServer struct {
 	pb.UnimplementedMetricsServer
 
-	repository repositories.StatsRepository // Сервис для чтения и записи данных метрик
-	signer     services.Signer              // Сервис для создания подписи
-	db         *sql.DB                      // База данных
-	decrypt    services.Decrypt             // Сервис для расшифрования данных
+	tenants   repositories.TenantRepository // Сервис для чтения и записи данных метрик арендаторов
+	ingest    services.Ingest               // Сервис записи метрик с проверкой ограничений и повторов
+	signer    services.Signer               // Сервис для создания подписи
+	db        *sql.DB                       // База данных
+	decrypt   services.Decrypt              // Сервис для расшифрования данных
+	rules     *validation.Rules             // Правила проверки метрик
+	telemetry *telemetry.Registry           // Метрики сервера
 }
 
-func (s *MetricsServer) BulkSaveMetrics(_ context.Context, in *pb.AddBulkMetricsRequest) (*emptypb.Empty, error) {
-	var metrics []types.Metrics
+func (s *MetricsServer) BulkSaveMetrics(ctx context.Context, in *pb.AddBulkMetricsRequest) (*emptypb.Empty, error) {
 	var counters = types.Counters{}
 	var gauges = types.Gauges{}
 
-	inBulkMetrics := in.GetMetrics()
-	inMetrics := inBulkMetrics.GetMetrics()
+	metrics := bulkMetrics(in)
 
-	for _, inMetric := range inMetrics {
-		var metricType string
-		switch inMetric.Type {
-		case pb.Metric_COUNTER:
-			metricType = dictionaries.CounterType
-		case pb.Metric_GAUGE:
-			metricType = dictionaries.GaugeType
-		default:
-			return nil, status.Errorf(codes.InvalidArgument, "Metric type does not exist")
-		}
-
-		metrics = append(metrics, types.Metrics{
-			ID:    inMetric.Id,
-			MType: metricType,
-			Delta: inMetric.Delta,
-			Value: inMetric.Value,
-			Hash:  *inMetric.Hash,
-		})
+	if err := s.rules.Batch(metrics); err != nil {
+		return nil, validationStatus(err).Err()
 	}
 
 	for _, metric := range metrics {
+		if !auth.AllowsWrite(ctx, metric.ID) {
+			return nil, status.Error(codes.PermissionDenied, "Metric name is not allowed for token")
+		}
+
+		if telemetry.IsReserved(metric.ID) {
+			return nil, status.Error(codes.PermissionDenied, "Metric name prefix is reserved")
+		}
+
 		switch metric.MType {
 		case dictionaries.GaugeType:
 			if !s.signer.IsEqualHashGauge(metric.ID, *metric.Value, metric.Hash) {
+				s.telemetry.Counter(telemetry.HashFailures, nil).Inc()
 				return nil, status.Error(codes.InvalidArgument, "Hash not equal for gauge type")
 			}
 
 			gauges[metric.ID] = types.Gauge(*metric.Value)
 		case dictionaries.CounterType:
 			if !s.signer.IsEqualHashCounter(metric.ID, *metric.Delta, metric.Hash) {
+				s.telemetry.Counter(telemetry.HashFailures, nil).Inc()
 				return nil, status.Error(codes.InvalidArgument, "Hash not equal for counter type")
 			}
 
-			counters[metric.ID] += types.Counter(*metric.Delta)
+			addCounter(ctx, counters, metric.ID, types.Counter(*metric.Delta))
 		}
 	}
 
-	err := s.repository.UpdateAll(gauges, counters)
+	err := s.save(ctx, in.GetBatchId(), gauges, counters)
+	if errors.Is(err, batch.ErrDuplicate) {
+		grpc.SetHeader(ctx, metadata.Pairs(strings.ToLower(batch.DuplicateHeader), "true"))
+
+		return new(emptypb.Empty), nil
+	}
+
 	if err != nil {
-		return nil, status.Error(codes.Internal, "Can't save metrics")
+		return nil, err
 	}
 
 	return new(emptypb.Empty), nil
 }
 
+// BulkSaveMetricsPartial Сохранение допустимых метрик пакета. Отклоненные метрики перечисляются в ответе, весь пакет
+// отклоняется только при превышении размера или ограничений
+func (s *MetricsServer) BulkSaveMetricsPartial(ctx context.Context, in *pb.AddBulkMetricsRequest) (*pb.BulkSaveMetricsPartialResponse, error) {
+	var counters = types.Counters{}
+	var gauges = types.Gauges{}
+
+	metrics := bulkMetrics(in)
+
+	result, err := s.rules.Partial(metrics)
+	if err != nil {
+		return nil, validationStatus(err).Err()
+	}
+
+	for i, metric := range metrics {
+		if result.Rejects(i) {
+			continue
+		}
+
+		if !auth.AllowsWrite(ctx, metric.ID) {
+			result.Reject(i, metric.ID, validation.FieldID, "metric name is not allowed for token")
+
+			continue
+		}
+
+		if telemetry.IsReserved(metric.ID) {
+			result.Reject(i, metric.ID, validation.FieldID, "metric name prefix is reserved")
+
+			continue
+		}
+
+		switch metric.MType {
+		case dictionaries.GaugeType:
+			if !s.signer.IsEqualHashGauge(metric.ID, *metric.Value, metric.Hash) {
+				s.telemetry.Counter(telemetry.HashFailures, nil).Inc()
+				result.Reject(i, metric.ID, validation.FieldHash, "hash does not match")
+
+				continue
+			}
+
+			gauges[metric.ID] = types.Gauge(*metric.Value)
+		case dictionaries.CounterType:
+			if !s.signer.IsEqualHashCounter(metric.ID, *metric.Delta, metric.Hash) {
+				s.telemetry.Counter(telemetry.HashFailures, nil).Inc()
+				result.Reject(i, metric.ID, validation.FieldHash, "hash does not match")
+
+				continue
+			}
+
+			addCounter(ctx, counters, metric.ID, types.Counter(*metric.Delta))
+		}
+
+		result.Accepted++
+	}
+
+	err = s.save(ctx, in.GetBatchId(), gauges, counters)
+	if errors.Is(err, batch.ErrDuplicate) {
+		return &pb.BulkSaveMetricsPartialResponse{Duplicate: true}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.telemetry.Counter(telemetry.RejectedMetrics, map[string]string{"reason": validation.ReasonInvalid}).Add(result.RejectedCount())
+
+	response := &pb.BulkSaveMetricsPartialResponse{Accepted: int32(result.Accepted)}
+	for _, rejected := range result.Rejected {
+		response.Rejected = append(response.Rejected, &pb.RejectedMetric{
+			Index:  int32(rejected.Index),
+			Id:     rejected.ID,
+			Field:  rejected.Field,
+			Reason: rejected.Reason,
+		})
+	}
+
+	return response, nil
+}
+
+// save Сохранение метрик арендатора через services.Ingest. Для повтора пакета batchID возвращается batch.ErrDuplicate,
+// остальные ошибки - с кодом gRPC
+func (s *MetricsServer) save(ctx context.Context, batchID string, gauges types.Gauges, counters types.Counters) error {
+	err := s.ingest.Save(ctx, services.IngestBatch{
+		Tenant:      tenant.FromContext(ctx),
+		ID:          batchID,
+		Agent:       agentFromContext(ctx),
+		TotalsAgent: totalsAgentFromContext(ctx),
+		CounterMode: counterMode(ctx),
+		Gauges:      gauges,
+		Counters:    counters,
+	})
+
+	switch {
+	case err == nil, errors.Is(err, batch.ErrDuplicate):
+		return err
+	case errors.Is(err, batch.ErrInProgress):
+		return status.Error(codes.Aborted, err.Error())
+	case errors.Is(err, batch.ErrInvalidID):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, services.ErrCounterMode):
+		return status.Errorf(codes.InvalidArgument, "Unsupported counter mode, expected %s", repositories.CounterModeCumulative)
+	case errors.Is(err, services.ErrReservedName):
+		return status.Error(codes.PermissionDenied, "Metric name prefix is reserved")
+	case limits.IsLimitError(err):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, repositories.ErrBufferFull):
+		return status.Error(codes.Unavailable, err.Error())
+	}
+
+	return status.Error(codes.Internal, "Can't save metrics")
+}
+
+// bulkMetrics Преобразование метрик запроса в метрики сервера
+func bulkMetrics(in *pb.AddBulkMetricsRequest) []types.Metrics {
+	inMetrics := in.GetMetrics().GetMetrics()
+	metrics := make([]types.Metrics, 0, len(inMetrics))
+
+	for _, inMetric := range inMetrics {
+		metrics = append(metrics, types.Metrics{
+			ID:    inMetric.GetId(),
+			MType: metricTypeName(inMetric.GetType()),
+			Delta: inMetric.Delta,
+			Value: inMetric.Value,
+			Hash:  inMetric.GetHash(),
+		})
+	}
+
+	return metrics
+}
+
+// GetMetric Получение значения метрики арендатора
+func (s *MetricsServer) GetMetric(ctx context.Context, in *pb.GetMetricRequest) (*pb.Metric, error) {
+	repository := s.tenants.Tenant(tenant.FromContext(ctx))
+
+	switch in.GetType() {
+	case pb.Metric_GAUGE:
+		gauge, err := repository.GetGaugeByKey(in.GetId())
+		if err != nil {
+			return nil, status.Error(codes.NotFound, "Metric not found")
+		}
+
+		return s.gaugeMetric(in.GetId(), gauge), nil
+	case pb.Metric_COUNTER:
+		counter, err := repository.GetCounterByKey(in.GetId())
+		if err != nil {
+			return nil, status.Error(codes.NotFound, "Metric not found")
+		}
+
+		return s.counterMetric(in.GetId(), counter), nil
+	}
+
+	return nil, status.Error(codes.InvalidArgument, "Metric type does not exist")
+}
+
+// ListMetrics Получение метрик арендатора, отсортированных по типу и имени. Метрики можно отфильтровать
+// по типу и регулярному выражению для имени
+func (s *MetricsServer) ListMetrics(ctx context.Context, in *pb.ListMetricsRequest) (*pb.ListMetricsResponse, error) {
+	re, err := regexp.Compile(in.GetPattern())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "Invalid pattern")
+	}
+
+	gauges, counters := s.tenants.Tenant(tenant.FromContext(ctx)).GetAll()
+
+	response := &pb.ListMetricsResponse{}
+
+	if in.GetType() != pb.Metric_COUNTER {
+		keys := make([]string, 0, len(gauges))
+		for key := range gauges {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if re.MatchString(key) {
+				response.Metrics = append(response.Metrics, s.gaugeMetric(key, gauges[key]))
+			}
+		}
+	}
+
+	if in.GetType() != pb.Metric_GAUGE {
+		keys := make([]string, 0, len(counters))
+		for key := range counters {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if re.MatchString(key) {
+				response.Metrics = append(response.Metrics, s.counterMetric(key, counters[key]))
+			}
+		}
+	}
+
+	return response, nil
+}
+
+// gaugeMetric Метрика типа Gauge с подписью сервера
+func (s *MetricsServer) gaugeMetric(key string, gauge types.Gauge) *pb.Metric {
+	value := float64(gauge)
+	hash := s.signer.GetHashGauge(key, value)
+
+	return &pb.Metric{Id: key, Type: pb.Metric_GAUGE, Value: &value, Hash: &hash}
+}
+
+// counterMetric Метрика типа Counter с подписью сервера
+func (s *MetricsServer) counterMetric(key string, counter types.Counter) *pb.Metric {
+	delta := int64(counter)
+	hash := s.signer.GetHashCounter(key, delta)
+
+	return &pb.Metric{Id: key, Type: pb.Metric_COUNTER, Delta: &delta, Hash: &hash}
+}
+
+// AdminServer поддерживает методы удаления и сброса метрик.
+type AdminServer struct {
+	pb.UnimplementedAdminServer
+
+	admin services.Admin // Сервис для удаления и сброса метрик
+}
+
+func (s *AdminServer) DeleteMetric(ctx context.Context, in *pb.DeleteMetricRequest) (*emptypb.Empty, error) {
+	err := s.admin.DeleteMetric(actorFromContext(ctx), tenant.FromContext(ctx), metricTypeName(in.GetType()), in.GetId())
+	if errors.Is(err, services.ErrUnknownMetricType) {
+		return nil, status.Error(codes.InvalidArgument, "Metric type does not exist")
+	}
+
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+
+	return new(emptypb.Empty), nil
+}
+
+func (s *AdminServer) DeleteMetrics(ctx context.Context, in *pb.DeleteMetricsRequest) (*pb.DeleteMetricsResponse, error) {
+	if in.GetPattern() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Missing pattern")
+	}
+
+	deleted, err := s.admin.DeleteMetrics(actorFromContext(ctx), tenant.FromContext(ctx), metricTypeName(in.GetType()), in.GetPattern())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return &pb.DeleteMetricsResponse{Deleted: int64(deleted)}, nil
+}
+
+func (s *AdminServer) ResetCounter(ctx context.Context, in *pb.ResetCounterRequest) (*emptypb.Empty, error) {
+	if err := s.admin.ResetCounter(actorFromContext(ctx), tenant.FromContext(ctx), in.GetId()); err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+
+	return new(emptypb.Empty), nil
+}
+
+// metricTypeName Получение имени типа метрики. Для UNKNOWN возвращается пустая строка
+func metricTypeName(metricType pb.Metric_Type) string {
+	switch metricType {
+	case pb.Metric_COUNTER:
+		return dictionaries.CounterType
+	case pb.Metric_GAUGE:
+		return dictionaries.GaugeType
+	}
+
+	return ""
+}
+
+// validationStatus Ошибка проверки метрик с описанием нарушений в errdetails.BadRequest
+func validationStatus(err error) *status.Status {
+	var validationErr *validation.Error
+	if !errors.As(err, &validationErr) {
+		return status.New(codes.InvalidArgument, err.Error())
+	}
+
+	code := codes.InvalidArgument
+	if validationErr.TooLarge {
+		code = codes.ResourceExhausted
+	}
+
+	badRequest := &errdetails.BadRequest{}
+	for _, violation := range validationErr.Violations {
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       fmt.Sprintf("metrics[%d].%s", violation.Index, violation.Field),
+			Description: violation.Reason,
+		})
+	}
+
+	st := status.New(code, validationErr.Detail)
+	if len(badRequest.FieldViolations) == 0 {
+		return st
+	}
+
+	detailed, err := st.WithDetails(badRequest)
+	if err != nil {
+		return st
+	}
+
+	return detailed
+}
+
+// actorFromContext Получение IP клиента из метаданных для журнала действий
+func actorFromContext(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if ok {
+		values := md.Get("ip")
+		if len(values) > 0 {
+			return values[0]
+		}
+	}
+
+	return ""
+}
+
+// agentFromContext Идентификатор агента для ограничений: токен или IP соединения. Метаданные ip задает сам клиент,
+// поэтому для ограничений они не используются
+func agentFromContext(ctx context.Context) string {
+	if token, ok := auth.FromContext(ctx); ok {
+		return "token:" + token.ID
+	}
+
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+
+	return host
+}
+
+// totalsAgentFromContext Агент, накопленные значения которого сравниваются с предыдущими: идентификатор
+// из метаданных logger.AgentIDMetadata, а без него - токен или IP клиента
+func totalsAgentFromContext(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(logger.AgentIDMetadata); len(values) > 0 && values[0] != "" {
+			return values[0]
+		}
+	}
+
+	return agentFromContext(ctx)
+}
+
+// counterMode Режим записи Counter из метаданных запроса (пустой - приращения)
+func counterMode(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(repositories.CounterModeMetadata); len(values) > 0 {
+			return values[0]
+		}
+	}
+
+	return ""
+}
+
+// addCounter Добавление значения Counter из запроса: приращения одной серии складываются,
+// а накопленное значение заменяет предыдущее значение серии в том же запросе
+func addCounter(ctx context.Context, counters types.Counters, key string, value types.Counter) {
+	if counterMode(ctx) == repositories.CounterModeCumulative {
+		counters[key] = value
+
+		return
+	}
+
+	counters[key] += value
+}
+
+// adminKeyInterceptor Проверка ключа администратора key в метаданных authorization для методов сервиса Admin.
+// При пустом ключе методы недоступны
+func adminKeyInterceptor(key string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !strings.HasPrefix(info.FullMethod, "/"+pb.Admin_ServiceDesc.ServiceName+"/") {
+			return handler(ctx, req)
+		}
+
+		var token string
+
+		md, ok := metadata.FromIncomingContext(ctx)
+		if ok {
+			values := md.Get("authorization")
+			if len(values) > 0 {
+				token = strings.TrimPrefix(values[0], "Bearer ")
+			}
+		}
+
+		if key == "" || subtle.ConstantTimeCompare([]byte(token), []byte(key)) != 1 {
+			return nil, status.Error(codes.PermissionDenied, "Invalid admin key")
+		}
+
+		// Администратор может выбрать любого арендатора, как и в HTTP middlewares.AdminKey
+		return handler(tenant.WithOverride(ctx), req)
+	}
+}
+
+// methodScopes Права доступа, необходимые для вызова методов
+var methodScopes = map[string]string{
+	"/proto.Metrics/BulkSaveMetrics":        auth.ScopeWrite,
+	"/proto.Metrics/BulkSaveMetricsPartial": auth.ScopeWrite,
+	"/proto.Metrics/GetMetric":              auth.ScopeRead,
+	"/proto.Metrics/ListMetrics":            auth.ScopeRead,
+}
+
+// authInterceptor Проверка токена из метаданных authorization и прав доступа к методу
+func authInterceptor(tokens auth.Store) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if healthMethod(info.FullMethod) {
+			return handler(ctx, req)
+		}
+
+		var secret string
+
+		md, ok := metadata.FromIncomingContext(ctx)
+		if ok {
+			values := md.Get("authorization")
+			if len(values) > 0 {
+				secret = auth.BearerSecret(values[0])
+			}
+		}
+
+		token, err := auth.Authenticate(tokens, secret)
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, "Invalid token")
+		}
+
+		scope, ok := methodScopes[info.FullMethod]
+		if strings.HasPrefix(info.FullMethod, "/"+pb.Admin_ServiceDesc.ServiceName+"/") {
+			scope, ok = auth.ScopeAdmin, true
+		}
+
+		if !ok || !token.HasScope(scope) {
+			return nil, status.Error(codes.PermissionDenied, "Token scope is not allowed")
+		}
+
+		return handler(auth.NewContext(ctx, token), req)
+	}
+}
+
+// tenantInterceptor Определение арендатора по токену или метаданным tenant и сохранение его в контексте
+func tenantInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	var requested string
+
+	md, ok := metadata.FromIncomingContext(ctx)
+	if ok {
+		values := md.Get(tenant.MetadataKey)
+		if len(values) > 0 {
+			requested = values[0]
+		}
+	}
+
+	name, err := tenant.Resolve(ctx, requested)
+	if errors.Is(err, tenant.ErrTenantMismatch) {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return handler(tenant.NewContext(ctx, name), req)
+}
+
+// federationInterceptor Отклонение запросов, пересланных сервером того же датацентра dc: запрос вернулся через петлю
+func federationInterceptor(dc string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if md, ok := metadata.FromIncomingContext(ctx); ok && dc != "" {
+			for _, source := range md.Get(federation.SourceHeader) {
+				if source == dc {
+					return nil, status.Error(codes.FailedPrecondition, "federation loop detected")
+				}
+			}
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// telemetryInterceptor Измерение длительности обработки запросов по методу и коду ответа
+func telemetryInterceptor(registry *telemetry.Registry) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+
+		resp, err := handler(ctx, req)
+
+		registry.Histogram(telemetry.GRPCRequestDuration, map[string]string{
+			"method": info.FullMethod,
+			"code":   status.Code(err).String(),
+		}, telemetry.DurationBuckets).Observe(time.Since(start).Seconds())
+
+		return resp, err
+	}
+}
+
+// loggingInterceptor Логирование запросов. Логгер с идентификаторами запроса и агента из метаданных
+// сохраняется в контексте запроса. Без x-request-id идентификатор генерируется сервером
+func loggingInterceptor(log *zap.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+
+		var requestID, agentID string
+
+		md, ok := metadata.FromIncomingContext(ctx)
+		if ok {
+			if values := md.Get(logger.RequestIDMetadata); len(values) > 0 {
+				requestID = values[0]
+			}
+
+			if values := md.Get(logger.AgentIDMetadata); len(values) > 0 {
+				agentID = values[0]
+			}
+		}
+
+		if requestID == "" {
+			id, _ := uuid.NewV4()
+			requestID = id.String()
+		}
+
+		requestLog := log.With(zap.String("request_id", requestID))
+		if agentID != "" {
+			requestLog = requestLog.With(zap.String("agent_id", agentID))
+		}
+
+		resp, err := handler(logger.NewContext(ctx, requestLog), req)
+
+		fields := []zap.Field{
+			zap.String("method", info.FullMethod),
+			zap.String("remote", actorFromContext(ctx)),
+			zap.String("code", status.Code(err).String()),
+			zap.Duration("duration", time.Since(start)),
+		}
+		if err != nil {
+			requestLog.Warn("request", append(fields, zap.Error(err))...)
+		} else {
+			requestLog.Info("request", fields...)
+		}
+
+		return resp, err
+	}
+}
+
+// healthMethod Метод сервиса проверки здоровья, доступный без токена и вне доверенной подсети
+func healthMethod(fullMethod string) bool {
+	return strings.HasPrefix(fullMethod, "/"+healthpb.Health_ServiceDesc.ServiceName+"/")
+}
+
 func trustSubnetInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if healthMethod(info.FullMethod) {
+		return handler(ctx, req)
+	}
+
 	config, err := conf.CreateServerConfig()
 	if err != nil {
-		log.Fatalf("Error with config: %v", err)
+		zap.S().Fatalf("Error with config: %v", err)
 	}
 
 	var ip string
@@ -132,26 +703,77 @@ func trustSubnetInterceptor(ctx context.Context, req interface{}, info *grpc.Una
 func main() {
 	config, err := conf.CreateServerConfig()
 	if err != nil {
-		log.Fatalf("Error with config: %v", err)
+		stdlog.Fatalf("Error with config: %v", err)
 	}
 
+	zapLogger, err := logger.New(config.LogLevel, config.LogFormat, config.LogSampling)
+	if err != nil {
+		stdlog.Fatalf("Error with logger: %v", err)
+	}
+	defer zapLogger.Sync()
+
+	zap.ReplaceGlobals(zapLogger)
+	log := zapLogger.Sugar()
+
 	db, err := postgres.ConnectDatabase(config.DatabaseDsn)
 	if err != nil {
 		log.Fatalf("Error with database: %v", err)
 	}
 	defer db.Close()
 
-	statsRepository := repositories.NewStatsDatabaseRepository(db)
-	if config.DatabaseDsn == "" {
-		statsRepository = repositories.NewStatsMemoryRepository()
-	}
+	registry := telemetry.NewRegistry()
 
-	decrypt, err := services.NewMetricDecrypt(config.CryptoKey)
-	if err != nil {
-		log.Fatalf("Ошибка с инициализацией сервиса шифрования: %v", err)
+	var tenantRepository repositories.TenantRepository = repositories.NewTenantMemoryRepository()
+	if config.DatabaseDsn != "" {
+		databaseRepository := repositories.NewTenantDatabaseRepository(db)
+		tenantRepository = databaseRepository
+
+		// Изменения накапливаются в памяти и записываются в бд одним пакетом
+		if config.WriteInterval > 0 {
+			writeBuffer, err := repositories.NewTenantBufferedRepository(databaseRepository, config.WriteInterval, config.WriteSize, config.WriteMaxPending, config.WriteDurability)
+			if err != nil {
+				log.Fatalf("Error with write buffer: %v", err)
+			}
+			defer writeBuffer.Close()
+
+			tenantRepository = writeBuffer
+		}
+
+		// Чтение из копии в памяти, изменения других экземпляров сервера сбрасывают копию
+		if config.Cache {
+			// Оповещение отправляется после ответа буфера, поэтому с async другие экземпляры загрузили бы копию до записи
+			if config.WriteInterval > 0 && config.WriteDurability == repositories.DurabilityAsync {
+				log.Fatalf("Error with cache: write durability %s is not supported, use %s", repositories.DurabilityAsync, repositories.DurabilitySync)
+			}
+
+			notifier := repositories.NewDatabaseNotifier(db)
+			cache := repositories.NewTenantCachedRepository(tenantRepository, notifier, config.CacheTTL)
+
+			// Копия загружается при запуске после подписки на оповещения и заново после каждого переподключения
+			listenCtx, stopListen := context.WithCancel(context.Background())
+			defer stopListen()
+
+			go notifier.Run(listenCtx, cache)
+
+			tenantRepository = cache
+		}
 	}
+	tenantRepository = telemetry.NewTenantRepository(tenantRepository, registry)
 
-	signer := services.NewMetricSigner(config.Key)
+	// Фоновые задачи нескольких экземпляров с общей бд выполняет только ведущий
+	var elector *leader.Elector
+	if config.LeaderElection {
+		if config.DatabaseDsn == "" {
+			log.Fatalf("Error with leader election: database dsn is required")
+		}
+
+		elector = leader.NewElector(leader.NewDatabaseLocker(db, leader.LockKey), config.LeaderInterval)
+
+		electionCtx, stopElection := context.WithCancel(context.Background())
+		defer stopElection()
+
+		go elector.Run(electionCtx)
+	}
 
 	consumer, err := file.NewFileConsumer(config.StoreFile)
 	if err != nil {
@@ -164,16 +786,73 @@ func main() {
 	}
 	defer producer.Close()
 
-	fileStorage := storage.NewStatsStorage(config, consumer, producer)
+	fileStorage := storage.NewStatsStorage(config, consumer, producer, registry)
 
-	defer fileStorage.Save(statsRepository)
+	defer func() {
+		if err := fileStorage.Save(tenantRepository); err != nil {
+			log.Errorf("Error with store file: %v", err)
+		}
+	}()
 
-	statsRepository, err = fileStorage.Start(statsRepository)
+	tenantRepository, err = fileStorage.Start(tenantRepository)
 	if err != nil {
 		log.Fatalf("Error with file file storage: %v", err)
 	}
 
-	s := grpc.NewServer(grpc.UnaryInterceptor(trustSubnetInterceptor))
+	// Вычисляемые метрики. История значений в gRPC сервере не хранится, поэтому rate() и increase() в правилах недоступны
+	evaluator, err := rules.NewFromConfig(config, tenantRepository, nil, elector)
+	if err != nil {
+		log.Fatalf("Error with recording rules: %v", err)
+	}
+
+	if evaluator != nil && config.RulesOnWrite {
+		tenantRepository = rules.NewTenantRepository(tenantRepository, evaluator)
+	}
+
+	// Последние накопленные значения счетчиков агентов, которые присылают их вместо приращений
+	totalsRepository := repositories.NewTenantTotalsDatabaseRepository(db)
+	if config.DatabaseDsn == "" {
+		totalsRepository = repositories.NewTenantTotalsRepository()
+	}
+
+	totalsStorage := storage.NewTotalsStorage(config.TotalsFile)
+	if err := totalsStorage.Restore(totalsRepository); err != nil {
+		log.Fatalf("Error with totals file: %v", err)
+	}
+
+	limiter, err := limits.NewFromConfig(config, tenantRepository, registry)
+	if err != nil {
+		log.Fatalf("Error with limits: %v", err)
+	}
+
+	decrypt, err := services.NewMetricDecrypt(config.CryptoKey)
+	if err != nil {
+		log.Fatalf("Ошибка с инициализацией сервиса шифрования: %v", err)
+	}
+
+	signer := services.NewMetricSigner(config.Key)
+
+	audit, err := services.NewAuditLogger(config.AuditFile)
+	if err != nil {
+		log.Fatalf("Error with audit log: %v", err)
+	}
+	defer audit.Close()
+
+	interceptors := []grpc.UnaryServerInterceptor{loggingInterceptor(zapLogger), telemetryInterceptor(registry), federationInterceptor(config.FederationDC), trustSubnetInterceptor, adminKeyInterceptor(config.AdminKey), tenantInterceptor}
+	if config.Auth {
+		if config.TokensFile == "" && config.DatabaseDsn == "" {
+			log.Fatalf("Error with tokens store: tokens file or database dsn is required")
+		}
+
+		tokens, err := auth.NewStore(config.TokensFile, db)
+		if err != nil {
+			log.Fatalf("Error with tokens store: %v", err)
+		}
+
+		interceptors = []grpc.UnaryServerInterceptor{loggingInterceptor(zapLogger), telemetryInterceptor(registry), federationInterceptor(config.FederationDC), trustSubnetInterceptor, authInterceptor(tokens), tenantInterceptor}
+	}
+
+	s := grpc.NewServer(grpc.ChainUnaryInterceptor(interceptors...))
 
 	go func() {
 		listen, err := net.Listen("tcp", config.Address)
@@ -182,30 +861,107 @@ func main() {
 		}
 
 		pb.RegisterMetricsServer(s, &MetricsServer{
-			repository: statsRepository,
-			signer:     signer,
-			db:         db,
-			decrypt:    decrypt,
+			tenants:   tenantRepository,
+			ingest:    services.NewMetricIngest(tenantRepository, totalsRepository, limiter, batch.NewFromConfig(config, db), registry),
+			signer:    signer,
+			db:        db,
+			decrypt:   decrypt,
+			rules:     validation.NewFromConfig(config),
+			telemetry: registry,
+		})
+		pb.RegisterAdminServer(s, &AdminServer{
+			admin: services.NewMetricAdmin(tenantRepository, audit, limiter),
 		})
+		healthpb.RegisterHealthServer(s, health.NewGRPCServer(
+			health.NewServerChecker(config, db, fileStorage.LastSave, elector),
+			pb.Metrics_ServiceDesc.ServiceName,
+			pb.Admin_ServiceDesc.ServiceName,
+		))
 
 		if err := s.Serve(listen); err != nil {
 			log.Fatal(err)
 		}
 	}()
 
+	if config.TelemetryAddress != "" {
+		go serveTelemetry(config.TelemetryAddress, registry)
+	}
+
+	// Пересылка метрик на вышестоящий сервер
+	forwarder, err := federation.NewFromConfig(config, tenantRepository, db, elector)
+	if err != nil {
+		log.Fatalf("Error with federation: %v", err)
+	}
+
+	if forwarder != nil {
+		defer forwarder.Close()
+
+		federationCtx, stopFederation := context.WithCancel(context.Background())
+		defer stopFederation()
+
+		go forwarder.Run(federationCtx)
+	}
+
+	if evaluator != nil && config.RulesInterval > 0 {
+		rulesCtx, stopRules := context.WithCancel(context.Background())
+		defer stopRules()
+
+		go evaluator.Run(rulesCtx, config.RulesInterval)
+	}
+
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
 	var storeTick = time.Tick(config.StoreInterval)
 
+	var telemetryTick <-chan time.Time
+	if config.TelemetrySelf {
+		telemetryTick = time.Tick(config.TelemetryInterval)
+	}
+
 	for {
 		select {
 		case <-c:
 			s.GracefulStop()
-			fileStorage.Save(statsRepository)
+			if err := fileStorage.Save(tenantRepository); err != nil {
+				log.Errorf("Error with store file: %v", err)
+			}
+
+			if err := totalsStorage.Save(totalsRepository); err != nil {
+				log.Errorf("Error with totals file: %v", err)
+			}
 
 			return
 		case <-storeTick:
-			fileStorage.Save(statsRepository)
+			if !elector.IsLeader() {
+				continue
+			}
+
+			if err := fileStorage.Save(tenantRepository); err != nil {
+				log.Errorf("Error with store file: %v", err)
+			}
+
+			if err := totalsStorage.Save(totalsRepository); err != nil {
+				log.Errorf("Error with totals file: %v", err)
+			}
+		case <-telemetryTick:
+			// Метрики сервера записывает только ведущий, иначе экземпляры перезаписывают значения друг друга
+			if !elector.IsLeader() {
+				continue
+			}
+
+			if err := registry.Export(tenantRepository.Tenant(tenant.Default)); err != nil {
+				log.Errorf("Error with telemetry: %v", err)
+			}
 		}
 	}
 }
+
+// serveTelemetry Запуск внутреннего HTTP-сервера с метриками самого сервера
+func serveTelemetry(address string, registry *telemetry.Registry) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", registry.Handler())
+
+	if err := http.ListenAndServe(address, mux); err != nil {
+		zap.S().Errorf("Error with telemetry server: %v", err)
+	}
+}
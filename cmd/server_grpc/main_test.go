@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/vllvll/devops/internal/batch"
+	"github.com/vllvll/devops/internal/limits"
+	"github.com/vllvll/devops/internal/repositories"
+	"github.com/vllvll/devops/internal/services"
+	"github.com/vllvll/devops/internal/tenant"
+	"github.com/vllvll/devops/internal/types"
+	pb "github.com/vllvll/devops/proto"
+)
+
+// chain Вызов обработчика через цепочку перехватчиков, как в grpc.ChainUnaryInterceptor
+func chain(ctx context.Context, method string, handler grpc.UnaryHandler, interceptors ...grpc.UnaryServerInterceptor) (interface{}, error) {
+	info := &grpc.UnaryServerInfo{FullMethod: method}
+
+	next := handler
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, current := interceptors[i], next
+		next = func(ctx context.Context, req interface{}) (interface{}, error) {
+			return interceptor(ctx, req, info, current)
+		}
+	}
+
+	return next(ctx, nil)
+}
+
+func TestAdminKeyInterceptor_Tenant(t *testing.T) {
+	var resolved string
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		resolved = tenant.FromContext(ctx)
+
+		return nil, nil
+	}
+
+	call := func(method string, pairs ...string) error {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(pairs...))
+		_, err := chain(ctx, method, handler, adminKeyInterceptor("admin-key"), tenantInterceptor)
+
+		return err
+	}
+
+	// Администратор выбирает арендатора метаданными tenant
+	require.NoError(t, call("/proto.Admin/DeleteMetric", "authorization", "Bearer admin-key", tenant.MetadataKey, "team-a"))
+	assert.Equal(t, "team-a", resolved)
+
+	assert.Equal(t, codes.PermissionDenied, status.Code(call("/proto.Admin/DeleteMetric", "authorization", "Bearer wrong", tenant.MetadataKey, "team-a")))
+
+	// Без токена остальные методы работают только с арендатором по умолчанию
+	assert.Equal(t, codes.PermissionDenied, status.Code(call("/proto.Metrics/GetMetric", tenant.MetadataKey, "team-a")))
+}
+
+func TestMetricsServer_BulkSaveMetrics(t *testing.T) {
+	tenants := repositories.NewTenantMemoryRepository()
+	totals := repositories.NewTenantTotalsRepository()
+	limiter := limits.New(nil, limits.NewAgentLimits(limits.AgentQuota{MaxSeries: 2}), nil)
+	server := &MetricsServer{
+		tenants: tenants,
+		ingest:  services.NewMetricIngest(tenants, totals, limiter, batch.NewMemoryStore(time.Minute, 0), nil),
+		signer:  services.NewMetricSigner(""),
+	}
+
+	request := func(batchID string, names ...string) *pb.AddBulkMetricsRequest {
+		in := &pb.AddBulkMetricsRequest{BatchId: batchID, Metrics: &pb.BulkMetrics{}}
+		for _, name := range names {
+			delta := int64(5)
+			in.Metrics.Metrics = append(in.Metrics.Metrics, &pb.Metric{Id: name, Type: pb.Metric_COUNTER, Delta: &delta})
+		}
+
+		return in
+	}
+
+	batchID := "6f2c1d9e-3f7b-4a55-8a0e-2b4f7c3d9e10"
+
+	_, err := server.BulkSaveMetrics(context.Background(), request(batchID, "PollCount"))
+	require.NoError(t, err)
+
+	// Повтор пакета не применяется второй раз
+	var header metadata.MD
+	_, err = server.BulkSaveMetrics(grpc.NewContextWithServerTransportStream(context.Background(), &headerStream{header: &header}), request(batchID, "PollCount"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"true"}, header.Get(strings.ToLower(batch.DuplicateHeader)))
+
+	counter, err := tenants.Tenant(tenant.Default).GetCounterByKey("PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, types.Counter(5), counter)
+
+	// Ошибки записи возвращаются с кодами gRPC
+	_, err = server.BulkSaveMetrics(context.Background(), request("", "Requests", "Errors"))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(repositories.CounterModeMetadata, "unknown"))
+	_, err = server.BulkSaveMetrics(ctx, request("", "PollCount"))
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// headerStream Поток сервера для проверки заголовков ответа
+type headerStream struct {
+	grpc.ServerTransportStream
+	header *metadata.MD
+}
+
+func (s *headerStream) SetHeader(md metadata.MD) error {
+	*s.header = metadata.Join(*s.header, md)
+
+	return nil
+}
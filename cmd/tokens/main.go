@@ -0,0 +1,147 @@
+// Модуль tokens выпускает и отзывает токены доступа к серверу
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	flag "github.com/spf13/pflag"
+
+	"github.com/vllvll/devops/internal/auth"
+	"github.com/vllvll/devops/internal/tenant"
+	"github.com/vllvll/devops/pkg/postgres"
+)
+
+const usage = `Usage:
+  tokens issue --scopes write,read [--name NAME] [--prefix PREFIX] [--tenant TENANT]
+  tokens revoke --id ID
+  tokens list
+
+Store flags (or TOKENS_FILE / DATABASE_DSN environment variables):
+  -f, --file string           Tokens JSON file
+  -d, --database-dsn string   Database dsn
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	flags := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	file := flags.StringP("file", "f", os.Getenv("TOKENS_FILE"), "Tokens JSON file")
+	dsn := flags.StringP("database-dsn", "d", os.Getenv("DATABASE_DSN"), "Database dsn")
+	name := flags.String("name", "", "Token name")
+	scopes := flags.StringSlice("scopes", nil, "Token scopes: write, read, admin")
+	prefix := flags.String("prefix", "", "Allowed metric name prefix for writes")
+	tenantName := flags.String("tenant", "", "Tenant the token is bound to")
+	id := flags.String("id", "", "Token id")
+
+	if err := flags.Parse(os.Args[2:]); err != nil {
+		log.Fatal(err)
+	}
+
+	var db *sql.DB
+	if *file == "" {
+		if *dsn == "" {
+			log.Fatal("Error with tokens store: --file or --database-dsn is required")
+		}
+
+		var err error
+
+		db, err = postgres.ConnectDatabase(*dsn)
+		if err != nil {
+			log.Fatalf("Error with database: %v", err)
+		}
+		defer db.Close()
+	}
+
+	store, err := auth.NewStore(*file, db)
+	if err != nil {
+		log.Fatalf("Error with tokens store: %v", err)
+	}
+
+	switch os.Args[1] {
+	case "issue":
+		err = issue(store, *name, *scopes, *prefix, *tenantName)
+	case "revoke":
+		err = store.Revoke(*id)
+	case "list":
+		err = list(store)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// issue Выпуск токена. Секрет выводится один раз и нигде не сохраняется
+func issue(store auth.Store, name string, scopes []string, prefix string, tenantName string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("at least one scope is required")
+	}
+
+	for _, scope := range scopes {
+		switch scope {
+		case auth.ScopeWrite, auth.ScopeRead, auth.ScopeAdmin:
+		default:
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+
+	if err := tenant.Validate(tenantName); err != nil {
+		return err
+	}
+
+	secret, token, err := auth.Generate(name, scopes, prefix)
+	if err != nil {
+		return err
+	}
+
+	token.Tenant = tenantName
+
+	if err := store.Save(token); err != nil {
+		return err
+	}
+
+	fmt.Printf("id: %s\ntoken: %s\n", token.ID, secret)
+
+	return nil
+}
+
+// list Вывод списка токенов без секретов
+func list(store auth.Store) error {
+	tokens, err := store.List()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tSCOPES\tPREFIX\tTENANT\tCREATED\tREVOKED")
+
+	for _, token := range tokens {
+		revoked := ""
+		if token.Revoked() {
+			revoked = token.RevokedAt.Format("2006-01-02 15:04:05")
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			token.ID,
+			token.Name,
+			strings.Join(token.Scopes, ","),
+			token.Prefix,
+			token.Tenant,
+			token.CreatedAt.Format("2006-01-02 15:04:05"),
+			revoked,
+		)
+	}
+
+	return w.Flush()
+}
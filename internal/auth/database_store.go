@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+type DatabaseStore struct {
+	db *sql.DB
+}
+
+// NewDatabaseStore Создание хранилища токенов в таблице tokens
+func NewDatabaseStore(db *sql.DB) Store {
+	return &DatabaseStore{
+		db: db,
+	}
+}
+
+// Find Поиск токена по хешу секрета
+func (s *DatabaseStore) Find(hash string) (*Token, error) {
+	row := s.db.QueryRow("SELECT id, hash, name, scopes, prefix, tenant, created_at, revoked_at FROM tokens WHERE hash = $1 LIMIT 1", hash)
+
+	token, err := scanToken(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTokenNotFound
+	}
+
+	return token, err
+}
+
+// Save Добавление токена
+func (s *DatabaseStore) Save(token Token) error {
+	_, err := s.db.Exec(
+		"INSERT INTO tokens (id, hash, name, scopes, prefix, tenant, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		token.ID,
+		token.Hash,
+		token.Name,
+		strings.Join(token.Scopes, ","),
+		token.Prefix,
+		token.Tenant,
+		token.CreatedAt,
+	)
+
+	return err
+}
+
+// Revoke Отзыв токена по идентификатору
+func (s *DatabaseStore) Revoke(id string) error {
+	result, err := s.db.Exec("UPDATE tokens SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL", id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrTokenNotFound
+	}
+
+	return nil
+}
+
+// List Получение всех токенов
+func (s *DatabaseStore) List() ([]Token, error) {
+	rows, err := s.db.Query("SELECT id, hash, name, scopes, prefix, tenant, created_at, revoked_at FROM tokens ORDER BY created_at")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []Token
+	for rows.Next() {
+		token, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, *token)
+	}
+
+	return tokens, rows.Err()
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanToken(row scanner) (*Token, error) {
+	var token Token
+	var scopes string
+	var revokedAt sql.NullTime
+
+	err := row.Scan(&token.ID, &token.Hash, &token.Name, &scopes, &token.Prefix, &token.Tenant, &token.CreatedAt, &revokedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if scopes != "" {
+		token.Scopes = strings.Split(scopes, ",")
+	}
+
+	if revokedAt.Valid {
+		token.RevokedAt = &revokedAt.Time
+	}
+
+	return &token, nil
+}
@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type FileStore struct {
+	mu      sync.Mutex
+	path    string    // Путь до JSON файла с токенами
+	modTime time.Time // Время изменения файла при последнем чтении
+	tokens  []Token   // Прочитанные токены
+}
+
+// NewFileStore Создание хранилища токенов в JSON файле. Файл перечитывается при изменении
+func NewFileStore(path string) Store {
+	return &FileStore{
+		path: path,
+	}
+}
+
+// Find Поиск токена по хешу секрета
+func (s *FileStore) Find(hash string) (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	for _, token := range s.tokens {
+		if token.Hash == hash {
+			token := token
+
+			return &token, nil
+		}
+	}
+
+	return nil, ErrTokenNotFound
+}
+
+// Save Добавление токена
+func (s *FileStore) Save(token Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	s.tokens = append(s.tokens, token)
+
+	return s.write()
+}
+
+// Revoke Отзыв токена по идентификатору
+func (s *FileStore) Revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	for i := range s.tokens {
+		if s.tokens[i].ID == id && !s.tokens[i].Revoked() {
+			now := time.Now().UTC()
+			s.tokens[i].RevokedAt = &now
+
+			return s.write()
+		}
+	}
+
+	return ErrTokenNotFound
+}
+
+// List Получение всех токенов
+func (s *FileStore) List() ([]Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	return append([]Token(nil), s.tokens...), nil
+}
+
+// load Чтение файла, если он изменился
+func (s *FileStore) load() error {
+	info, err := os.Stat(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		s.tokens = nil
+
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if info.ModTime().Equal(s.modTime) && s.tokens != nil {
+		return nil
+	}
+
+	content, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	var tokens []Token
+	if err := json.Unmarshal(content, &tokens); err != nil {
+		return err
+	}
+
+	s.tokens = tokens
+	s.modTime = info.ModTime()
+
+	return nil
+}
+
+// write Атомарная запись файла через временный файл
+func (s *FileStore) write() error {
+	content, err := json.MarshalIndent(s.tokens, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".tokens-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+
+		return err
+	}
+
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+
+	s.modTime = info.ModTime()
+
+	return nil
+}
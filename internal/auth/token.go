@@ -0,0 +1,157 @@
+// Package auth Функционал для аутентификации по токенам и проверки прав доступа
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Права доступа токена
+const (
+	ScopeWrite = "write" // Запись метрик
+	ScopeRead  = "read"  // Чтение метрик
+	ScopeAdmin = "admin" // Удаление и сброс метрик
+)
+
+// ErrTokenNotFound Токен не найден или отозван
+var ErrTokenNotFound = errors.New("token not found")
+
+// Token Токен доступа. Хранится только хеш секрета
+type Token struct {
+	ID        string     `json:"id"`                   // Идентификатор токена
+	Hash      string     `json:"hash"`                 // SHA-256 хеш секрета
+	Name      string     `json:"name,omitempty"`       // Описание (команда, сервис)
+	Scopes    []string   `json:"scopes"`               // Права доступа
+	Prefix    string     `json:"prefix,omitempty"`     // Префикс имен метрик, доступных для записи
+	Tenant    string     `json:"tenant,omitempty"`     // Арендатор, к которому привязан токен
+	CreatedAt time.Time  `json:"created_at"`           // Время создания
+	RevokedAt *time.Time `json:"revoked_at,omitempty"` // Время отзыва
+}
+
+type Store interface {
+	Find(hash string) (*Token, error)
+	Save(token Token) error
+	Revoke(id string) error
+	List() ([]Token, error)
+}
+
+type contextKey struct{}
+
+// HasScope Проверка наличия права доступа
+func (t Token) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Allows Проверка, что токену разрешена запись метрики с именем name
+func (t Token) Allows(name string) bool {
+	return strings.HasPrefix(name, t.Prefix)
+}
+
+// Revoked Проверка, что токен отозван
+func (t Token) Revoked() bool {
+	return t.RevokedAt != nil
+}
+
+// Generate Создание нового токена. Возвращает секрет, который показывается только один раз
+func Generate(name string, scopes []string, prefix string) (string, Token, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", Token{}, err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", Token{}, err
+	}
+
+	raw := hex.EncodeToString(secret)
+
+	return raw, Token{
+		ID:        hex.EncodeToString(id),
+		Hash:      HashSecret(raw),
+		Name:      name,
+		Scopes:    scopes,
+		Prefix:    prefix,
+		CreatedAt: time.Now().UTC(),
+	}, nil
+}
+
+// HashSecret Получение хеша секрета токена
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// Authenticate Поиск действующего токена по секрету
+func Authenticate(store Store, secret string) (*Token, error) {
+	if secret == "" {
+		return nil, ErrTokenNotFound
+	}
+
+	token, err := store.Find(HashSecret(secret))
+	if err != nil {
+		return nil, err
+	}
+
+	if token.Revoked() {
+		return nil, ErrTokenNotFound
+	}
+
+	return token, nil
+}
+
+// BearerSecret Получение секрета из заголовка Authorization: Bearer <secret>
+func BearerSecret(header string) string {
+	const prefix = "Bearer "
+
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return ""
+	}
+
+	return strings.TrimSpace(header[len(prefix):])
+}
+
+// NewContext Сохранение токена в контексте запроса
+func NewContext(ctx context.Context, token *Token) context.Context {
+	return context.WithValue(ctx, contextKey{}, token)
+}
+
+// FromContext Получение токена из контекста запроса
+func FromContext(ctx context.Context) (*Token, bool) {
+	token, ok := ctx.Value(contextKey{}).(*Token)
+
+	return token, ok
+}
+
+// AllowsWrite Проверка права записи метрики. Без токена (аутентификация выключена) запись разрешена
+func AllowsWrite(ctx context.Context, name string) bool {
+	token, ok := FromContext(ctx)
+
+	return !ok || token.Allows(name)
+}
+
+// NewStore Создание хранилища токенов: JSON файл, если задан путь, иначе таблица tokens в бд
+func NewStore(path string, db *sql.DB) (Store, error) {
+	if path != "" {
+		return NewFileStore(path), nil
+	}
+
+	if db == nil {
+		return nil, errors.New("tokens store is not configured")
+	}
+
+	return NewDatabaseStore(db), nil
+}
@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStore(t *testing.T) {
+	store := NewFileStore(filepath.Join(t.TempDir(), "tokens.json"))
+
+	secret, token, err := Generate("team-a", []string{ScopeWrite}, "teamA.")
+	require.NoError(t, err)
+	require.NoError(t, store.Save(token))
+
+	found, err := Authenticate(store, secret)
+	require.NoError(t, err)
+	assert.Equal(t, token.ID, found.ID)
+	assert.True(t, found.HasScope(ScopeWrite))
+	assert.False(t, found.HasScope(ScopeAdmin))
+	assert.True(t, found.Allows("teamA.requests"))
+	assert.False(t, found.Allows("teamB.requests"))
+
+	_, err = Authenticate(store, "wrong")
+	assert.ErrorIs(t, err, ErrTokenNotFound)
+
+	require.NoError(t, store.Revoke(token.ID))
+
+	_, err = Authenticate(store, secret)
+	assert.ErrorIs(t, err, ErrTokenNotFound)
+	assert.ErrorIs(t, store.Revoke(token.ID), ErrTokenNotFound)
+
+	tokens, err := store.List()
+	require.NoError(t, err)
+	require.Len(t, tokens, 1)
+	assert.NotEqual(t, secret, tokens[0].Hash)
+}
+
+func TestBearerSecret(t *testing.T) {
+	assert.Equal(t, "abc", BearerSecret("Bearer abc"))
+	assert.Equal(t, "abc", BearerSecret("bearer abc"))
+	assert.Equal(t, "", BearerSecret("Basic abc"))
+	assert.Equal(t, "", BearerSecret(""))
+}
@@ -0,0 +1,118 @@
+// Package backup Функционал для резервного копирования и восстановления метрик
+package backup
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/vllvll/devops/internal/types"
+)
+
+// Формат архива: gzip с JSON-строками, первая строка - заголовок, остальные - метрики
+const (
+	Format  = "devops-metrics" // Название формата архива
+	Version = 1                // Текущая версия формата архива
+)
+
+var (
+	ErrInvalidArchive     = errors.New("invalid backup archive")
+	ErrUnsupportedVersion = errors.New("unsupported backup archive version")
+)
+
+// Header Заголовок архива
+type Header struct {
+	Format    string    `json:"format"`     // Название формата архива
+	Version   int       `json:"version"`    // Версия формата архива
+	CreatedAt time.Time `json:"created_at"` // Время создания архива
+	Source    string    `json:"source"`     // Источник метрик
+}
+
+type Writer struct {
+	gzip    *gzip.Writer
+	encoder *json.Encoder
+}
+
+// NewWriter Создание архива в w с заголовком для источника source
+func NewWriter(w io.Writer, source string) (*Writer, error) {
+	zw := gzip.NewWriter(w)
+	encoder := json.NewEncoder(zw)
+
+	header := Header{
+		Format:    Format,
+		Version:   Version,
+		CreatedAt: time.Now().UTC(),
+		Source:    source,
+	}
+
+	if err := encoder.Encode(header); err != nil {
+		return nil, err
+	}
+
+	return &Writer{
+		gzip:    zw,
+		encoder: encoder,
+	}, nil
+}
+
+// WriteMetric Запись метрики в архив
+func (w *Writer) WriteMetric(metric types.Metrics) error {
+	return w.encoder.Encode(metric)
+}
+
+// Close Завершение архива. Не закрывает исходный io.Writer
+func (w *Writer) Close() error {
+	return w.gzip.Close()
+}
+
+type Reader struct {
+	header  Header
+	decoder *json.Decoder
+}
+
+// NewReader Открытие архива из r с проверкой формата и версии
+func NewReader(r io.Reader) (*Reader, error) {
+	zr, err := gzip.NewReader(bufio.NewReader(r))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+
+	decoder := json.NewDecoder(zr)
+
+	var header Header
+	if err := decoder.Decode(&header); err != nil || header.Format != Format {
+		return nil, ErrInvalidArchive
+	}
+
+	if header.Version < 1 || header.Version > Version {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, header.Version)
+	}
+
+	return &Reader{
+		header:  header,
+		decoder: decoder,
+	}, nil
+}
+
+// Header Заголовок архива
+func (r *Reader) Header() Header {
+	return r.header
+}
+
+// ReadMetric Чтение следующей метрики из архива. В конце архива возвращается io.EOF
+func (r *Reader) ReadMetric() (*types.Metrics, error) {
+	var metric types.Metrics
+	if err := r.decoder.Decode(&metric); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+
+		return nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+
+	return &metric, nil
+}
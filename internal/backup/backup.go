@@ -0,0 +1,154 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/vllvll/devops/internal/dictionaries"
+	"github.com/vllvll/devops/internal/tenant"
+	"github.com/vllvll/devops/internal/types"
+)
+
+// Режимы восстановления метрик типа Counter. Метрики типа Gauge всегда перезаписываются
+const (
+	ModeMerge   = "merge"   // Значение из архива прибавляется к текущему значению
+	ModeReplace = "replace" // Текущее значение заменяется значением из архива
+)
+
+// ErrUnknownMode Неизвестный режим восстановления
+var ErrUnknownMode = errors.New("unknown restore mode")
+
+// Source Источник метрик для резервной копии
+type Source interface {
+	Tenants() ([]string, error)
+	GetAll(tenantName string) (types.Gauges, types.Counters, error)
+}
+
+// Target Получатель метрик при восстановлении
+type Target interface {
+	Restore(tenantName string, gauges types.Gauges, counters types.Counters, replace bool) error
+}
+
+// Backup Запись всех метрик всех арендаторов источника в архив. Возвращает количество записанных метрик
+func Backup(w io.Writer, source Source, name string) (int, error) {
+	archive, err := NewWriter(w, name)
+	if err != nil {
+		return 0, err
+	}
+
+	tenants, err := source.Tenants()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+
+	for _, tenantName := range tenants {
+		gauges, counters, err := source.GetAll(tenantName)
+		if err != nil {
+			return count, fmt.Errorf("tenant %q: %w", tenantName, err)
+		}
+
+		for _, key := range gaugeKeys(gauges) {
+			value := float64(gauges[key])
+
+			if err := archive.WriteMetric(types.Metrics{ID: key, MType: dictionaries.GaugeType, Value: &value, Tenant: tenantName}); err != nil {
+				return count, err
+			}
+			count++
+		}
+
+		for _, key := range counterKeys(counters) {
+			delta := int64(counters[key])
+
+			if err := archive.WriteMetric(types.Metrics{ID: key, MType: dictionaries.CounterType, Delta: &delta, Tenant: tenantName}); err != nil {
+				return count, err
+			}
+			count++
+		}
+	}
+
+	return count, archive.Close()
+}
+
+// Restore Восстановление метрик из архива в режиме mode. Возвращает заголовок архива и количество восстановленных метрик
+func Restore(r io.Reader, target Target, mode string) (Header, int, error) {
+	if mode != ModeMerge && mode != ModeReplace {
+		return Header{}, 0, fmt.Errorf("%w: %q", ErrUnknownMode, mode)
+	}
+
+	archive, err := NewReader(r)
+	if err != nil {
+		return Header{}, 0, err
+	}
+
+	gauges := map[string]types.Gauges{}
+	counters := map[string]types.Counters{}
+	var order []string
+
+	for {
+		metric, err := archive.ReadMetric()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return archive.Header(), 0, err
+		}
+
+		if err := tenant.Validate(metric.Tenant); err != nil {
+			return archive.Header(), 0, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+		}
+
+		if _, ok := gauges[metric.Tenant]; !ok {
+			gauges[metric.Tenant] = types.Gauges{}
+			counters[metric.Tenant] = types.Counters{}
+			order = append(order, metric.Tenant)
+		}
+
+		switch {
+		case metric.MType == dictionaries.GaugeType && metric.Value != nil:
+			gauges[metric.Tenant][metric.ID] = types.Gauge(*metric.Value)
+		case metric.MType == dictionaries.CounterType && metric.Delta != nil:
+			counters[metric.Tenant][metric.ID] += types.Counter(*metric.Delta)
+		default:
+			return archive.Header(), 0, fmt.Errorf("%w: bad metric %q", ErrInvalidArchive, metric.ID)
+		}
+	}
+
+	count := 0
+
+	for _, tenantName := range order {
+		if err := target.Restore(tenantName, gauges[tenantName], counters[tenantName], mode == ModeReplace); err != nil {
+			return archive.Header(), count, fmt.Errorf("tenant %q: %w", tenantName, err)
+		}
+
+		count += len(gauges[tenantName]) + len(counters[tenantName])
+	}
+
+	return archive.Header(), count, nil
+}
+
+// gaugeKeys Ключи метрик типа Gauge в алфавитном порядке, чтобы архивы одинаковых данных совпадали
+func gaugeKeys(gauges types.Gauges) []string {
+	keys := make([]string, 0, len(gauges))
+	for key := range gauges {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// counterKeys Ключи метрик типа Counter в алфавитном порядке
+func counterKeys(counters types.Counters) []string {
+	keys := make([]string, 0, len(counters))
+	for key := range counters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,154 @@
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vllvll/devops/internal/client"
+	"github.com/vllvll/devops/internal/handlers"
+	"github.com/vllvll/devops/internal/middlewares"
+	"github.com/vllvll/devops/internal/repositories"
+	"github.com/vllvll/devops/internal/services"
+	"github.com/vllvll/devops/internal/types"
+)
+
+func TestBackupRestore(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     string
+		gauges   map[string]types.Gauge
+		counters map[string]types.Counter
+	}{
+		{
+			name:     "merge",
+			mode:     ModeMerge,
+			gauges:   map[string]types.Gauge{"Alloc": 1.5, "HeapAlloc": 7},
+			counters: map[string]types.Counter{"PollCount": 15, "Other": 1},
+		},
+		{
+			name:     "replace",
+			mode:     ModeReplace,
+			gauges:   map[string]types.Gauge{"Alloc": 1.5, "HeapAlloc": 7},
+			counters: map[string]types.Counter{"PollCount": 10, "Other": 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := repositories.NewTenantMemoryRepository()
+			require.NoError(t, source.Tenant("").UpdateAll(types.Gauges{"Alloc": 1.5}, types.Counters{"PollCount": 10}))
+			require.NoError(t, source.Tenant("team-a").UpdateAll(types.Gauges{"Alloc": 2}, types.Counters{"Requests;path=/": 3}))
+
+			var archive bytes.Buffer
+			count, err := Backup(&archive, NewRepositoryStore(source), "memory")
+			require.NoError(t, err)
+			assert.Equal(t, 4, count)
+
+			target := repositories.NewTenantMemoryRepository()
+			require.NoError(t, target.Tenant("").UpdateAll(types.Gauges{"HeapAlloc": 7}, types.Counters{"PollCount": 5, "Other": 1}))
+
+			header, count, err := Restore(&archive, NewRepositoryStore(target), tt.mode)
+			require.NoError(t, err)
+			assert.Equal(t, 4, count)
+			assert.Equal(t, Version, header.Version)
+			assert.Equal(t, "memory", header.Source)
+
+			gauges, counters := target.Tenant("").GetAll()
+			assert.Equal(t, tt.gauges, gauges)
+			assert.Equal(t, tt.counters, counters)
+
+			gauges, counters = target.Tenant("team-a").GetAll()
+			assert.Equal(t, map[string]types.Gauge{"Alloc": 2}, gauges)
+			assert.Equal(t, map[string]types.Counter{"Requests;path=/": 3}, counters)
+		})
+	}
+}
+
+func TestRestoreErrors(t *testing.T) {
+	newer := func() *bytes.Buffer {
+		var buffer bytes.Buffer
+		zw := gzip.NewWriter(&buffer)
+		_, _ = zw.Write([]byte(`{"format":"devops-metrics","version":99}` + "\n"))
+		_ = zw.Close()
+
+		return &buffer
+	}
+
+	tests := []struct {
+		name    string
+		archive *bytes.Buffer
+		mode    string
+		err     error
+	}{
+		{
+			name:    "not gzip",
+			archive: bytes.NewBufferString("Alloc 1"),
+			mode:    ModeMerge,
+			err:     ErrInvalidArchive,
+		},
+		{
+			name:    "newer version",
+			archive: newer(),
+			mode:    ModeMerge,
+			err:     ErrUnsupportedVersion,
+		},
+		{
+			name:    "unknown mode",
+			archive: &bytes.Buffer{},
+			mode:    "overwrite",
+			err:     ErrUnknownMode,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := repositories.NewTenantMemoryRepository()
+
+			_, _, err := Restore(tt.archive, NewRepositoryStore(target), tt.mode)
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+}
+
+func TestAPIStore(t *testing.T) {
+	tenants := repositories.NewTenantMemoryRepository()
+	require.NoError(t, tenants.Tenant("").UpdateAll(types.Gauges{"Alloc": 0.123456}, types.Counters{"PollCount": 10}))
+
+	audit, err := services.NewAuditLogger(filepath.Join(t.TempDir(), "audit.log"))
+	require.NoError(t, err)
+
+	handler := handlers.NewHandler(tenants, services.NewMetricSigner("secret"), nil, nil, handlers.HandlerOptions{})
+	admin := handlers.NewAdminHandler(services.NewMetricAdmin(tenants, audit, nil))
+
+	r := chi.NewRouter()
+	r.With(middlewares.Tenant).Get("/", handler.GetAll())
+	r.With(middlewares.Tenant).Post("/updates/", handler.BulkSaveMetricJSON())
+	r.With(middlewares.AdminKey("admin"), middlewares.Tenant).Post("/admin/counter/{key}/reset", admin.ResetCounter())
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	api := NewAPIStore(client.New(ts.URL, "admin", services.NewMetricSigner("secret"), nil), nil, 1)
+
+	var archive bytes.Buffer
+	count, err := Backup(&archive, api, "server")
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	require.NoError(t, tenants.Tenant("").UpdateAll(types.Gauges{"Alloc": 5}, types.Counters{"PollCount": 7}))
+
+	_, count, err = Restore(&archive, api, ModeReplace)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	gauges, counters := tenants.Tenant("").GetAll()
+	assert.Equal(t, map[string]types.Gauge{"Alloc": 0.123456}, gauges)
+	assert.Equal(t, map[string]types.Counter{"PollCount": 10}, counters)
+}
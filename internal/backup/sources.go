@@ -0,0 +1,132 @@
+package backup
+
+import (
+	"errors"
+
+	"github.com/vllvll/devops/internal/client"
+	"github.com/vllvll/devops/internal/dictionaries"
+	"github.com/vllvll/devops/internal/repositories"
+	"github.com/vllvll/devops/internal/types"
+)
+
+type repositoryStore struct {
+	tenants repositories.TenantRepository
+}
+
+// NewRepositoryStore Источник и получатель метрик на основе репозитория (например, бд через StatsDatabase)
+func NewRepositoryStore(tenants repositories.TenantRepository) *repositoryStore {
+	return &repositoryStore{
+		tenants: tenants,
+	}
+}
+
+// Tenants Список арендаторов репозитория
+func (s *repositoryStore) Tenants() ([]string, error) {
+	return s.tenants.Tenants()
+}
+
+// GetAll Все метрики арендатора из репозитория
+func (s *repositoryStore) GetAll(tenantName string) (types.Gauges, types.Counters, error) {
+	gauges, counters := s.tenants.Tenant(tenantName).GetAll()
+
+	return gauges, counters, nil
+}
+
+// Restore Запись метрик арендатора в репозиторий. При replace значения Counter заменяются одной операцией,
+// поэтому при ошибке текущие значения не теряются
+func (s *repositoryStore) Restore(tenantName string, gauges types.Gauges, counters types.Counters, replace bool) error {
+	repository := s.tenants.Tenant(tenantName)
+
+	if replace {
+		return repository.ReplaceAll(gauges, counters)
+	}
+
+	return repository.UpdateAll(gauges, counters)
+}
+
+type apiStore struct {
+	client    *client.Client
+	tenants   []string // Арендаторы, метрики которых копируются (API не отдает список арендаторов)
+	batchSize int      // Количество метрик в одном запросе записи
+}
+
+// NewAPIStore Источник и получатель метрик на основе HTTP API запущенного сервера
+func NewAPIStore(client *client.Client, tenants []string, batchSize int) *apiStore {
+	if len(tenants) == 0 {
+		tenants = []string{""}
+	}
+
+	if batchSize < 1 {
+		batchSize = 1
+	}
+
+	return &apiStore{
+		client:    client,
+		tenants:   tenants,
+		batchSize: batchSize,
+	}
+}
+
+// Tenants Список арендаторов, заданный при создании
+func (s *apiStore) Tenants() ([]string, error) {
+	return s.tenants, nil
+}
+
+// GetAll Все метрики арендатора с сервера
+func (s *apiStore) GetAll(tenantName string) (types.Gauges, types.Counters, error) {
+	metrics, err := s.client.Metrics(tenantName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	gauges := types.Gauges{}
+	counters := types.Counters{}
+
+	for _, metric := range metrics {
+		switch {
+		case metric.MType == dictionaries.GaugeType && metric.Value != nil:
+			gauges[metric.ID] = types.Gauge(*metric.Value)
+		case metric.MType == dictionaries.CounterType && metric.Delta != nil:
+			counters[metric.ID] = types.Counter(*metric.Delta)
+		}
+	}
+
+	return gauges, counters, nil
+}
+
+// Restore Запись метрик арендатора на сервер пакетами. При replace текущие значения Counter предварительно
+// сбрасываются через API администрирования
+func (s *apiStore) Restore(tenantName string, gauges types.Gauges, counters types.Counters, replace bool) error {
+	if replace {
+		for key := range counters {
+			if err := s.client.ResetCounter(tenantName, key); err != nil && !errors.Is(err, client.ErrNotFound) {
+				return err
+			}
+		}
+	}
+
+	metrics := make([]types.Metrics, 0, len(gauges)+len(counters))
+
+	for _, key := range gaugeKeys(gauges) {
+		value := float64(gauges[key])
+		metrics = append(metrics, types.Metrics{ID: key, MType: dictionaries.GaugeType, Value: &value})
+	}
+
+	for _, key := range counterKeys(counters) {
+		delta := int64(counters[key])
+		metrics = append(metrics, types.Metrics{ID: key, MType: dictionaries.CounterType, Delta: &delta})
+	}
+
+	for start := 0; start < len(metrics); start += s.batchSize {
+		end := start + s.batchSize
+		if end > len(metrics) {
+			end = len(metrics)
+		}
+
+		if err := s.client.Push(tenantName, metrics[start:end]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
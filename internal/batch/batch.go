@@ -0,0 +1,88 @@
+// Package batch Учет идентификаторов пакетов метрик, чтобы повторная отправка пакета не применялась дважды
+package batch
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	conf "github.com/vllvll/devops/internal/config"
+)
+
+// Header Заголовок HTTP запроса с идентификатором пакета
+const Header = "X-Batch-ID"
+
+// DuplicateHeader Заголовок ответа (и метаданные gRPC) для пакета, который уже был применен
+const DuplicateHeader = "X-Batch-Duplicate"
+
+// MaxIDLength Максимальная длина идентификатора пакета
+const MaxIDLength = 128
+
+// ApplyTimeout Время, после которого неподтвержденная отметка пакета считается брошенной (экземпляр сервера
+// остановился до записи) и пакет можно применить повторно
+const ApplyTimeout = time.Minute
+
+// RetryAfter Рекомендуемая пауза в секундах перед повтором пакета, который еще применяется
+const RetryAfter = "1"
+
+var (
+	ErrDuplicate  = errors.New("batch has already been applied")
+	ErrInProgress = errors.New("batch is being applied, retry later")
+	ErrInvalidID  = errors.New("batch id is too long")
+)
+
+// Store Хранилище идентификаторов недавно примененных пакетов
+type Store interface {
+	// Claim Отметка пакета арендатора применяемым. ErrDuplicate - пакет уже был применен,
+	// ErrInProgress - пакет применяется другим запросом
+	Claim(tenant string, id string) error
+	// Apply Подтверждение отметки после записи метрик пакета
+	Apply(tenant string, id string) error
+	// Release Снятие отметки, если применить пакет не удалось
+	Release(tenant string, id string) error
+}
+
+// NewFromConfig Создание хранилища идентификаторов пакетов: в бд при наличии DSN, иначе в оперативной памяти
+func NewFromConfig(config *conf.ServerConfig, db *sql.DB) Store {
+	if config.DatabaseDsn != "" {
+		return NewDatabaseStore(db, config.BatchTTL)
+	}
+
+	return NewMemoryStore(config.BatchTTL, config.BatchMaxIDs)
+}
+
+// Claim Отметка пакета применяемым. Без хранилища или идентификатора пакет всегда применяется
+func Claim(store Store, tenant string, id string) error {
+	if store == nil || id == "" {
+		return nil
+	}
+
+	if len(id) > MaxIDLength {
+		return ErrInvalidID
+	}
+
+	return store.Claim(tenant, id)
+}
+
+// Apply Подтверждение отметки пакета после записи метрик. До подтверждения повтор пакета получает ErrInProgress
+func Apply(store Store, tenant string, id string) error {
+	if store == nil || id == "" {
+		return nil
+	}
+
+	return store.Apply(tenant, id)
+}
+
+// Release Снятие отметки пакета после ошибки применения
+func Release(store Store, tenant string, id string) error {
+	if store == nil || id == "" {
+		return nil
+	}
+
+	return store.Release(tenant, id)
+}
+
+// expired Время, раньше которого отметки пакетов устарели
+func expired(now time.Time, ttl time.Duration) time.Time {
+	return now.Add(-ttl)
+}
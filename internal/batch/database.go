@@ -0,0 +1,96 @@
+package batch
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+)
+
+type DatabaseStore struct {
+	db     *sql.DB       // База данных
+	ttl    time.Duration // Время хранения отметки
+	mu     sync.Mutex
+	purged time.Time // Время последнего удаления устаревших отметок
+}
+
+// NewDatabaseStore Создание хранилища идентификаторов пакетов в бд. Отметки видны всем экземплярам сервера
+// и сохраняются при перезапуске
+func NewDatabaseStore(db *sql.DB, ttl time.Duration) *DatabaseStore {
+	return &DatabaseStore{
+		db:  db,
+		ttl: ttl,
+	}
+}
+
+// Claim Отметка пакета арендатора применяемым. Устаревшая или брошенная отметка заменяется новой.
+// ErrDuplicate - пакет уже был применен, ErrInProgress - пакет применяется другим запросом
+func (s *DatabaseStore) Claim(tenant string, id string) error {
+	now := time.Now()
+
+	if err := s.purge(now); err != nil {
+		return err
+	}
+
+	result, err := s.db.Exec(`
+		INSERT INTO batches (tenant, id, claimed_at, applied) VALUES ($1, $2, $3, false)
+		ON CONFLICT (tenant, id) DO UPDATE SET claimed_at = EXCLUDED.claimed_at, applied = false
+			WHERE batches.claimed_at < $4 OR (NOT batches.applied AND batches.claimed_at < $5)`,
+		tenant, id, now, expired(now, s.ttl), expired(now, ApplyTimeout),
+	)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 1 {
+		return nil
+	}
+
+	var applied bool
+	err = s.db.QueryRow("SELECT applied FROM batches WHERE tenant = $1 AND id = $2", tenant, id).Scan(&applied)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	// Отметка, снятая после неудачной записи, тоже требует повтора
+	if !applied {
+		return ErrInProgress
+	}
+
+	return ErrDuplicate
+}
+
+// Apply Подтверждение отметки после записи метрик пакета
+func (s *DatabaseStore) Apply(tenant string, id string) error {
+	_, err := s.db.Exec("UPDATE batches SET applied = true WHERE tenant = $1 AND id = $2", tenant, id)
+
+	return err
+}
+
+// Release Снятие отметки, если применить пакет не удалось
+func (s *DatabaseStore) Release(tenant string, id string) error {
+	_, err := s.db.Exec("DELETE FROM batches WHERE tenant = $1 AND id = $2", tenant, id)
+
+	return err
+}
+
+// purge Удаление устаревших отметок не чаще одного раза за время хранения
+func (s *DatabaseStore) purge(now time.Time) error {
+	s.mu.Lock()
+	if now.Sub(s.purged) < s.ttl {
+		s.mu.Unlock()
+
+		return nil
+	}
+	s.purged = now
+	s.mu.Unlock()
+
+	_, err := s.db.Exec("DELETE FROM batches WHERE claimed_at < $1", expired(now, s.ttl))
+
+	return err
+}
@@ -0,0 +1,110 @@
+package batch
+
+import (
+	"sync"
+	"time"
+)
+
+// entry Отметка пакета в порядке добавления
+type entry struct {
+	key     string
+	claimed time.Time
+}
+
+// mark Отметка пакета
+type mark struct {
+	claimed time.Time // Время отметки
+	applied bool      // Метрики пакета записаны
+}
+
+// MemoryStore Хранилище идентификаторов пакетов в оперативной памяти
+type MemoryStore struct {
+	mu      sync.Mutex
+	ttl     time.Duration   // Время хранения отметки
+	maxIDs  int             // Максимальное количество отметок (0 - без ограничения)
+	claimed map[string]mark // Отметки по ключу арендатор/пакет
+	order   []entry         // Отметки в порядке добавления для удаления устаревших
+	now     func() time.Time
+}
+
+// NewMemoryStore Создание хранилища идентификаторов пакетов в оперативной памяти
+func NewMemoryStore(ttl time.Duration, maxIDs int) *MemoryStore {
+	return &MemoryStore{
+		ttl:     ttl,
+		maxIDs:  maxIDs,
+		claimed: make(map[string]mark),
+		now:     time.Now,
+	}
+}
+
+// Claim Отметка пакета арендатора применяемым. ErrDuplicate - пакет уже был применен,
+// ErrInProgress - пакет применяется другим запросом
+func (s *MemoryStore) Claim(tenant string, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+
+	key := tenant + "/" + id
+	if m, ok := s.claimed[key]; ok && !m.claimed.Before(expired(now, s.ttl)) {
+		if m.applied {
+			return ErrDuplicate
+		}
+
+		if !m.claimed.Before(expired(now, ApplyTimeout)) {
+			return ErrInProgress
+		}
+	}
+
+	s.expire(now)
+
+	s.claimed[key] = mark{claimed: now}
+	s.order = append(s.order, entry{key: key, claimed: now})
+
+	return nil
+}
+
+// Apply Подтверждение отметки после записи метрик пакета
+func (s *MemoryStore) Apply(tenant string, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := tenant + "/" + id
+	if m, ok := s.claimed[key]; ok {
+		m.applied = true
+		s.claimed[key] = m
+	}
+
+	return nil
+}
+
+// Release Снятие отметки, если применить пакет не удалось
+func (s *MemoryStore) Release(tenant string, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.claimed, tenant+"/"+id)
+
+	return nil
+}
+
+// expire Удаление устаревших отметок и самых старых отметок, чтобы освободить место для новой
+func (s *MemoryStore) expire(now time.Time) {
+	cutoff := expired(now, s.ttl)
+
+	var removed int
+	for _, e := range s.order {
+		if !e.claimed.Before(cutoff) && (s.maxIDs <= 0 || len(s.claimed) < s.maxIDs) {
+			break
+		}
+
+		// Отметка могла быть снята и поставлена заново, тогда в order есть более новая запись
+		if m, ok := s.claimed[e.key]; ok && m.claimed.Equal(e.claimed) {
+			delete(s.claimed, e.key)
+		}
+
+		removed++
+	}
+
+	s.order = s.order[removed:]
+}
@@ -0,0 +1,70 @@
+package batch
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore_Claim(t *testing.T) {
+	store := NewMemoryStore(time.Minute, 0)
+
+	require.NoError(t, Claim(store, "team-a", "b1"))
+	assert.ErrorIs(t, Claim(store, "team-a", "b1"), ErrInProgress)
+
+	require.NoError(t, Apply(store, "team-a", "b1"))
+	assert.ErrorIs(t, Claim(store, "team-a", "b1"), ErrDuplicate)
+
+	// Идентификаторы пакетов разных арендаторов не пересекаются
+	assert.NoError(t, Claim(store, "team-b", "b1"))
+
+	require.NoError(t, Release(store, "team-a", "b1"))
+	assert.NoError(t, Claim(store, "team-a", "b1"))
+}
+
+func TestMemoryStore_Expire(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	store := NewMemoryStore(time.Minute, 2)
+	store.now = func() time.Time { return now }
+
+	require.NoError(t, Claim(store, "", "b1"))
+	require.NoError(t, Apply(store, "", "b1"))
+
+	now = now.Add(30 * time.Second)
+	require.NoError(t, Claim(store, "", "b2"))
+	require.NoError(t, Apply(store, "", "b2"))
+	assert.ErrorIs(t, Claim(store, "", "b1"), ErrDuplicate)
+
+	now = now.Add(40 * time.Second)
+	assert.NoError(t, Claim(store, "", "b1"), "expired batch id")
+	assert.ErrorIs(t, Claim(store, "", "b2"), ErrDuplicate)
+
+	require.NoError(t, Claim(store, "", "b3"))
+	assert.NoError(t, Claim(store, "", "b2"), "oldest batch id over the capacity")
+}
+
+func TestMemoryStore_ApplyTimeout(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	store := NewMemoryStore(time.Hour, 0)
+	store.now = func() time.Time { return now }
+
+	require.NoError(t, Claim(store, "", "b1"))
+
+	now = now.Add(ApplyTimeout / 2)
+	assert.ErrorIs(t, Claim(store, "", "b1"), ErrInProgress)
+
+	// Экземпляр сервера остановился между отметкой и записью: брошенную отметку можно занять повторно
+	now = now.Add(ApplyTimeout)
+	assert.NoError(t, Claim(store, "", "b1"))
+}
+
+func TestClaim(t *testing.T) {
+	assert.NoError(t, Claim(nil, "", "b1"))
+	assert.NoError(t, Claim(NewMemoryStore(time.Minute, 0), "", ""))
+	assert.ErrorIs(t, Claim(NewMemoryStore(time.Minute, 0), "", strings.Repeat("a", MaxIDLength+1)), ErrInvalidID)
+}
@@ -0,0 +1,254 @@
+// Package client Клиент HTTP API сервера метрик для утилит командной строки
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-resty/resty/v2"
+
+	"github.com/vllvll/devops/internal/batch"
+	"github.com/vllvll/devops/internal/dictionaries"
+	"github.com/vllvll/devops/internal/services"
+	"github.com/vllvll/devops/internal/tenant"
+	"github.com/vllvll/devops/internal/types"
+)
+
+// ErrNotFound Метрика не найдена на сервере
+var ErrNotFound = errors.New("metric not found")
+
+// API Операции с метриками, общие для HTTP и gRPC клиентов
+type API interface {
+	Metric(tenantName string, mType string, id string) (types.Metrics, error)
+	List(tenantName string, mType string, pattern string) ([]types.Metrics, error)
+	Push(tenantName string, metrics []types.Metrics) error
+}
+
+type Client struct {
+	http    *resty.Client    // HTTP клиент
+	signer  services.Signer  // Сервис для подписи метрик
+	encrypt services.Encrypt // Сервис для ассиметричного шифрования (nil - без шифрования)
+}
+
+// New Создание клиента сервера address (ip:port или URL). Токен или ключ администратора передается
+// в заголовке Authorization
+func New(address string, token string, signer services.Signer, encrypt services.Encrypt) *Client {
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+
+	client := resty.New().
+		SetBaseURL(strings.TrimRight(address, "/")).
+		SetHeader("Content-Type", "application/json")
+
+	if parsed, err := url.Parse(address); err == nil {
+		client.SetHeader("X-Real-IP", localIP(parsed.Host))
+	}
+
+	if token != "" {
+		client.SetAuthToken(token)
+	}
+
+	return &Client{
+		http:    client,
+		signer:  signer,
+		encrypt: encrypt,
+	}
+}
+
+// Metrics Получение всех метрик арендатора
+func (c *Client) Metrics(tenantName string) ([]types.Metrics, error) {
+	response, err := c.request(tenantName).
+		SetHeader("Accept", "application/json").
+		Get("/")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkResponse(response); err != nil {
+		return nil, err
+	}
+
+	var metrics []types.Metrics
+	if err := json.Unmarshal(response.Body(), &metrics); err != nil {
+		return nil, err
+	}
+
+	return metrics, nil
+}
+
+// Metric Получение значения метрики арендатора
+func (c *Client) Metric(tenantName string, mType string, id string) (types.Metrics, error) {
+	var metric types.Metrics
+
+	content, err := json.Marshal(types.Metrics{ID: id, MType: mType})
+	if err != nil {
+		return metric, err
+	}
+
+	response, err := c.request(tenantName).
+		SetBody(content).
+		Post("/value/")
+	if err != nil {
+		return metric, err
+	}
+
+	if response.StatusCode() == http.StatusNotFound {
+		return metric, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+
+	if err := checkResponse(response); err != nil {
+		return metric, err
+	}
+
+	if err := json.Unmarshal(response.Body(), &metric); err != nil {
+		return metric, err
+	}
+
+	return metric, nil
+}
+
+// List Получение метрик арендатора, отсортированных по типу и имени. Пустые mType и pattern не ограничивают выборку
+func (c *Client) List(tenantName string, mType string, pattern string) ([]types.Metrics, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %w", err)
+	}
+
+	metrics, err := c.Metrics(tenantName)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := make([]types.Metrics, 0, len(metrics))
+	for _, metric := range metrics {
+		if (mType == "" || metric.MType == mType) && re.MatchString(metric.ID) {
+			filtered = append(filtered, metric)
+		}
+	}
+
+	sort.Slice(filtered, func(i, j int) bool {
+		if filtered[i].MType != filtered[j].MType {
+			return filtered[i].MType == dictionaries.GaugeType
+		}
+
+		return filtered[i].ID < filtered[j].ID
+	})
+
+	return filtered, nil
+}
+
+// Push Запись метрик арендатора одним запросом. Метрики подписываются ключом клиента
+func (c *Client) Push(tenantName string, metrics []types.Metrics) error {
+	return c.PushBatch(tenantName, "", metrics)
+}
+
+// PushBatch Запись пакета метрик арендатора с идентификатором batchID. Сервер не применяет пакет
+// с тем же идентификатором дважды, поэтому пакет можно отправлять повторно. Пустой batchID - без идентификатора
+func (c *Client) PushBatch(tenantName string, batchID string, metrics []types.Metrics) error {
+	signed := make([]types.Metrics, 0, len(metrics))
+
+	for _, metric := range metrics {
+		switch metric.MType {
+		case dictionaries.GaugeType:
+			metric.Hash = c.signer.GetHashGauge(metric.ID, *metric.Value)
+		case dictionaries.CounterType:
+			metric.Hash = c.signer.GetHashCounter(metric.ID, *metric.Delta)
+		}
+
+		metric.Tenant = ""
+		signed = append(signed, metric)
+	}
+
+	content, err := json.Marshal(signed)
+	if err != nil {
+		return err
+	}
+
+	if c.encrypt != nil {
+		content, err = c.encrypt.Encrypt(content)
+		if err != nil {
+			return err
+		}
+	}
+
+	request := c.request(tenantName)
+	if batchID != "" {
+		request.SetHeader(batch.Header, batchID)
+	}
+
+	response, err := request.
+		SetBody(content).
+		Post("/updates/")
+	if err != nil {
+		return err
+	}
+
+	return checkResponse(response)
+}
+
+// ResetCounter Сброс значения метрики типа Counter. Требует прав администратора
+func (c *Client) ResetCounter(tenantName string, key string) error {
+	response, err := c.request(tenantName).
+		Post("/admin/counter/" + url.PathEscape(key) + "/reset")
+	if err != nil {
+		return err
+	}
+
+	if response.StatusCode() == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+
+	return checkResponse(response)
+}
+
+// SetHeader Заголовок, который передается во всех запросах клиента
+func (c *Client) SetHeader(key string, value string) {
+	c.http.SetHeader(key, value)
+}
+
+// request Запрос от имени арендатора
+func (c *Client) request(tenantName string) *resty.Request {
+	request := c.http.R()
+	if tenantName != "" {
+		request.SetHeader(tenant.Header, tenantName)
+	}
+
+	return request
+}
+
+// localIP IP адрес, с которого клиент подключается к серверу. Передается серверу для проверки доверенной подсети
+func localIP(host string) string {
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "80")
+	}
+
+	// Для UDP соединение не устанавливается, выбирается только локальный адрес
+	conn, err := net.Dial("udp", host)
+	if err != nil {
+		return ""
+	}
+	defer conn.Close()
+
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+		return addr.IP.String()
+	}
+
+	return ""
+}
+
+// checkResponse Ошибка для ответов сервера с кодом 4xx и 5xx
+func checkResponse(response *resty.Response) error {
+	if !response.IsError() {
+		return nil
+	}
+
+	return fmt.Errorf("%s %s: %s: %s", response.Request.Method, response.Request.URL, response.Status(), strings.TrimSpace(string(response.Body())))
+}
@@ -0,0 +1,146 @@
+package client
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/vllvll/devops/internal/auth"
+	"github.com/vllvll/devops/internal/dictionaries"
+	"github.com/vllvll/devops/internal/handlers"
+	"github.com/vllvll/devops/internal/middlewares"
+	"github.com/vllvll/devops/internal/repositories"
+	"github.com/vllvll/devops/internal/services"
+	"github.com/vllvll/devops/internal/tenant"
+	"github.com/vllvll/devops/internal/types"
+	pb "github.com/vllvll/devops/proto"
+)
+
+func TestClient(t *testing.T) {
+	tenants := repositories.NewTenantMemoryRepository()
+	require.NoError(t, tenants.Tenant("team-a").UpdateAll(types.Gauges{"HeapAlloc": 7, "Alloc": 1.5}, types.Counters{"PollCount": 10}))
+
+	handler := handlers.NewHandler(tenants, services.NewMetricSigner("secret"), nil, nil, handlers.HandlerOptions{})
+
+	// Выбрать арендатора может токен администратора
+	store := auth.NewFileStore(filepath.Join(t.TempDir(), "tokens.json"))
+	secret, token, err := auth.Generate("metricsctl", []string{auth.ScopeAdmin}, "")
+	require.NoError(t, err)
+	require.NoError(t, store.Save(token))
+
+	r := chi.NewRouter()
+	r.Use(middlewares.Authenticate(store), middlewares.Tenant)
+	r.Get("/", handler.GetAll())
+	r.Post("/value/", handler.GetMetricJSON())
+	r.Post("/updates/", handler.BulkSaveMetricJSON())
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	api := New(ts.URL, secret, services.NewMetricSigner("secret"), nil)
+
+	delta := int64(5)
+	require.NoError(t, api.Push("team-a", []types.Metrics{{ID: "PollCount", MType: dictionaries.CounterType, Delta: &delta}}))
+
+	metric, err := api.Metric("team-a", dictionaries.CounterType, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(15), *metric.Delta)
+
+	_, err = api.Metric("team-a", dictionaries.GaugeType, "Unknown")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	metrics, err := api.List("team-a", "", "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Alloc", "HeapAlloc", "PollCount"}, metricIDs(metrics))
+
+	metrics, err = api.List("team-a", dictionaries.GaugeType, "^Heap")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"HeapAlloc"}, metricIDs(metrics))
+
+	_, err = api.List("team-a", "", "(")
+	assert.Error(t, err)
+}
+
+// metricsServer Сервер метрик для проверки gRPC клиента
+type metricsServer struct {
+	pb.UnimplementedMetricsServer
+
+	tenants repositories.TenantRepository
+}
+
+func (s *metricsServer) GetMetric(ctx context.Context, in *pb.GetMetricRequest) (*pb.Metric, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(tenant.MetadataKey); len(values) == 0 || values[0] != "team-a" {
+		return nil, status.Error(codes.PermissionDenied, "Unknown tenant")
+	}
+
+	value, err := s.tenants.Tenant("team-a").GetGaugeByKey(in.GetId())
+	if err != nil {
+		return nil, status.Error(codes.NotFound, "Metric not found")
+	}
+
+	gauge := float64(value)
+
+	return &pb.Metric{Id: in.GetId(), Type: in.GetType(), Value: &gauge}, nil
+}
+
+func (s *metricsServer) BulkSaveMetrics(ctx context.Context, in *pb.AddBulkMetricsRequest) (*emptypb.Empty, error) {
+	for _, metric := range in.GetMetrics().GetMetrics() {
+		if !services.NewMetricSigner("secret").IsEqualHashGauge(metric.GetId(), metric.GetValue(), metric.GetHash()) {
+			return nil, status.Error(codes.InvalidArgument, "Hash not equal for gauge type")
+		}
+
+		s.tenants.Tenant("team-a").UpdateGauge(metric.GetId(), types.Gauge(metric.GetValue()))
+	}
+
+	return new(emptypb.Empty), nil
+}
+
+func TestGRPCClient(t *testing.T) {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := grpc.NewServer()
+	pb.RegisterMetricsServer(server, &metricsServer{tenants: repositories.NewTenantMemoryRepository()})
+
+	go server.Serve(listen)
+	defer server.Stop()
+
+	api, err := NewGRPC(listen.Addr().String(), "", services.NewMetricSigner("secret"))
+	require.NoError(t, err)
+	defer api.Close()
+
+	value := 1.5
+	require.NoError(t, api.Push("team-a", []types.Metrics{{ID: "Alloc", MType: dictionaries.GaugeType, Value: &value}}))
+
+	metric, err := api.Metric("team-a", dictionaries.GaugeType, "Alloc")
+	require.NoError(t, err)
+	assert.Equal(t, dictionaries.GaugeType, metric.MType)
+	assert.Equal(t, 1.5, *metric.Value)
+
+	_, err = api.Metric("team-a", dictionaries.GaugeType, "Unknown")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = api.Metric("team-b", dictionaries.GaugeType, "Alloc")
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func metricIDs(metrics []types.Metrics) []string {
+	ids := make([]string, 0, len(metrics))
+	for _, metric := range metrics {
+		ids = append(ids, metric.ID)
+	}
+
+	return ids
+}
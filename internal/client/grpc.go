@@ -0,0 +1,173 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/vllvll/devops/internal/dictionaries"
+	"github.com/vllvll/devops/internal/services"
+	"github.com/vllvll/devops/internal/tenant"
+	"github.com/vllvll/devops/internal/types"
+	pb "github.com/vllvll/devops/proto"
+)
+
+// timeout Время ожидания ответа gRPC сервера
+const timeout = 10 * time.Second
+
+type GRPCClient struct {
+	conn   *grpc.ClientConn // Соединение с сервером
+	client pb.MetricsClient // Клиент сервиса метрик
+	signer services.Signer  // Сервис для подписи метрик
+	token  string           // Токен доступа к серверу
+	ip     string           // IP клиента для проверки доверенной подсети
+	header metadata.MD      // Метаданные, которые передаются во всех запросах клиента
+}
+
+// NewGRPC Создание клиента gRPC сервера address (ip:port). Соединение устанавливается при первом запросе
+func NewGRPC(address string, token string, signer services.Signer) (*GRPCClient, error) {
+	conn, err := grpc.Dial(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+
+	return &GRPCClient{
+		conn:   conn,
+		client: pb.NewMetricsClient(conn),
+		signer: signer,
+		token:  token,
+		ip:     localIP(address),
+		header: metadata.MD{},
+	}, nil
+}
+
+// Metric Получение значения метрики арендатора
+func (c *GRPCClient) Metric(tenantName string, mType string, id string) (types.Metrics, error) {
+	ctx, cancel := c.context(tenantName)
+	defer cancel()
+
+	metric, err := c.client.GetMetric(ctx, &pb.GetMetricRequest{Type: metricType(mType), Id: id})
+	if status.Code(err) == codes.NotFound {
+		return types.Metrics{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+
+	if err != nil {
+		return types.Metrics{}, err
+	}
+
+	return fromProto(metric), nil
+}
+
+// List Получение метрик арендатора, отсортированных по типу и имени. Фильтрация выполняется сервером
+func (c *GRPCClient) List(tenantName string, mType string, pattern string) ([]types.Metrics, error) {
+	ctx, cancel := c.context(tenantName)
+	defer cancel()
+
+	response, err := c.client.ListMetrics(ctx, &pb.ListMetricsRequest{Type: metricType(mType), Pattern: pattern})
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := make([]types.Metrics, 0, len(response.GetMetrics()))
+	for _, metric := range response.GetMetrics() {
+		metrics = append(metrics, fromProto(metric))
+	}
+
+	return metrics, nil
+}
+
+// Push Запись метрик арендатора одним запросом. Метрики подписываются ключом клиента
+func (c *GRPCClient) Push(tenantName string, metrics []types.Metrics) error {
+	return c.PushBatch(tenantName, "", metrics)
+}
+
+// PushBatch Запись пакета метрик арендатора с идентификатором batchID. Пустой batchID - без идентификатора
+func (c *GRPCClient) PushBatch(tenantName string, batchID string, metrics []types.Metrics) error {
+	bulk := make([]*pb.Metric, 0, len(metrics))
+
+	for _, metric := range metrics {
+		var hash string
+
+		switch metric.MType {
+		case dictionaries.GaugeType:
+			hash = c.signer.GetHashGauge(metric.ID, *metric.Value)
+		case dictionaries.CounterType:
+			hash = c.signer.GetHashCounter(metric.ID, *metric.Delta)
+		}
+
+		bulk = append(bulk, &pb.Metric{
+			Id:    metric.ID,
+			Type:  metricType(metric.MType),
+			Delta: metric.Delta,
+			Value: metric.Value,
+			Hash:  &hash,
+		})
+	}
+
+	ctx, cancel := c.context(tenantName)
+	defer cancel()
+
+	_, err := c.client.BulkSaveMetrics(ctx, &pb.AddBulkMetricsRequest{Metrics: &pb.BulkMetrics{Metrics: bulk}, BatchId: batchID})
+
+	return err
+}
+
+// SetHeader Метаданные, которые передаются во всех запросах клиента
+func (c *GRPCClient) SetHeader(key string, value string) {
+	c.header.Set(key, value)
+}
+
+// Close Закрытие соединения с сервером
+func (c *GRPCClient) Close() error {
+	return c.conn.Close()
+}
+
+// context Контекст запроса с метаданными ip, authorization и арендатором
+func (c *GRPCClient) context(tenantName string) (context.Context, context.CancelFunc) {
+	md := metadata.Join(c.header, metadata.New(map[string]string{"ip": c.ip}))
+	if c.token != "" {
+		md.Set("authorization", "Bearer "+c.token)
+	}
+	if tenantName != "" {
+		md.Set(tenant.MetadataKey, tenantName)
+	}
+
+	return context.WithTimeout(metadata.NewOutgoingContext(context.Background(), md), timeout)
+}
+
+// metricType Тип метрики protobuf. Для пустой строки возвращается UNKNOWN
+func metricType(mType string) pb.Metric_Type {
+	switch mType {
+	case dictionaries.GaugeType:
+		return pb.Metric_GAUGE
+	case dictionaries.CounterType:
+		return pb.Metric_COUNTER
+	}
+
+	return pb.Metric_UNKNOWN
+}
+
+// fromProto Преобразование метрики protobuf в метрику сервера
+func fromProto(metric *pb.Metric) types.Metrics {
+	result := types.Metrics{
+		ID:    metric.GetId(),
+		Delta: metric.Delta,
+		Value: metric.Value,
+		Hash:  metric.GetHash(),
+	}
+
+	switch metric.GetType() {
+	case pb.Metric_GAUGE:
+		result.MType = dictionaries.GaugeType
+	case pb.Metric_COUNTER:
+		result.MType = dictionaries.CounterType
+	}
+
+	return result
+}
@@ -22,14 +22,29 @@ type jsonAgentConfig struct {
 	ReportInterval string `json:"report_interval"`
 	PollInterval   string `json:"poll_interval"`
 	CryptoKey      string `json:"crypto_key"`
+	StatsDAddress  string `json:"statsd_address"`
+	Tenant         string `json:"tenant"`
+	AgentID        string `json:"agent_id"`
+	Cumulative     bool   `json:"cumulative_counters"`
+	LogLevel       string `json:"log_level"`
+	LogFormat      string `json:"log_format"`
+	LogSampling    bool   `json:"log_sampling"`
 }
 
 type AgentConfig struct {
-	Address        string        `env:"ADDRESS"`         // Адрес для отправки значений
-	ReportInterval time.Duration `env:"REPORT_INTERVAL"` // Периодичность отправки значений на сервер
-	PollInterval   time.Duration `env:"POLL_INTERVAL"`   // Периодичность получения значений
-	Key            string        `env:"KEY"`             // Ключ шифрования сообщений
-	CryptoKey      string        `env:"CRYPTO_KEY"`      // Путь до файла с публичным ключом
+	Address        string        `env:"ADDRESS"`             // Адрес для отправки значений
+	ReportInterval time.Duration `env:"REPORT_INTERVAL"`     // Периодичность отправки значений на сервер
+	PollInterval   time.Duration `env:"POLL_INTERVAL"`       // Периодичность получения значений
+	Key            string        `env:"KEY"`                 // Ключ шифрования сообщений
+	CryptoKey      string        `env:"CRYPTO_KEY"`          // Путь до файла с публичным ключом
+	StatsDAddress  string        `env:"STATSD_ADDRESS"`      // Адрес UDP для приема метрик StatsD (пустой - отключено)
+	Token          string        `env:"TOKEN"`               // Токен доступа к серверу
+	Tenant         string        `env:"TENANT"`              // Арендатор, от имени которого отправляются метрики
+	AgentID        string        `env:"AGENT_ID"`            // Идентификатор агента (по умолчанию - имя хоста)
+	Cumulative     bool          `env:"CUMULATIVE_COUNTERS"` // Отправка накопленных с запуска агента значений Counter вместо приращений
+	LogLevel       string        `env:"LOG_LEVEL"`           // Уровень логирования: debug, info, warn, error
+	LogFormat      string        `env:"LOG_FORMAT"`          // Формат логов: json или text
+	LogSampling    bool          `env:"LOG_SAMPLING"`        // Сэмплирование повторяющихся сообщений
 }
 
 // CreateAgentConfig возвращает структуру конфига AgentConfig со значениями для работы агента.
@@ -42,6 +57,8 @@ func CreateAgentConfig() (*AgentConfig, error) {
 		Address:        "127.0.0.1:8080",
 		ReportInterval: "10s",
 		PollInterval:   "2s",
+		LogLevel:       "info",
+		LogFormat:      "json",
 	}
 
 	jsonConfigFlag := flag.NewFlagSet("file", flag.ContinueOnError)
@@ -82,6 +99,14 @@ func CreateAgentConfig() (*AgentConfig, error) {
 	flag.DurationVarP(&config.PollInterval, "poll", "p", pollInterval, "Poll interval. Format: any input valid for time.ParseDuration (for example: 1s)")
 	flag.StringVarP(&config.Key, "key", "k", "", "Key. Format: string (for example: ?)")
 	flag.StringVarP(&config.CryptoKey, "crypto-key", "y", jsonConfig.CryptoKey, "Path for public key")
+	flag.StringVar(&config.Token, "token", "", "Access token. Format: string (for example: ?)")
+	flag.StringVar(&config.Tenant, "tenant", jsonConfig.Tenant, "Tenant. Format: string (for example: team-a)")
+	flag.StringVar(&config.AgentID, "agent-id", jsonConfig.AgentID, "Agent id. Format: string (for example: web-1)")
+	flag.BoolVar(&config.Cumulative, "cumulative-counters", jsonConfig.Cumulative, "Send counter totals since agent start instead of deltas. Format: bool (for example: true)")
+	flag.StringVar(&config.LogLevel, "log-level", jsonConfig.LogLevel, "Log level. Format: debug, info, warn, error")
+	flag.StringVar(&config.LogFormat, "log-format", jsonConfig.LogFormat, "Log format. Format: json or text")
+	flag.BoolVar(&config.LogSampling, "log-sampling", jsonConfig.LogSampling, "Log sampling. Format: bool (for example: true)")
+	flag.StringVar(&config.StatsDAddress, "statsd-address", jsonConfig.StatsDAddress, "StatsD UDP address. Format: ip:port (for example: 127.0.0.1:8125)")
 
 	flag.Parse()
 
@@ -90,6 +115,10 @@ func CreateAgentConfig() (*AgentConfig, error) {
 		return nil, err
 	}
 
+	if config.AgentID == "" {
+		config.AgentID, _ = os.Hostname()
+	}
+
 	return &config, nil
 }
 
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/caarlos0/env/v6"
+	flag "github.com/spf13/pflag"
+)
+
+type jsonProxyConfig struct {
+	Address        string   `json:"address"`
+	GRPCAddress    string   `json:"grpc_address"`
+	Backends       []string `json:"backends"`
+	HealthInterval string   `json:"health_interval"`
+	Replicas       int      `json:"replicas"`
+	CryptoKey      string   `json:"crypto_key"`
+	LogLevel       string   `json:"log_level"`
+	LogFormat      string   `json:"log_format"`
+	LogSampling    bool     `json:"log_sampling"`
+}
+
+type ProxyConfig struct {
+	Address        string        `env:"ADDRESS"`                   // Адрес запуска HTTP-сервера прокси
+	GRPCAddress    string        `env:"GRPC_ADDRESS"`              // Адрес запуска gRPC-сервера прокси (пустой - отключено)
+	Backends       []string      `env:"BACKENDS" envSeparator:","` // HTTP адреса серверов метрик
+	HealthInterval time.Duration `env:"HEALTH_INTERVAL"`           // Интервал проверки готовности серверов (0 - без проверки, все серверы считаются доступными)
+	Replicas       int           `env:"REPLICAS"`                  // Количество виртуальных узлов сервера в кольце согласованного хеширования
+	Key            string        `env:"KEY"`                       // Ключ подписи значений, объединенных из ответов серверов
+	CryptoKey      string        `env:"CRYPTO_KEY"`                // Путь до файла с приватным ключом для расшифрования запросов
+	LogLevel       string        `env:"LOG_LEVEL"`                 // Уровень логирования: debug, info, warn, error
+	LogFormat      string        `env:"LOG_FORMAT"`                // Формат логов: json или text
+	LogSampling    bool          `env:"LOG_SAMPLING"`              // Сэмплирование повторяющихся сообщений
+}
+
+// CreateProxyConfig возвращает структуру конфига ProxyConfig со значениями для работы прокси.
+// Значения для конфига задаются через флаги или переменные окружения
+// Приоритет значений у переменных окружения
+func CreateProxyConfig() (*ProxyConfig, error) {
+	var config ProxyConfig
+	var jsonFileConfig fileConfig
+	var jsonConfig = jsonProxyConfig{
+		Address:        "127.0.0.1:8090",
+		HealthInterval: "5s",
+		Replicas:       100,
+		LogLevel:       "info",
+		LogFormat:      "json",
+	}
+
+	jsonConfigFlag := flag.NewFlagSet("file", flag.ContinueOnError)
+	jsonConfigFlag.StringVarP(&jsonFileConfig.JSONConfig, "config", "c", "", "JSON Config file")
+	err := jsonConfigFlag.Parse([]string{"c"})
+	if err != nil {
+		return nil, err
+	}
+
+	err = env.Parse(&jsonFileConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if jsonFileConfig.JSONConfig != "" {
+		content, err := os.ReadFile(jsonFileConfig.JSONConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal(content, &jsonConfig); err != nil {
+			return nil, err
+		}
+	}
+
+	healthInterval, err := time.ParseDuration(jsonConfig.HealthInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	flag.StringVarP(&config.Address, "address", "a", jsonConfig.Address, "Address. Format: ip:port (for example: 127.0.0.1:8090)")
+	flag.StringVar(&config.GRPCAddress, "grpc-address", jsonConfig.GRPCAddress, "gRPC address, empty disables gRPC. Format: ip:port (for example: 127.0.0.1:3200)")
+	flag.StringSliceVarP(&config.Backends, "backends", "b", jsonConfig.Backends, "Metrics servers. Format: comma separated ip:port list (for example: 10.0.0.1:8080,10.0.0.2:8080)")
+	flag.DurationVar(&config.HealthInterval, "health-interval", healthInterval, "Backend health check interval. Format: any input valid for time.ParseDuration (for example: 5s)")
+	flag.IntVar(&config.Replicas, "replicas", jsonConfig.Replicas, "Virtual nodes per backend on the hash ring. Format: int (for example: 100)")
+	flag.StringVarP(&config.Key, "key", "k", "", "Key. Format: string (for example: ?)")
+	flag.StringVarP(&config.CryptoKey, "crypto-key", "y", jsonConfig.CryptoKey, "Path for private key")
+	flag.StringVar(&config.LogLevel, "log-level", jsonConfig.LogLevel, "Log level. Format: debug, info, warn, error")
+	flag.StringVar(&config.LogFormat, "log-format", jsonConfig.LogFormat, "Log format. Format: json or text")
+	flag.BoolVar(&config.LogSampling, "log-sampling", jsonConfig.LogSampling, "Log sampling. Format: bool (for example: true)")
+
+	flag.Parse()
+
+	err = env.Parse(&config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
@@ -0,0 +1,50 @@
+package federation
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff Экспоненциальная задержка повторов со случайным разбросом
+type Backoff struct {
+	min     time.Duration
+	max     time.Duration
+	current time.Duration  // Задержка последнего повтора (0 - повторов не было)
+	jitter  func() float64 // Случайное число в [0, 1)
+}
+
+// NewBackoff Создание задержки, которая удваивается от min до max
+func NewBackoff(min time.Duration, max time.Duration) *Backoff {
+	if max < min {
+		max = min
+	}
+
+	return &Backoff{
+		min:    min,
+		max:    max,
+		jitter: rand.Float64,
+	}
+}
+
+// Next Задержка следующего повтора: от половины до полного значения текущей задержки,
+// чтобы серверы разных датацентров не повторяли отправку одновременно
+func (b *Backoff) Next() time.Duration {
+	switch {
+	case b.current == 0:
+		b.current = b.min
+	case b.current < b.max:
+		b.current *= 2
+		if b.current > b.max {
+			b.current = b.max
+		}
+	}
+
+	half := b.current / 2
+
+	return half + time.Duration(b.jitter()*float64(b.current-half))
+}
+
+// Reset Сброс задержки после успешной отправки
+func (b *Backoff) Reset() {
+	b.current = 0
+}
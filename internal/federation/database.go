@@ -0,0 +1,156 @@
+package federation
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// DatabaseSpool Очередь пакетов в бд. Очередь и значения счетчиков общие для всех экземпляров сервера:
+// новый ведущий отправляет пакеты предыдущего и продолжает сбор с его значений счетчиков
+type DatabaseSpool struct {
+	db   *sql.DB // База данных
+	size int     // Максимальное количество пакетов
+}
+
+// NewDatabaseSpool Создание очереди пакетов в бд не больше чем из size пакетов
+func NewDatabaseSpool(db *sql.DB, size int) *DatabaseSpool {
+	return &DatabaseSpool{db: db, size: size}
+}
+
+// Append Добавление пакетов и сохранение значений счетчиков в одной транзакции
+func (s *DatabaseSpool) Append(batches []Batch, sent Sent) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Блокировка на время транзакции: при смене ведущего старый и новый экземпляры не меняют очередь одновременно
+	if _, err := tx.Exec("LOCK TABLE federation_batches IN EXCLUSIVE MODE"); err != nil {
+		return err
+	}
+
+	var queued int
+	if err := tx.QueryRow("SELECT count(*) FROM federation_batches").Scan(&queued); err != nil {
+		return err
+	}
+
+	if dropped := overflow(queued, len(batches), s.size); dropped > 0 {
+		old, err := s.query(tx, "SELECT id, tenant, metrics FROM federation_batches ORDER BY seq LIMIT $1", dropped)
+		if err != nil {
+			return err
+		}
+
+		if batches, err = carry(old, batches); err != nil {
+			return err
+		}
+
+		for _, batch := range old {
+			if _, err := tx.Exec("DELETE FROM federation_batches WHERE id = $1", batch.ID); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, batch := range batches {
+		metrics, err := json.Marshal(batch.Metrics)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(
+			"INSERT INTO federation_batches (id, tenant, metrics) VALUES ($1, $2, $3)",
+			batch.ID, batch.Tenant, string(metrics),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	content, err := json.Marshal(sent)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO federation_sent (id, sent) VALUES (1, $1)
+		ON CONFLICT (id) DO UPDATE SET sent = EXCLUDED.sent`,
+		string(content),
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Batches Пакеты в порядке добавления
+func (s *DatabaseSpool) Batches() ([]Batch, error) {
+	return s.query(s.db, "SELECT id, tenant, metrics FROM federation_batches ORDER BY seq")
+}
+
+// Remove Удаление отправленного пакета
+func (s *DatabaseSpool) Remove(id string) error {
+	_, err := s.db.Exec("DELETE FROM federation_batches WHERE id = $1", id)
+
+	return err
+}
+
+// Sent Значения счетчиков, сохраненные последним Append любого экземпляра
+func (s *DatabaseSpool) Sent() (Sent, error) {
+	var content []byte
+
+	// Спул в бд общий для всех экземпляров, поэтому без сохраненных значений счетчики еще не пересылались
+	// и отправляются целиком
+	err := s.db.QueryRow("SELECT sent FROM federation_sent WHERE id = 1").Scan(&content)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Sent{}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var sent Sent
+	if err := json.Unmarshal(content, &sent); err != nil {
+		return nil, fmt.Errorf("invalid federation sent values: %w", err)
+	}
+
+	return sent, nil
+}
+
+// querier Выполнение запроса в бд или в транзакции
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// query Чтение пакетов запросом
+func (s *DatabaseSpool) query(q querier, query string, args ...interface{}) ([]Batch, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batches []Batch
+	for rows.Next() {
+		var (
+			batch   Batch
+			metrics []byte
+		)
+
+		if err := rows.Scan(&batch.ID, &batch.Tenant, &metrics); err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal(metrics, &batch.Metrics); err != nil {
+			return nil, fmt.Errorf("invalid federation batch %s: %w", batch.ID, err)
+		}
+
+		batches = append(batches, batch)
+	}
+
+	return batches, rows.Err()
+}
@@ -0,0 +1,99 @@
+// Package federation Пересылка метрик сервера датацентра на вышестоящий сервер.
+//
+// Пересылающий сервер каждые interval собирает метрики всех арендаторов (или серии, имена которых соответствуют
+// шаблону), добавляет к ним метку dc с именем датацентра или префикс имени и отправляет на вышестоящий сервер
+// по тому же протоколу, что и агент (/updates/ или gRPC BulkSaveMetrics), с подписью ключом вышестоящего сервера.
+// Gauge отправляются текущими значениями, Counter - приращениями с предыдущего сбора.
+//
+// Собранные пакеты сначала записываются в спул и удаляются из него после успешной отправки, поэтому метрики
+// не теряются, пока вышестоящий сервер недоступен. У каждого пакета есть идентификатор, и повторная отправка
+// пакета не применяется вышестоящим сервером дважды. После ошибки отправка повторяется с экспоненциальной задержкой.
+// При переполнении спула удаляются самые старые пакеты, но приращения счетчиков из них переносятся в новые пакеты.
+//
+// С выбором ведущего пересылку выполняет только ведущий, а спул и значения счетчиков хранятся в бд. Новый ведущий
+// отправляет пакеты, которые не успел отправить предыдущий, и считает приращения от его последнего сбора.
+//
+// Для защиты от петель запросы содержат имя датацентра в заголовке SourceHeader: сервер отклоняет запросы
+// своего датацентра, а пересылающий сервер не отправляет серии, которые уже помечены его датацентром.
+package federation
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/vllvll/devops/internal/client"
+	conf "github.com/vllvll/devops/internal/config"
+	"github.com/vllvll/devops/internal/leader"
+	"github.com/vllvll/devops/internal/repositories"
+	"github.com/vllvll/devops/internal/services"
+	"github.com/vllvll/devops/internal/types"
+)
+
+// SourceHeader Заголовок HTTP запроса (и метаданные gRPC) с именем датацентра пересылающего сервера
+const SourceHeader = "X-Federation-Source"
+
+// Label Метка с именем датацентра пересылаемых метрик
+const Label = "dc"
+
+// maxBackoff Максимальная задержка повтора отправки
+const maxBackoff = 5 * time.Minute
+
+// Upstream Клиент вышестоящего сервера
+type Upstream interface {
+	// PushBatch Запись пакета метрик арендатора. Пакет с тем же batchID не применяется дважды
+	PushBatch(tenantName string, batchID string, metrics []types.Metrics) error
+	// SetHeader Заголовок, который передается во всех запросах
+	SetHeader(key string, value string)
+}
+
+// Batch Пакет метрик арендатора для отправки на вышестоящий сервер
+type Batch struct {
+	ID      string          `json:"id"`      // Идентификатор пакета
+	Tenant  string          `json:"tenant"`  // Арендатор
+	Metrics []types.Metrics `json:"metrics"` // Подготовленные для отправки метрики
+}
+
+// Sent Значения счетчиков арендаторов, приращения которых уже добавлены в спул
+type Sent map[string]map[string]types.Counter
+
+// NewFromConfig Создание пересылки метрик на вышестоящий сервер. Для пустого адреса возвращается nil.
+// С выбором ведущего спул хранится в бд db, чтобы после смены ведущего пересылка продолжилась без потерь
+func NewFromConfig(config *conf.ServerConfig, tenants repositories.TenantRepository, db *sql.DB, elector *leader.Elector) (*Forwarder, error) {
+	if config.FederationUpstream == "" {
+		return nil, nil
+	}
+
+	if config.FederationDC == "" {
+		return nil, fmt.Errorf("federation dc is required")
+	}
+
+	signer := services.NewMetricSigner(config.FederationKey)
+
+	var upstream Upstream
+	if config.FederationGRPC {
+		grpcClient, err := client.NewGRPC(config.FederationUpstream, config.FederationToken, signer)
+		if err != nil {
+			return nil, err
+		}
+
+		upstream = grpcClient
+	} else {
+		upstream = client.New(config.FederationUpstream, config.FederationToken, signer, nil)
+	}
+
+	var spool Spool = NewMemorySpool(config.FederationSpoolSize)
+	switch {
+	case config.LeaderElection:
+		spool = NewDatabaseSpool(db, config.FederationSpoolSize)
+	case config.FederationSpool != "":
+		directorySpool, err := NewDirectorySpool(config.FederationSpool, config.FederationSpoolSize)
+		if err != nil {
+			return nil, err
+		}
+
+		spool = directorySpool
+	}
+
+	return NewForwarder(tenants, upstream, spool, elector, config.FederationDC, config.FederationPrefix, config.FederationPattern, config.FederationInterval)
+}
@@ -0,0 +1,259 @@
+package federation
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"go.uber.org/zap"
+
+	"github.com/vllvll/devops/internal/dictionaries"
+	"github.com/vllvll/devops/internal/leader"
+	"github.com/vllvll/devops/internal/repositories"
+	"github.com/vllvll/devops/internal/types"
+)
+
+// batchSize Максимальное количество метрик в одном пакете
+const batchSize = 1000
+
+// Forwarder Пересылка метрик арендаторов на вышестоящий сервер
+type Forwarder struct {
+	tenants  repositories.TenantRepository // Метрики арендаторов
+	upstream Upstream                      // Клиент вышестоящего сервера
+	spool    Spool                         // Очередь неотправленных пакетов
+	elector  *leader.Elector               // Выбор ведущего: пересылку выполняет только ведущий (nil - всегда)
+	dc       string                        // Имя датацентра
+	prefix   string                        // Префикс имени пересылаемых метрик (пустой - добавляется метка dc)
+	pattern  *regexp.Regexp                // Шаблон имен пересылаемых серий (nil - все серии)
+	interval time.Duration                 // Интервал пересылки
+	backoff  *Backoff                      // Задержка повтора после ошибки
+
+	sent   Sent // Значения счетчиков, учтенные в спуле (nil - неизвестны, первый сбор только запоминает значения)
+	loaded bool // Значения счетчиков загружены из спула
+}
+
+// NewForwarder Создание пересылки метрик арендаторов на вышестоящий сервер каждые interval.
+// Пустой pattern - пересылаются все серии
+func NewForwarder(tenants repositories.TenantRepository, upstream Upstream, spool Spool, elector *leader.Elector, dc string, prefix string, pattern string, interval time.Duration) (*Forwarder, error) {
+	var re *regexp.Regexp
+	if pattern != "" {
+		var err error
+		if re, err = regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("invalid federation pattern: %w", err)
+		}
+	}
+
+	upstream.SetHeader(SourceHeader, dc)
+
+	return &Forwarder{
+		tenants:  tenants,
+		upstream: upstream,
+		spool:    spool,
+		elector:  elector,
+		dc:       dc,
+		prefix:   prefix,
+		pattern:  re,
+		interval: interval,
+		backoff:  NewBackoff(interval, maxBackoff),
+	}, nil
+}
+
+// Run Пересылка метрик каждые interval до отмены ctx. После ошибки пересылка повторяется с задержкой
+func (f *Forwarder) Run(ctx context.Context) {
+	timer := time.NewTimer(f.interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		wait := f.interval
+
+		if f.elector.IsLeader() {
+			if err := f.Forward(); err != nil {
+				wait = f.backoff.Next()
+				zap.S().Warnf("Error with federation, retry in %s: %v", wait, err)
+			} else {
+				f.backoff.Reset()
+			}
+		} else {
+			// Пока экземпляр ведомый, счетчики пересылает ведущий и сохраняет их значения в общем спуле.
+			// Став ведущим, экземпляр загрузит эти значения и отправит приращения с последнего сбора предыдущего ведущего
+			f.loaded = false
+		}
+
+		timer.Reset(wait)
+	}
+}
+
+// Forward Сбор метрик в спул и отправка всех пакетов спула в порядке добавления
+func (f *Forwarder) Forward() error {
+	if err := f.collect(); err != nil {
+		return err
+	}
+
+	batches, err := f.spool.Batches()
+	if err != nil {
+		return err
+	}
+
+	for _, batch := range batches {
+		if err := f.upstream.PushBatch(batch.Tenant, batch.ID, batch.Metrics); err != nil {
+			return err
+		}
+
+		if err := f.spool.Remove(batch.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// collect Сбор метрик всех арендаторов в пакеты спула. Counter отправляются приращениями с предыдущего сбора,
+// значение меньше предыдущего считается сбросом счетчика. Если значения предыдущего сбора неизвестны (спул в памяти
+// или в каталоге без сохраненных значений), первый сбор только запоминает значения счетчиков и пишет их количество в лог
+func (f *Forwarder) collect() error {
+	if !f.loaded {
+		sent, err := f.spool.Sent()
+		if err != nil {
+			return err
+		}
+
+		f.sent, f.loaded = sent, true
+	}
+
+	names, err := f.tenants.Tenants()
+	if err != nil {
+		return err
+	}
+
+	sent := Sent{}
+	var batches []Batch
+	var skipped int
+
+	for _, name := range names {
+		gauges, counters := f.tenants.Tenant(name).GetAll()
+		metrics := make([]types.Metrics, 0, len(gauges)+len(counters))
+
+		for series, value := range gauges {
+			id, ok := f.rename(series)
+			if !ok {
+				continue
+			}
+
+			value := float64(value)
+			metrics = append(metrics, types.Metrics{ID: id, MType: dictionaries.GaugeType, Value: &value})
+		}
+
+		sent[name] = make(map[string]types.Counter, len(counters))
+
+		for series, value := range counters {
+			id, ok := f.rename(series)
+			if !ok {
+				continue
+			}
+
+			sent[name][series] = value
+			if f.sent == nil {
+				skipped++
+
+				continue
+			}
+
+			previous, ok := f.sent[name][series]
+			delta := int64(value - previous)
+			if !ok || value < previous {
+				delta = int64(value)
+			}
+
+			if delta == 0 {
+				continue
+			}
+
+			metrics = append(metrics, types.Metrics{ID: id, MType: dictionaries.CounterType, Delta: &delta})
+		}
+
+		sort.Slice(metrics, func(i, j int) bool {
+			if metrics[i].MType != metrics[j].MType {
+				return metrics[i].MType == dictionaries.GaugeType
+			}
+
+			return metrics[i].ID < metrics[j].ID
+		})
+
+		for start := 0; start < len(metrics); start += batchSize {
+			end := start + batchSize
+			if end > len(metrics) {
+				end = len(metrics)
+			}
+
+			id, err := uuid.NewV4()
+			if err != nil {
+				return err
+			}
+
+			batches = append(batches, Batch{ID: id.String(), Tenant: name, Metrics: metrics[start:end]})
+		}
+	}
+
+	if err := f.spool.Append(batches, sent); err != nil {
+		return err
+	}
+
+	if skipped > 0 {
+		zap.S().Warnf("Federation skipped %d counters without previously sent values, they are forwarded from the next collection", skipped)
+	}
+
+	f.sent = sent
+
+	return nil
+}
+
+// Close Закрытие соединения с вышестоящим сервером
+func (f *Forwarder) Close() error {
+	if closer, ok := f.upstream.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// rename Имя серии на вышестоящем сервере: с префиксом или с меткой dc. false - серия не пересылается:
+// не соответствует шаблону или уже помечена этим датацентром и вернулась через петлю
+func (f *Forwarder) rename(series string) (string, bool) {
+	if f.pattern != nil && !f.pattern.MatchString(series) {
+		return "", false
+	}
+
+	name, labels := types.ParseSeriesName(series)
+
+	if f.prefix != "" {
+		if strings.HasPrefix(name, f.prefix) {
+			return "", false
+		}
+
+		return types.SeriesName(f.prefix+name, labels), true
+	}
+
+	if dc, ok := labels[Label]; ok {
+		if dc == f.dc {
+			return "", false
+		}
+
+		// Серии других датацентров пересылаются без изменений
+		return series, true
+	}
+
+	labels[Label] = f.dc
+
+	return types.SeriesName(name, labels), true
+}
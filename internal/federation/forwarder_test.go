@@ -0,0 +1,241 @@
+package federation
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vllvll/devops/internal/dictionaries"
+	"github.com/vllvll/devops/internal/repositories"
+	"github.com/vllvll/devops/internal/types"
+)
+
+// recordingUpstream Вышестоящий сервер, который запоминает принятые пакеты
+type recordingUpstream struct {
+	mu      sync.Mutex
+	header  map[string]string
+	batches []Batch
+	err     error // Ошибка отправки (nil - пакеты принимаются)
+}
+
+func (u *recordingUpstream) PushBatch(tenantName string, batchID string, metrics []types.Metrics) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.err != nil {
+		return u.err
+	}
+
+	u.batches = append(u.batches, Batch{ID: batchID, Tenant: tenantName, Metrics: metrics})
+
+	return nil
+}
+
+func (u *recordingUpstream) SetHeader(key string, value string) {
+	if u.header == nil {
+		u.header = map[string]string{}
+	}
+
+	u.header[key] = value
+}
+
+// received Принятые метрики: Gauge текущими значениями, Counter суммой приращений
+func (u *recordingUpstream) received() (map[string]float64, map[string]int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	gauges, counters := map[string]float64{}, map[string]int64{}
+	for _, batch := range u.batches {
+		for _, metric := range batch.Metrics {
+			switch metric.MType {
+			case dictionaries.GaugeType:
+				gauges[batch.Tenant+"/"+metric.ID] = *metric.Value
+			case dictionaries.CounterType:
+				counters[batch.Tenant+"/"+metric.ID] += *metric.Delta
+			}
+		}
+	}
+
+	return gauges, counters
+}
+
+func TestForwarder_Forward(t *testing.T) {
+	tenants := repositories.NewTenantMemoryRepository()
+	require.NoError(t, tenants.Tenant("").UpdateAll(types.Gauges{"Alloc": 1}, types.Counters{"PollCount": 10}))
+	require.NoError(t, tenants.Tenant("team-a").UpdateAll(types.Gauges{}, types.Counters{"Requests;dc=us": 3, "Requests;dc=eu": 7}))
+
+	upstream := &recordingUpstream{}
+	forwarder, err := NewForwarder(tenants, upstream, NewMemorySpool(10), nil, "eu", "", "", time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{SourceHeader: "eu"}, upstream.header)
+
+	// Без сохраненных значений первый сбор только запоминает значения счетчиков
+	require.NoError(t, forwarder.Forward())
+
+	gauges, counters := upstream.received()
+	assert.Equal(t, map[string]float64{"/Alloc;dc=eu": 1}, gauges)
+	assert.Empty(t, counters)
+
+	tenants.Tenant("").UpdateCount("PollCount", 5)
+	tenants.Tenant("team-a").UpdateCount("Requests;dc=us", 2)
+	tenants.Tenant("team-a").UpdateCount("Requests;dc=eu", 2)
+	require.NoError(t, forwarder.Forward())
+
+	// Серии других датацентров пересылаются без изменений, серии своего датацентра вернулись через петлю
+	_, counters = upstream.received()
+	assert.Equal(t, map[string]int64{"/PollCount;dc=eu": 5, "team-a/Requests;dc=us": 2}, counters)
+
+	// Сброс счетчика: значение меньше предыдущего отправляется целиком
+	require.NoError(t, tenants.Tenant("").ResetCounter("PollCount"))
+	tenants.Tenant("").UpdateCount("PollCount", 4)
+	require.NoError(t, forwarder.Forward())
+
+	_, counters = upstream.received()
+	assert.Equal(t, int64(9), counters["/PollCount;dc=eu"])
+
+	batches, err := forwarder.spool.Batches()
+	require.NoError(t, err)
+	assert.Empty(t, batches)
+}
+
+func TestForwarder_ForwardNeverSent(t *testing.T) {
+	tenants := repositories.NewTenantMemoryRepository()
+	require.NoError(t, tenants.Tenant("").UpdateAll(types.Gauges{}, types.Counters{"PollCount": 10}))
+
+	// Пустые сохраненные значения, как в спуле в бд: счетчики еще не пересылались и отправляются целиком
+	spool := NewMemorySpool(10)
+	require.NoError(t, spool.Append(nil, Sent{}))
+
+	upstream := &recordingUpstream{}
+	forwarder, err := NewForwarder(tenants, upstream, spool, nil, "eu", "", "", time.Second)
+	require.NoError(t, err)
+
+	require.NoError(t, forwarder.Forward())
+
+	_, counters := upstream.received()
+	assert.Equal(t, map[string]int64{"/PollCount;dc=eu": 10}, counters)
+}
+
+func TestForwarder_Failover(t *testing.T) {
+	tenants := repositories.NewTenantMemoryRepository()
+	require.NoError(t, tenants.Tenant("").UpdateAll(types.Gauges{}, types.Counters{"PollCount": 10}))
+
+	// Экземпляры используют общий спул, как спул в бд при выборе ведущего
+	upstream, spool := &recordingUpstream{}, NewMemorySpool(10)
+	first, err := NewForwarder(tenants, upstream, spool, nil, "eu", "", "", time.Second)
+	require.NoError(t, err)
+	second, err := NewForwarder(tenants, upstream, spool, nil, "eu", "", "", time.Second)
+	require.NoError(t, err)
+
+	require.NoError(t, first.Forward())
+
+	// Ведущий не успел отправить пакет до смены ведущего
+	tenants.Tenant("").UpdateCount("PollCount", 5)
+	upstream.err = errors.New("connection refused")
+	assert.Error(t, first.Forward())
+	upstream.err = nil
+
+	// Новый ведущий отправляет пакет предыдущего и приращения с его последнего сбора
+	tenants.Tenant("").UpdateCount("PollCount", 3)
+	require.NoError(t, second.Forward())
+
+	_, counters := upstream.received()
+	assert.Equal(t, map[string]int64{"/PollCount;dc=eu": 8}, counters)
+
+	// Ведомый сбрасывает загруженные значения и, снова став ведущим, продолжает с последнего сбора
+	first.loaded = false
+	tenants.Tenant("").UpdateCount("PollCount", 1)
+	require.NoError(t, first.Forward())
+
+	_, counters = upstream.received()
+	assert.Equal(t, map[string]int64{"/PollCount;dc=eu": 9}, counters)
+}
+
+func TestForwarder_Spool(t *testing.T) {
+	tenants := repositories.NewTenantMemoryRepository()
+	require.NoError(t, tenants.Tenant("").UpdateAll(types.Gauges{"Alloc": 1}, types.Counters{"PollCount": 10}))
+
+	upstream := &recordingUpstream{err: errors.New("connection refused")}
+	forwarder, err := NewForwarder(tenants, upstream, NewMemorySpool(10), nil, "eu", "", "", time.Second)
+	require.NoError(t, err)
+
+	forwarder.sent, forwarder.loaded = Sent{}, true
+
+	// Пока вышестоящий сервер недоступен, пакеты остаются в спуле
+	assert.Error(t, forwarder.Forward())
+	tenants.Tenant("").UpdateCount("PollCount", 5)
+	assert.Error(t, forwarder.Forward())
+
+	spooled, err := forwarder.spool.Batches()
+	require.NoError(t, err)
+	require.Len(t, spooled, 2)
+
+	upstream.err = nil
+	require.NoError(t, forwarder.Forward())
+
+	// Пакеты отправлены в порядке добавления с теми же идентификаторами
+	require.Len(t, upstream.batches, 3)
+	assert.Equal(t, spooled[0].ID, upstream.batches[0].ID)
+	assert.Equal(t, spooled[1].ID, upstream.batches[1].ID)
+
+	_, counters := upstream.received()
+	assert.Equal(t, map[string]int64{"/PollCount;dc=eu": 15}, counters)
+}
+
+func TestForwarder_rename(t *testing.T) {
+	forwarder, err := NewForwarder(nil, &recordingUpstream{}, nil, nil, "eu", "", "^(Alloc|Requests)", time.Second)
+	require.NoError(t, err)
+
+	tests := []struct {
+		series string
+		want   string
+		ok     bool
+	}{
+		{series: "Alloc", want: "Alloc;dc=eu", ok: true},
+		{series: "Requests;path=/", want: "Requests;dc=eu;path=/", ok: true},
+		{series: "Requests;dc=us", want: "Requests;dc=us", ok: true},
+		{series: "Requests;dc=eu", ok: false},
+		{series: "PollCount", ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.series, func(t *testing.T) {
+			got, ok := forwarder.rename(tt.series)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	forwarder.prefix = "eu."
+
+	got, ok := forwarder.rename("Requests;path=/")
+	assert.True(t, ok)
+	assert.Equal(t, "eu.Requests;path=/", got)
+
+	_, ok = forwarder.rename("eu.Alloc")
+	assert.False(t, ok)
+
+	_, err = NewForwarder(nil, &recordingUpstream{}, nil, nil, "eu", "", "(", time.Second)
+	assert.Error(t, err)
+}
+
+func TestBackoff(t *testing.T) {
+	backoff := NewBackoff(time.Second, 5*time.Second)
+	backoff.jitter = func() float64 { return 1 }
+
+	var delays []time.Duration
+	for i := 0; i < 5; i++ {
+		delays = append(delays, backoff.Next())
+	}
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}, delays)
+
+	backoff.jitter = func() float64 { return 0 }
+	assert.Equal(t, 2500*time.Millisecond, backoff.Next())
+
+	backoff.Reset()
+	assert.Equal(t, 500*time.Millisecond, backoff.Next())
+}
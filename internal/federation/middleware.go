@@ -0,0 +1,23 @@
+package federation
+
+import (
+	"net/http"
+)
+
+// RejectLoop Отклонение запросов, пересланных сервером того же датацентра dc: запрос вернулся через петлю.
+// Пустой dc - проверка выключена
+func RejectLoop(dc string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if dc != "" && r.Header.Get(SourceHeader) == dc {
+				http.Error(w, "federation loop detected", http.StatusLoopDetected)
+
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
@@ -0,0 +1,385 @@
+package federation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gofrs/uuid"
+	"go.uber.org/zap"
+
+	"github.com/vllvll/devops/internal/dictionaries"
+	"github.com/vllvll/devops/internal/types"
+)
+
+// Spool Очередь пакетов, которые еще не приняты вышестоящим сервером
+type Spool interface {
+	// Append Добавление пакетов в конец очереди и сохранение значений счетчиков, учтенных в пакетах.
+	// При переполнении удаляются самые старые пакеты, приращения счетчиков из них переносятся в новые пакеты
+	Append(batches []Batch, sent Sent) error
+	// Batches Пакеты в порядке добавления
+	Batches() ([]Batch, error)
+	// Remove Удаление отправленного пакета
+	Remove(id string) error
+	// Sent Значения счетчиков, сохраненные последним Append (nil - значения неизвестны, пустые - счетчики еще не пересылались)
+	Sent() (Sent, error)
+}
+
+// MemorySpool Очередь пакетов в оперативной памяти. Не сохраняется при перезапуске сервера
+type MemorySpool struct {
+	size int // Максимальное количество пакетов
+
+	mu      sync.Mutex
+	batches []Batch
+	sent    Sent
+}
+
+// NewMemorySpool Создание очереди пакетов в оперативной памяти не больше чем из size пакетов
+func NewMemorySpool(size int) *MemorySpool {
+	return &MemorySpool{size: size}
+}
+
+// Append Добавление пакетов в конец очереди
+func (s *MemorySpool) Append(batches []Batch, sent Sent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dropped := overflow(len(s.batches), len(batches), s.size)
+	if dropped > 0 {
+		var err error
+		if batches, err = carry(s.batches[:dropped], batches); err != nil {
+			return err
+		}
+	}
+
+	s.batches = append(append([]Batch(nil), s.batches[dropped:]...), batches...)
+	s.sent = sent
+
+	return nil
+}
+
+// Batches Пакеты в порядке добавления
+func (s *MemorySpool) Batches() ([]Batch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Batch(nil), s.batches...), nil
+}
+
+// Remove Удаление отправленного пакета
+func (s *MemorySpool) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, batch := range s.batches {
+		if batch.ID == id {
+			s.batches = append(s.batches[:i], s.batches[i+1:]...)
+
+			break
+		}
+	}
+
+	return nil
+}
+
+// Sent Значения счетчиков, сохраненные последним Append
+func (s *MemorySpool) Sent() (Sent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sent, nil
+}
+
+// Файлы очереди в каталоге
+const (
+	batchPattern = "batch-*.json" // Пакет: batch-<номер>-<идентификатор>.json
+	sentFile     = "sent.json"    // Значения счетчиков
+)
+
+// DirectorySpool Очередь пакетов в каталоге: каждый пакет хранится в отдельном файле.
+// Очередь и значения счетчиков сохраняются при перезапуске сервера
+type DirectorySpool struct {
+	dir  string
+	size int // Максимальное количество пакетов
+
+	mu  sync.Mutex
+	seq uint64 // Номер последнего добавленного пакета
+}
+
+// NewDirectorySpool Создание очереди пакетов в каталоге dir не больше чем из size пакетов
+func NewDirectorySpool(dir string, size int) (*DirectorySpool, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	s := &DirectorySpool{dir: dir, size: size}
+
+	files, err := s.files()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(files) > 0 {
+		if _, err := fmt.Sscanf(filepath.Base(files[len(files)-1]), "batch-%d-", &s.seq); err != nil {
+			return nil, fmt.Errorf("invalid spool file %s: %w", files[len(files)-1], err)
+		}
+	}
+
+	return s, nil
+}
+
+// Append Запись пакетов в файлы и сохранение значений счетчиков.
+// Значения и удаление старых пакетов выполняются после записи новых: при сбое между записями приращения будут
+// отправлены повторно, но не потеряны
+func (s *DirectorySpool) Append(batches []Batch, sent Sent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files, err := s.files()
+	if err != nil {
+		return err
+	}
+
+	dropped := files[:overflow(len(files), len(batches), s.size)]
+	if len(dropped) > 0 {
+		old, err := s.read(dropped)
+		if err != nil {
+			return err
+		}
+
+		if batches, err = carry(old, batches); err != nil {
+			return err
+		}
+	}
+
+	for _, batch := range batches {
+		s.seq++
+
+		if err := s.write(fmt.Sprintf("batch-%020d-%s.json", s.seq, batch.ID), batch); err != nil {
+			return err
+		}
+	}
+
+	if err := s.write(sentFile, sent); err != nil {
+		return err
+	}
+
+	for _, file := range dropped {
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Batches Чтение пакетов в порядке добавления
+func (s *DirectorySpool) Batches() ([]Batch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files, err := s.files()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.read(files)
+}
+
+// read Чтение пакетов из файлов
+func (s *DirectorySpool) read(files []string) ([]Batch, error) {
+	batches := make([]Batch, 0, len(files))
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		var batch Batch
+		if err := json.Unmarshal(content, &batch); err != nil {
+			return nil, fmt.Errorf("invalid spool file %s: %w", file, err)
+		}
+
+		batches = append(batches, batch)
+	}
+
+	return batches, nil
+}
+
+// Remove Удаление файла отправленного пакета
+func (s *DirectorySpool) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files, err := s.files()
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if strings.HasSuffix(file, "-"+id+".json") {
+			return os.Remove(file)
+		}
+	}
+
+	return nil
+}
+
+// Sent Чтение значений счетчиков. Отсутствие файла не считается ошибкой
+func (s *DirectorySpool) Sent() (Sent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	content, err := os.ReadFile(filepath.Join(s.dir, sentFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var sent Sent
+	if err := json.Unmarshal(content, &sent); err != nil {
+		return nil, fmt.Errorf("invalid spool file %s: %w", sentFile, err)
+	}
+
+	return sent, nil
+}
+
+// files Файлы пакетов в порядке добавления. Номер в имени дополнен нулями, поэтому порядок совпадает с сортировкой имен
+func (s *DirectorySpool) files() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(s.dir, batchPattern))
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// write Запись во временный файл и переименование, чтобы не оставить поврежденный файл при сбое
+func (s *DirectorySpool) write(name string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(s.dir, "."+name+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(s.dir, name))
+}
+
+// overflow Количество самых старых из queued пакетов, которые удаляются при добавлении added пакетов в очередь
+// не больше чем из size пакетов (0 - без ограничения)
+func overflow(queued int, added int, size int) int {
+	dropped := queued + added - size
+	if size <= 0 || dropped <= 0 {
+		return 0
+	}
+
+	if dropped > queued {
+		return queued
+	}
+
+	return dropped
+}
+
+// carry Перенос приращений Counter из удаляемых пакетов dropped в последние из добавляемых пакетов batches того же
+// арендатора, чтобы переполнение спула не теряло приращения. Для арендатора без добавляемого пакета создается новый.
+// Gauge удаляемых пакетов не переносятся: добавляемые пакеты содержат более свежие значения
+func carry(dropped []Batch, batches []Batch) ([]Batch, error) {
+	deltas := map[string]map[string]int64{}
+	for _, batch := range dropped {
+		for _, metric := range batch.Metrics {
+			if metric.MType != dictionaries.CounterType || metric.Delta == nil {
+				continue
+			}
+
+			if deltas[batch.Tenant] == nil {
+				deltas[batch.Tenant] = map[string]int64{}
+			}
+
+			deltas[batch.Tenant][metric.ID] += *metric.Delta
+		}
+	}
+
+	zap.S().Warnf("Federation spool is full, dropped %d oldest batches, counter deltas are carried to new batches", len(dropped))
+
+	tenants := make([]string, 0, len(deltas))
+	for tenant := range deltas {
+		tenants = append(tenants, tenant)
+	}
+
+	sort.Strings(tenants)
+
+	batches = append([]Batch(nil), batches...)
+
+	for _, tenant := range tenants {
+		index := -1
+		for i, batch := range batches {
+			if batch.Tenant == tenant {
+				index = i
+			}
+		}
+
+		if index < 0 {
+			id, err := uuid.NewV4()
+			if err != nil {
+				return nil, err
+			}
+
+			batches = append(batches, Batch{ID: id.String(), Tenant: tenant})
+			index = len(batches) - 1
+		}
+
+		// Метрики копируются: пакеты сбора ссылаются на части одного среза
+		metrics := make([]types.Metrics, 0, len(batches[index].Metrics)+len(deltas[tenant]))
+		for _, metric := range batches[index].Metrics {
+			if delta, ok := deltas[tenant][metric.ID]; ok && metric.MType == dictionaries.CounterType && metric.Delta != nil {
+				delta += *metric.Delta
+				metric.Delta = &delta
+
+				delete(deltas[tenant], metric.ID)
+			}
+
+			metrics = append(metrics, metric)
+		}
+
+		ids := make([]string, 0, len(deltas[tenant]))
+		for id := range deltas[tenant] {
+			ids = append(ids, id)
+		}
+
+		sort.Strings(ids)
+
+		for _, id := range ids {
+			delta := deltas[tenant][id]
+			metrics = append(metrics, types.Metrics{ID: id, MType: dictionaries.CounterType, Delta: &delta})
+		}
+
+		batches[index].Metrics = metrics
+	}
+
+	return batches, nil
+}
@@ -0,0 +1,163 @@
+package federation
+
+import (
+	"database/sql"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vllvll/devops/internal/types"
+	"github.com/vllvll/devops/pkg/postgres"
+)
+
+// testDatabase Подключение к PostgreSQL из TEST_DATABASE_DSN с отдельной схемой, которая удаляется после теста.
+// Без переменной возвращается nil
+func testDatabase(t *testing.T) *sql.DB {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		return nil
+	}
+
+	admin, err := sql.Open("pgx", dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { admin.Close() })
+
+	id, err := uuid.NewV4()
+	require.NoError(t, err)
+
+	schema := "federation_" + strings.ReplaceAll(id.String(), "-", "")
+
+	_, err = admin.Exec("CREATE SCHEMA " + schema)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		assert.NoError(t, err)
+	})
+
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		require.NoError(t, err)
+
+		query := u.Query()
+		query.Set("search_path", schema)
+		u.RawQuery = query.Encode()
+		dsn = u.String()
+	} else {
+		dsn += " search_path=" + schema
+	}
+
+	db, err := postgres.ConnectDatabase(dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+// batchIDs Идентификаторы пакетов спула
+func batchIDs(t *testing.T, spool Spool) []string {
+	batches, err := spool.Batches()
+	require.NoError(t, err)
+
+	ids := make([]string, 0, len(batches))
+	for _, batch := range batches {
+		ids = append(ids, batch.ID)
+	}
+
+	return ids
+}
+
+func TestSpool(t *testing.T) {
+	directory, err := NewDirectorySpool(t.TempDir(), 3)
+	require.NoError(t, err)
+
+	spools := map[string]Spool{
+		"memory":    NewMemorySpool(3),
+		"directory": directory,
+	}
+
+	if db := testDatabase(t); db != nil {
+		spools["database"] = NewDatabaseSpool(db, 3)
+	}
+
+	for name, spool := range spools {
+		t.Run(name, func(t *testing.T) {
+			// Спул в бд общий для всех экземпляров, поэтому без сохраненных значений счетчики еще не пересылались
+			sent, err := spool.Sent()
+			require.NoError(t, err)
+			if name == "database" {
+				assert.Equal(t, Sent{}, sent)
+			} else {
+				assert.Nil(t, sent)
+			}
+
+			delta := int64(5)
+			require.NoError(t, spool.Append([]Batch{
+				{ID: "a", Tenant: "team-a", Metrics: []types.Metrics{{ID: "PollCount", MType: "counter", Delta: &delta}}},
+				{ID: "b"},
+			}, Sent{"team-a": {"PollCount": 5}}))
+			require.NoError(t, spool.Append([]Batch{{ID: "c"}}, Sent{"team-a": {"PollCount": 7}}))
+
+			batches, err := spool.Batches()
+			require.NoError(t, err)
+			require.Len(t, batches, 3)
+			assert.Equal(t, "team-a", batches[0].Tenant)
+			assert.Equal(t, int64(5), *batches[0].Metrics[0].Delta)
+
+			sent, err = spool.Sent()
+			require.NoError(t, err)
+			assert.Equal(t, Sent{"team-a": {"PollCount": 7}}, sent)
+
+			require.NoError(t, spool.Remove("b"))
+			assert.Equal(t, []string{"a", "c"}, batchIDs(t, spool))
+
+			// При переполнении удаляются самые старые пакеты, а их приращения добавляются в новый пакет арендатора
+			added := int64(2)
+			require.NoError(t, spool.Append([]Batch{
+				{ID: "d", Tenant: "team-a", Metrics: []types.Metrics{{ID: "PollCount", MType: "counter", Delta: &added}}},
+				{ID: "e"},
+			}, Sent{}))
+			assert.Equal(t, []string{"c", "d", "e"}, batchIDs(t, spool))
+
+			batches, err = spool.Batches()
+			require.NoError(t, err)
+			require.Len(t, batches[1].Metrics, 1)
+			assert.Equal(t, int64(7), *batches[1].Metrics[0].Delta)
+			assert.Equal(t, int64(2), added, "appended batch is not modified")
+
+			// Без нового пакета арендатора приращения переносятся в отдельный пакет
+			require.NoError(t, spool.Append([]Batch{{ID: "f"}, {ID: "g"}}, Sent{}))
+
+			batches, err = spool.Batches()
+			require.NoError(t, err)
+			require.Len(t, batches, 4)
+			assert.Equal(t, []string{"e", "f", "g"}, batchIDs(t, spool)[:3])
+			assert.Equal(t, "team-a", batches[3].Tenant)
+			require.Len(t, batches[3].Metrics, 1)
+			assert.Equal(t, int64(7), *batches[3].Metrics[0].Delta)
+		})
+	}
+}
+
+func TestDirectorySpool_Reopen(t *testing.T) {
+	dir := t.TempDir()
+
+	spool, err := NewDirectorySpool(dir, 10)
+	require.NoError(t, err)
+	require.NoError(t, spool.Append([]Batch{{ID: "a"}, {ID: "b"}}, Sent{"": {"PollCount": 3}}))
+
+	// После перезапуска очередь и значения счетчиков сохраняются, новые пакеты добавляются в конец
+	spool, err = NewDirectorySpool(dir, 10)
+	require.NoError(t, err)
+
+	sent, err := spool.Sent()
+	require.NoError(t, err)
+	assert.Equal(t, Sent{"": {"PollCount": 3}}, sent)
+
+	require.NoError(t, spool.Append([]Batch{{ID: "c"}}, sent))
+	assert.Equal(t, []string{"a", "b", "c"}, batchIDs(t, spool))
+}
@@ -0,0 +1,90 @@
+package federation_test
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vllvll/devops/internal/auth"
+	"github.com/vllvll/devops/internal/batch"
+	"github.com/vllvll/devops/internal/client"
+	"github.com/vllvll/devops/internal/dictionaries"
+	"github.com/vllvll/devops/internal/federation"
+	"github.com/vllvll/devops/internal/handlers"
+	"github.com/vllvll/devops/internal/middlewares"
+	"github.com/vllvll/devops/internal/repositories"
+	"github.com/vllvll/devops/internal/services"
+	"github.com/vllvll/devops/internal/types"
+)
+
+// newUpstream Вышестоящий сервер датацентра dc с ключом подписи key. Возвращает адрес сервера и токен
+// с правом выбора арендатора, с которым нижестоящий сервер пересылает метрики
+func newUpstream(t *testing.T, tenants repositories.TenantRepository, dc string, key string) (string, string) {
+	handler := handlers.NewHandler(tenants, services.NewMetricSigner(key), nil, nil, handlers.HandlerOptions{Batches: batch.NewMemoryStore(time.Minute, 0)})
+
+	store := auth.NewFileStore(filepath.Join(t.TempDir(), "tokens.json"))
+	secret, token, err := auth.Generate("edge", []string{auth.ScopeWrite, auth.ScopeAdmin}, "")
+	require.NoError(t, err)
+	require.NoError(t, store.Save(token))
+
+	r := chi.NewRouter()
+	r.Use(federation.RejectLoop(dc), middlewares.Authenticate(store), middlewares.Tenant)
+	r.Post("/updates/", handler.BulkSaveMetricJSON())
+
+	ts := httptest.NewServer(r)
+	t.Cleanup(ts.Close)
+
+	return ts.URL, secret
+}
+
+func TestForwarder_Upstream(t *testing.T) {
+	global := repositories.NewTenantMemoryRepository()
+	address, secret := newUpstream(t, global, "global", "secret")
+
+	edge := repositories.NewTenantMemoryRepository()
+	require.NoError(t, edge.Tenant("team-a").UpdateAll(types.Gauges{"Alloc": 1}, types.Counters{"PollCount": 10}))
+
+	upstream := client.New(address, secret, services.NewMetricSigner("secret"), nil)
+	forwarder, err := federation.NewForwarder(edge, upstream, federation.NewMemorySpool(10), nil, "eu", "", "", time.Second)
+	require.NoError(t, err)
+
+	require.NoError(t, forwarder.Forward())
+	edge.Tenant("team-a").UpdateCount("PollCount", 5)
+	require.NoError(t, forwarder.Forward())
+
+	gauges, counters := global.Tenant("team-a").GetAll()
+	assert.Equal(t, map[string]types.Gauge{"Alloc;dc=eu": 1}, gauges)
+	assert.Equal(t, map[string]types.Counter{"PollCount;dc=eu": 5}, counters)
+
+	// Повторная отправка пакета не применяется дважды
+	delta := int64(5)
+	metrics := []types.Metrics{{ID: "PollCount;dc=eu", MType: dictionaries.CounterType, Delta: &delta}}
+	require.NoError(t, upstream.PushBatch("team-a", "batch-1", metrics))
+	require.NoError(t, upstream.PushBatch("team-a", "batch-1", metrics))
+
+	counter, err := global.Tenant("team-a").GetCounterByKey("PollCount;dc=eu")
+	require.NoError(t, err)
+	assert.Equal(t, types.Counter(10), counter)
+}
+
+func TestForwarder_Loop(t *testing.T) {
+	tenants := repositories.NewTenantMemoryRepository()
+	require.NoError(t, tenants.Tenant("").UpdateAll(types.Gauges{"Alloc": 1}, types.Counters{}))
+
+	// Сервер настроен пересылать метрики самому себе
+	address, secret := newUpstream(t, tenants, "global", "")
+	forwarder, err := federation.NewForwarder(tenants, client.New(address, secret, services.NewMetricSigner(""), nil), federation.NewMemorySpool(10), nil, "global", "", "", time.Second)
+	require.NoError(t, err)
+
+	err = forwarder.Forward()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "508")
+
+	gauges, _ := tenants.Tenant("").GetAll()
+	assert.Equal(t, map[string]types.Gauge{"Alloc": 1}, gauges)
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/vllvll/devops/internal/auth"
+	"github.com/vllvll/devops/internal/services"
+	"github.com/vllvll/devops/internal/tenant"
+	"github.com/vllvll/devops/internal/urlparam"
+)
+
+type AdminHandler struct {
+	admin services.Admin // Сервис для удаления и сброса метрик
+}
+
+// NewAdminHandler Получение хендлера для администрирования метрик
+func NewAdminHandler(admin services.Admin) *AdminHandler {
+	return &AdminHandler{
+		admin: admin,
+	}
+}
+
+// AdminHandlers Список методов для хендлеров администрирования (сервер)
+type AdminHandlers interface {
+	DeleteMetric() http.HandlerFunc
+	DeleteMetrics() http.HandlerFunc
+	ResetCounter() http.HandlerFunc
+}
+
+// DeleteMetric Удаление метрики по типу и ключу
+func (h AdminHandler) DeleteMetric() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		err := h.admin.DeleteMetric(actor(r), tenant.FromContext(r.Context()), chi.URLParam(r, "format"), urlparam.Key(r))
+		if errors.Is(err, services.ErrUnknownMetricType) {
+			http.Error(rw, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+
+			return
+		}
+
+		if err != nil {
+			http.Error(rw, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		rw.Write([]byte(http.StatusText(http.StatusOK)))
+	}
+}
+
+// DeleteMetrics Удаление метрик по регулярному выражению: ?pattern=^Heap&type=gauge
+func (h AdminHandler) DeleteMetrics() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		pattern := r.URL.Query().Get("pattern")
+		if pattern == "" {
+			http.Error(rw, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+			return
+		}
+
+		deleted, err := h.admin.DeleteMetrics(actor(r), tenant.FromContext(r.Context()), r.URL.Query().Get("type"), pattern)
+		if errors.Is(err, services.ErrUnknownMetricType) {
+			http.Error(rw, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+
+			return
+		}
+
+		if err != nil {
+			http.Error(rw, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+			return
+		}
+
+		response, err := json.Marshal(map[string]int{"deleted": deleted})
+		if err != nil {
+			http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+
+			return
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(response)
+	}
+}
+
+// ResetCounter Сброс значения метрики типа Counter
+func (h AdminHandler) ResetCounter() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		err := h.admin.ResetCounter(actor(r), tenant.FromContext(r.Context()), urlparam.Key(r))
+		if err != nil {
+			http.Error(rw, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		rw.Write([]byte(http.StatusText(http.StatusOK)))
+	}
+}
+
+// actor Идентификатор токена или IP клиента для журнала действий
+func actor(r *http.Request) string {
+	if token, ok := auth.FromContext(r.Context()); ok {
+		return "token:" + token.ID
+	}
+
+	return r.RemoteAddr
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vllvll/devops/internal/middlewares"
+	"github.com/vllvll/devops/internal/repositories"
+	"github.com/vllvll/devops/internal/services"
+	"github.com/vllvll/devops/internal/tenant"
+	"github.com/vllvll/devops/internal/types"
+)
+
+type auditRecorder struct {
+	entries []services.AuditEntry
+}
+
+func (a *auditRecorder) Record(entry services.AuditEntry) {
+	a.entries = append(a.entries, entry)
+}
+
+func (a *auditRecorder) Close() error {
+	return nil
+}
+
+func Example_deleteMetric() {
+	client := resty.New().SetAuthToken("admin-key")
+	_, _ = client.R().Delete("/admin/metric/gauge/Alloc")
+}
+
+func TestAdminHandler(t *testing.T) {
+	type want struct {
+		code     int
+		response string
+		gauges   types.Gauges
+		counters types.Counters
+		action   string
+		failed   bool
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		key    string
+		want   want
+	}{
+		{
+			name:   "delete gauge",
+			method: "DELETE",
+			url:    "/admin/metric/gauge/Alloc",
+			key:    "secret",
+			want: want{
+				code:     200,
+				response: "OK",
+				gauges:   types.Gauges{"HeapAlloc": 2},
+				counters: types.Counters{"PollCount": 5},
+				action:   services.AuditDeleteMetric,
+			},
+		},
+		{
+			name:   "delete unknown gauge",
+			method: "DELETE",
+			url:    "/admin/metric/gauge/Unknown",
+			key:    "secret",
+			want: want{
+				code:     404,
+				response: "Not Found",
+				gauges:   types.Gauges{"Alloc": 1, "HeapAlloc": 2},
+				counters: types.Counters{"PollCount": 5},
+				action:   services.AuditDeleteMetric,
+				failed:   true,
+			},
+		},
+		{
+			name:   "delete by pattern",
+			method: "DELETE",
+			url:    "/admin/metrics?pattern=Alloc$",
+			key:    "secret",
+			want: want{
+				code:     200,
+				response: `{"deleted":2}`,
+				gauges:   types.Gauges{},
+				counters: types.Counters{"PollCount": 5},
+				action:   services.AuditDeleteMetrics,
+			},
+		},
+		{
+			name:   "delete by pattern with unknown type",
+			method: "DELETE",
+			url:    "/admin/metrics?pattern=Alloc$&type=histogram",
+			key:    "secret",
+			want: want{
+				code:     501,
+				response: "Not Implemented",
+				gauges:   types.Gauges{"Alloc": 1, "HeapAlloc": 2},
+				counters: types.Counters{"PollCount": 5},
+				action:   services.AuditDeleteMetrics,
+				failed:   true,
+			},
+		},
+		{
+			name:   "reset counter",
+			method: "POST",
+			url:    "/admin/counter/PollCount/reset",
+			key:    "secret",
+			want: want{
+				code:     200,
+				response: "OK",
+				gauges:   types.Gauges{"Alloc": 1, "HeapAlloc": 2},
+				counters: types.Counters{"PollCount": 0},
+				action:   services.AuditResetCounter,
+			},
+		},
+		{
+			name:   "wrong admin key",
+			method: "DELETE",
+			url:    "/admin/metric/gauge/Alloc",
+			key:    "wrong",
+			want: want{
+				code:     403,
+				response: "Forbidden",
+				gauges:   types.Gauges{"Alloc": 1, "HeapAlloc": 2},
+				counters: types.Counters{"PollCount": 5},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tenants := repositories.NewTenantMemoryRepository()
+			repository := tenants.Tenant(tenant.Default)
+			_ = repository.UpdateAll(types.Gauges{"Alloc": 1, "HeapAlloc": 2}, types.Counters{"PollCount": 5})
+
+			audit := &auditRecorder{}
+			handler := NewAdminHandler(services.NewMetricAdmin(tenants, audit, nil))
+
+			r := chi.NewRouter()
+			r.Route("/admin/", func(r chi.Router) {
+				r.Use(middlewares.AdminKey("secret"))
+				r.Delete("/metric/{format:[A-Za-z]+}/{key}", handler.DeleteMetric())
+				r.Delete("/metrics", handler.DeleteMetrics())
+				r.Post("/counter/{key}/reset", handler.ResetCounter())
+			})
+
+			ts := httptest.NewServer(r)
+			defer ts.Close()
+
+			response, err := resty.New().SetAuthToken(tt.key).R().Execute(tt.method, ts.URL+tt.url)
+			require.NoError(t, err)
+
+			gauges, counters := repository.GetAll()
+
+			assert.Equal(t, tt.want.code, response.StatusCode())
+			assert.Equal(t, tt.want.response, strings.Trim(string(response.Body()), "\n"))
+			assert.Equal(t, tt.want.gauges, types.Gauges(gauges))
+			assert.Equal(t, tt.want.counters, types.Counters(counters))
+
+			if tt.want.action == "" {
+				assert.Empty(t, audit.entries)
+			} else {
+				require.Len(t, audit.entries, 1)
+				assert.Equal(t, tt.want.action, audit.entries[0].Action)
+				assert.Equal(t, tt.want.failed, audit.entries[0].Error != "")
+			}
+		})
+	}
+}
+
+func TestAdminHandler_EscapedKey(t *testing.T) {
+	tenants := repositories.NewTenantMemoryRepository()
+	repository := tenants.Tenant(tenant.Default)
+	_ = repository.UpdateAll(types.Gauges{"Requests;path=/api": 1}, types.Counters{"Hits;path=/api": 5})
+
+	handler := NewAdminHandler(services.NewMetricAdmin(tenants, &auditRecorder{}, nil))
+
+	r := chi.NewRouter()
+	r.Route("/admin/", func(r chi.Router) {
+		r.Use(middlewares.AdminKey("secret"))
+		r.Delete("/metric/{format:[A-Za-z]+}/{key}", handler.DeleteMetric())
+		r.Post("/counter/{key}/reset", handler.ResetCounter())
+	})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	client := resty.New().SetAuthToken("secret")
+
+	// Метки серии в адресе экранируются так же, как при чтении значения
+	response, err := client.R().Delete(ts.URL + "/admin/metric/gauge/Requests%3Bpath=%2Fapi")
+	require.NoError(t, err)
+	assert.Equal(t, 200, response.StatusCode())
+
+	response, err = client.R().Post(ts.URL + "/admin/counter/Hits%3Bpath=%2Fapi/reset")
+	require.NoError(t, err)
+	assert.Equal(t, 200, response.StatusCode())
+
+	gauges, counters := repository.GetAll()
+	assert.Empty(t, gauges)
+	assert.Equal(t, types.Counters{"Hits;path=/api": 0}, types.Counters(counters))
+}
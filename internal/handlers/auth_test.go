@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vllvll/devops/internal/auth"
+	"github.com/vllvll/devops/internal/middlewares"
+	"github.com/vllvll/devops/internal/repositories"
+	"github.com/vllvll/devops/internal/services"
+	"github.com/vllvll/devops/internal/types"
+)
+
+func TestHandler_BulkSaveMetricJSONAuth(t *testing.T) {
+	store := auth.NewFileStore(filepath.Join(t.TempDir(), "tokens.json"))
+
+	writer, writeToken, err := auth.Generate("team-a", []string{auth.ScopeWrite}, "teamA.")
+	require.NoError(t, err)
+	require.NoError(t, store.Save(writeToken))
+
+	reader, readToken, err := auth.Generate("dashboard", []string{auth.ScopeRead}, "")
+	require.NoError(t, err)
+	require.NoError(t, store.Save(readToken))
+
+	tests := []struct {
+		name     string
+		token    string
+		metric   types.Metrics
+		code     int
+		response string
+	}{
+		{
+			name:     "allowed prefix",
+			token:    writer,
+			metric:   types.Metrics{ID: "teamA.requests", MType: "counter", Delta: getCounter(1)},
+			code:     200,
+			response: "OK",
+		},
+		{
+			name:     "foreign prefix",
+			token:    writer,
+			metric:   types.Metrics{ID: "teamB.requests", MType: "counter", Delta: getCounter(1)},
+			code:     403,
+			response: "Forbidden",
+		},
+		{
+			name:     "missing scope",
+			token:    reader,
+			metric:   types.Metrics{ID: "teamA.requests", MType: "counter", Delta: getCounter(1)},
+			code:     403,
+			response: "Forbidden",
+		},
+		{
+			name:     "unknown token",
+			token:    "unknown",
+			metric:   types.Metrics{ID: "teamA.requests", MType: "counter", Delta: getCounter(1)},
+			code:     401,
+			response: "Unauthorized",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tenants := repositories.NewTenantMemoryRepository()
+			handler := NewHandler(tenants, services.NewMetricSigner(""), nil, nil, HandlerOptions{})
+
+			r := chi.NewRouter()
+			r.With(middlewares.Authenticate(store), middlewares.RequireScope(auth.ScopeWrite)).
+				Post("/updates/", handler.BulkSaveMetricJSON())
+
+			ts := httptest.NewServer(r)
+			defer ts.Close()
+
+			response, err := resty.New().SetAuthToken(tt.token).R().
+				SetBody([]types.Metrics{tt.metric}).
+				Post(ts.URL + "/updates/")
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.code, response.StatusCode())
+			assert.Equal(t, tt.response, strings.Trim(string(response.Body()), "\n"))
+		})
+	}
+}
@@ -2,11 +2,16 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 
+	"github.com/vllvll/devops/internal/auth"
+	"github.com/vllvll/devops/internal/batch"
 	"github.com/vllvll/devops/internal/dictionaries"
+	"github.com/vllvll/devops/internal/telemetry"
 	"github.com/vllvll/devops/internal/types"
+	"github.com/vllvll/devops/internal/validation"
 )
 
 // BulkSaveMetricJSON Сохранение всех описанных метрик (Gauge, Counter) в запросе за один раз
@@ -26,6 +31,7 @@ func (h Handler) BulkSaveMetricJSON() http.HandlerFunc {
 		if h.decrypt != nil {
 			encodedContent, err = h.decrypt.Decrypt(encodedContent)
 			if err != nil {
+				h.telemetry.Counter(telemetry.DecryptFailures, nil).Inc()
 				http.Error(rw, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 
 				return
@@ -38,30 +44,43 @@ func (h Handler) BulkSaveMetricJSON() http.HandlerFunc {
 			return
 		}
 
+		if r.URL.Query().Get(validation.PartialQuery) == "true" {
+			h.bulkSavePartial(rw, r, metrics)
+
+			return
+		}
+
+		if err := h.rules.Batch(metrics); err != nil {
+			writeValidationError(rw, r, err)
+
+			return
+		}
+
 		for _, metric := range metrics {
-			switch metric.MType {
-			case dictionaries.GaugeType:
-				if !h.signer.IsEqualHashGauge(metric.ID, *metric.Value, metric.Hash) {
-					http.Error(rw, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			if !auth.AllowsWrite(r.Context(), metric.ID) {
+				http.Error(rw, http.StatusText(http.StatusForbidden), http.StatusForbidden)
 
-					return
-				}
+				return
+			}
 
-				gauges[metric.ID] = types.Gauge(*metric.Value)
-			case dictionaries.CounterType:
-				if !h.signer.IsEqualHashCounter(metric.ID, *metric.Delta, metric.Hash) {
-					http.Error(rw, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			if !h.isEqualHash(metric) {
+				h.telemetry.Counter(telemetry.HashFailures, nil).Inc()
+				http.Error(rw, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 
-					return
-				}
+				return
+			}
 
-				counters[metric.ID] += types.Counter(*metric.Delta)
+			switch metric.MType {
+			case dictionaries.GaugeType:
+				gauges[metric.ID] = types.Gauge(*metric.Value)
+			case dictionaries.CounterType:
+				addCounter(r, counters, metric.ID, types.Counter(*metric.Delta))
 			}
 		}
 
-		err = h.repository.UpdateAll(gauges, counters)
+		err = h.save(r, gauges, counters)
 		if err != nil {
-			http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			writeSaveError(rw, r, err)
 
 			return
 		}
@@ -70,3 +89,86 @@ func (h Handler) BulkSaveMetricJSON() http.HandlerFunc {
 		rw.Write([]byte(http.StatusText(http.StatusOK)))
 	}
 }
+
+// bulkSavePartial Сохранение допустимых метрик пакета. Отклоненные метрики перечисляются в ответе, весь пакет
+// отклоняется только при превышении размера или ограничений
+func (h Handler) bulkSavePartial(rw http.ResponseWriter, r *http.Request, metrics []types.Metrics) {
+	var counters = types.Counters{}
+	var gauges = types.Gauges{}
+
+	result, err := h.rules.Partial(metrics)
+	if err != nil {
+		writeValidationError(rw, r, err)
+
+		return
+	}
+
+	for i, metric := range metrics {
+		if result.Rejects(i) {
+			continue
+		}
+
+		if !auth.AllowsWrite(r.Context(), metric.ID) {
+			result.Reject(i, metric.ID, validation.FieldID, "metric name is not allowed for token")
+
+			continue
+		}
+
+		if telemetry.IsReserved(metric.ID) {
+			result.Reject(i, metric.ID, validation.FieldID, ErrReservedName.Error())
+
+			continue
+		}
+
+		if !h.isEqualHash(metric) {
+			h.telemetry.Counter(telemetry.HashFailures, nil).Inc()
+			result.Reject(i, metric.ID, validation.FieldHash, "hash does not match")
+
+			continue
+		}
+
+		switch metric.MType {
+		case dictionaries.GaugeType:
+			gauges[metric.ID] = types.Gauge(*metric.Value)
+		case dictionaries.CounterType:
+			addCounter(r, counters, metric.ID, types.Counter(*metric.Delta))
+		}
+
+		result.Accepted++
+	}
+
+	err = h.save(r, gauges, counters)
+	if errors.Is(err, batch.ErrDuplicate) {
+		rw.Header().Set(batch.DuplicateHeader, "true")
+		result = &validation.Result{Rejected: []validation.Violation{}, Duplicate: true}
+	} else if err != nil {
+		writeSaveError(rw, r, err)
+
+		return
+	}
+
+	h.telemetry.Counter(telemetry.RejectedMetrics, map[string]string{"reason": validation.ReasonInvalid}).Add(result.RejectedCount())
+
+	response, err := json.Marshal(result)
+	if err != nil {
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(response)
+}
+
+// isEqualHash Проверка подписи метрики
+func (h Handler) isEqualHash(metric types.Metrics) bool {
+	switch metric.MType {
+	case dictionaries.GaugeType:
+		return h.signer.IsEqualHashGauge(metric.ID, *metric.Value, metric.Hash)
+	case dictionaries.CounterType:
+		return h.signer.IsEqualHashCounter(metric.ID, *metric.Delta, metric.Hash)
+	}
+
+	return false
+}
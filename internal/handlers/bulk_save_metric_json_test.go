@@ -2,18 +2,23 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-resty/resty/v2"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/vllvll/devops/internal/batch"
+	"github.com/vllvll/devops/internal/logger"
 	"github.com/vllvll/devops/internal/repositories"
 	"github.com/vllvll/devops/internal/services"
 	"github.com/vllvll/devops/internal/types"
+	"github.com/vllvll/devops/internal/validation"
 )
 
 func Example_bulkSaveMetricJSON() {
@@ -96,10 +101,10 @@ func TestHandler_BulkSaveMetricJSON(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			repository := repositories.NewStatsMemoryRepository()
+			tenants := repositories.NewTenantMemoryRepository()
 			signer := services.NewMetricSigner(tt.signerKey)
 			decrypt, _ := services.NewMetricDecrypt(tt.privateKeyPath)
-			handler := NewHandler(repository, signer, nil, decrypt)
+			handler := NewHandler(tenants, signer, nil, decrypt, HandlerOptions{})
 
 			r := chi.NewRouter()
 			r.Post("/updates/", handler.BulkSaveMetricJSON())
@@ -124,3 +129,268 @@ func TestHandler_BulkSaveMetricJSON(t *testing.T) {
 		})
 	}
 }
+
+func TestHandler_BulkSaveMetricJSONValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   *validation.Rules
+		body    string
+		code    int
+		detail  string
+		invalid []validation.Violation
+	}{
+		{
+			name:   "gauge without value and unknown type",
+			body:   `[{"id":"Alloc","type":"gauge"},{"id":"PollCount","type":"counter","delta":1},{"id":"Other","type":"histogram"}]`,
+			code:   400,
+			detail: "2 of 3 metrics are invalid",
+			invalid: []validation.Violation{
+				{Index: 0, ID: "Alloc", Field: validation.FieldValue, Reason: "value is required for gauge"},
+				{Index: 2, ID: "Other", Field: validation.FieldType, Reason: `unknown type "histogram", expected gauge or counter`},
+			},
+		},
+		{
+			name:   "batch too large",
+			rules:  validation.NewRules(0, 1, false),
+			body:   `[{"id":"Alloc","type":"gauge","value":1},{"id":"PollCount","type":"counter","delta":1}]`,
+			code:   413,
+			detail: "batch of 2 metrics exceeds the limit of 1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tenants := repositories.NewTenantMemoryRepository()
+			handler := NewHandler(tenants, services.NewMetricSigner(""), nil, nil, HandlerOptions{Rules: tt.rules})
+
+			r := chi.NewRouter()
+			r.Post("/updates/", handler.BulkSaveMetricJSON())
+
+			ts := httptest.NewServer(r)
+			defer ts.Close()
+
+			response, err := resty.New().R().
+				SetHeader("Content-Type", "application/json").
+				SetBody(tt.body).
+				Post(ts.URL + "/updates/")
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.code, response.StatusCode())
+			assert.Equal(t, ProblemContentType, response.Header().Get("Content-Type"))
+
+			var problem Problem
+			require.NoError(t, json.Unmarshal(response.Body(), &problem))
+			assert.Equal(t, tt.code, problem.Status)
+			assert.Equal(t, "/updates/", problem.Instance)
+			assert.Equal(t, tt.detail, problem.Detail)
+			assert.Equal(t, tt.invalid, problem.Errors)
+
+			gauges, counters := tenants.Tenant("").GetAll()
+			assert.Empty(t, gauges)
+			assert.Empty(t, counters)
+		})
+	}
+}
+
+func TestHandler_BulkSaveMetricJSONPartial(t *testing.T) {
+	signer := services.NewMetricSigner("secret")
+	tenants := repositories.NewTenantMemoryRepository()
+	handler := NewHandler(tenants, signer, nil, nil, HandlerOptions{})
+
+	r := chi.NewRouter()
+	r.Post("/updates/", handler.BulkSaveMetricJSON())
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	metrics := []types.Metrics{
+		{ID: "Alloc", MType: "gauge", Value: getGauge(1.5), Hash: signer.GetHashGauge("Alloc", 1.5)},
+		{ID: "PollCount", MType: "counter", Delta: getCounter(5), Hash: "errorhash"},
+		{ID: "Other", MType: "gauge"},
+		{ID: "Requests", MType: "counter", Delta: getCounter(2), Hash: signer.GetHashCounter("Requests", 2)},
+		{ID: "devops_server_fake", MType: "counter", Delta: getCounter(1), Hash: signer.GetHashCounter("devops_server_fake", 1)},
+	}
+
+	response, err := resty.New().R().
+		SetHeader("Content-Type", "application/json").
+		SetQueryParam(validation.PartialQuery, "true").
+		SetBody(metrics).
+		Post(ts.URL + "/updates/")
+	require.NoError(t, err)
+
+	assert.Equal(t, 200, response.StatusCode())
+	assert.Equal(t, "application/json", response.Header().Get("Content-Type"))
+
+	var result validation.Result
+	require.NoError(t, json.Unmarshal(response.Body(), &result))
+	assert.Equal(t, 2, result.Accepted)
+	assert.Equal(t, []validation.Violation{
+		{Index: 1, ID: "PollCount", Field: validation.FieldHash, Reason: "hash does not match"},
+		{Index: 2, ID: "Other", Field: validation.FieldValue, Reason: "value is required for gauge"},
+		{Index: 4, ID: "devops_server_fake", Field: validation.FieldID, Reason: ErrReservedName.Error()},
+	}, result.Rejected)
+
+	gauges, counters := tenants.Tenant("").GetAll()
+	assert.Equal(t, map[string]types.Gauge{"Alloc": 1.5}, gauges)
+	assert.Equal(t, map[string]types.Counter{"Requests": 2}, counters)
+}
+
+func TestHandler_BulkSaveMetricJSONBatchID(t *testing.T) {
+	tenants := repositories.NewTenantMemoryRepository()
+	batches := batch.NewMemoryStore(time.Minute, 0)
+	handler := NewHandler(tenants, services.NewMetricSigner(""), nil, nil, HandlerOptions{Batches: batches})
+
+	r := chi.NewRouter()
+	r.Post("/updates/", handler.BulkSaveMetricJSON())
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	metrics := []types.Metrics{{ID: "PollCount", MType: "counter", Delta: getCounter(5)}}
+
+	for i := 0; i < 2; i++ {
+		response, err := resty.New().R().
+			SetHeader("Content-Type", "application/json").
+			SetHeader(batch.Header, "batch-1").
+			SetBody(metrics).
+			Post(ts.URL + "/updates/")
+		require.NoError(t, err)
+
+		assert.Equal(t, 200, response.StatusCode())
+		assert.Equal(t, i == 1, response.Header().Get(batch.DuplicateHeader) == "true")
+	}
+
+	response, err := resty.New().R().
+		SetHeader("Content-Type", "application/json").
+		SetHeader(batch.Header, "batch-2").
+		SetQueryParam(validation.PartialQuery, "true").
+		SetBody(metrics).
+		Post(ts.URL + "/updates/")
+	require.NoError(t, err)
+	assert.Equal(t, 200, response.StatusCode())
+
+	response, err = resty.New().R().
+		SetHeader("Content-Type", "application/json").
+		SetHeader(batch.Header, strings.Repeat("a", batch.MaxIDLength+1)).
+		SetBody(metrics).
+		Post(ts.URL + "/updates/")
+	require.NoError(t, err)
+	assert.Equal(t, 400, response.StatusCode())
+
+	// Пакет, запись которого еще не завершена, не подтверждается как примененный
+	require.NoError(t, batches.Claim("", "batch-3"))
+
+	response, err = resty.New().R().
+		SetHeader("Content-Type", "application/json").
+		SetHeader(batch.Header, "batch-3").
+		SetBody(metrics).
+		Post(ts.URL + "/updates/")
+	require.NoError(t, err)
+	assert.Equal(t, 409, response.StatusCode())
+	assert.Equal(t, batch.RetryAfter, response.Header().Get("Retry-After"))
+
+	_, counters := tenants.Tenant("").GetAll()
+	assert.Equal(t, map[string]types.Counter{"PollCount": 10}, counters)
+}
+
+func TestHandler_BulkSaveMetricJSONCumulative(t *testing.T) {
+	tenants := repositories.NewTenantMemoryRepository()
+	handler := NewHandler(tenants, services.NewMetricSigner(""), nil, nil, HandlerOptions{Totals: repositories.NewTenantTotalsRepository()})
+
+	r := chi.NewRouter()
+	r.Post("/updates/", handler.BulkSaveMetricJSON())
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	push := func(agent string, mode string, metrics ...types.Metrics) *resty.Response {
+		response, err := resty.New().R().
+			SetHeader("Content-Type", "application/json").
+			SetHeader(logger.AgentIDHeader, agent).
+			SetHeader(repositories.CounterModeHeader, mode).
+			SetBody(metrics).
+			Post(ts.URL + "/updates/")
+		require.NoError(t, err)
+
+		return response
+	}
+
+	// Агент присылает накопленные значения, сервер сохраняет приращения
+	assert.Equal(t, 200, push("web-1", repositories.CounterModeCumulative, types.Metrics{ID: "PollCount", MType: "counter", Delta: getCounter(5)}).StatusCode())
+	assert.Equal(t, 200, push("web-1", repositories.CounterModeCumulative,
+		types.Metrics{ID: "PollCount", MType: "counter", Delta: getCounter(7)},
+		types.Metrics{ID: "PollCount", MType: "counter", Delta: getCounter(9)},
+	).StatusCode())
+
+	// После перезапуска агента значение начинается с нуля
+	assert.Equal(t, 200, push("web-1", repositories.CounterModeCumulative, types.Metrics{ID: "PollCount", MType: "counter", Delta: getCounter(2)}).StatusCode())
+	assert.Equal(t, 200, push("web-2", repositories.CounterModeCumulative, types.Metrics{ID: "PollCount", MType: "counter", Delta: getCounter(4)}).StatusCode())
+
+	// Приращения без заголовка складываются как раньше
+	assert.Equal(t, 200, push("web-3", "", types.Metrics{ID: "PollCount", MType: "counter", Delta: getCounter(1)}).StatusCode())
+
+	assert.Equal(t, 400, push("web-1", "unknown", types.Metrics{ID: "PollCount", MType: "counter", Delta: getCounter(1)}).StatusCode())
+
+	_, counters := tenants.Tenant("").GetAll()
+	assert.Equal(t, map[string]types.Counter{"PollCount": 16}, counters)
+}
+
+// failingTenants Хранилище, запись в которое завершается ошибкой, пока failures больше нуля
+type failingTenants struct {
+	repositories.TenantRepository
+
+	failures int
+}
+
+func (t *failingTenants) Tenant(name string) repositories.StatsRepository {
+	return &failingStats{StatsRepository: t.TenantRepository.Tenant(name), tenants: t}
+}
+
+type failingStats struct {
+	repositories.StatsRepository
+
+	tenants *failingTenants
+}
+
+func (s *failingStats) UpdateAll(gauges types.Gauges, counters types.Counters) error {
+	if s.tenants.failures > 0 {
+		s.tenants.failures--
+
+		return errors.New("database is unavailable")
+	}
+
+	return s.StatsRepository.UpdateAll(gauges, counters)
+}
+
+func TestHandler_BulkSaveMetricJSONCumulativeRetry(t *testing.T) {
+	tenants := &failingTenants{TenantRepository: repositories.NewTenantMemoryRepository()}
+	handler := NewHandler(tenants, services.NewMetricSigner(""), nil, nil, HandlerOptions{Totals: repositories.NewTenantTotalsRepository()})
+
+	r := chi.NewRouter()
+	r.Post("/updates/", handler.BulkSaveMetricJSON())
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	push := func(total int64) int {
+		response, err := resty.New().R().
+			SetHeader("Content-Type", "application/json").
+			SetHeader(logger.AgentIDHeader, "web-1").
+			SetHeader(repositories.CounterModeHeader, repositories.CounterModeCumulative).
+			SetBody([]types.Metrics{{ID: "PollCount", MType: "counter", Delta: getCounter(total)}}).
+			Post(ts.URL + "/updates/")
+		require.NoError(t, err)
+
+		return response.StatusCode()
+	}
+
+	assert.Equal(t, 200, push(5))
+
+	// Накопленное значение не запоминается, пока приращение не записано, поэтому повтор его не теряет
+	tenants.failures = 1
+	assert.Equal(t, 500, push(12))
+	assert.Equal(t, 200, push(12))
+
+	_, counters := tenants.Tenant("").GetAll()
+	assert.Equal(t, map[string]types.Counter{"PollCount": 12}, counters)
+}
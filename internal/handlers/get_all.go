@@ -1,28 +1,198 @@
 package handlers
 
 import (
+	"embed"
+	"encoding/json"
 	"fmt"
+	"html/template"
+	"math"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vllvll/devops/internal/dictionaries"
+	"github.com/vllvll/devops/internal/repositories"
+	"github.com/vllvll/devops/internal/types"
+)
+
+// Период и размер графиков последних значений на странице метрик
+const (
+	sparklinePeriod = 15 * time.Minute
+	sparklineWidth  = 120
+	sparklineHeight = 24
 )
 
-// GetAll Получение всех метрик типа Gauge и Counter в текстовом формате
+//go:embed templates/dashboard.html
+var dashboardFS embed.FS
+
+//go:embed static
+var staticFS embed.FS
+
+var dashboardTemplate = template.Must(template.ParseFS(dashboardFS, "templates/dashboard.html"))
+
+type dashboardMetric struct {
+	Name      string
+	Type      string
+	Value     string
+	Sparkline string
+}
+
+type dashboardPage struct {
+	Metrics         []dashboardMetric
+	UpdatedAt       string
+	SparklineWidth  int
+	SparklineHeight int
+}
+
+// GetAll Получение всех метрик типа Gauge и Counter.
+// Страница с метриками в формате HTML, текст при запросе с заголовком Accept: text/plain
+// или JSON при запросе с заголовком Accept: application/json
 func (h Handler) GetAll() http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
-		gauges, counters := h.repository.GetAll()
+		gauges, counters := h.repository(r).GetAll()
+		history := h.tenantHistory(r)
+
+		gaugeKeys := make([]string, 0, len(gauges))
+		for key := range gauges {
+			gaugeKeys = append(gaugeKeys, key)
+		}
+		sort.Strings(gaugeKeys)
+
+		counterKeys := make([]string, 0, len(counters))
+		for key := range counters {
+			counterKeys = append(counterKeys, key)
+		}
+		sort.Strings(counterKeys)
+
+		if strings.Contains(r.Header.Get("Accept"), "application/json") {
+			metrics := make([]types.Metrics, 0, len(gauges)+len(counters))
+
+			for _, key := range gaugeKeys {
+				value := float64(gauges[key])
+				metrics = append(metrics, types.Metrics{ID: key, MType: dictionaries.GaugeType, Value: &value})
+			}
+
+			for _, key := range counterKeys {
+				delta := int64(counters[key])
+				metrics = append(metrics, types.Metrics{ID: key, MType: dictionaries.CounterType, Delta: &delta})
+			}
+
+			response, err := json.Marshal(metrics)
+			if err != nil {
+				http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+
+				return
+			}
+
+			rw.Header().Set("Content-Type", "application/json")
+			rw.WriteHeader(http.StatusOK)
+			rw.Write(response)
+
+			return
+		}
+
+		if strings.Contains(r.Header.Get("Accept"), "text/plain") {
+			var answer strings.Builder
+
+			answer.WriteString("Gauges:\n")
+			for _, key := range gaugeKeys {
+				fmt.Fprintf(&answer, "%s - %s\n", key, strconv.FormatFloat(float64(gauges[key]), 'f', 3, 64))
+			}
+
+			answer.WriteString("Counters:\n")
+			for _, key := range counterKeys {
+				fmt.Fprintf(&answer, "%s - %s\n", key, strconv.FormatInt(int64(counters[key]), 10))
+			}
+
+			rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			rw.WriteHeader(http.StatusOK)
+			rw.Write([]byte(answer.String()))
 
-		answer := "Gauges:\n"
-		for key, value := range gauges {
-			answer += fmt.Sprintf("%s - %s\n", key, strconv.FormatFloat(float64(value), 'f', 3, 64))
+			return
 		}
 
-		answer += "Counters:\n"
-		for key, value := range counters {
-			answer += fmt.Sprintf("%s - %s\n", key, strconv.FormatInt(int64(value), 10))
+		now := time.Now()
+		page := dashboardPage{
+			Metrics:         make([]dashboardMetric, 0, len(gauges)+len(counters)),
+			UpdatedAt:       now.Format(time.RFC3339),
+			SparklineWidth:  sparklineWidth,
+			SparklineHeight: sparklineHeight,
 		}
 
-		rw.Header().Set("Content-Type", "text/html")
+		gaugeSamples := sparklineSamples(history, dictionaries.GaugeType, now)
+		for _, key := range gaugeKeys {
+			page.Metrics = append(page.Metrics, dashboardMetric{
+				Name:      key,
+				Type:      dictionaries.GaugeType,
+				Value:     strconv.FormatFloat(float64(gauges[key]), 'f', 3, 64),
+				Sparkline: sparkline(gaugeSamples[key]),
+			})
+		}
+
+		counterSamples := sparklineSamples(history, dictionaries.CounterType, now)
+		for _, key := range counterKeys {
+			page.Metrics = append(page.Metrics, dashboardMetric{
+				Name:      key,
+				Type:      dictionaries.CounterType,
+				Value:     strconv.FormatInt(int64(counters[key]), 10),
+				Sparkline: sparkline(counterSamples[key]),
+			})
+		}
+
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
 		rw.WriteHeader(http.StatusOK)
-		rw.Write([]byte(answer))
+
+		if err := dashboardTemplate.Execute(rw, page); err != nil {
+			http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
 	}
 }
+
+// Static Получение статических файлов страницы метрик
+func (h Handler) Static() http.HandlerFunc {
+	return http.FileServer(http.FS(staticFS)).ServeHTTP
+}
+
+// sparklineSamples Последние значения всех метрик типа mType для графиков, прочитанные из истории одним запросом.
+// Без истории возвращается nil
+func sparklineSamples(history repositories.HistoryRepository, mType string, now time.Time) map[string][]repositories.Sample {
+	if history == nil {
+		return nil
+	}
+
+	return history.RangeAll(mType, now.Add(-sparklinePeriod), now)
+}
+
+// sparkline Координаты точек графика последних значений метрики в формате SVG polyline
+func sparkline(samples []repositories.Sample) string {
+	if len(samples) < 2 {
+		return ""
+	}
+
+	return sparklinePoints(samples)
+}
+
+// sparklinePoints Масштабирование значений в размеры графика
+func sparklinePoints(samples []repositories.Sample) string {
+	low, high := math.Inf(1), math.Inf(-1)
+	for _, sample := range samples {
+		low = math.Min(low, sample.Value)
+		high = math.Max(high, sample.Value)
+	}
+
+	points := make([]string, 0, len(samples))
+	for i, sample := range samples {
+		x := float64(i) * sparklineWidth / float64(len(samples)-1)
+		y := float64(sparklineHeight) / 2
+
+		if high > low {
+			y = sparklineHeight - (sample.Value-low)*sparklineHeight/(high-low)
+		}
+
+		points = append(points, strconv.FormatFloat(x, 'f', 1, 64)+","+strconv.FormatFloat(y, 'f', 1, 64))
+	}
+
+	return strings.Join(points, " ")
+}
@@ -4,6 +4,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/vllvll/devops/internal/dictionaries"
 
@@ -14,12 +15,13 @@ import (
 
 	"github.com/vllvll/devops/internal/repositories"
 	"github.com/vllvll/devops/internal/services"
+	"github.com/vllvll/devops/internal/tenant"
 	"github.com/vllvll/devops/internal/types"
 )
 
 func Example_getAll() {
 	client := resty.New()
-	_, _ = client.R().Get("/")
+	_, _ = client.R().SetHeader("Accept", "text/plain").Get("/")
 }
 
 func TestHandler_GetAll(t *testing.T) {
@@ -33,11 +35,13 @@ func TestHandler_GetAll(t *testing.T) {
 		name           string
 		signerKey      string
 		privateKeyPath string
+		accept         string
 		metric         types.Metrics
 		want           want
 	}{
 		{
-			name: "gauge success",
+			name:   "gauge success",
+			accept: "text/plain",
 			metric: types.Metrics{
 				ID:    "Alloc",
 				MType: "gauge",
@@ -47,11 +51,12 @@ func TestHandler_GetAll(t *testing.T) {
 			want: want{
 				code:        200,
 				response:    "Gauges:\nAlloc - 0.100\nCounters:",
-				contentType: "text/html",
+				contentType: "text/plain; charset=utf-8",
 			},
 		},
 		{
-			name: "counter success",
+			name:   "counter success",
+			accept: "text/plain",
 			metric: types.Metrics{
 				ID:    "PollCount",
 				MType: "counter",
@@ -61,14 +66,45 @@ func TestHandler_GetAll(t *testing.T) {
 			want: want{
 				code:        200,
 				response:    "Gauges:\nCounters:\nPollCount - 100",
-				contentType: "text/html",
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+		{
+			name:   "json success",
+			accept: "application/json",
+			metric: types.Metrics{
+				ID:    "Alloc",
+				MType: "gauge",
+				Value: getGauge(0.123456),
+				Hash:  "",
+			},
+			want: want{
+				code:        200,
+				response:    `[{"id":"Alloc","type":"gauge","value":0.123456}]`,
+				contentType: "application/json",
+			},
+		},
+		{
+			name:   "dashboard success",
+			accept: "text/html",
+			metric: types.Metrics{
+				ID:    "PollCount",
+				MType: "counter",
+				Delta: getCounter(100),
+				Hash:  "",
+			},
+			want: want{
+				code:        200,
+				response:    `<td class="value">100</td>`,
+				contentType: "text/html; charset=utf-8",
 			},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			repository := repositories.NewStatsMemoryRepository()
+			tenants := repositories.NewTenantMemoryRepository()
+			repository := tenants.Tenant(tenant.Default)
 			switch tt.metric.MType {
 			case dictionaries.CounterType:
 				repository.UpdateCount(tt.metric.ID, types.Counter(*tt.metric.Delta))
@@ -78,7 +114,7 @@ func TestHandler_GetAll(t *testing.T) {
 
 			signer := services.NewMetricSigner(tt.signerKey)
 			decrypt, _ := services.NewMetricDecrypt(tt.privateKeyPath)
-			handler := NewHandler(repository, signer, nil, decrypt)
+			handler := NewHandler(tenants, signer, nil, decrypt, HandlerOptions{})
 
 			r := chi.NewRouter()
 			r.Get("/", handler.GetAll())
@@ -88,12 +124,46 @@ func TestHandler_GetAll(t *testing.T) {
 			ts := httptest.NewServer(r)
 			defer ts.Close()
 
-			response, err := client.R().Get(ts.URL)
+			response, err := client.R().SetHeader("Accept", tt.accept).Get(ts.URL)
 			require.NoError(t, err)
 
 			assert.Equal(t, tt.want.code, response.StatusCode())
-			assert.Equal(t, tt.want.response, strings.Trim(string(response.Body()), "\n"))
+			if tt.accept == "text/plain" {
+				assert.Equal(t, tt.want.response, strings.Trim(string(response.Body()), "\n"))
+			} else {
+				assert.Contains(t, string(response.Body()), tt.want.response)
+			}
 			assert.Equal(t, tt.want.contentType, response.Header().Get("Content-Type"))
 		})
 	}
 }
+
+func TestHandler_GetAllSparkline(t *testing.T) {
+	now := time.Now()
+
+	tenants := repositories.NewTenantMemoryRepository()
+	repository := tenants.Tenant(tenant.Default)
+	repository.UpdateGauge("Alloc", 3)
+
+	history := repositories.NewTenantHistoryRepository([]repositories.Tier{{Retention: time.Hour}})
+	history.Tenant(tenant.Default).Record(now.Add(-2*time.Minute), types.Gauges{"Alloc": 1}, nil)
+	history.Tenant(tenant.Default).Record(now.Add(-time.Minute), types.Gauges{"Alloc": 3}, nil)
+
+	handler := NewHandler(tenants, services.NewMetricSigner(""), nil, nil, HandlerOptions{History: history})
+
+	r := chi.NewRouter()
+	r.Get("/", handler.GetAll())
+	r.Get("/static/*", handler.Static())
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	response, err := resty.New().R().Get(ts.URL)
+	require.NoError(t, err)
+	assert.Contains(t, string(response.Body()), `<polyline points="0.0,24.0 120.0,0.0"/>`)
+
+	response, err = resty.New().R().Get(ts.URL + "/static/dashboard.js")
+	require.NoError(t, err)
+	assert.Equal(t, 200, response.StatusCode())
+	assert.Contains(t, response.Header().Get("Content-Type"), "javascript")
+}
@@ -4,20 +4,20 @@ import (
 	"net/http"
 	"strconv"
 
-	"github.com/go-chi/chi/v5"
+	"github.com/vllvll/devops/internal/urlparam"
 )
 
 // GetCounter Получение значения типа Counter по ключу
 func (h Handler) GetCounter() http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
-		key := chi.URLParam(r, "key")
+		key := urlparam.Key(r)
 		if key == "" {
 			http.Error(rw, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 
 			return
 		}
 
-		value, err := h.repository.GetCounterByKey(key)
+		value, err := h.repository(r).GetCounterByKey(key)
 		if err != nil {
 			http.Error(rw, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 
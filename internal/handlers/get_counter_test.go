@@ -12,6 +12,7 @@ import (
 
 	"github.com/vllvll/devops/internal/repositories"
 	"github.com/vllvll/devops/internal/services"
+	"github.com/vllvll/devops/internal/tenant"
 	"github.com/vllvll/devops/internal/types"
 )
 
@@ -63,12 +64,13 @@ func TestHandler_GetCounter(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			repository := repositories.NewStatsMemoryRepository()
+			tenants := repositories.NewTenantMemoryRepository()
+			repository := tenants.Tenant(tenant.Default)
 			repository.UpdateCount(tt.metric.ID, types.Counter(*tt.metric.Delta))
 
 			signer := services.NewMetricSigner(tt.signerKey)
 			decrypt, _ := services.NewMetricDecrypt(tt.privateKeyPath)
-			handler := NewHandler(repository, signer, nil, decrypt)
+			handler := NewHandler(tenants, signer, nil, decrypt, HandlerOptions{})
 
 			r := chi.NewRouter()
 			r.Get("/value/counter/{key:[A-Za-z0-9]+}", handler.GetCounter())
@@ -4,20 +4,20 @@ import (
 	"net/http"
 	"strconv"
 
-	"github.com/go-chi/chi/v5"
+	"github.com/vllvll/devops/internal/urlparam"
 )
 
 // GetGauge Получение значения типа Gauge по ключу
 func (h Handler) GetGauge() http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
-		key := chi.URLParam(r, "key")
+		key := urlparam.Key(r)
 		if key == "" {
 			http.Error(rw, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 
 			return
 		}
 
-		value, err := h.repository.GetGaugeByKey(key)
+		value, err := h.repository(r).GetGaugeByKey(key)
 		if err != nil {
 			http.Error(rw, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
 
@@ -12,6 +13,7 @@ import (
 
 	"github.com/vllvll/devops/internal/repositories"
 	"github.com/vllvll/devops/internal/services"
+	"github.com/vllvll/devops/internal/tenant"
 	"github.com/vllvll/devops/internal/types"
 )
 
@@ -48,6 +50,19 @@ func TestHandler_GetGauge(t *testing.T) {
 				contentType: "text/plain; charset=utf-8",
 			},
 		},
+		{
+			name: "series with labels",
+			metric: types.Metrics{
+				ID:    "cpu_usage;host=server 01/a",
+				MType: "gauge",
+				Value: getGauge(0.64),
+			},
+			want: want{
+				code:        200,
+				response:    "0.640",
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
 		{
 			name: "without gauge key",
 			metric: types.Metrics{
@@ -63,22 +78,23 @@ func TestHandler_GetGauge(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			repository := repositories.NewStatsMemoryRepository()
+			tenants := repositories.NewTenantMemoryRepository()
+			repository := tenants.Tenant(tenant.Default)
 			repository.UpdateGauge(tt.metric.ID, types.Gauge(*tt.metric.Value))
 
 			signer := services.NewMetricSigner(tt.signerKey)
 			decrypt, _ := services.NewMetricDecrypt(tt.privateKeyPath)
-			handler := NewHandler(repository, signer, nil, decrypt)
+			handler := NewHandler(tenants, signer, nil, decrypt, HandlerOptions{})
 
 			r := chi.NewRouter()
-			r.Get("/value/gauge/{key:[A-Za-z0-9]+}", handler.GetGauge())
+			r.Get("/value/gauge/{key}", handler.GetGauge())
 
 			client := resty.New()
 
 			ts := httptest.NewServer(r)
 			defer ts.Close()
 
-			response, err := client.R().Get(ts.URL + "/value/gauge/" + tt.metric.ID)
+			response, err := client.R().Get(ts.URL + "/value/gauge/" + url.PathEscape(tt.metric.ID))
 			require.NoError(t, err)
 
 			assert.Equal(t, tt.want.code, response.StatusCode())
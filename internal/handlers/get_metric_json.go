@@ -23,7 +23,7 @@ func (h Handler) GetMetricJSON() http.HandlerFunc {
 		case dictionaries.GaugeType:
 			var value float64
 
-			gauge, err := h.repository.GetGaugeByKey(metric.ID)
+			gauge, err := h.repository(r).GetGaugeByKey(metric.ID)
 			if err != nil {
 				http.Error(rw, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 
@@ -37,7 +37,7 @@ func (h Handler) GetMetricJSON() http.HandlerFunc {
 		case dictionaries.CounterType:
 			var value int64
 
-			counter, err := h.repository.GetCounterByKey(metric.ID)
+			counter, err := h.repository(r).GetCounterByKey(metric.ID)
 			if err != nil {
 				http.Error(rw, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 
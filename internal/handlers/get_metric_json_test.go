@@ -15,6 +15,7 @@ import (
 
 	"github.com/vllvll/devops/internal/repositories"
 	"github.com/vllvll/devops/internal/services"
+	"github.com/vllvll/devops/internal/tenant"
 	"github.com/vllvll/devops/internal/types"
 )
 
@@ -76,7 +77,8 @@ func TestHandler_GetMetricJSON(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			repository := repositories.NewStatsMemoryRepository()
+			tenants := repositories.NewTenantMemoryRepository()
+			repository := tenants.Tenant(tenant.Default)
 			switch tt.metric.MType {
 			case dictionaries.CounterType:
 				repository.UpdateCount(tt.metric.ID, types.Counter(*tt.metric.Delta))
@@ -86,7 +88,7 @@ func TestHandler_GetMetricJSON(t *testing.T) {
 
 			signer := services.NewMetricSigner(tt.signerKey)
 			decrypt, _ := services.NewMetricDecrypt(tt.privateKeyPath)
-			handler := NewHandler(repository, signer, nil, decrypt)
+			handler := NewHandler(tenants, signer, nil, decrypt, HandlerOptions{})
 
 			r := chi.NewRouter()
 			r.Post("/value/", handler.GetMetricJSON())
@@ -146,10 +148,10 @@ func TestHandler_GetMetricJSON(t *testing.T) {
 
 	for _, tt := range failTests {
 		t.Run(tt.name, func(t *testing.T) {
-			repository := repositories.NewStatsMemoryRepository()
+			tenants := repositories.NewTenantMemoryRepository()
 			signer := services.NewMetricSigner("")
 			decrypt, _ := services.NewMetricDecrypt(tt.privateKeyPath)
-			handler := NewHandler(repository, signer, nil, decrypt)
+			handler := NewHandler(tenants, signer, nil, decrypt, HandlerOptions{})
 
 			r := chi.NewRouter()
 			r.Post("/value/", handler.GetMetricJSON())
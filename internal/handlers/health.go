@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/vllvll/devops/internal/health"
+)
+
+type HealthHandler struct {
+	checker *health.Checker // Проверки готовности сервера
+}
+
+// NewHealthHandler Получение хендлера для проверок живости и готовности
+func NewHealthHandler(checker *health.Checker) *HealthHandler {
+	return &HealthHandler{
+		checker: checker,
+	}
+}
+
+// HealthHandlers Список методов для хендлеров проверок живости и готовности (сервер)
+type HealthHandlers interface {
+	Healthz() http.HandlerFunc
+	Readyz() http.HandlerFunc
+}
+
+// Healthz Проверка живости: процесс запущен и обрабатывает запросы
+func (h HealthHandler) Healthz() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		writeHealth(rw, http.StatusOK, health.Report{Status: health.StatusOK, Checks: map[string]health.Result{}})
+	}
+}
+
+// Readyz Проверка готовности: результаты проверок всех зависимостей, 503 при неуспешной проверке
+func (h HealthHandler) Readyz() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		report := h.checker.Run(r.Context())
+
+		code := http.StatusOK
+		if !report.Ready() {
+			code = http.StatusServiceUnavailable
+		}
+
+		writeHealth(rw, code, report)
+	}
+}
+
+// writeHealth Ответ с результатом проверок в формате JSON
+func writeHealth(rw http.ResponseWriter, code int, report health.Report) {
+	response, err := json.Marshal(report)
+	if err != nil {
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+	rw.Write(response)
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vllvll/devops/internal/health"
+)
+
+func TestHealthHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		loaded bool
+		code   int
+		status string
+		checks int
+	}{
+		{
+			name:   "healthz",
+			path:   "/healthz",
+			loaded: false,
+			code:   200,
+			status: health.StatusOK,
+			checks: 0,
+		},
+		{
+			name:   "readyz ok",
+			path:   "/readyz",
+			loaded: true,
+			code:   200,
+			status: health.StatusOK,
+			checks: 1,
+		},
+		{
+			name:   "readyz fail",
+			path:   "/readyz",
+			loaded: false,
+			code:   503,
+			status: health.StatusFail,
+			checks: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := health.NewChecker(time.Second)
+			checker.Add("crypto_key", health.Loaded(tt.loaded))
+			handler := NewHealthHandler(checker)
+
+			r := chi.NewRouter()
+			r.Get("/healthz", handler.Healthz())
+			r.Get("/readyz", handler.Readyz())
+
+			ts := httptest.NewServer(r)
+			defer ts.Close()
+
+			response, err := resty.New().R().Get(ts.URL + tt.path)
+			require.NoError(t, err)
+
+			var report health.Report
+			require.NoError(t, json.Unmarshal(response.Body(), &report))
+
+			assert.Equal(t, tt.code, response.StatusCode())
+			assert.Equal(t, "application/json", response.Header().Get("Content-Type"))
+			assert.Equal(t, tt.status, report.Status)
+			assert.Len(t, report.Checks, tt.checks)
+		})
+	}
+}
@@ -3,26 +3,62 @@ package handlers
 
 import (
 	"database/sql"
+	"errors"
+	"net"
 	"net/http"
 
+	"go.uber.org/zap"
+
+	"github.com/vllvll/devops/internal/auth"
+	"github.com/vllvll/devops/internal/batch"
+	"github.com/vllvll/devops/internal/limits"
+	"github.com/vllvll/devops/internal/logger"
 	"github.com/vllvll/devops/internal/repositories"
 	"github.com/vllvll/devops/internal/services"
+	"github.com/vllvll/devops/internal/telemetry"
+	"github.com/vllvll/devops/internal/tenant"
+	"github.com/vllvll/devops/internal/types"
+	"github.com/vllvll/devops/internal/validation"
 )
 
+// ErrReservedName Имя метрики занято метриками самого сервера
+var ErrReservedName = services.ErrReservedName
+
+// ErrCounterMode Неизвестный режим записи Counter в заголовке repositories.CounterModeHeader
+var ErrCounterMode = services.ErrCounterMode
+
 type Handler struct {
-	repository repositories.StatsRepository // Сервис для чтения и записи данных метрик
-	signer     services.Signer              // Сервис для создания подписи
-	db         *sql.DB                      // База данных
-	decrypt    services.Decrypt             // Сервис для расшифрования данных
+	tenants   repositories.TenantRepository        // Сервис для чтения и записи данных метрик арендаторов
+	history   repositories.TenantHistoryRepository // Сервис для чтения истории значений метрик арендаторов
+	ingest    services.Ingest                      // Сервис записи метрик с проверкой ограничений и повторов
+	signer    services.Signer                      // Сервис для создания подписи
+	db        *sql.DB                              // База данных
+	decrypt   services.Decrypt                     // Сервис для расшифрования данных
+	rules     *validation.Rules                    // Правила проверки метрик (nil - правила по умолчанию)
+	telemetry *telemetry.Registry                  // Метрики сервера (nil - не собираются)
+}
+
+// HandlerOptions Необязательные зависимости хендлера. Нулевое значение поля выключает соответствующую возможность
+type HandlerOptions struct {
+	History   repositories.TenantHistoryRepository // История значений метрик арендаторов (nil - история не ведется)
+	Totals    repositories.TenantTotalsRepository  // Накопленные значения счетчиков агентов (nil - режим накопленных значений выключен)
+	Limits    *limits.Limits                       // Ограничения арендаторов и агентов (nil - без ограничений)
+	Rules     *validation.Rules                    // Правила проверки метрик (nil - правила по умолчанию)
+	Batches   batch.Store                          // Идентификаторы примененных пакетов (nil - без защиты от повторов)
+	Telemetry *telemetry.Registry                  // Метрики сервера (nil - не собираются)
 }
 
 // NewHandler Получение хендлера
-func NewHandler(repository repositories.StatsRepository, signer services.Signer, db *sql.DB, decrypt services.Decrypt) *Handler {
+func NewHandler(tenants repositories.TenantRepository, signer services.Signer, db *sql.DB, decrypt services.Decrypt, options HandlerOptions) *Handler {
 	return &Handler{
-		repository: repository,
-		signer:     signer,
-		db:         db,
-		decrypt:    decrypt,
+		tenants:   tenants,
+		history:   options.History,
+		ingest:    services.NewMetricIngest(tenants, options.Totals, options.Limits, options.Batches, options.Telemetry),
+		signer:    signer,
+		db:        db,
+		decrypt:   decrypt,
+		rules:     options.Rules,
+		telemetry: options.Telemetry,
 	}
 }
 
@@ -31,9 +67,130 @@ type MetricHandlers interface {
 	SaveMetricJSON() http.HandlerFunc
 	SaveMetric() http.HandlerFunc
 	GetAll() http.HandlerFunc
+	Static() http.HandlerFunc
 	GetMetricJSON() http.HandlerFunc
 	GetGauge() http.HandlerFunc
 	GetCounter() http.HandlerFunc
 	Ping() http.HandlerFunc
 	BulkSaveMetricJSON() http.HandlerFunc
+	WriteLineProtocol() http.HandlerFunc
+	Query() http.HandlerFunc
+}
+
+// repository Метрики арендатора, от имени которого выполняется запрос
+func (h Handler) repository(r *http.Request) repositories.StatsRepository {
+	return h.tenants.Tenant(tenant.FromContext(r.Context()))
+}
+
+// tenantHistory История значений метрик арендатора. Без истории возвращается nil
+func (h Handler) tenantHistory(r *http.Request) repositories.HistoryRepository {
+	if h.history == nil {
+		return nil
+	}
+
+	return h.history.Tenant(tenant.FromContext(r.Context()))
+}
+
+// save Сохранение метрик арендатора через services.Ingest. Идентификатор пакета передается в заголовке batch.Header,
+// режим записи Counter - в заголовке repositories.CounterModeHeader
+func (h Handler) save(r *http.Request, gauges types.Gauges, counters types.Counters) error {
+	return h.ingest.Save(r.Context(), services.IngestBatch{
+		Tenant:      tenant.FromContext(r.Context()),
+		ID:          r.Header.Get(batch.Header),
+		Agent:       agentID(r),
+		TotalsAgent: totalsAgent(r),
+		CounterMode: r.Header.Get(repositories.CounterModeHeader),
+		Gauges:      gauges,
+		Counters:    counters,
+	})
+}
+
+// addCounter Добавление значения Counter из запроса: приращения одной серии складываются,
+// а накопленное значение заменяет предыдущее значение серии в том же запросе
+func addCounter(r *http.Request, counters types.Counters, key string, value types.Counter) {
+	if r.Header.Get(repositories.CounterModeHeader) == repositories.CounterModeCumulative {
+		counters[key] = value
+
+		return
+	}
+
+	counters[key] += value
+}
+
+// writeSaveError Ответ с ошибкой сохранения: повтор примененного пакета - 200 с заголовком batch.DuplicateHeader,
+// пакет еще применяется - 409 с заголовком Retry-After, неверный идентификатор пакета или режим записи Counter - 400,
+// зарезервированное имя - 403, превышение ограничений - 429, переполненный буфер записи - 503, иначе - 500
+func writeSaveError(rw http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, batch.ErrDuplicate) {
+		rw.Header().Set(batch.DuplicateHeader, "true")
+		rw.WriteHeader(http.StatusOK)
+		rw.Write([]byte(http.StatusText(http.StatusOK)))
+
+		return
+	}
+
+	if errors.Is(err, batch.ErrInProgress) {
+		rw.Header().Set("Retry-After", batch.RetryAfter)
+		http.Error(rw, err.Error(), http.StatusConflict)
+
+		return
+	}
+
+	if errors.Is(err, batch.ErrInvalidID) {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	if errors.Is(err, ErrCounterMode) {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	if errors.Is(err, ErrReservedName) {
+		http.Error(rw, err.Error(), http.StatusForbidden)
+
+		return
+	}
+
+	if limits.IsLimitError(err) {
+		http.Error(rw, err.Error(), http.StatusTooManyRequests)
+
+		return
+	}
+
+	if errors.Is(err, repositories.ErrBufferFull) {
+		http.Error(rw, err.Error(), http.StatusServiceUnavailable)
+
+		return
+	}
+
+	logger.FromContext(r.Context()).Error("save metrics", zap.Error(err))
+
+	http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
+
+// totalsAgent Агент, накопленные значения которого сравниваются с предыдущими: идентификатор из заголовка
+// logger.AgentIDHeader, а без него - токен или IP клиента
+func totalsAgent(r *http.Request) string {
+	if agent := r.Header.Get(logger.AgentIDHeader); agent != "" {
+		return agent
+	}
+
+	return agentID(r)
+}
+
+// agentID Идентификатор агента для ограничений: токен или IP клиента
+func agentID(r *http.Request) string {
+	if token, ok := auth.FromContext(r.Context()); ok {
+		return "token:" + token.ID
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
 }
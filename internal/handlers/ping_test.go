@@ -21,10 +21,10 @@ func Example_ping() {
 
 func TestHandler_Ping(t *testing.T) {
 	t.Run("default", func(t *testing.T) {
-		repository := repositories.NewStatsMemoryRepository()
+		tenants := repositories.NewTenantMemoryRepository()
 		signer := services.NewMetricSigner("")
 		decrypt, _ := services.NewMetricDecrypt("")
-		handler := NewHandler(repository, signer, nil, decrypt)
+		handler := NewHandler(tenants, signer, nil, decrypt, HandlerOptions{})
 
 		r := chi.NewRouter()
 		r.Get("/ping", handler.Ping())
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/vllvll/devops/internal/validation"
+)
+
+// ProblemContentType Тип содержимого ответа с описанием ошибки (RFC 7807)
+const ProblemContentType = "application/problem+json"
+
+// Problem Описание ошибки запроса в формате RFC 7807
+type Problem struct {
+	Type     string                 `json:"type"`             // Тип ошибки
+	Title    string                 `json:"title"`            // Краткое описание типа ошибки
+	Status   int                    `json:"status"`           // Код ответа
+	Detail   string                 `json:"detail,omitempty"` // Описание ошибки
+	Instance string                 `json:"instance"`         // Путь запроса
+	Errors   []validation.Violation `json:"errors,omitempty"` // Нарушения отдельных метрик
+}
+
+// writeValidationError Ответ с описанием нарушений правил проверки метрик: превышение размера пакета - 413, иначе - 400
+func writeValidationError(rw http.ResponseWriter, r *http.Request, err error) {
+	var validationErr *validation.Error
+	if !errors.As(err, &validationErr) {
+		http.Error(rw, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return
+	}
+
+	status := http.StatusBadRequest
+	if validationErr.TooLarge {
+		status = http.StatusRequestEntityTooLarge
+	}
+
+	writeProblem(rw, Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   validationErr.Detail,
+		Instance: r.URL.Path,
+		Errors:   validationErr.Violations,
+	})
+}
+
+// writeProblem Запись ответа с описанием ошибки
+func writeProblem(rw http.ResponseWriter, problem Problem) {
+	response, err := json.Marshal(problem)
+	if err != nil {
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+
+		return
+	}
+
+	rw.Header().Set("Content-Type", ProblemContentType)
+	rw.Header().Set("X-Content-Type-Options", "nosniff")
+	rw.WriteHeader(problem.Status)
+	rw.Write(response)
+}
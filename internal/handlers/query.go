@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/vllvll/devops/internal/query"
+)
+
+type queryData struct {
+	ResultType string         `json:"resultType"`
+	Result     []query.Series `json:"result"`
+}
+
+type queryResponse struct {
+	Status string     `json:"status"`
+	Data   *queryData `json:"data,omitempty"`
+	Error  string     `json:"error,omitempty"`
+}
+
+// Query Вычисление запроса с функциями агрегации по метрикам.
+// Например: /api/v1/query?query=sum by (host) ({__name__=~"Heap.*"})
+func (h Handler) Query() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		engine := query.NewEngine(h.repository(r), h.tenantHistory(r))
+
+		result, err := engine.Query(r.URL.Query().Get("query"))
+		if err != nil {
+			writeQueryResponse(rw, http.StatusBadRequest, queryResponse{Status: "error", Error: err.Error()})
+
+			return
+		}
+
+		if result == nil {
+			result = []query.Series{}
+		}
+
+		writeQueryResponse(rw, http.StatusOK, queryResponse{
+			Status: "success",
+			Data:   &queryData{ResultType: "vector", Result: result},
+		})
+	}
+}
+
+func writeQueryResponse(rw http.ResponseWriter, code int, response queryResponse) {
+	content, err := json.Marshal(response)
+	if err != nil {
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+	rw.Write(content)
+}
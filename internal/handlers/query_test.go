@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vllvll/devops/internal/repositories"
+	"github.com/vllvll/devops/internal/services"
+	"github.com/vllvll/devops/internal/tenant"
+)
+
+func Example_query() {
+	client := resty.New()
+	_, _ = client.R().SetQueryParam("query", `sum({__name__=~"Heap.*"})`).Get("/api/v1/query")
+}
+
+func TestHandler_Query(t *testing.T) {
+	type want struct {
+		code        int
+		response    string
+		contentType string
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  want
+	}{
+		{
+			name:  "sum success",
+			query: `sum({__name__=~"Heap.*"})`,
+			want: want{
+				code:        200,
+				response:    `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":30}]}}`,
+				contentType: "application/json",
+			},
+		},
+		{
+			name:  "empty result",
+			query: `Alloc`,
+			want: want{
+				code:        200,
+				response:    `{"status":"success","data":{"resultType":"vector","result":[]}}`,
+				contentType: "application/json",
+			},
+		},
+		{
+			name:  "division by zero",
+			query: `HeapAlloc / 0`,
+			want: want{
+				code:        200,
+				response:    `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":"+Inf"}]}}`,
+				contentType: "application/json",
+			},
+		},
+		{
+			name:  "parse error",
+			query: `sum(`,
+			want: want{
+				code:        400,
+				response:    `{"status":"error","error":"unexpected end of query"}`,
+				contentType: "application/json",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tenants := repositories.NewTenantMemoryRepository()
+			repository := tenants.Tenant(tenant.Default)
+			repository.UpdateGauge("HeapAlloc", 10)
+			repository.UpdateGauge("HeapSys", 20)
+
+			signer := services.NewMetricSigner("")
+			handler := NewHandler(tenants, signer, nil, nil, HandlerOptions{})
+
+			r := chi.NewRouter()
+			r.Get("/api/v1/query", handler.Query())
+
+			ts := httptest.NewServer(r)
+			defer ts.Close()
+
+			response, err := resty.New().R().SetQueryParam("query", tt.query).Get(ts.URL + "/api/v1/query")
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.want.code, response.StatusCode())
+			assert.JSONEq(t, tt.want.response, string(response.Body()))
+			assert.Equal(t, tt.want.contentType, response.Header().Get("Content-Type"))
+		})
+	}
+}
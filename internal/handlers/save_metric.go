@@ -6,17 +6,25 @@ import (
 
 	"github.com/go-chi/chi/v5"
 
+	"github.com/vllvll/devops/internal/auth"
 	"github.com/vllvll/devops/internal/dictionaries"
 	"github.com/vllvll/devops/internal/types"
+	"github.com/vllvll/devops/internal/urlparam"
 )
 
 // SaveMetric Сохранение метрики, с данными переданными в url
 func (h Handler) SaveMetric() http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		format := chi.URLParam(r, "format")
-		key := chi.URLParam(r, "key")
+		key := urlparam.Key(r)
 		value := chi.URLParam(r, "value")
 
+		if !auth.AllowsWrite(r.Context(), key) {
+			http.Error(rw, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+
+			return
+		}
+
 		switch format {
 		case dictionaries.GaugeType:
 			f, err := strconv.ParseFloat(value, 64)
@@ -26,7 +34,18 @@ func (h Handler) SaveMetric() http.HandlerFunc {
 				return
 			}
 
-			h.repository.UpdateGauge(key, types.Gauge(f))
+			if err := h.rules.Metric(types.Metrics{ID: key, MType: format, Value: &f}); err != nil {
+				writeValidationError(rw, r, err)
+
+				return
+			}
+
+			err = h.save(r, types.Gauges{key: types.Gauge(f)}, types.Counters{})
+			if err != nil {
+				writeSaveError(rw, r, err)
+
+				return
+			}
 
 		case dictionaries.CounterType:
 			i, err := strconv.ParseInt(value, 10, 64)
@@ -36,7 +55,18 @@ func (h Handler) SaveMetric() http.HandlerFunc {
 				return
 			}
 
-			h.repository.UpdateCount(key, types.Counter(i))
+			if err := h.rules.Metric(types.Metrics{ID: key, MType: format, Delta: &i}); err != nil {
+				writeValidationError(rw, r, err)
+
+				return
+			}
+
+			err = h.save(r, types.Gauges{}, types.Counters{key: types.Counter(i)})
+			if err != nil {
+				writeSaveError(rw, r, err)
+
+				return
+			}
 
 		default:
 			http.Error(rw, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
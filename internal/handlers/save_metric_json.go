@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/vllvll/devops/internal/auth"
 	"github.com/vllvll/devops/internal/dictionaries"
+	"github.com/vllvll/devops/internal/telemetry"
 	"github.com/vllvll/devops/internal/types"
 )
 
@@ -19,24 +21,46 @@ func (h Handler) SaveMetricJSON() http.HandlerFunc {
 			return
 		}
 
+		if err := h.rules.Metric(metric); err != nil {
+			writeValidationError(rw, r, err)
+
+			return
+		}
+
+		if !auth.AllowsWrite(r.Context(), metric.ID) {
+			http.Error(rw, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+
+			return
+		}
+
 		switch metric.MType {
 		case dictionaries.GaugeType:
 			if !h.signer.IsEqualHashGauge(metric.ID, *metric.Value, metric.Hash) {
+				h.telemetry.Counter(telemetry.HashFailures, nil).Inc()
 				http.Error(rw, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 
 				return
 			}
 
-			h.repository.UpdateGauge(metric.ID, types.Gauge(*metric.Value))
+			if err := h.save(r, types.Gauges{metric.ID: types.Gauge(*metric.Value)}, types.Counters{}); err != nil {
+				writeSaveError(rw, r, err)
+
+				return
+			}
 
 		case dictionaries.CounterType:
 			if !h.signer.IsEqualHashCounter(metric.ID, *metric.Delta, metric.Hash) {
+				h.telemetry.Counter(telemetry.HashFailures, nil).Inc()
 				http.Error(rw, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 
 				return
 			}
 
-			h.repository.UpdateCount(metric.ID, types.Counter(*metric.Delta))
+			if err := h.save(r, types.Gauges{}, types.Counters{metric.ID: types.Counter(*metric.Delta)}); err != nil {
+				writeSaveError(rw, r, err)
+
+				return
+			}
 		}
 
 		rw.WriteHeader(http.StatusOK)
@@ -71,6 +71,17 @@ func TestHandler_SaveMetric(t *testing.T) {
 				contentType: "text/plain; charset=utf-8",
 			},
 		},
+		{
+			name:   "NaN gauge",
+			format: "gauge",
+			key:    "Alloc",
+			value:  "NaN",
+			want: want{
+				code:        400,
+				response:    `{"type":"about:blank","title":"Bad Request","status":400,"detail":"invalid metric","instance":"/update/gauge/Alloc/NaN","errors":[{"index":0,"id":"Alloc","field":"value","reason":"NaN and Inf values are not allowed"}]}`,
+				contentType: "application/problem+json",
+			},
+		},
 		{
 			name:   "success counter",
 			format: "counter",
@@ -96,10 +107,10 @@ func TestHandler_SaveMetric(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			repository := repositories.NewStatsMemoryRepository()
+			tenants := repositories.NewTenantMemoryRepository()
 			signer := services.NewMetricSigner("")
 			decrypt, _ := services.NewMetricDecrypt("")
-			handler := NewHandler(repository, signer, nil, decrypt)
+			handler := NewHandler(tenants, signer, nil, decrypt, HandlerOptions{})
 
 			r := chi.NewRouter()
 			r.Post("/update/{format:[A-Za-z]+}/{key:[A-Za-z0-9]+}/{value:[A-Za-z0-9.]+}", handler.SaveMetric())
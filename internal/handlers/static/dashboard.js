@@ -0,0 +1,54 @@
+(function () {
+    var search = document.getElementById('search');
+    var refresh = document.getElementById('refresh');
+    var types = document.querySelectorAll('.type-filter');
+    var timer = null;
+
+    function filter() {
+        var query = search.value.toLowerCase();
+        var enabled = {};
+
+        types.forEach(function (checkbox) {
+            enabled[checkbox.value] = checkbox.checked;
+        });
+
+        document.querySelectorAll('#metrics tr').forEach(function (row) {
+            var visible = enabled[row.dataset.type] && row.dataset.name.toLowerCase().indexOf(query) !== -1;
+            row.style.display = visible ? '' : 'none';
+        });
+    }
+
+    function reload() {
+        fetch(window.location.pathname, {headers: {'Accept': 'text/html'}})
+            .then(function (response) {
+                return response.text();
+            })
+            .then(function (html) {
+                var page = new DOMParser().parseFromString(html, 'text/html');
+
+                document.getElementById('metrics').replaceWith(page.getElementById('metrics'));
+                document.getElementById('updated').textContent = page.getElementById('updated').textContent;
+                filter();
+            });
+    }
+
+    function schedule() {
+        clearInterval(timer);
+        localStorage.setItem('dashboard.refresh', refresh.value);
+
+        if (refresh.value !== '0') {
+            timer = setInterval(reload, refresh.value * 1000);
+        }
+    }
+
+    refresh.value = localStorage.getItem('dashboard.refresh') || refresh.value;
+
+    search.addEventListener('input', filter);
+    types.forEach(function (checkbox) {
+        checkbox.addEventListener('change', filter);
+    });
+    refresh.addEventListener('change', schedule);
+
+    filter();
+    schedule();
+})();
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vllvll/devops/internal/repositories"
+	"github.com/vllvll/devops/internal/services"
+	"github.com/vllvll/devops/internal/telemetry"
+	"github.com/vllvll/devops/internal/types"
+)
+
+func TestHandler_BulkSaveMetricJSONTelemetry(t *testing.T) {
+	tests := []struct {
+		name      string
+		signerKey string
+		metrics   []types.Metrics
+		code      int
+		response  string
+		ingested  uint64
+		hashFails uint64
+	}{
+		{
+			name: "ingested metrics",
+			metrics: []types.Metrics{
+				{ID: "Alloc", MType: "gauge", Value: getGauge(1)},
+				{ID: "HeapAlloc", MType: "gauge", Value: getGauge(2)},
+			},
+			code:     200,
+			response: "OK",
+			ingested: 2,
+		},
+		{
+			name:      "hash failure",
+			signerKey: "secret",
+			metrics: []types.Metrics{
+				{ID: "Alloc", MType: "gauge", Value: getGauge(1), Hash: "invalid"},
+			},
+			code:      400,
+			response:  "Bad Request",
+			hashFails: 1,
+		},
+		{
+			name: "reserved prefix",
+			metrics: []types.Metrics{
+				{ID: "devops_server_hash_failures", MType: "counter", Delta: getCounter(1)},
+			},
+			code:     403,
+			response: ErrReservedName.Error(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := telemetry.NewRegistry()
+			tenants := repositories.NewTenantMemoryRepository()
+			handler := NewHandler(tenants, services.NewMetricSigner(tt.signerKey), nil, nil, HandlerOptions{Telemetry: registry})
+
+			r := chi.NewRouter()
+			r.Post("/updates/", handler.BulkSaveMetricJSON())
+
+			ts := httptest.NewServer(r)
+			defer ts.Close()
+
+			response, err := resty.New().R().SetBody(tt.metrics).Post(ts.URL + "/updates/")
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.code, response.StatusCode())
+			assert.Equal(t, tt.response, strings.Trim(string(response.Body()), "\n"))
+			assert.Equal(t, tt.hashFails, registry.Counter(telemetry.HashFailures, nil).Value())
+			assert.Equal(t, tt.ingested, registry.Counter(telemetry.IngestedMetrics, map[string]string{"type": "gauge"}).Value())
+		})
+	}
+}
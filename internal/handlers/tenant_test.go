@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vllvll/devops/internal/auth"
+	"github.com/vllvll/devops/internal/limits"
+	"github.com/vllvll/devops/internal/middlewares"
+	"github.com/vllvll/devops/internal/repositories"
+	"github.com/vllvll/devops/internal/services"
+	"github.com/vllvll/devops/internal/tenant"
+	"github.com/vllvll/devops/internal/types"
+)
+
+func TestHandler_SaveMetricTenant(t *testing.T) {
+	store := auth.NewFileStore(filepath.Join(t.TempDir(), "tokens.json"))
+
+	secret, token, err := auth.Generate("team-a", []string{auth.ScopeWrite}, "")
+	require.NoError(t, err)
+	token.Tenant = "team-a"
+	require.NoError(t, store.Save(token))
+
+	unbound, token, err := auth.Generate("agent", []string{auth.ScopeWrite}, "")
+	require.NoError(t, err)
+	require.NoError(t, store.Save(token))
+
+	admin, token, err := auth.Generate("admin", []string{auth.ScopeWrite, auth.ScopeAdmin}, "")
+	require.NoError(t, err)
+	require.NoError(t, store.Save(token))
+
+	tests := []struct {
+		name    string
+		token   string
+		header  string
+		code    int
+		tenant  string
+		counter types.Counter
+	}{
+		{
+			name:    "tenant from token",
+			token:   secret,
+			code:    200,
+			tenant:  "team-a",
+			counter: 5,
+		},
+		{
+			name:    "same tenant in header",
+			token:   secret,
+			header:  "team-a",
+			code:    200,
+			tenant:  "team-a",
+			counter: 5,
+		},
+		{
+			name:   "foreign tenant in header",
+			token:  secret,
+			header: "team-b",
+			code:   403,
+		},
+		{
+			name:   "foreign tenant with unbound token",
+			token:  unbound,
+			header: "team-b",
+			code:   403,
+		},
+		{
+			name:    "default tenant with unbound token",
+			token:   unbound,
+			code:    200,
+			tenant:  tenant.Default,
+			counter: 5,
+		},
+		{
+			name:    "any tenant with admin token",
+			token:   admin,
+			header:  "team-b",
+			code:    200,
+			tenant:  "team-b",
+			counter: 5,
+		},
+		{
+			name:   "invalid tenant",
+			token:  secret,
+			header: "team/a",
+			code:   400,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tenants := repositories.NewTenantMemoryRepository()
+			handler := NewHandler(tenants, services.NewMetricSigner(""), nil, nil, HandlerOptions{})
+
+			r := chi.NewRouter()
+			r.With(middlewares.Authenticate(store), middlewares.Tenant).
+				Post("/update/{format:[A-Za-z]+}/{key:[A-Za-z0-9]+}/{value:[A-Za-z0-9.]+}", handler.SaveMetric())
+
+			ts := httptest.NewServer(r)
+			defer ts.Close()
+
+			response, err := resty.New().SetAuthToken(tt.token).R().
+				SetHeader(tenant.Header, tt.header).
+				Post(ts.URL + "/update/counter/PollCount/5")
+			require.NoError(t, err)
+			assert.Equal(t, tt.code, response.StatusCode())
+
+			if tt.code != 200 {
+				names, err := tenants.Tenants()
+				require.NoError(t, err)
+				assert.Empty(t, names)
+
+				return
+			}
+
+			value, err := tenants.Tenant(tt.tenant).GetCounterByKey("PollCount")
+			require.NoError(t, err)
+			assert.Equal(t, tt.counter, value)
+
+			if tt.tenant != tenant.Default {
+				_, err = tenants.Tenant(tenant.Default).GetCounterByKey("PollCount")
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestHandler_SaveMetricTenantWithoutAuth(t *testing.T) {
+	tenants := repositories.NewTenantMemoryRepository()
+	handler := NewHandler(tenants, services.NewMetricSigner(""), nil, nil, HandlerOptions{})
+
+	r := chi.NewRouter()
+	r.With(middlewares.Tenant).
+		Post("/update/{format:[A-Za-z]+}/{key:[A-Za-z0-9]+}/{value:[A-Za-z0-9.]+}", handler.SaveMetric())
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	// Без аутентификации выбрать арендатора заголовком нельзя
+	response, err := resty.New().R().
+		SetHeader(tenant.Header, "team-b").
+		Post(ts.URL + "/update/counter/PollCount/5")
+	require.NoError(t, err)
+	assert.Equal(t, 403, response.StatusCode())
+
+	response, err = resty.New().R().Post(ts.URL + "/update/counter/PollCount/5")
+	require.NoError(t, err)
+	assert.Equal(t, 200, response.StatusCode())
+
+	names, err := tenants.Tenants()
+	require.NoError(t, err)
+	assert.Equal(t, []string{tenant.Default}, names)
+}
+
+func TestHandler_GetAllTenantIsolation(t *testing.T) {
+	tenants := repositories.NewTenantMemoryRepository()
+	tenants.Tenant("team-a").UpdateGauge("Alloc", 1)
+	tenants.Tenant("team-b").UpdateGauge("HeapAlloc", 2)
+
+	handler := NewHandler(tenants, services.NewMetricSigner(""), nil, nil, HandlerOptions{})
+
+	r := chi.NewRouter()
+	store, secret := adminToken(t)
+	r.With(middlewares.Authenticate(store), middlewares.Tenant).Get("/", handler.GetAll())
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	response, err := resty.New().SetAuthToken(secret).R().
+		SetHeader("Accept", "text/plain").
+		SetHeader(tenant.Header, "team-a").
+		Get(ts.URL)
+	require.NoError(t, err)
+
+	assert.Equal(t, "Gauges:\nAlloc - 1.000\nCounters:\n", string(response.Body()))
+}
+
+func TestHandler_BulkSaveMetricJSONQuotas(t *testing.T) {
+	quotas := limits.New(limits.NewTenantQuotas(limits.Quotas{
+		Tenants: map[string]limits.Quota{
+			"team-a": {MaxSeries: 2},
+		},
+	}), nil, nil)
+
+	tests := []struct {
+		name     string
+		tenant   string
+		metrics  []types.Metrics
+		code     int
+		response string
+	}{
+		{
+			name:   "within quota",
+			tenant: "team-a",
+			metrics: []types.Metrics{
+				{ID: "Alloc", MType: "gauge", Value: getGauge(1)},
+				{ID: "PollCount", MType: "counter", Delta: getCounter(1)},
+			},
+			code:     200,
+			response: "OK",
+		},
+		{
+			name:   "series limit exceeded",
+			tenant: "team-a",
+			metrics: []types.Metrics{
+				{ID: "Alloc", MType: "gauge", Value: getGauge(1)},
+				{ID: "HeapAlloc", MType: "gauge", Value: getGauge(1)},
+				{ID: "PollCount", MType: "counter", Delta: getCounter(1)},
+			},
+			code:     429,
+			response: limits.ErrSeriesLimit.Error(),
+		},
+		{
+			name:   "tenant without quota",
+			tenant: "team-b",
+			metrics: []types.Metrics{
+				{ID: "Alloc", MType: "gauge", Value: getGauge(1)},
+				{ID: "HeapAlloc", MType: "gauge", Value: getGauge(1)},
+				{ID: "PollCount", MType: "counter", Delta: getCounter(1)},
+			},
+			code:     200,
+			response: "OK",
+		},
+	}
+
+	store, secret := adminToken(t)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tenants := repositories.NewTenantMemoryRepository()
+			handler := NewHandler(tenants, services.NewMetricSigner(""), nil, nil, HandlerOptions{Limits: quotas})
+
+			r := chi.NewRouter()
+			r.With(middlewares.Authenticate(store), middlewares.Tenant).Post("/updates/", handler.BulkSaveMetricJSON())
+
+			ts := httptest.NewServer(r)
+			defer ts.Close()
+
+			response, err := resty.New().SetAuthToken(secret).R().
+				SetHeader(tenant.Header, tt.tenant).
+				SetBody(tt.metrics).
+				Post(ts.URL + "/updates/")
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.code, response.StatusCode())
+			assert.Equal(t, tt.response, strings.Trim(string(response.Body()), "\n"))
+		})
+	}
+}
+
+// adminToken Хранилище с токеном администратора, который может выбрать любого арендатора
+func adminToken(t *testing.T) (auth.Store, string) {
+	store := auth.NewFileStore(filepath.Join(t.TempDir(), "tokens.json"))
+
+	secret, token, err := auth.Generate("admin", []string{auth.ScopeRead, auth.ScopeWrite, auth.ScopeAdmin}, "")
+	require.NoError(t, err)
+	require.NoError(t, store.Save(token))
+
+	return store, secret
+}
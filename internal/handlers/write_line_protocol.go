@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+
+	"github.com/vllvll/devops/internal/auth"
+	"github.com/vllvll/devops/internal/dictionaries"
+	"github.com/vllvll/devops/internal/telemetry"
+	"github.com/vllvll/devops/internal/types"
+	"github.com/vllvll/devops/pkg/lineprotocol"
+)
+
+// HashHeader Заголовок с подписью тела запроса
+const HashHeader = "HashSHA256"
+
+// WriteLineProtocol Сохранение метрик в формате InfluxDB line protocol.
+// Целочисленные поля сохраняются как Counter, дробные - как Gauge.
+// Имя метрики: measurement_field;tag=value. С ключом шифрования сервера тело должно быть зашифровано
+func (h Handler) WriteLineProtocol() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		var counters = types.Counters{}
+		var gauges = types.Gauges{}
+		var metrics []types.Metrics
+		var body io.Reader = r.Body
+
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(rw, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+				return
+			}
+			defer gz.Close()
+
+			body = gz
+		}
+
+		content, err := io.ReadAll(body)
+		if err != nil {
+			http.Error(rw, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+			return
+		}
+
+		// При включенном шифровании тело зашифровано так же, как в /updates/, а подпись считается от расшифрованного тела
+		if h.decrypt != nil {
+			content, err = h.decrypt.Decrypt(content)
+			if err != nil {
+				h.telemetry.Counter(telemetry.DecryptFailures, nil).Inc()
+				http.Error(rw, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+				return
+			}
+		}
+
+		if !h.signer.IsEqualHashContent(content, r.Header.Get(HashHeader)) {
+			h.telemetry.Counter(telemetry.HashFailures, nil).Inc()
+			http.Error(rw, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+			return
+		}
+
+		points, err := lineprotocol.Parse(content, r.URL.Query().Get("precision"))
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		for _, point := range points {
+			for _, field := range point.Fields {
+				name := types.SeriesName(point.Measurement+"_"+field.Key, point.Tags)
+
+				if !auth.AllowsWrite(r.Context(), name) {
+					http.Error(rw, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+
+					return
+				}
+
+				switch value := field.Value.(type) {
+				case int64:
+					metrics = append(metrics, types.Metrics{ID: name, MType: dictionaries.CounterType, Delta: &value})
+				case uint64:
+					if value > math.MaxInt64 {
+						http.Error(rw, fmt.Sprintf("field %q: value %d overflows counter", field.Key, value), http.StatusBadRequest)
+
+						return
+					}
+
+					delta := int64(value)
+					metrics = append(metrics, types.Metrics{ID: name, MType: dictionaries.CounterType, Delta: &delta})
+				case float64:
+					metrics = append(metrics, types.Metrics{ID: name, MType: dictionaries.GaugeType, Value: &value})
+				}
+			}
+		}
+
+		if err := h.rules.Batch(metrics); err != nil {
+			writeValidationError(rw, r, err)
+
+			return
+		}
+
+		for _, metric := range metrics {
+			switch metric.MType {
+			case dictionaries.GaugeType:
+				gauges[metric.ID] = types.Gauge(*metric.Value)
+			case dictionaries.CounterType:
+				addCounter(r, counters, metric.ID, types.Counter(*metric.Delta))
+			}
+		}
+
+		err = h.save(r, gauges, counters)
+		if err != nil {
+			writeSaveError(rw, r, err)
+
+			return
+		}
+
+		rw.WriteHeader(http.StatusNoContent)
+	}
+}
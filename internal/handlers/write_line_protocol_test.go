@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vllvll/devops/internal/repositories"
+	"github.com/vllvll/devops/internal/services"
+	"github.com/vllvll/devops/internal/tenant"
+	"github.com/vllvll/devops/internal/types"
+)
+
+func Example_writeLineProtocol() {
+	client := resty.New()
+	_, _ = client.R().SetBody("cpu,host=server01 usage=0.64,requests=10i").Post("/write")
+}
+
+func TestHandler_WriteLineProtocol(t *testing.T) {
+	type want struct {
+		code     int
+		response string
+		gauges   types.Gauges
+		counters types.Counters
+	}
+
+	tests := []struct {
+		name      string
+		signerKey string
+		hash      string
+		body      string
+		want      want
+	}{
+		{
+			name: "fields success",
+			body: "cpu,host=server01,dc=eu usage=0.64,requests=10i 1465839830100400200\ncpu,host=server01,dc=eu requests=5u",
+			want: want{
+				code:     204,
+				gauges:   types.Gauges{"cpu_usage;dc=eu;host=server01": 0.64},
+				counters: types.Counters{"cpu_requests;dc=eu;host=server01": 15},
+			},
+		},
+		{
+			name: "string and bool fields are skipped",
+			body: `service status="ok",up=true,latency=12.5`,
+			want: want{
+				code:     204,
+				gauges:   types.Gauges{"service_latency": 12.5},
+				counters: types.Counters{},
+			},
+		},
+		{
+			name: "invalid line",
+			body: "cpu usage=abc",
+			want: want{
+				code:     400,
+				response: `line 1: field "usage": invalid value "abc"`,
+				gauges:   types.Gauges{},
+				counters: types.Counters{},
+			},
+		},
+		{
+			name: "unsigned counter overflow",
+			body: "cpu requests=9223372036854775808u",
+			want: want{
+				code:     400,
+				response: `field "requests": value 9223372036854775808 overflows counter`,
+				gauges:   types.Gauges{},
+				counters: types.Counters{},
+			},
+		},
+		{
+			name: "invalid series name",
+			body: "cp@u usage=1",
+			want: want{
+				code:     400,
+				response: `{"type":"about:blank","title":"Bad Request","status":400,"detail":"1 of 1 metrics are invalid","instance":"/write","errors":[{"index":0,"id":"cp@u_usage","field":"id","reason":"name may only contain letters, digits and _.:-"}]}`,
+				gauges:   types.Gauges{},
+				counters: types.Counters{},
+			},
+		},
+		{
+			name:      "wrong hash",
+			signerKey: "6d9d04f1f54f1b11944a9bb143b4ad786d502f29f801ee75da2e612e459f98f4",
+			hash:      "errorhash",
+			body:      "cpu usage=1",
+			want: want{
+				code:     400,
+				response: "Bad Request",
+				gauges:   types.Gauges{},
+				counters: types.Counters{},
+			},
+		},
+		{
+			name:      "correct hash",
+			signerKey: "6d9d04f1f54f1b11944a9bb143b4ad786d502f29f801ee75da2e612e459f98f4",
+			hash:      services.NewMetricSigner("6d9d04f1f54f1b11944a9bb143b4ad786d502f29f801ee75da2e612e459f98f4").GetHashContent([]byte("cpu usage=1")),
+			body:      "cpu usage=1",
+			want: want{
+				code:     204,
+				gauges:   types.Gauges{"cpu_usage": 1},
+				counters: types.Counters{},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tenants := repositories.NewTenantMemoryRepository()
+			repository := tenants.Tenant(tenant.Default)
+			signer := services.NewMetricSigner(tt.signerKey)
+			handler := NewHandler(tenants, signer, nil, nil, HandlerOptions{})
+
+			r := chi.NewRouter()
+			r.Post("/write", handler.WriteLineProtocol())
+
+			ts := httptest.NewServer(r)
+			defer ts.Close()
+
+			response, err := resty.New().R().
+				SetHeader(HashHeader, tt.hash).
+				SetBody(tt.body).
+				Post(ts.URL + "/write")
+			require.NoError(t, err)
+
+			gauges, counters := repository.GetAll()
+
+			assert.Equal(t, tt.want.code, response.StatusCode())
+			assert.Equal(t, tt.want.response, strings.Trim(string(response.Body()), "\n"))
+			assert.Equal(t, tt.want.gauges, types.Gauges(gauges))
+			assert.Equal(t, tt.want.counters, types.Counters(counters))
+		})
+	}
+}
+
+func TestHandler_WriteLineProtocolEncrypted(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	privateBytes, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	require.NoError(t, err)
+	publicBytes, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	require.NoError(t, err)
+
+	privatePath := filepath.Join(t.TempDir(), "private.pem")
+	publicPath := filepath.Join(t.TempDir(), "public.pem")
+	require.NoError(t, os.WriteFile(privatePath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateBytes}), 0600))
+	require.NoError(t, os.WriteFile(publicPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicBytes}), 0600))
+
+	decrypt, err := services.NewMetricDecrypt(privatePath)
+	require.NoError(t, err)
+	encrypt, err := services.NewMetricEncrypt(publicPath)
+	require.NoError(t, err)
+
+	tenants := repositories.NewTenantMemoryRepository()
+	handler := NewHandler(tenants, services.NewMetricSigner(""), nil, decrypt, HandlerOptions{})
+
+	r := chi.NewRouter()
+	r.Post("/write", handler.WriteLineProtocol())
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	// Незашифрованное тело отклоняется
+	response, err := resty.New().R().SetBody("cpu usage=1").Post(ts.URL + "/write")
+	require.NoError(t, err)
+	assert.Equal(t, 400, response.StatusCode())
+
+	body, err := encrypt.Encrypt([]byte("cpu usage=1"))
+	require.NoError(t, err)
+
+	response, err = resty.New().R().SetBody(body).Post(ts.URL + "/write")
+	require.NoError(t, err)
+	assert.Equal(t, 204, response.StatusCode())
+
+	gauges, _ := tenants.Tenant(tenant.Default).GetAll()
+	assert.Equal(t, types.Gauges{"cpu_usage": 1}, types.Gauges(gauges))
+}
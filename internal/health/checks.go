@@ -0,0 +1,109 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	conf "github.com/vllvll/devops/internal/config"
+	"github.com/vllvll/devops/internal/leader"
+	"github.com/vllvll/devops/internal/services"
+)
+
+const (
+	checkTimeout    = 2 * time.Second // Максимальная длительность одной проверки сервера
+	snapshotOverdue = 2               // Через сколько интервалов сохранения снимок считается просроченным
+)
+
+// ErrNotLoaded Зависимость не была загружена при запуске
+var ErrNotLoaded = errors.New("not loaded")
+
+// Database Проверка доступности базы данных
+func Database(db *sql.DB) Check {
+	return func(ctx context.Context) error {
+		if db == nil {
+			return ErrNotLoaded
+		}
+
+		return db.PingContext(ctx)
+	}
+}
+
+// Writable Проверка возможности записи в файл path
+func Writable(path string) Check {
+	return func(ctx context.Context) error {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			return err
+		}
+
+		return file.Close()
+	}
+}
+
+// Fresh Проверка, что с момента last прошло не больше maxAge
+func Fresh(last func() time.Time, maxAge time.Duration) Check {
+	return func(ctx context.Context) error {
+		age := time.Since(last())
+		if age > maxAge {
+			return fmt.Errorf("overdue: last run %s ago, expected every %s", age.Round(time.Second), maxAge)
+		}
+
+		return nil
+	}
+}
+
+// CryptoKey Проверка, что приватный ключ шифрования по пути path можно прочитать и разобрать
+func CryptoKey(path string) Check {
+	return func(ctx context.Context) error {
+		_, err := services.NewMetricDecrypt(path)
+
+		return err
+	}
+}
+
+// Loaded Проверка, что зависимость была загружена при запуске
+func Loaded(loaded bool) Check {
+	return func(ctx context.Context) error {
+		if !loaded {
+			return ErrNotLoaded
+		}
+
+		return nil
+	}
+}
+
+// NewServerChecker Создание проверок готовности сервера по его конфигурации: база данных (если задана),
+// файл хранилища и своевременность его сохранения (без базы данных), ключ шифрования (если задан).
+// При выборе ведущего в отчет добавляется роль экземпляра, ведомый экземпляр тоже считается готовым
+func NewServerChecker(config *conf.ServerConfig, db *sql.DB, lastSave func() time.Time, elector *leader.Elector) *Checker {
+	checker := NewChecker(checkTimeout)
+
+	if config.DatabaseDsn != "" {
+		checker.Add("database", Database(db))
+	}
+
+	if config.DatabaseDsn == "" && config.StoreFile != "" {
+		checker.Add("store_file", Writable(config.StoreFile))
+
+		if config.StoreInterval > 0 {
+			checker.Add("snapshot", Fresh(lastSave, snapshotOverdue*config.StoreInterval))
+		}
+	}
+
+	if config.CryptoKey != "" {
+		checker.Add("crypto_key", CryptoKey(config.CryptoKey))
+	}
+
+	if elector != nil {
+		checker.AddInfo("role", elector.Role)
+		checker.AddInfo("role_since", func() string {
+			return elector.Since().UTC().Format(time.RFC3339)
+		})
+	}
+
+	return checker
+}
@@ -0,0 +1,81 @@
+package health
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// watchInterval Интервал повторения проверок для подписчиков Watch
+const watchInterval = 5 * time.Second
+
+// GRPCServer Реализация стандартного сервиса grpc.health.v1.Health поверх проверок готовности
+type GRPCServer struct {
+	healthpb.UnimplementedHealthServer
+
+	checker  *Checker        // Проверки готовности
+	services map[string]bool // Имена сервисов, о которых можно спрашивать (пустое имя - сервер целиком)
+}
+
+// NewGRPCServer Создание сервиса проверки здоровья для сервисов services
+func NewGRPCServer(checker *Checker, services ...string) *GRPCServer {
+	known := map[string]bool{"": true}
+	for _, service := range services {
+		known[service] = true
+	}
+
+	return &GRPCServer{
+		checker:  checker,
+		services: known,
+	}
+}
+
+// Check Проверка готовности сервера
+func (s *GRPCServer) Check(ctx context.Context, in *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	if !s.services[in.GetService()] {
+		return nil, status.Error(codes.NotFound, "unknown service")
+	}
+
+	return &healthpb.HealthCheckResponse{Status: s.status(ctx)}, nil
+}
+
+// Watch Отправка статуса готовности при каждом его изменении
+func (s *GRPCServer) Watch(in *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	if !s.services[in.GetService()] {
+		return stream.Send(&healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVICE_UNKNOWN})
+	}
+
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+
+	last := healthpb.HealthCheckResponse_UNKNOWN
+
+	for {
+		current := s.status(stream.Context())
+		if current != last {
+			if err := stream.Send(&healthpb.HealthCheckResponse{Status: current}); err != nil {
+				return err
+			}
+
+			last = current
+		}
+
+		select {
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// status Статус готовности по результатам всех проверок
+func (s *GRPCServer) status(ctx context.Context) healthpb.HealthCheckResponse_ServingStatus {
+	if s.checker.Run(ctx).Ready() {
+		return healthpb.HealthCheckResponse_SERVING
+	}
+
+	return healthpb.HealthCheckResponse_NOT_SERVING
+}
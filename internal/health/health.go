@@ -0,0 +1,147 @@
+// Package health Функционал для проверки живости и готовности сервера
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Статусы проверок
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// Check Проверка одной зависимости сервера. Ошибка означает, что зависимость недоступна
+type Check func(ctx context.Context) error
+
+// Result Результат одной проверки
+type Result struct {
+	Status  string  `json:"status"`          // Статус проверки: ok или fail
+	Latency float64 `json:"latency_ms"`      // Длительность проверки в миллисекундах
+	Error   string  `json:"error,omitempty"` // Текст ошибки для неуспешной проверки
+}
+
+// Report Результат всех проверок готовности
+type Report struct {
+	Status string            `json:"status"`         // Общий статус: ok, если успешны все проверки
+	Checks map[string]Result `json:"checks"`         // Результаты проверок по имени
+	Info   map[string]string `json:"info,omitempty"` // Сведения о сервере, не влияющие на готовность
+}
+
+// Ready Успешны ли все проверки
+func (r Report) Ready() bool {
+	return r.Status == StatusOK
+}
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+type namedInfo struct {
+	name  string
+	value func() string
+}
+
+type Checker struct {
+	mu      sync.RWMutex
+	checks  []namedCheck
+	info    []namedInfo   // Сведения о сервере
+	timeout time.Duration // Максимальная длительность одной проверки
+}
+
+// NewChecker Создание набора проверок готовности с ограничением длительности каждой проверки
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{
+		timeout: timeout,
+	}
+}
+
+// Add Добавление проверки с именем name
+func (c *Checker) Add(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+}
+
+// AddInfo Добавление сведения о сервере с именем name, которое выводится в отчете без проверки
+func (c *Checker) AddInfo(name string, value func() string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.info = append(c.info, namedInfo{name: name, value: value})
+}
+
+// Run Параллельный запуск всех проверок
+func (c *Checker) Run(ctx context.Context) Report {
+	c.mu.RLock()
+	checks := make([]namedCheck, len(c.checks))
+	copy(checks, c.checks)
+	info := make([]namedInfo, len(c.info))
+	copy(info, c.info)
+	c.mu.RUnlock()
+
+	results := make([]Result, len(checks))
+
+	var wg sync.WaitGroup
+	for i, item := range checks {
+		wg.Add(1)
+
+		go func(i int, check Check) {
+			defer wg.Done()
+
+			results[i] = c.run(ctx, check)
+		}(i, item.check)
+	}
+	wg.Wait()
+
+	report := Report{
+		Status: StatusOK,
+		Checks: make(map[string]Result, len(checks)),
+	}
+
+	for i, item := range checks {
+		if results[i].Status != StatusOK {
+			report.Status = StatusFail
+		}
+
+		report.Checks[item.name] = results[i]
+	}
+
+	if len(info) > 0 {
+		report.Info = make(map[string]string, len(info))
+		for _, item := range info {
+			report.Info[item.name] = item.value()
+		}
+	}
+
+	return report
+}
+
+// run Запуск одной проверки с ограничением длительности
+func (c *Checker) run(ctx context.Context, check Check) Result {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	err := check(ctx)
+
+	result := Result{
+		Status:  StatusOK,
+		Latency: float64(time.Since(start).Microseconds()) / 1000,
+	}
+
+	if err != nil {
+		result.Status = StatusFail
+		result.Error = err.Error()
+	}
+
+	return result
+}
@@ -0,0 +1,189 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	conf "github.com/vllvll/devops/internal/config"
+	"github.com/vllvll/devops/internal/leader"
+)
+
+func TestChecker_Run(t *testing.T) {
+	tests := []struct {
+		name   string
+		checks map[string]Check
+		status string
+		failed []string
+	}{
+		{
+			name:   "no checks",
+			checks: map[string]Check{},
+			status: StatusOK,
+		},
+		{
+			name: "all ok",
+			checks: map[string]Check{
+				"store_file": Writable(filepath.Join(t.TempDir(), "metrics.json")),
+				"crypto_key": Loaded(true),
+			},
+			status: StatusOK,
+		},
+		{
+			name: "one failed",
+			checks: map[string]Check{
+				"crypto_key": Loaded(false),
+				"database": func(ctx context.Context) error {
+					return errors.New("connection refused")
+				},
+				"store_file": Writable(filepath.Join(t.TempDir(), "metrics.json")),
+			},
+			status: StatusFail,
+			failed: []string{"crypto_key", "database"},
+		},
+		{
+			name: "timeout",
+			checks: map[string]Check{
+				"slow": func(ctx context.Context) error {
+					<-ctx.Done()
+
+					return ctx.Err()
+				},
+			},
+			status: StatusFail,
+			failed: []string{"slow"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := NewChecker(10 * time.Millisecond)
+			for name, check := range tt.checks {
+				checker.Add(name, check)
+			}
+
+			report := checker.Run(context.Background())
+
+			assert.Equal(t, tt.status, report.Status)
+			assert.Len(t, report.Checks, len(tt.checks))
+
+			var failed []string
+			for name, result := range report.Checks {
+				if result.Status == StatusFail {
+					assert.NotEmpty(t, result.Error)
+					failed = append(failed, name)
+				}
+			}
+
+			assert.ElementsMatch(t, tt.failed, failed)
+		})
+	}
+}
+
+func TestFresh(t *testing.T) {
+	fresh := Fresh(func() time.Time { return time.Now().Add(-time.Second) }, time.Minute)
+	assert.NoError(t, fresh(context.Background()))
+
+	overdue := Fresh(func() time.Time { return time.Now().Add(-time.Hour) }, time.Minute)
+	assert.Error(t, overdue(context.Background()))
+}
+
+func TestNewServerChecker(t *testing.T) {
+	config := &conf.ServerConfig{
+		StoreFile:     filepath.Join(t.TempDir(), "metrics.json"),
+		StoreInterval: time.Second,
+		CryptoKey:     "../../cert/private.key",
+	}
+
+	report := NewServerChecker(config, nil, time.Now, nil).Run(context.Background())
+
+	assert.Equal(t, StatusOK, report.Status)
+	assert.Contains(t, report.Checks, "store_file")
+	assert.Contains(t, report.Checks, "snapshot")
+	assert.Contains(t, report.Checks, "crypto_key")
+	assert.NotContains(t, report.Checks, "database")
+	assert.Empty(t, report.Info)
+
+	info, err := os.Stat(config.StoreFile)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	// Ключ, который нельзя прочитать, делает сервер неготовым
+	config.CryptoKey = filepath.Join(t.TempDir(), "missing.pem")
+
+	report = NewServerChecker(config, nil, time.Now, nil).Run(context.Background())
+	assert.Equal(t, StatusFail, report.Status)
+}
+
+func TestNewServerChecker_Leader(t *testing.T) {
+	elector := leader.NewElector(leader.NewMemoryLock().Locker(), time.Minute)
+
+	report := NewServerChecker(&conf.ServerConfig{}, nil, time.Now, elector).Run(context.Background())
+
+	// Ведомый экземпляр готов принимать метрики
+	assert.Equal(t, StatusOK, report.Status)
+	assert.Equal(t, leader.RoleFollower, report.Info["role"])
+	assert.Contains(t, report.Info, "role_since")
+}
+
+func TestGRPCServer_Check(t *testing.T) {
+	ready := NewChecker(time.Second)
+	ready.Add("crypto_key", Loaded(true))
+
+	notReady := NewChecker(time.Second)
+	notReady.Add("crypto_key", Loaded(false))
+
+	tests := []struct {
+		name    string
+		checker *Checker
+		service string
+		status  healthpb.HealthCheckResponse_ServingStatus
+		wantErr bool
+	}{
+		{
+			name:    "serving",
+			checker: ready,
+			status:  healthpb.HealthCheckResponse_SERVING,
+		},
+		{
+			name:    "known service",
+			checker: ready,
+			service: "devops.Metrics",
+			status:  healthpb.HealthCheckResponse_SERVING,
+		},
+		{
+			name:    "not serving",
+			checker: notReady,
+			status:  healthpb.HealthCheckResponse_NOT_SERVING,
+		},
+		{
+			name:    "unknown service",
+			checker: ready,
+			service: "unknown",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := NewGRPCServer(tt.checker, "devops.Metrics")
+
+			response, err := server.Check(context.Background(), &healthpb.HealthCheckRequest{Service: tt.service})
+			if tt.wantErr {
+				assert.Error(t, err)
+
+				return
+			}
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.status, response.GetStatus())
+		})
+	}
+}
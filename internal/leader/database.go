@@ -0,0 +1,108 @@
+package leader
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+)
+
+// ErrLockLost Блокировка больше не удерживается
+var ErrLockLost = errors.New("leader lock lost")
+
+// DatabaseLocker Рекомендательная блокировка PostgreSQL. Блокировка принадлежит сессии,
+// поэтому удерживается на отдельном подключении и снимается сервером бд при его разрыве
+type DatabaseLocker struct {
+	db  *sql.DB
+	key int64
+
+	mu   sync.Mutex
+	conn *sql.Conn // Подключение, на котором захвачена блокировка (nil - не захвачена)
+}
+
+// NewDatabaseLocker Создание рекомендательной блокировки PostgreSQL с ключом key
+func NewDatabaseLocker(db *sql.DB, key int64) *DatabaseLocker {
+	return &DatabaseLocker{
+		db:  db,
+		key: key,
+	}
+}
+
+// TryLock Захват блокировки через pg_try_advisory_lock
+func (l *DatabaseLocker) TryLock(ctx context.Context) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn != nil {
+		return true, nil
+	}
+
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", l.key).Scan(&acquired); err != nil {
+		conn.Close()
+
+		return false, err
+	}
+
+	if !acquired {
+		return false, conn.Close()
+	}
+
+	l.conn = conn
+
+	return true, nil
+}
+
+// Check Проверка, что подключение с блокировкой не разорвано
+func (l *DatabaseLocker) Check(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn == nil {
+		return ErrLockLost
+	}
+
+	if err := l.conn.PingContext(ctx); err != nil {
+		discard(l.conn)
+		l.conn = nil
+
+		return err
+	}
+
+	return nil
+}
+
+// Unlock Освобождение блокировки и закрытие подключения
+func (l *DatabaseLocker) Unlock(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn == nil {
+		return nil
+	}
+
+	conn := l.conn
+	l.conn = nil
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", l.key); err != nil {
+		discard(conn)
+
+		return err
+	}
+
+	return conn.Close()
+}
+
+// discard Закрытие подключения без возврата в пул: завершение сессии снимает блокировку на стороне бд
+func discard(conn *sql.Conn) {
+	conn.Raw(func(driverConn interface{}) error {
+		return driver.ErrBadConn
+	})
+	conn.Close()
+}
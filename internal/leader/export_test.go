@@ -0,0 +1,13 @@
+package leader
+
+import "context"
+
+// Step Шаг выбора ведущего для тестов внешнего пакета
+func (e *Elector) Step(ctx context.Context) {
+	e.step(ctx)
+}
+
+// Resign Освобождение блокировки для тестов внешнего пакета
+func (e *Elector) Resign() {
+	e.resign()
+}
@@ -0,0 +1,271 @@
+package leader_test
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/gofrs/uuid"
+	_ "github.com/jackc/pgx/v4/stdlib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vllvll/devops/internal/health"
+	"github.com/vllvll/devops/internal/leader"
+	"github.com/vllvll/devops/internal/types"
+)
+
+// testDatabase Подключение к PostgreSQL из TEST_DATABASE_DSN. Без переменной тест пропускается
+func testDatabase(t *testing.T) (*sql.DB, string) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	db, err := sql.Open("pgx", dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	return db, dsn
+}
+
+// throwawaySchema Отдельная схема бд, которая удаляется после теста. Возвращает DSN с этой схемой в search_path,
+// чтобы экземпляры сервера создали свои таблицы в ней и не затронули метрики других тестов
+func throwawaySchema(t *testing.T, db *sql.DB, dsn string) string {
+	id, err := uuid.NewV4()
+	require.NoError(t, err)
+
+	schema := "ha_" + strings.ReplaceAll(id.String(), "-", "")
+
+	_, err = db.Exec("CREATE SCHEMA " + schema)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_, err := db.Exec("DROP SCHEMA " + schema + " CASCADE")
+		assert.NoError(t, err)
+	})
+
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		require.NoError(t, err)
+
+		query := u.Query()
+		query.Set("search_path", schema)
+		u.RawQuery = query.Encode()
+
+		return u.String()
+	}
+
+	return dsn + " search_path=" + schema
+}
+
+// buildServer Сборка cmd/server во временный каталог
+func buildServer(t *testing.T) string {
+	binary := filepath.Join(t.TempDir(), "server")
+
+	output, err := exec.Command("go", "build", "-o", binary, "github.com/vllvll/devops/cmd/server").CombinedOutput()
+	require.NoError(t, err, string(output))
+
+	return binary
+}
+
+// freeAddress Свободный адрес для HTTP-сервера экземпляра
+func freeAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	return listener.Addr().String()
+}
+
+// haServer Экземпляр сервера в отдельном процессе
+type haServer struct {
+	url     string
+	command *exec.Cmd
+	done    chan struct{}
+}
+
+// startServer Запуск экземпляра сервера с общей бд, выбором ведущего, буфером записи и копией метрик в памяти
+func startServer(t *testing.T, binary string, dsn string) *haServer {
+	address := freeAddress(t)
+
+	command := exec.Command(binary)
+	command.Env = append(os.Environ(),
+		"ADDRESS="+address,
+		"DATABASE_DSN="+dsn,
+		"STORE_FILE="+filepath.Join(t.TempDir(), "metrics.json"),
+		"RESTORE=false",
+		"LEADER_ELECTION=true",
+		"LEADER_INTERVAL=100ms",
+		"WRITE_INTERVAL=20ms",
+		"WRITE_DURABILITY=sync",
+		"CACHE=true",
+		"LOG_LEVEL=error",
+	)
+	command.Stdout = os.Stdout
+	command.Stderr = os.Stderr
+	require.NoError(t, command.Start())
+
+	server := &haServer{url: "http://" + address, command: command, done: make(chan struct{})}
+	go func() {
+		command.Wait()
+		close(server.done)
+	}()
+
+	t.Cleanup(func() { server.stop(t) })
+
+	require.Eventually(t, func() bool {
+		response, err := resty.New().R().Get(server.url + "/healthz")
+
+		return err == nil && response.StatusCode() == 200
+	}, 10*time.Second, 50*time.Millisecond, "server %s did not start", address)
+
+	return server
+}
+
+// stop Остановка экземпляра сигналом SIGTERM, как при штатном завершении
+func (s *haServer) stop(t *testing.T) {
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+
+	require.NoError(t, s.command.Process.Signal(syscall.SIGTERM))
+
+	select {
+	case <-s.done:
+	case <-time.After(10 * time.Second):
+		s.command.Process.Kill()
+		<-s.done
+	}
+}
+
+// role Роль экземпляра из отчета /readyz
+func (s *haServer) role() string {
+	var report health.Report
+
+	_, err := resty.New().R().SetResult(&report).SetError(&report).Get(s.url + "/readyz")
+	if err != nil {
+		return ""
+	}
+
+	return report.Info["role"]
+}
+
+// counter Значение счетчика, прочитанное через экземпляр
+func (s *haServer) counter(name string) int64 {
+	var metric types.Metrics
+
+	response, err := resty.New().R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(types.Metrics{ID: name, MType: "counter"}).
+		SetResult(&metric).
+		Post(s.url + "/value/")
+	if err != nil || response.StatusCode() != 200 || metric.Delta == nil {
+		return 0
+	}
+
+	return *metric.Delta
+}
+
+// leaders Количество экземпляров, считающих себя ведущими
+func leaders(servers []*haServer) int {
+	var count int
+	for _, server := range servers {
+		if server.role() == leader.RoleLeader {
+			count++
+		}
+	}
+
+	return count
+}
+
+func TestHA_TwoServers(t *testing.T) {
+	db, dsn := testDatabase(t)
+	dsn = throwawaySchema(t, db, dsn)
+	binary := buildServer(t)
+
+	servers := []*haServer{startServer(t, binary, dsn), startServer(t, binary, dsn)}
+
+	// Ведущим становится ровно один экземпляр
+	require.Eventually(t, func() bool {
+		return leaders(servers) == 1
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// Приращения счетчика через оба экземпляра складываются, копии метрик обоих экземпляров сбрасываются оповещениями
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(server *haServer) {
+			defer wg.Done()
+
+			response, err := resty.New().R().
+				SetHeader("Content-Type", "application/json").
+				SetBody(types.Metrics{ID: "PollCount", MType: "counter", Delta: getCounter(1)}).
+				Post(server.url + "/update/")
+			assert.NoError(t, err)
+			assert.Equal(t, 200, response.StatusCode())
+		}(servers[i%2])
+	}
+	wg.Wait()
+
+	for _, server := range servers {
+		assert.Eventually(t, func() bool {
+			return server.counter("PollCount") == 20
+		}, 5*time.Second, 50*time.Millisecond, server.url)
+	}
+
+	// После остановки ведущего его роль переходит к другому экземпляру
+	var current, other *haServer
+	if servers[0].role() == leader.RoleLeader {
+		current, other = servers[0], servers[1]
+	} else {
+		current, other = servers[1], servers[0]
+	}
+
+	current.stop(t)
+
+	require.Eventually(t, func() bool {
+		return other.role() == leader.RoleLeader
+	}, 5*time.Second, 50*time.Millisecond)
+
+	assert.Equal(t, int64(20), other.counter("PollCount"), "metrics are kept after %s stopped", current.url)
+}
+
+func TestDatabaseLocker(t *testing.T) {
+	db, _ := testDatabase(t)
+
+	a, b := leader.NewDatabaseLocker(db, leader.LockKey), leader.NewDatabaseLocker(db, leader.LockKey)
+	ctx := context.Background()
+
+	acquired, err := a.TryLock(ctx)
+	require.NoError(t, err)
+	assert.True(t, acquired)
+
+	acquired, err = b.TryLock(ctx)
+	require.NoError(t, err)
+	assert.False(t, acquired)
+	assert.ErrorIs(t, b.Check(ctx), leader.ErrLockLost)
+
+	require.NoError(t, a.Check(ctx))
+	require.NoError(t, a.Unlock(ctx))
+
+	acquired, err = b.TryLock(ctx)
+	require.NoError(t, err)
+	assert.True(t, acquired)
+	require.NoError(t, b.Unlock(ctx))
+}
+
+func getCounter(value int64) *int64 {
+	return &value
+}
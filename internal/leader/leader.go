@@ -0,0 +1,170 @@
+// Package leader Выбор ведущего экземпляра сервера.
+//
+// Несколько экземпляров сервера могут работать с одной бд PostgreSQL: метрики принимает и отдает любой экземпляр,
+// счетчики остаются согласованными, потому что приращения применяются в бд (value = value + excluded.value).
+// Фоновые задачи, которые должны выполняться в одном экземпляре (сохранение снимка, запись и агрегация истории),
+// выполняет только ведущий. Ведущим становится экземпляр, захвативший рекомендательную блокировку PostgreSQL
+// (pg_try_advisory_lock) на отдельном подключении. Блокировка снимается при остановке ведущего или при разрыве
+// его подключения, после чего ее захватывает другой экземпляр не позже чем через интервал проверки.
+// Пока ведущий не обнаружил разрыв, задачи могут выполниться в двух экземплярах, поэтому они должны допускать повтор.
+package leader
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// LockKey Ключ рекомендательной блокировки ведущего
+const LockKey int64 = 0x6465766f7073
+
+// Роли экземпляра сервера
+const (
+	RoleLeader   = "leader"
+	RoleFollower = "follower"
+)
+
+// Locker Блокировка, которую удерживает ведущий
+type Locker interface {
+	// TryLock Попытка захвата блокировки без ожидания. false - блокировку удерживает другой экземпляр
+	TryLock(ctx context.Context) (bool, error)
+	// Check Проверка, что захваченная блокировка по-прежнему удерживается
+	Check(ctx context.Context) error
+	// Unlock Освобождение блокировки
+	Unlock(ctx context.Context) error
+}
+
+// Elector Выбор ведущего. Для nil экземпляр всегда ведущий (единственный экземпляр сервера)
+type Elector struct {
+	locker   Locker
+	interval time.Duration // Интервал захвата и проверки блокировки
+
+	mu     sync.RWMutex
+	leader bool
+	since  time.Time // Время последней смены роли
+}
+
+// NewElector Создание выбора ведущего с захватом и проверкой блокировки каждые interval
+func NewElector(locker Locker, interval time.Duration) *Elector {
+	return &Elector{
+		locker:   locker,
+		interval: interval,
+		since:    time.Now(),
+	}
+}
+
+// IsLeader Является ли экземпляр ведущим
+func (e *Elector) IsLeader() bool {
+	if e == nil {
+		return true
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.leader
+}
+
+// Role Роль экземпляра: RoleLeader или RoleFollower
+func (e *Elector) Role() string {
+	if e.IsLeader() {
+		return RoleLeader
+	}
+
+	return RoleFollower
+}
+
+// Since Время последней смены роли
+func (e *Elector) Since() time.Time {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.since
+}
+
+// Run Захват и проверка блокировки каждые interval до отмены ctx. При остановке блокировка освобождается
+func (e *Elector) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		e.step(ctx)
+
+		select {
+		case <-ctx.Done():
+			e.resign()
+
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// step Захват блокировки ведомым или проверка блокировки ведущим
+func (e *Elector) step(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, e.interval)
+	defer cancel()
+
+	if e.IsLeader() {
+		if err := e.locker.Check(ctx); err != nil {
+			zap.S().Errorf("Error with leader lock, stepping down: %v", err)
+			e.set(false)
+		}
+
+		return
+	}
+
+	acquired, err := e.locker.TryLock(ctx)
+	if err != nil {
+		zap.S().Warnf("Error with leader lock: %v", err)
+
+		return
+	}
+
+	if acquired {
+		e.set(true)
+	}
+}
+
+// resign Освобождение блокировки при остановке
+func (e *Elector) resign() {
+	if !e.IsLeader() {
+		return
+	}
+
+	// Роль снимается до освобождения блокировки, чтобы задачи не выполнялись одновременно с новым ведущим
+	e.set(false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.interval)
+	defer cancel()
+
+	if err := e.locker.Unlock(ctx); err != nil {
+		zap.S().Errorf("Error with leader unlock: %v", err)
+	}
+}
+
+// set Смена роли
+func (e *Elector) set(leader bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.leader == leader {
+		return
+	}
+
+	e.leader = leader
+	e.since = time.Now()
+
+	zap.S().Infow("Leader election", "role", e.roleLocked())
+}
+
+// roleLocked Роль экземпляра под e.mu
+func (e *Elector) roleLocked() string {
+	if e.leader {
+		return RoleLeader
+	}
+
+	return RoleFollower
+}
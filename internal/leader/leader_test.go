@@ -0,0 +1,76 @@
+package leader
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestElector_step(t *testing.T) {
+	lock := NewMemoryLock()
+	lockerA, lockerB := lock.Locker(), lock.Locker()
+	a, b := NewElector(lockerA, time.Second), NewElector(lockerB, time.Second)
+
+	a.step(context.Background())
+	b.step(context.Background())
+	assert.True(t, a.IsLeader())
+	assert.False(t, b.IsLeader())
+	assert.Equal(t, RoleFollower, b.Role())
+
+	// Ведущий потерял подключение: он узнает об этом при проверке, после чего блокировку захватывает другой экземпляр
+	lockerA.Disconnect()
+	a.step(context.Background())
+	assert.False(t, a.IsLeader())
+
+	b.step(context.Background())
+	a.step(context.Background())
+	assert.True(t, b.IsLeader())
+	assert.False(t, a.IsLeader())
+
+	since := b.Since()
+	b.step(context.Background())
+	assert.Equal(t, since, b.Since(), "role does not change while the lock is held")
+
+	b.resign()
+	assert.False(t, b.IsLeader())
+
+	a.step(context.Background())
+	assert.True(t, a.IsLeader())
+}
+
+func TestElector_Run(t *testing.T) {
+	lock := NewMemoryLock()
+
+	ctxA, stopA := context.WithCancel(context.Background())
+	defer stopA()
+
+	ctxB, stopB := context.WithCancel(context.Background())
+	defer stopB()
+
+	a := NewElector(lock.Locker(), 10*time.Millisecond)
+	go a.Run(ctxA)
+
+	require.Eventually(t, a.IsLeader, time.Second, time.Millisecond)
+
+	b := NewElector(lock.Locker(), 10*time.Millisecond)
+	go b.Run(ctxB)
+
+	// Остановка ведущего освобождает блокировку
+	time.Sleep(30 * time.Millisecond)
+	assert.False(t, b.IsLeader())
+
+	stopA()
+
+	require.Eventually(t, b.IsLeader, time.Second, time.Millisecond)
+	assert.False(t, a.IsLeader())
+}
+
+func TestElector_Nil(t *testing.T) {
+	var elector *Elector
+
+	assert.True(t, elector.IsLeader())
+	assert.Equal(t, RoleLeader, elector.Role())
+}
@@ -0,0 +1,68 @@
+package leader
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryLock Блокировка в оперативной памяти, общая для нескольких экземпляров в одном процессе.
+// Заменяет рекомендательную блокировку PostgreSQL в тестах
+type MemoryLock struct {
+	mu    sync.Mutex
+	owner *MemoryLocker
+}
+
+// NewMemoryLock Создание блокировки в оперативной памяти
+func NewMemoryLock() *MemoryLock {
+	return &MemoryLock{}
+}
+
+// Locker Создание участника выбора ведущего для одного экземпляра
+func (l *MemoryLock) Locker() *MemoryLocker {
+	return &MemoryLocker{lock: l}
+}
+
+type MemoryLocker struct {
+	lock *MemoryLock
+}
+
+// TryLock Захват блокировки, если она свободна
+func (l *MemoryLocker) TryLock(ctx context.Context) (bool, error) {
+	l.lock.mu.Lock()
+	defer l.lock.mu.Unlock()
+
+	if l.lock.owner == nil {
+		l.lock.owner = l
+	}
+
+	return l.lock.owner == l, nil
+}
+
+// Check Проверка, что блокировка принадлежит участнику
+func (l *MemoryLocker) Check(ctx context.Context) error {
+	l.lock.mu.Lock()
+	defer l.lock.mu.Unlock()
+
+	if l.lock.owner != l {
+		return ErrLockLost
+	}
+
+	return nil
+}
+
+// Unlock Освобождение блокировки
+func (l *MemoryLocker) Unlock(ctx context.Context) error {
+	l.Disconnect()
+
+	return nil
+}
+
+// Disconnect Потеря блокировки, как при разрыве подключения к бд
+func (l *MemoryLocker) Disconnect() {
+	l.lock.mu.Lock()
+	defer l.lock.mu.Unlock()
+
+	if l.lock.owner == l {
+		l.lock.owner = nil
+	}
+}
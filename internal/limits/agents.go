@@ -0,0 +1,225 @@
+package limits
+
+import (
+	"sync"
+	"time"
+
+	"github.com/vllvll/devops/internal/dictionaries"
+	"github.com/vllvll/devops/internal/types"
+)
+
+// AgentQuota Ограничения агентов. Нулевое значение - без ограничения
+type AgentQuota struct {
+	Rate           float64       // Скорость записи одного агента (значений в секунду)
+	Burst          int           // Допустимый всплеск записи одного агента
+	MaxSeries      int           // Максимальное количество серий, которые может создать один агент
+	MaxSeriesTotal int           // Максимальное общее количество серий на сервере
+	IdleTimeout    time.Duration // Время без записи, после которого корзина и серии агента забываются (0 - не забываются)
+}
+
+type AgentLimits struct {
+	mu      sync.Mutex
+	quota   AgentQuota
+	buckets map[string]*TokenBucket        // Корзины токенов агентов
+	agents  map[string]map[string]struct{} // Серии, записанные каждым агентом
+	seen    map[string]time.Time           // Время последней записи каждого агента
+	series  map[string]struct{}            // Все известные серии сервера
+	swept   time.Time                      // Время последней очистки неактивных агентов
+	now     func() time.Time
+}
+
+// NewAgentLimits Создание сервиса для ограничения записи агентами (по токену или IP).
+// Серии учитываются с момента запуска сервера и после восстановления через Seed
+func NewAgentLimits(quota AgentQuota) *AgentLimits {
+	return &AgentLimits{
+		quota:   quota,
+		buckets: map[string]*TokenBucket{},
+		agents:  map[string]map[string]struct{}{},
+		seen:    map[string]time.Time{},
+		series:  map[string]struct{}{},
+		now:     time.Now,
+	}
+}
+
+// Seed Учет серий, которые уже есть в хранилище арендатора
+func (a *AgentLimits) Seed(tenant string, gauges types.Gauges, counters types.Counters) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, key := range seriesKeys(tenant, gauges, counters) {
+		a.series[key] = struct{}{}
+	}
+}
+
+// Reservation Серии, впервые учтенные при проверке записи. Если запись не удалась, их нужно освободить через Release
+type Reservation struct {
+	limits       *AgentLimits
+	agent        string
+	agents       []string // Серии, новые для агента
+	series       []string // Серии, новые для сервера
+	quotas       *TenantQuotas
+	tenant       string
+	tenantSeries []string // Серии, новые для арендатора
+}
+
+// Release Освобождение серий, учтенных при проверке записи, которая не удалась. Без ограничений (nil) ничего не делает
+func (r *Reservation) Release() {
+	if r == nil {
+		return
+	}
+
+	if r.limits != nil {
+		r.limits.mu.Lock()
+
+		written := r.limits.agents[r.agent]
+		for _, key := range r.agents {
+			delete(written, key)
+		}
+
+		for _, key := range r.series {
+			delete(r.limits.series, key)
+		}
+
+		r.limits.mu.Unlock()
+	}
+
+	if r.quotas != nil {
+		r.quotas.mu.Lock()
+
+		series := r.quotas.series[r.tenant]
+		for _, key := range r.tenantSeries {
+			delete(series, key)
+		}
+
+		r.quotas.mu.Unlock()
+	}
+}
+
+// join Объединение серий, учтенных в ограничениях арендатора и агента
+func (r *Reservation) join(agent *Reservation) *Reservation {
+	if r == nil {
+		return agent
+	}
+
+	if agent != nil {
+		r.limits, r.agent, r.agents, r.series = agent.limits, agent.agent, agent.agents, agent.series
+	}
+
+	return r
+}
+
+// Allow Проверка, что агент может записать метрики арендатора. Новые серии учитываются сразу, чтобы одновременные
+// запросы не превысили ограничение; если запись не удалась, их нужно освободить через Reservation.Release.
+// Без ограничений (nil) запись разрешена
+func (a *AgentLimits) Allow(agent string, tenant string, gauges types.Gauges, counters types.Counters) (*Reservation, error) {
+	if a == nil {
+		return nil, nil
+	}
+
+	keys := seriesKeys(tenant, gauges, counters)
+	now := a.now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.expire(now)
+	a.seen[agent] = now
+
+	written, ok := a.agents[agent]
+	if !ok {
+		written = map[string]struct{}{}
+	}
+
+	reservation := &Reservation{limits: a, agent: agent}
+	for _, key := range keys {
+		if _, ok := written[key]; !ok {
+			reservation.agents = append(reservation.agents, key)
+		}
+
+		if _, ok := a.series[key]; !ok {
+			reservation.series = append(reservation.series, key)
+		}
+	}
+
+	if a.quota.MaxSeries > 0 && len(written)+len(reservation.agents) > a.quota.MaxSeries {
+		return nil, &LimitError{Reason: ReasonAgentSeries, Err: ErrSeriesLimit}
+	}
+
+	if a.quota.MaxSeriesTotal > 0 && len(a.series)+len(reservation.series) > a.quota.MaxSeriesTotal {
+		return nil, &LimitError{Reason: ReasonGlobalSeries, Err: ErrSeriesLimit}
+	}
+
+	if a.quota.Rate > 0 && !a.bucket(agent).AllowN(now, len(keys)) {
+		return nil, &LimitError{Reason: ReasonAgentRate, Err: ErrRateLimited}
+	}
+
+	for _, key := range reservation.agents {
+		written[key] = struct{}{}
+	}
+	for _, key := range reservation.series {
+		a.series[key] = struct{}{}
+	}
+	a.agents[agent] = written
+
+	return reservation, nil
+}
+
+// Forget Освобождение удаленной серии арендатора во всех ограничениях. Без ограничений (nil) ничего не делает
+func (a *AgentLimits) Forget(tenant string, mType string, name string) {
+	if a == nil {
+		return
+	}
+
+	key := tenant + "/" + mType + "/" + name
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.series, key)
+	for _, written := range a.agents {
+		delete(written, key)
+	}
+}
+
+// expire Удаление корзин и серий агентов, которые не записывали метрики дольше IdleTimeout. Серии остаются
+// в общем количестве серий сервера. Агенты проверяются не чаще раза в IdleTimeout. Вызывается под a.mu
+func (a *AgentLimits) expire(now time.Time) {
+	if a.quota.IdleTimeout <= 0 || now.Sub(a.swept) < a.quota.IdleTimeout {
+		return
+	}
+
+	for agent, seen := range a.seen {
+		if now.Sub(seen) >= a.quota.IdleTimeout {
+			delete(a.seen, agent)
+			delete(a.buckets, agent)
+			delete(a.agents, agent)
+		}
+	}
+
+	a.swept = now
+}
+
+func (a *AgentLimits) bucket(agent string) *TokenBucket {
+	bucket, ok := a.buckets[agent]
+	if !ok {
+		bucket = NewTokenBucket(a.quota.Rate, a.quota.Burst)
+		a.buckets[agent] = bucket
+	}
+
+	return bucket
+}
+
+// seriesKeys Ключи серий в формате tenant/type/name
+func seriesKeys(tenant string, gauges types.Gauges, counters types.Counters) []string {
+	keys := make([]string, 0, len(gauges)+len(counters))
+
+	for name := range gauges {
+		keys = append(keys, tenant+"/"+dictionaries.GaugeType+"/"+name)
+	}
+
+	for name := range counters {
+		keys = append(keys, tenant+"/"+dictionaries.CounterType+"/"+name)
+	}
+
+	return keys
+}
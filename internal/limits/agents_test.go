@@ -0,0 +1,170 @@
+package limits
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vllvll/devops/internal/dictionaries"
+	"github.com/vllvll/devops/internal/repositories"
+	"github.com/vllvll/devops/internal/telemetry"
+	"github.com/vllvll/devops/internal/types"
+)
+
+func TestAgentLimits_Allow(t *testing.T) {
+	tests := []struct {
+		name   string
+		quota  AgentQuota
+		agent  string
+		gauges types.Gauges
+		reason string
+	}{
+		{
+			name:   "new series within agent limit",
+			quota:  AgentQuota{MaxSeries: 3},
+			agent:  "10.0.0.1",
+			gauges: types.Gauges{"Alloc": 1, "HeapAlloc": 1},
+		},
+		{
+			name:   "agent series limit",
+			quota:  AgentQuota{MaxSeries: 2},
+			agent:  "10.0.0.1",
+			gauges: types.Gauges{"Alloc": 1, "HeapAlloc": 1},
+			reason: ReasonAgentSeries,
+		},
+		{
+			name:   "other agent has own series limit",
+			quota:  AgentQuota{MaxSeries: 2},
+			agent:  "10.0.0.2",
+			gauges: types.Gauges{"Alloc": 1, "HeapAlloc": 1},
+		},
+		{
+			name:   "global series limit",
+			quota:  AgentQuota{MaxSeriesTotal: 3},
+			agent:  "10.0.0.2",
+			gauges: types.Gauges{"Alloc": 1, "HeapAlloc": 1},
+			reason: ReasonGlobalSeries,
+		},
+		{
+			name:   "agent rate limit",
+			quota:  AgentQuota{Rate: 2},
+			agent:  "10.0.0.1",
+			gauges: types.Gauges{"Alloc": 1},
+			reason: ReasonAgentRate,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now()
+			agents := NewAgentLimits(tt.quota)
+			agents.now = func() time.Time { return now }
+
+			agents.Seed("", types.Gauges{"Sys": 1}, nil)
+			_, err := agents.Allow("10.0.0.1", "", types.Gauges{"Alloc": 1}, types.Counters{"PollCount": 1})
+			require.NoError(t, err)
+
+			_, err = agents.Allow(tt.agent, "", tt.gauges, nil)
+			if tt.reason == "" {
+				assert.NoError(t, err)
+
+				return
+			}
+
+			var limitErr *LimitError
+			require.ErrorAs(t, err, &limitErr)
+			assert.Equal(t, tt.reason, limitErr.Reason)
+		})
+	}
+}
+
+func TestAgentLimits_Release(t *testing.T) {
+	agents := NewAgentLimits(AgentQuota{MaxSeries: 2, MaxSeriesTotal: 2})
+
+	_, err := agents.Allow("10.0.0.1", "", types.Gauges{"Alloc": 1}, nil)
+	require.NoError(t, err)
+
+	// Серии записи, которая не удалась, освобождаются. Уже учтенная серия Alloc остается
+	reservation, err := agents.Allow("10.0.0.1", "", types.Gauges{"Alloc": 2, "HeapAlloc": 1}, nil)
+	require.NoError(t, err)
+	reservation.Release()
+
+	_, err = agents.Allow("10.0.0.1", "", types.Gauges{"Sys": 1}, nil)
+	require.NoError(t, err)
+
+	_, err = agents.Allow("10.0.0.2", "", types.Gauges{"HeapAlloc": 1}, nil)
+	assert.ErrorIs(t, err, ErrSeriesLimit)
+
+	// Удаленная серия больше не учитывается
+	agents.Forget("", dictionaries.GaugeType, "Alloc")
+
+	_, err = agents.Allow("10.0.0.2", "", types.Gauges{"HeapAlloc": 1}, nil)
+	assert.NoError(t, err)
+}
+
+func TestAgentLimits_IdleExpiry(t *testing.T) {
+	now := time.Now()
+	agents := NewAgentLimits(AgentQuota{MaxSeries: 1, IdleTimeout: time.Hour})
+	agents.now = func() time.Time { return now }
+
+	_, err := agents.Allow("10.0.0.1", "", types.Gauges{"Alloc": 1}, nil)
+	require.NoError(t, err)
+
+	_, err = agents.Allow("10.0.0.1", "", types.Gauges{"HeapAlloc": 1}, nil)
+	assert.ErrorIs(t, err, ErrSeriesLimit)
+
+	// Агент, который не записывал метрики дольше IdleTimeout, забывается вместе со своими сериями
+	now = now.Add(time.Hour)
+
+	_, err = agents.Allow("10.0.0.2", "", types.Gauges{"Sys": 1}, nil)
+	require.NoError(t, err)
+	assert.NotContains(t, agents.agents, "10.0.0.1")
+	assert.NotContains(t, agents.seen, "10.0.0.1")
+	assert.Contains(t, agents.seen, "10.0.0.2")
+
+	_, err = agents.Allow("10.0.0.1", "", types.Gauges{"HeapAlloc": 1}, nil)
+	assert.NoError(t, err)
+}
+
+func TestLimits_DefaultBurst(t *testing.T) {
+	assert.Equal(t, 10000, defaultBurst(2, 0, 10000))
+	assert.Equal(t, 50000, defaultBurst(50000, 0, 10000))
+	assert.Equal(t, 5, defaultBurst(2, 5, 10000))
+}
+
+func TestLimits_AllowRecordsRejections(t *testing.T) {
+	repository := repositories.NewStatsMemoryRepository()
+	registry := telemetry.NewRegistry()
+	limits := New(nil, NewAgentLimits(AgentQuota{MaxSeries: 1}), registry)
+
+	_, err := limits.Allow("10.0.0.1", "", repository, types.Gauges{"Alloc": 1}, nil)
+	assert.NoError(t, err)
+
+	_, err = limits.Allow("10.0.0.1", "", repository, types.Gauges{"HeapAlloc": 1, "Sys": 1}, nil)
+	assert.ErrorIs(t, err, ErrSeriesLimit)
+
+	rejected := registry.Counter(telemetry.RejectedMetrics, map[string]string{"reason": ReasonAgentSeries})
+	assert.Equal(t, uint64(2), rejected.Value())
+}
+
+func TestLimits_AllowReleasesTenantSeries(t *testing.T) {
+	repository := repositories.NewStatsMemoryRepository()
+	limits := New(NewTenantQuotas(Quotas{Default: Quota{MaxSeries: 2}}), NewAgentLimits(AgentQuota{MaxSeries: 1}), telemetry.NewRegistry())
+
+	// Серии арендатора, учтенные до отказа по ограничению агента, освобождаются
+	_, err := limits.Allow("10.0.0.1", "team-a", repository, types.Gauges{"Alloc": 1, "HeapAlloc": 1}, nil)
+	assert.ErrorIs(t, err, ErrSeriesLimit)
+
+	reservation, err := limits.Allow("10.0.0.2", "team-a", repository, types.Gauges{"Alloc": 1}, nil)
+	require.NoError(t, err)
+	_, err = limits.Allow("10.0.0.3", "team-a", repository, types.Gauges{"HeapAlloc": 1}, nil)
+	require.NoError(t, err)
+
+	// Освобождаются серии и арендатора, и агента
+	reservation.Release()
+
+	_, err = limits.Allow("10.0.0.2", "team-a", repository, types.Gauges{"Sys": 1}, nil)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,128 @@
+package limits
+
+import (
+	"errors"
+	"math"
+
+	conf "github.com/vllvll/devops/internal/config"
+	"github.com/vllvll/devops/internal/repositories"
+	"github.com/vllvll/devops/internal/telemetry"
+	"github.com/vllvll/devops/internal/types"
+)
+
+type Limits struct {
+	tenants   *TenantQuotas       // Ограничения арендаторов
+	agents    *AgentLimits        // Ограничения агентов
+	telemetry *telemetry.Registry // Метрики сервера
+}
+
+// New Создание сервиса проверки ограничений арендаторов и агентов.
+// Количество отказов учитывается в метрике сервера telemetry.RejectedMetrics
+func New(tenants *TenantQuotas, agents *AgentLimits, registry *telemetry.Registry) *Limits {
+	return &Limits{
+		tenants:   tenants,
+		agents:    agents,
+		telemetry: registry,
+	}
+}
+
+// Allow Проверка, что агент может записать метрики арендатора. Если запись после проверки не удалась,
+// учтенные серии нужно освободить через Reservation.Release. Без ограничений (nil) запись разрешена
+func (l *Limits) Allow(agent string, tenant string, repository repositories.StatsRepository, gauges types.Gauges, counters types.Counters) (*Reservation, error) {
+	if l == nil {
+		return nil, nil
+	}
+
+	reservation, err := l.tenants.Allow(tenant, repository, gauges, counters)
+	if err == nil {
+		var agentReservation *Reservation
+
+		agentReservation, err = l.agents.Allow(agent, tenant, gauges, counters)
+		if err != nil {
+			reservation.Release()
+			reservation = nil
+		}
+
+		reservation = reservation.join(agentReservation)
+	}
+
+	var limitErr *LimitError
+	if errors.As(err, &limitErr) {
+		l.telemetry.Counter(telemetry.RejectedMetrics, map[string]string{"reason": limitErr.Reason}).Add(len(gauges) + len(counters))
+	}
+
+	return reservation, err
+}
+
+// Forget Освобождение удаленной серии в ограничениях арендатора и агентов. Без ограничений (nil) ничего не делает
+func (l *Limits) Forget(tenant string, mType string, name string) {
+	if l == nil {
+		return
+	}
+
+	l.tenants.Forget(tenant, mType, name)
+	l.agents.Forget(tenant, mType, name)
+}
+
+// IsLimitError Проверка, что запись отклонена из-за превышения ограничений
+func IsLimitError(err error) bool {
+	return errors.Is(err, ErrRateLimited) || errors.Is(err, ErrSeriesLimit)
+}
+
+// NewFromConfig Создание сервиса ограничений по конфигу сервера. Если ограничения не заданы, возвращается nil.
+// Уже сохраненные серии арендаторов учитываются в общем ограничении
+func NewFromConfig(config *conf.ServerConfig, tenants repositories.TenantRepository, registry *telemetry.Registry) (*Limits, error) {
+	var tenantQuotas *TenantQuotas
+	var agentLimits *AgentLimits
+
+	if config.QuotasFile != "" {
+		quotas, err := LoadQuotas(config.QuotasFile)
+		if err != nil {
+			return nil, err
+		}
+
+		quotas.Default.Burst = defaultBurst(quotas.Default.Rate, quotas.Default.Burst, config.MaxBatchSize)
+		for name, quota := range quotas.Tenants {
+			quota.Burst = defaultBurst(quota.Rate, quota.Burst, config.MaxBatchSize)
+			quotas.Tenants[name] = quota
+		}
+
+		tenantQuotas = NewTenantQuotas(quotas)
+	}
+
+	if config.AgentRate > 0 || config.AgentMaxSeries > 0 || config.MaxSeries > 0 {
+		agentLimits = NewAgentLimits(AgentQuota{
+			Rate:           config.AgentRate,
+			Burst:          defaultBurst(config.AgentRate, config.AgentBurst, config.MaxBatchSize),
+			MaxSeries:      config.AgentMaxSeries,
+			MaxSeriesTotal: config.MaxSeries,
+			IdleTimeout:    config.AgentIdleTimeout,
+		})
+
+		names, err := tenants.Tenants()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, name := range names {
+			gauges, counters := tenants.Tenant(name).GetAll()
+			agentLimits.Seed(name, gauges, counters)
+		}
+	}
+
+	if tenantQuotas == nil && agentLimits == nil {
+		return nil, nil
+	}
+
+	return New(tenantQuotas, agentLimits, registry), nil
+}
+
+// defaultBurst Допустимый всплеск записи. По умолчанию он вмещает самый большой запрос maxBatchSize,
+// иначе такой запрос отклонялся бы при каждом повторе
+func defaultBurst(rate float64, burst int, maxBatchSize int) int {
+	if burst > 0 {
+		return burst
+	}
+
+	return int(math.Max(math.Ceil(rate), float64(maxBatchSize)))
+}
@@ -0,0 +1,184 @@
+package limits
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/vllvll/devops/internal/repositories"
+	"github.com/vllvll/devops/internal/types"
+)
+
+var (
+	ErrRateLimited = errors.New("ingestion rate limit exceeded") // Превышена скорость записи
+	ErrSeriesLimit = errors.New("series limit exceeded")         // Превышено количество серий
+)
+
+// Причины отказа в записи
+const (
+	ReasonTenantRate   = "tenant_rate"   // Скорость записи арендатора
+	ReasonTenantSeries = "tenant_series" // Количество серий арендатора
+	ReasonAgentRate    = "agent_rate"    // Скорость записи агента
+	ReasonAgentSeries  = "agent_series"  // Количество серий агента
+	ReasonGlobalSeries = "global_series" // Общее количество серий
+)
+
+// LimitError Отказ в записи с указанием причины
+type LimitError struct {
+	Reason string
+	Err    error
+}
+
+func (e *LimitError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+// Quota Ограничения арендатора. Нулевое значение - без ограничения
+type Quota struct {
+	MaxSeries int     `json:"max_series"` // Максимальное количество серий (Gauge и Counter)
+	Rate      float64 `json:"rate"`       // Скорость записи (значений в секунду)
+	Burst     int     `json:"burst"`      // Допустимый всплеск записи
+}
+
+// Quotas Ограничения по умолчанию и для отдельных арендаторов
+type Quotas struct {
+	Default Quota            `json:"default"`
+	Tenants map[string]Quota `json:"tenants"`
+}
+
+type TenantQuotas struct {
+	mu      sync.Mutex
+	quotas  Quotas
+	buckets map[string]*TokenBucket        // Корзины токенов арендаторов
+	series  map[string]map[string]struct{} // Серии арендаторов с ограничением количества серий
+	now     func() time.Time
+}
+
+// LoadQuotas Чтение ограничений арендаторов из JSON файла
+func LoadQuotas(path string) (Quotas, error) {
+	var quotas Quotas
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return quotas, err
+	}
+
+	err = json.Unmarshal(data, &quotas)
+
+	return quotas, err
+}
+
+// NewTenantQuotas Создание сервиса для проверки ограничений арендаторов
+func NewTenantQuotas(quotas Quotas) *TenantQuotas {
+	return &TenantQuotas{
+		quotas:  quotas,
+		buckets: map[string]*TokenBucket{},
+		series:  map[string]map[string]struct{}{},
+		now:     time.Now,
+	}
+}
+
+// Quota Получение ограничений арендатора
+func (q *TenantQuotas) Quota(tenant string) Quota {
+	if quota, ok := q.quotas.Tenants[tenant]; ok {
+		return quota
+	}
+
+	return q.quotas.Default
+}
+
+// Allow Проверка, что арендатор может записать метрики. Серии арендатора читаются из repository один раз
+// при первой записи, а новые серии учитываются сразу, чтобы одновременные запросы не превысили ограничение;
+// если запись не удалась, их нужно освободить через Reservation.Release. Без ограничений (nil) запись разрешена
+func (q *TenantQuotas) Allow(tenant string, repository repositories.StatsRepository, gauges types.Gauges, counters types.Counters) (*Reservation, error) {
+	if q == nil {
+		return nil, nil
+	}
+
+	quota := q.Quota(tenant)
+
+	var reservation *Reservation
+	if quota.MaxSeries > 0 {
+		var err error
+
+		reservation, err = q.reserve(quota.MaxSeries, tenant, repository, gauges, counters)
+		if err != nil {
+			return nil, &LimitError{Reason: ReasonTenantSeries, Err: err}
+		}
+	}
+
+	if quota.Rate > 0 && !q.bucket(tenant, quota).AllowN(q.now(), len(gauges)+len(counters)) {
+		reservation.Release()
+
+		return nil, &LimitError{Reason: ReasonTenantRate, Err: ErrRateLimited}
+	}
+
+	return reservation, nil
+}
+
+// Forget Освобождение удаленной серии арендатора. Без ограничений (nil) ничего не делает
+func (q *TenantQuotas) Forget(tenant string, mType string, name string) {
+	if q == nil {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.series[tenant], tenant+"/"+mType+"/"+name)
+}
+
+func (q *TenantQuotas) bucket(tenant string, quota Quota) *TokenBucket {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	bucket, ok := q.buckets[tenant]
+	if !ok {
+		bucket = NewTokenBucket(quota.Rate, quota.Burst)
+		q.buckets[tenant] = bucket
+	}
+
+	return bucket
+}
+
+// reserve Учет новых серий арендатора, если они не превысят максимальное количество
+func (q *TenantQuotas) reserve(maxSeries int, tenant string, repository repositories.StatsRepository, gauges types.Gauges, counters types.Counters) (*Reservation, error) {
+	keys := seriesKeys(tenant, gauges, counters)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	series, ok := q.series[tenant]
+	if !ok {
+		existingGauges, existingCounters := repository.GetAll()
+
+		series = map[string]struct{}{}
+		for _, key := range seriesKeys(tenant, existingGauges, existingCounters) {
+			series[key] = struct{}{}
+		}
+		q.series[tenant] = series
+	}
+
+	reservation := &Reservation{quotas: q, tenant: tenant}
+	for _, key := range keys {
+		if _, ok := series[key]; !ok {
+			reservation.tenantSeries = append(reservation.tenantSeries, key)
+		}
+	}
+
+	if len(series)+len(reservation.tenantSeries) > maxSeries {
+		return nil, ErrSeriesLimit
+	}
+
+	for _, key := range reservation.tenantSeries {
+		series[key] = struct{}{}
+	}
+
+	return reservation, nil
+}
@@ -0,0 +1,85 @@
+package limits
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vllvll/devops/internal/dictionaries"
+	"github.com/vllvll/devops/internal/repositories"
+	"github.com/vllvll/devops/internal/types"
+)
+
+func TestTokenBucket_AllowN(t *testing.T) {
+	now := time.Now()
+	bucket := NewTokenBucket(10, 20)
+
+	assert.True(t, bucket.AllowN(now, 15))
+	assert.False(t, bucket.AllowN(now, 10))
+	assert.True(t, bucket.AllowN(now.Add(500*time.Millisecond), 10))
+	assert.False(t, bucket.AllowN(now.Add(500*time.Millisecond), 1))
+	assert.True(t, bucket.AllowN(now.Add(time.Hour), 20))
+	assert.False(t, bucket.AllowN(now.Add(time.Hour), 21))
+}
+
+func TestTenantQuotas_Allow(t *testing.T) {
+	now := time.Now()
+	quotas := NewTenantQuotas(Quotas{
+		Default: Quota{Rate: 2},
+		Tenants: map[string]Quota{
+			"team-a": {MaxSeries: 2},
+		},
+	})
+	quotas.now = func() time.Time { return now }
+
+	repository := repositories.NewStatsMemoryRepository()
+	repository.UpdateGauge("Alloc", 1)
+
+	_, err := quotas.Allow("team-a", repository, types.Gauges{"Alloc": 2, "HeapAlloc": 1}, nil)
+	assert.NoError(t, err)
+	_, err = quotas.Allow("team-a", repository, types.Gauges{"HeapAlloc": 1}, types.Counters{"PollCount": 1})
+	assert.ErrorIs(t, err, ErrSeriesLimit)
+
+	_, err = quotas.Allow("team-b", repository, types.Gauges{"Alloc": 1, "HeapAlloc": 1}, nil)
+	assert.NoError(t, err)
+	_, err = quotas.Allow("team-b", repository, types.Gauges{"Alloc": 1}, nil)
+	assert.ErrorIs(t, err, ErrRateLimited)
+	_, err = quotas.Allow("team-c", repository, types.Gauges{"Alloc": 1}, nil)
+	assert.NoError(t, err)
+
+	var disabled *TenantQuotas
+	_, err = disabled.Allow("team-b", repository, types.Gauges{"Alloc": 1}, nil)
+	assert.NoError(t, err)
+}
+
+func TestTenantQuotas_Release(t *testing.T) {
+	quotas := NewTenantQuotas(Quotas{Default: Quota{MaxSeries: 2}})
+
+	repository := repositories.NewStatsMemoryRepository()
+	repository.UpdateGauge("Alloc", 1)
+
+	// Серии записи, которая не удалась, освобождаются. Серия Alloc учтена из хранилища
+	reservation, err := quotas.Allow("team-a", repository, types.Gauges{"HeapAlloc": 1}, nil)
+	require.NoError(t, err)
+	reservation.Release()
+
+	_, err = quotas.Allow("team-a", repository, types.Gauges{"Sys": 1}, nil)
+	require.NoError(t, err)
+
+	// Хранилище читается только при первой записи арендатора
+	repository.UpdateGauge("Sys", 1)
+	repository.UpdateGauge("HeapAlloc", 1)
+
+	_, err = quotas.Allow("team-a", repository, types.Gauges{"Alloc": 2, "Sys": 2}, nil)
+	assert.NoError(t, err)
+	_, err = quotas.Allow("team-a", repository, types.Gauges{"HeapAlloc": 1}, nil)
+	assert.ErrorIs(t, err, ErrSeriesLimit)
+
+	// Удаленная серия больше не учитывается
+	quotas.Forget("team-a", dictionaries.GaugeType, "Alloc")
+
+	_, err = quotas.Allow("team-a", repository, types.Gauges{"HeapAlloc": 1}, nil)
+	assert.NoError(t, err)
+}
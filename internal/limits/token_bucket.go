@@ -0,0 +1,52 @@
+// Package limits Функционал для ограничения скорости записи и количества серий метрик
+package limits
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64   // Скорость пополнения (значений в секунду)
+	burst  float64   // Емкость корзины
+	tokens float64   // Доступное количество значений
+	last   time.Time // Время последнего пополнения
+}
+
+// NewTokenBucket Создание корзины токенов. Если емкость не задана, она равна скорости за одну секунду
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	capacity := float64(burst)
+	if capacity <= 0 {
+		capacity = math.Max(math.Ceil(rate), 1)
+	}
+
+	return &TokenBucket{
+		rate:   rate,
+		burst:  capacity,
+		tokens: capacity,
+	}
+}
+
+// AllowN Проверка, что в момент at можно записать n значений. При успехе токены списываются
+func (b *TokenBucket) AllowN(at time.Time, n int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.last.IsZero() && at.After(b.last) {
+		b.tokens = math.Min(b.burst, b.tokens+at.Sub(b.last).Seconds()*b.rate)
+	}
+
+	if b.last.IsZero() || at.After(b.last) {
+		b.last = at
+	}
+
+	if float64(n) > b.tokens {
+		return false
+	}
+
+	b.tokens -= float64(n)
+
+	return true
+}
@@ -0,0 +1,95 @@
+// Package logger Функционал для структурированного логирования сервера и агента
+package logger
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Форматы вывода
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// Ключи для передачи идентификаторов между агентом и сервером
+const (
+	RequestIDHeader   = "X-Request-Id" // HTTP заголовок с идентификатором запроса
+	RequestIDMetadata = "x-request-id" // Ключ метаданных gRPC с идентификатором запроса
+	AgentIDHeader     = "X-Agent-Id"   // HTTP заголовок с идентификатором агента
+	AgentIDMetadata   = "x-agent-id"   // Ключ метаданных gRPC с идентификатором агента
+)
+
+// Параметры сэмплирования: в течение секунды пишутся первые sampleInitial одинаковых сообщений,
+// затем каждое sampleThereafter-е
+const (
+	sampleInitial    = 100
+	sampleThereafter = 100
+)
+
+type contextKey struct{}
+
+// New Создание логгера с уровнем level (debug, info, warn, error) и форматом json или text
+func New(level string, format string, sampling bool) (*zap.Logger, error) {
+	var config zap.Config
+
+	switch format {
+	case FormatJSON, "":
+		config = zap.NewProductionConfig()
+	case FormatText:
+		config = zap.NewDevelopmentConfig()
+		config.Development = false
+		config.EncoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+		if isTerminal(os.Stderr) {
+			config.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
+		}
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+
+	atomicLevel, err := zap.ParseAtomicLevel(level)
+	if err != nil {
+		return nil, err
+	}
+
+	config.Level = atomicLevel
+	config.Sampling = nil
+	config.DisableStacktrace = true
+
+	if sampling {
+		config.Sampling = &zap.SamplingConfig{
+			Initial:    sampleInitial,
+			Thereafter: sampleThereafter,
+		}
+	}
+
+	return config.Build()
+}
+
+// isTerminal Проверка, что вывод идет в терминал. Цвет уровней в файлах и сборщиках логов мешает разбору строк
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// NewContext Сохранение логгера запроса в контексте
+func NewContext(ctx context.Context, log *zap.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, log)
+}
+
+// FromContext Получение логгера запроса из контекста. Без логгера в контексте возвращается глобальный логгер
+func FromContext(ctx context.Context) *zap.Logger {
+	if log, ok := ctx.Value(contextKey{}).(*zap.Logger); ok {
+		return log
+	}
+
+	return zap.L()
+}
@@ -0,0 +1,84 @@
+package logger
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		level   string
+		format  string
+		enabled zapcore.Level
+		wantErr bool
+	}{
+		{
+			name:    "json info",
+			level:   "info",
+			format:  FormatJSON,
+			enabled: zapcore.InfoLevel,
+		},
+		{
+			name:    "text debug",
+			level:   "debug",
+			format:  FormatText,
+			enabled: zapcore.DebugLevel,
+		},
+		{
+			name:    "default format",
+			level:   "warn",
+			format:  "",
+			enabled: zapcore.WarnLevel,
+		},
+		{
+			name:    "unknown format",
+			level:   "info",
+			format:  "xml",
+			wantErr: true,
+		},
+		{
+			name:    "unknown level",
+			level:   "verbose",
+			format:  FormatJSON,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log, err := New(tt.level, tt.format, true)
+			if tt.wantErr {
+				assert.Error(t, err)
+
+				return
+			}
+			require.NoError(t, err)
+
+			assert.True(t, log.Core().Enabled(tt.enabled))
+			assert.False(t, log.Core().Enabled(tt.enabled-1))
+		})
+	}
+}
+
+func TestIsTerminal(t *testing.T) {
+	file, err := os.Create(filepath.Join(t.TempDir(), "server.log"))
+	require.NoError(t, err)
+	defer file.Close()
+
+	assert.False(t, isTerminal(file))
+}
+
+func TestFromContext(t *testing.T) {
+	assert.Equal(t, zap.L(), FromContext(context.Background()))
+
+	log := zap.NewNop()
+	assert.Same(t, log, FromContext(NewContext(context.Background(), log)))
+}
@@ -0,0 +1,29 @@
+package middlewares
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/vllvll/devops/internal/tenant"
+)
+
+// AdminKey Проверка ключа администратора в заголовке Authorization: Bearer <key>.
+// При пустом ключе доступ к обработчикам запрещен. Администратор может выбрать любого арендатора
+func AdminKey(key string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+			if key == "" || subtle.ConstantTimeCompare([]byte(token), []byte(key)) != 1 {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(tenant.WithOverride(r.Context())))
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
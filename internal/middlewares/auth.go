@@ -0,0 +1,44 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/vllvll/devops/internal/auth"
+)
+
+// Authenticate Проверка токена из заголовка Authorization: Bearer <token> и сохранение его в контексте запроса
+func Authenticate(store auth.Store) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			token, err := auth.Authenticate(store, auth.BearerSecret(r.Header.Get("Authorization")))
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), token)))
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// RequireScope Проверка права доступа у токена из контекста запроса
+func RequireScope(scope string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			token, ok := auth.FromContext(r.Context())
+			if !ok || !token.HasScope(scope) {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
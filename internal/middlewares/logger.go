@@ -0,0 +1,45 @@
+package middlewares
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"go.uber.org/zap"
+
+	"github.com/vllvll/devops/internal/logger"
+)
+
+// Logger Логирование запросов. Логгер с идентификаторами запроса и агента сохраняется в контексте запроса.
+// Должен подключаться после middleware.RequestID
+func Logger(log *zap.Logger) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+			requestLog := log.With(zap.String("request_id", middleware.GetReqID(r.Context())))
+			if agentID := r.Header.Get(logger.AgentIDHeader); agentID != "" {
+				requestLog = requestLog.With(zap.String("agent_id", agentID))
+			}
+
+			next.ServeHTTP(ww, r.WithContext(logger.NewContext(r.Context(), requestLog)))
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			requestLog.Info("request",
+				zap.String("method", r.Method),
+				zap.String("path", r.URL.Path),
+				zap.String("remote", r.RemoteAddr),
+				zap.Int("status", status),
+				zap.Int("bytes", ww.BytesWritten()),
+				zap.Duration("duration", time.Since(start)),
+			)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
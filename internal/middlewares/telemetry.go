@@ -0,0 +1,42 @@
+package middlewares
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+
+	"github.com/vllvll/devops/internal/telemetry"
+)
+
+// Telemetry Измерение длительности обработки запросов по шаблону маршрута, методу и коду ответа
+func Telemetry(registry *telemetry.Registry) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+			next.ServeHTTP(ww, r)
+
+			route := "unmatched"
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+				route = rctx.RoutePattern()
+			}
+
+			code := ww.Status()
+			if code == 0 {
+				code = http.StatusOK
+			}
+
+			registry.Histogram(telemetry.HTTPRequestDuration, map[string]string{
+				"route":  route,
+				"method": r.Method,
+				"code":   strconv.Itoa(code),
+			}, telemetry.DurationBuckets).Observe(time.Since(start).Seconds())
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
@@ -0,0 +1,30 @@
+package middlewares
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/vllvll/devops/internal/tenant"
+)
+
+// Tenant Определение арендатора по токену или заголовку X-Tenant и сохранение его в контексте запроса
+func Tenant(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		name, err := tenant.Resolve(r.Context(), r.Header.Get(tenant.Header))
+		if errors.Is(err, tenant.ErrTenantMismatch) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+
+			return
+		}
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(tenant.NewContext(r.Context(), name)))
+	}
+
+	return http.HandlerFunc(fn)
+}
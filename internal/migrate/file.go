@@ -0,0 +1,72 @@
+package migrate
+
+import (
+	"fmt"
+
+	"github.com/vllvll/devops/internal/backup"
+	conf "github.com/vllvll/devops/internal/config"
+	"github.com/vllvll/devops/internal/repositories"
+	"github.com/vllvll/devops/internal/storage"
+	"github.com/vllvll/devops/internal/storage/file"
+	"github.com/vllvll/devops/internal/types"
+)
+
+// FileStore Хранилище метрик в файле сервера
+type FileStore struct {
+	path    string                        // Файл хранилища сервера (STORE_FILE)
+	tenants repositories.TenantRepository // Метрики файла в оперативной памяти
+}
+
+// OpenFile Чтение файла хранилища сервера в оперативную память. Отсутствующий файл считается пустым
+func OpenFile(path string) (*FileStore, error) {
+	consumer, err := file.NewFileConsumer(path)
+	if err != nil {
+		return nil, err
+	}
+	defer consumer.Close()
+
+	config := &conf.ServerConfig{StoreFile: path, Restore: true}
+
+	tenants, err := storage.NewStatsStorage(config, consumer, nil, nil).Start(repositories.NewTenantMemoryRepository())
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileStore{
+		path:    path,
+		tenants: tenants,
+	}, nil
+}
+
+// Tenants Список арендаторов файла
+func (s *FileStore) Tenants() ([]string, error) {
+	return s.tenants.Tenants()
+}
+
+// GetAll Все метрики арендатора из файла
+func (s *FileStore) GetAll(tenantName string) (types.Gauges, types.Counters, error) {
+	gauges, counters := s.tenants.Tenant(tenantName).GetAll()
+
+	return gauges, counters, nil
+}
+
+// Restore Запись метрик арендатора в оперативную память. В файл метрики попадают после Flush
+func (s *FileStore) Restore(tenantName string, gauges types.Gauges, counters types.Counters, replace bool) error {
+	return backup.NewRepositoryStore(s.tenants).Restore(tenantName, gauges, counters, replace)
+}
+
+// Flush Запись всех метрик в файл
+func (s *FileStore) Flush() error {
+	producer, err := file.NewFileProducer(s.path)
+	if err != nil {
+		return err
+	}
+	defer producer.Close()
+
+	config := &conf.ServerConfig{StoreFile: s.path}
+	if err := storage.NewStatsStorage(config, nil, producer, nil).Save(s.tenants); err != nil {
+		return fmt.Errorf("write %s: %w", s.path, err)
+	}
+
+	return nil
+}
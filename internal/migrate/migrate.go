@@ -0,0 +1,195 @@
+// Package migrate Функционал для переноса метрик между хранилищами сервера: файлом и бд
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/vllvll/devops/internal/backup"
+	"github.com/vllvll/devops/internal/types"
+)
+
+// ErrVerification Метрики в получателе не совпадают с метриками источника после переноса
+var ErrVerification = errors.New("verification failed")
+
+// Summary Количество и суммы значений метрик
+type Summary struct {
+	Tenants    int     // Количество арендаторов
+	Gauges     int     // Количество метрик типа Gauge
+	Counters   int     // Количество метрик типа Counter
+	GaugeSum   float64 // Сумма значений Gauge
+	CounterSum int64   // Сумма значений Counter
+}
+
+// Plan Изменения, которые внесет перенос в получатель
+type Plan struct {
+	Source    Summary // Метрики источника
+	Created   int     // Метрики, которых нет в получателе
+	Changed   int     // Метрики, значение которых в получателе отличается
+	Unchanged int     // Метрики, значение которых в получателе уже совпадает
+}
+
+// Store Хранилище, из которого и в которое можно переносить метрики
+type Store interface {
+	backup.Source
+	backup.Target
+}
+
+// Migrate Перенос всех метрик source в target. Значения Counter в получателе заменяются значениями источника,
+// поэтому повторный перенос не удваивает счетчики. При dryRun получатель не изменяется
+func Migrate(source backup.Source, target Store, dryRun bool) (Plan, error) {
+	plan, err := Compare(source, target)
+	if err != nil || dryRun {
+		return plan, err
+	}
+
+	tenants, err := source.Tenants()
+	if err != nil {
+		return plan, err
+	}
+
+	for _, tenantName := range tenants {
+		gauges, counters, err := source.GetAll(tenantName)
+		if err != nil {
+			return plan, fmt.Errorf("tenant %q: %w", tenantName, err)
+		}
+
+		if err := target.Restore(tenantName, gauges, counters, true); err != nil {
+			return plan, fmt.Errorf("tenant %q: %w", tenantName, err)
+		}
+	}
+
+	return plan, nil
+}
+
+// Compare Сравнение метрик источника с метриками получателя
+func Compare(source backup.Source, target backup.Source) (Plan, error) {
+	var plan Plan
+
+	tenants, err := source.Tenants()
+	if err != nil {
+		return plan, err
+	}
+
+	for _, tenantName := range tenants {
+		gauges, counters, err := source.GetAll(tenantName)
+		if err != nil {
+			return plan, fmt.Errorf("tenant %q: %w", tenantName, err)
+		}
+
+		targetGauges, targetCounters, err := target.GetAll(tenantName)
+		if err != nil {
+			return plan, fmt.Errorf("tenant %q: %w", tenantName, err)
+		}
+
+		plan.Source.Tenants++
+
+		for key, value := range gauges {
+			plan.Source.Gauges++
+			plan.Source.GaugeSum += float64(value)
+
+			current, ok := targetGauges[key]
+			plan.count(ok, current == value)
+		}
+
+		for key, value := range counters {
+			plan.Source.Counters++
+			plan.Source.CounterSum += int64(value)
+
+			current, ok := targetCounters[key]
+			plan.count(ok, current == value)
+		}
+	}
+
+	return plan, nil
+}
+
+// Verify Проверка после переноса: количество и суммы значений метрик источника в получателе совпадают
+func Verify(source backup.Source, target backup.Source) (Summary, error) {
+	var expected, actual Summary
+
+	tenants, err := source.Tenants()
+	if err != nil {
+		return actual, err
+	}
+
+	for _, tenantName := range tenants {
+		gauges, counters, err := source.GetAll(tenantName)
+		if err != nil {
+			return actual, fmt.Errorf("tenant %q: %w", tenantName, err)
+		}
+
+		targetGauges, targetCounters, err := target.GetAll(tenantName)
+		if err != nil {
+			return actual, fmt.Errorf("tenant %q: %w", tenantName, err)
+		}
+
+		expected.Tenants++
+		actual.Tenants++
+		expected.add(gauges, counters)
+		actual.add(pickGauges(targetGauges, gauges), pickCounters(targetCounters, counters))
+	}
+
+	if actual.Gauges != expected.Gauges || actual.Counters != expected.Counters ||
+		actual.CounterSum != expected.CounterSum || !sameSum(actual.GaugeSum, expected.GaugeSum) {
+		return actual, fmt.Errorf("%w: expected %+v, got %+v", ErrVerification, expected, actual)
+	}
+
+	return actual, nil
+}
+
+// count Учет одной метрики в плане
+func (p *Plan) count(exists bool, equal bool) {
+	switch {
+	case !exists:
+		p.Created++
+	case equal:
+		p.Unchanged++
+	default:
+		p.Changed++
+	}
+}
+
+// add Учет метрик арендатора в сумме
+func (s *Summary) add(gauges types.Gauges, counters types.Counters) {
+	s.Gauges += len(gauges)
+	s.Counters += len(counters)
+
+	for _, value := range gauges {
+		s.GaugeSum += float64(value)
+	}
+
+	for _, value := range counters {
+		s.CounterSum += int64(value)
+	}
+}
+
+// pickGauges Метрики типа Gauge получателя с ключами источника
+func pickGauges(target types.Gauges, source types.Gauges) types.Gauges {
+	picked := types.Gauges{}
+	for key := range source {
+		if value, ok := target[key]; ok {
+			picked[key] = value
+		}
+	}
+
+	return picked
+}
+
+// pickCounters Метрики типа Counter получателя с ключами источника
+func pickCounters(target types.Counters, source types.Counters) types.Counters {
+	picked := types.Counters{}
+	for key := range source {
+		if value, ok := target[key]; ok {
+			picked[key] = value
+		}
+	}
+
+	return picked
+}
+
+// sameSum Сравнение сумм Gauge с учетом погрешности сложения в разном порядке
+func sameSum(a float64, b float64) bool {
+	return math.Abs(a-b) <= 1e-9*math.Max(1, math.Max(math.Abs(a), math.Abs(b)))
+}
@@ -0,0 +1,76 @@
+package migrate
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vllvll/devops/internal/backup"
+	"github.com/vllvll/devops/internal/repositories"
+	"github.com/vllvll/devops/internal/types"
+)
+
+func TestMigrateFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+
+	source := repositories.NewTenantMemoryRepository()
+	require.NoError(t, source.Tenant("").UpdateAll(types.Gauges{"Alloc": 1.5}, types.Counters{"PollCount": 10}))
+	require.NoError(t, source.Tenant("team-a").UpdateAll(types.Gauges{"Alloc": 2}, types.Counters{"Requests": 3}))
+
+	target, err := OpenFile(path)
+	require.NoError(t, err)
+
+	plan, err := Migrate(backup.NewRepositoryStore(source), target, false)
+	require.NoError(t, err)
+	assert.Equal(t, Summary{Tenants: 2, Gauges: 2, Counters: 2, GaugeSum: 3.5, CounterSum: 13}, plan.Source)
+	assert.Equal(t, 4, plan.Created)
+	require.NoError(t, target.Flush())
+
+	// Повторный перенос не удваивает значения Counter
+	target, err = OpenFile(path)
+	require.NoError(t, err)
+
+	plan, err = Migrate(backup.NewRepositoryStore(source), target, false)
+	require.NoError(t, err)
+	assert.Equal(t, 4, plan.Unchanged)
+	require.NoError(t, target.Flush())
+
+	target, err = OpenFile(path)
+	require.NoError(t, err)
+
+	summary, err := Verify(backup.NewRepositoryStore(source), target)
+	require.NoError(t, err)
+	assert.Equal(t, int64(13), summary.CounterSum)
+
+	gauges, counters, err := target.GetAll("team-a")
+	require.NoError(t, err)
+	assert.Equal(t, types.Gauges{"Alloc": 2}, gauges)
+	assert.Equal(t, types.Counters{"Requests": 3}, counters)
+}
+
+func TestMigrateDryRun(t *testing.T) {
+	source := repositories.NewTenantMemoryRepository()
+	require.NoError(t, source.Tenant("").UpdateAll(types.Gauges{"Alloc": 1.5, "HeapAlloc": 7}, types.Counters{"PollCount": 10}))
+
+	tenants := repositories.NewTenantMemoryRepository()
+	require.NoError(t, tenants.Tenant("").UpdateAll(types.Gauges{"Alloc": 1.5}, types.Counters{"PollCount": 4}))
+	target := backup.NewRepositoryStore(tenants)
+
+	plan, err := Migrate(backup.NewRepositoryStore(source), target, true)
+	require.NoError(t, err)
+	assert.Equal(t, Plan{
+		Source:    Summary{Tenants: 1, Gauges: 2, Counters: 1, GaugeSum: 8.5, CounterSum: 10},
+		Created:   1,
+		Changed:   1,
+		Unchanged: 1,
+	}, plan)
+
+	gauges, counters := tenants.Tenant("").GetAll()
+	assert.Equal(t, map[string]types.Gauge{"Alloc": 1.5}, gauges)
+	assert.Equal(t, map[string]types.Counter{"PollCount": 4}, counters)
+
+	_, err = Verify(backup.NewRepositoryStore(source), target)
+	assert.ErrorIs(t, err, ErrVerification)
+}
@@ -0,0 +1,176 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/vllvll/devops/internal/batch"
+	"github.com/vllvll/devops/internal/dictionaries"
+	"github.com/vllvll/devops/internal/logger"
+	"github.com/vllvll/devops/internal/repositories"
+	"github.com/vllvll/devops/internal/tenant"
+	"github.com/vllvll/devops/internal/types"
+	pb "github.com/vllvll/devops/proto"
+)
+
+// GRPCServer Прием пакетов метрик по gRPC. Части пакета передаются серверам по HTTP
+type GRPCServer struct {
+	pb.UnimplementedMetricsServer
+
+	proxy *Proxy
+}
+
+// NewGRPCServer Создание gRPC сервиса метрик прокси
+func NewGRPCServer(proxy *Proxy) *GRPCServer {
+	return &GRPCServer{proxy: proxy}
+}
+
+// BulkSaveMetrics Запись пакета метрик: каждый сервер получает метрики своих серий одним запросом
+func (s *GRPCServer) BulkSaveMetrics(ctx context.Context, in *pb.AddBulkMetricsRequest) (*emptypb.Empty, error) {
+	_, replies, err := s.bulk(ctx, in, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if duplicate(replies) {
+		grpc.SetHeader(ctx, metadata.Pairs(strings.ToLower(batch.DuplicateHeader), "true"))
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// BulkSaveMetricsPartial Запись допустимых метрик пакета. Результаты серверов объединяются с номерами метрик
+// исходного пакета
+func (s *GRPCServer) BulkSaveMetricsPartial(ctx context.Context, in *pb.AddBulkMetricsRequest) (*pb.BulkSaveMetricsPartialResponse, error) {
+	parts, replies, err := s.bulk(ctx, in, true)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := mergeResults(parts, replies)
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+
+	response := &pb.BulkSaveMetricsPartialResponse{
+		Accepted:  int32(result.Accepted),
+		Duplicate: result.Duplicate,
+	}
+	for _, rejected := range result.Rejected {
+		response.Rejected = append(response.Rejected, &pb.RejectedMetric{
+			Index:  int32(rejected.Index),
+			Id:     rejected.ID,
+			Field:  rejected.Field,
+			Reason: rejected.Reason,
+		})
+	}
+
+	return response, nil
+}
+
+// bulk Отправка частей пакета серверам. Ошибка соединения или ответ сервера с ошибкой возвращаются кодом gRPC
+func (s *GRPCServer) bulk(ctx context.Context, in *pb.AddBulkMetricsRequest, partial bool) ([]*part, []reply, error) {
+	inMetrics := in.GetMetrics().GetMetrics()
+	metrics := make([]types.Metrics, 0, len(inMetrics))
+
+	for _, inMetric := range inMetrics {
+		metric := types.Metrics{
+			ID:    inMetric.GetId(),
+			Delta: inMetric.Delta,
+			Value: inMetric.Value,
+			Hash:  inMetric.GetHash(),
+		}
+
+		switch inMetric.GetType() {
+		case pb.Metric_GAUGE:
+			metric.MType = dictionaries.GaugeType
+		case pb.Metric_COUNTER:
+			metric.MType = dictionaries.CounterType
+		}
+
+		metrics = append(metrics, metric)
+	}
+
+	header := incoming(ctx)
+	if in.GetBatchId() != "" {
+		header.Set(batch.Header, in.GetBatchId())
+	}
+
+	parts, replies, err := s.proxy.push(header, partial, metrics)
+	if err != nil {
+		return nil, nil, status.Error(codes.Unavailable, err.Error())
+	}
+
+	for _, reply := range replies {
+		if reply.err != nil {
+			return nil, nil, status.Errorf(codes.Unavailable, "%s: %v", reply.backend.Address, reply.err)
+		}
+
+		if reply.response.IsError() {
+			return nil, nil, status.Error(statusCode(reply.response.StatusCode()), strings.TrimSpace(string(reply.response.Body())))
+		}
+	}
+
+	return parts, replies, nil
+}
+
+// incoming Заголовки HTTP запроса к серверам из метаданных gRPC запроса. Без метаданных ip передается адрес клиента прокси
+func incoming(ctx context.Context) http.Header {
+	header := http.Header{}
+
+	if p, ok := peer.FromContext(ctx); ok {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			header.Set("X-Real-IP", host)
+		}
+	}
+
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return header
+	}
+
+	for key, name := range map[string]string{
+		"authorization":                  "Authorization",
+		"ip":                             "X-Real-IP",
+		tenant.MetadataKey:               tenant.Header,
+		logger.AgentIDMetadata:           logger.AgentIDHeader,
+		repositories.CounterModeMetadata: repositories.CounterModeHeader,
+	} {
+		if values := md.Get(key); len(values) > 0 {
+			header.Set(name, values[0])
+		}
+	}
+
+	return header
+}
+
+// statusCode Код gRPC для кода ответа сервера
+func statusCode(code int) codes.Code {
+	switch code {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.Aborted
+	case http.StatusRequestEntityTooLarge, http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusServiceUnavailable, http.StatusBadGateway:
+		return codes.Unavailable
+	}
+
+	return codes.Internal
+}
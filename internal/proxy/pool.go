@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"go.uber.org/zap"
+)
+
+// timeout Время ожидания ответа сервера
+const timeout = 10 * time.Second
+
+// Backend Сервер метрик за прокси
+type Backend struct {
+	Address string        // Адрес сервера из конфигурации
+	client  *resty.Client // HTTP клиент сервера
+}
+
+// Pool Серверы за прокси и кольцо доступных серверов
+type Pool struct {
+	backends map[string]*Backend // Серверы по адресу
+	ring     *Ring               // Кольцо доступных серверов
+	home     *Ring               // Кольцо всех серверов: серверы серий, когда все серверы доступны
+
+	mu    sync.Mutex
+	moved map[string]string // Адрес сервера последней записи Gauge, записанных не на свой сервер, по арендатору и серии
+}
+
+// NewPool Создание списка серверов addresses (ip:port или URL). До первой проверки все серверы считаются доступными
+func NewPool(addresses []string, replicas int) *Pool {
+	p := &Pool{
+		backends: make(map[string]*Backend, len(addresses)),
+		ring:     NewRing(replicas),
+		home:     NewRing(replicas),
+		moved:    map[string]string{},
+	}
+
+	for _, address := range addresses {
+		baseURL := address
+		if !strings.Contains(baseURL, "://") {
+			baseURL = "http://" + baseURL
+		}
+
+		p.backends[address] = &Backend{
+			Address: address,
+			client:  resty.New().SetBaseURL(strings.TrimRight(baseURL, "/")).SetTimeout(timeout),
+		}
+	}
+
+	p.ring.Set(addresses)
+	p.home.Set(addresses)
+
+	return p
+}
+
+// Owner Доступный сервер, которому принадлежит серия. false - доступных серверов нет
+func (p *Pool) Owner(series string) (*Backend, bool) {
+	address, ok := p.ring.Get(series)
+	if !ok {
+		return nil, false
+	}
+
+	return p.backends[address], true
+}
+
+// Written Отметка записи Gauge арендатора на сервер backend. Серия, записанная не на свой сервер кольца всех серверов,
+// читается с backend, пока следующая запись не вернет ее на свой сервер
+func (p *Pool) Written(tenantName string, series string, backend *Backend) {
+	home, _ := p.home.Get(series)
+	key := movedKey(tenantName, series)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if backend.Address == home {
+		delete(p.moved, key)
+
+		return
+	}
+
+	p.moved[key] = backend.Address
+}
+
+// Holder Доступный сервер с последним значением Gauge арендатора: сервер последней записи перемещенной серии
+// или сервер, которому серия принадлежит. false - доступных серверов нет
+func (p *Pool) Holder(tenantName string, series string) (*Backend, bool) {
+	p.mu.Lock()
+	address, ok := p.moved[movedKey(tenantName, series)]
+	p.mu.Unlock()
+
+	if ok && p.ring.Contains(address) {
+		return p.backends[address], true
+	}
+
+	return p.Owner(series)
+}
+
+// movedKey Ключ перемещенной серии арендатора
+func movedKey(tenantName string, series string) string {
+	return tenantName + "\x00" + series
+}
+
+// Healthy Доступные серверы по возрастанию адреса
+func (p *Pool) Healthy() []*Backend {
+	members := p.ring.Members()
+
+	backends := make([]*Backend, 0, len(members))
+	for _, address := range members {
+		backends = append(backends, p.backends[address])
+	}
+
+	return backends
+}
+
+// Check Проверка готовности всех серверов запросом /readyz и обновление кольца доступных серверов
+func (p *Pool) Check(ctx context.Context) {
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		healthy []string
+	)
+
+	for _, backend := range p.backends {
+		wg.Add(1)
+
+		go func(backend *Backend) {
+			defer wg.Done()
+
+			response, err := backend.client.R().SetContext(ctx).Get("/readyz")
+			if err != nil || response.StatusCode() != http.StatusOK {
+				return
+			}
+
+			mu.Lock()
+			healthy = append(healthy, backend.Address)
+			mu.Unlock()
+		}(backend)
+	}
+
+	wg.Wait()
+
+	previous := p.ring.Members()
+	if p.ring.Set(healthy) {
+		zap.S().Infow("Backend membership changed", "healthy", p.ring.Members(), "previous", previous)
+	}
+}
+
+// Run Проверка серверов каждые interval до отмены ctx. Интервал должен быть больше нуля
+func (p *Pool) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		p.Check(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
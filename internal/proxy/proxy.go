@@ -0,0 +1,441 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-resty/resty/v2"
+	"go.uber.org/zap"
+
+	"github.com/vllvll/devops/internal/batch"
+	"github.com/vllvll/devops/internal/dictionaries"
+	"github.com/vllvll/devops/internal/logger"
+	"github.com/vllvll/devops/internal/middlewares"
+	"github.com/vllvll/devops/internal/repositories"
+	"github.com/vllvll/devops/internal/services"
+	"github.com/vllvll/devops/internal/tenant"
+	"github.com/vllvll/devops/internal/types"
+	"github.com/vllvll/devops/internal/urlparam"
+	"github.com/vllvll/devops/internal/validation"
+)
+
+// ErrNoBackends Нет доступных серверов
+var ErrNoBackends = errors.New("no healthy backends")
+
+// forwardHeaders Заголовки запроса, которые передаются серверам
+var forwardHeaders = []string{"Authorization", "X-Real-IP", tenant.Header, batch.Header, logger.AgentIDHeader, repositories.CounterModeHeader}
+
+type Proxy struct {
+	pool    *Pool            // Серверы за прокси
+	signer  services.Signer  // Сервис для подписи объединенных значений
+	decrypt services.Decrypt // Сервис для расшифрования запросов (nil - без шифрования)
+}
+
+// NewProxy Создание прокси для серверов pool. Серверам запросы передаются без шифрования
+func NewProxy(pool *Pool, signer services.Signer, decrypt services.Decrypt) *Proxy {
+	return &Proxy{
+		pool:    pool,
+		signer:  signer,
+		decrypt: decrypt,
+	}
+}
+
+// NewRouter Роутер прокси с теми же адресами записи и чтения метрик, что у сервера
+func NewRouter(p *Proxy, log *zap.Logger) chi.Router {
+	r := chi.NewRouter()
+
+	r.Use(middleware.RequestID)
+	r.Use(middleware.RealIP)
+	r.Use(middlewares.Logger(log))
+	r.Use(middleware.Recoverer)
+
+	r.Get("/healthz", p.Healthz())
+	r.Get("/readyz", p.Readyz())
+
+	r.Get("/", p.GetAll())
+	r.Post("/value/", p.GetMetricJSON())
+	r.Get("/value/{format:[A-Za-z]+}/{key}", p.GetValue())
+
+	r.Post("/update/{format:[A-Za-z]+}/{key}/{value}", p.SaveMetric())
+	r.Post("/update/", p.SaveMetricJSON())
+	r.Post("/updates/", p.BulkSaveMetricJSON())
+
+	return r
+}
+
+// Healthz Проверка живости прокси
+func (p *Proxy) Healthz() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+		rw.Write([]byte(http.StatusText(http.StatusOK)))
+	}
+}
+
+// Readyz Проверка готовности прокси: есть хотя бы один доступный сервер
+func (p *Proxy) Readyz() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if len(p.pool.Healthy()) == 0 {
+			http.Error(rw, ErrNoBackends.Error(), http.StatusServiceUnavailable)
+
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		rw.Write([]byte(http.StatusText(http.StatusOK)))
+	}
+}
+
+// SaveMetric Запись метрики из адреса запроса на сервер, которому принадлежит серия
+func (p *Proxy) SaveMetric() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		key := urlparam.Key(r)
+
+		backend, ok := p.pool.Owner(key)
+		if !ok {
+			http.Error(rw, ErrNoBackends.Error(), http.StatusServiceUnavailable)
+
+			return
+		}
+
+		header := forwarded(r)
+		response, err := request(backend, header).Post(r.URL.Path)
+		if err == nil && !response.IsError() && chi.URLParam(r, "format") == dictionaries.GaugeType {
+			p.pool.Written(tenantKey(header), key, backend)
+		}
+
+		writeReply(rw, reply{backend: backend, response: response, err: err})
+	}
+}
+
+// SaveMetricJSON Запись метрики в формате JSON на сервер, которому принадлежит серия
+func (p *Proxy) SaveMetricJSON() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		content, ok := p.body(rw, r)
+		if !ok {
+			return
+		}
+
+		var metric types.Metrics
+		if err := json.Unmarshal(content, &metric); err != nil {
+			http.Error(rw, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+			return
+		}
+
+		backend, ok := p.pool.Owner(metric.ID)
+		if !ok {
+			http.Error(rw, ErrNoBackends.Error(), http.StatusServiceUnavailable)
+
+			return
+		}
+
+		header := forwarded(r)
+		response, err := request(backend, header).SetBody(content).Post("/update/")
+		if err == nil && !response.IsError() && metric.MType == dictionaries.GaugeType {
+			p.pool.Written(tenantKey(header), metric.ID, backend)
+		}
+
+		writeReply(rw, reply{backend: backend, response: response, err: err})
+	}
+}
+
+// BulkSaveMetricJSON Запись пакета метрик: каждый сервер получает метрики своих серий одним запросом.
+// Идентификатор пакета передается всем серверам, поэтому повтор пакета не применяется дважды ни на одном из них
+func (p *Proxy) BulkSaveMetricJSON() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		content, ok := p.body(rw, r)
+		if !ok {
+			return
+		}
+
+		var metrics []types.Metrics
+		if err := json.Unmarshal(content, &metrics); err != nil {
+			http.Error(rw, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+			return
+		}
+
+		partial := r.URL.Query().Get(validation.PartialQuery) == "true"
+
+		parts, replies, err := p.push(forwarded(r), partial, metrics)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusServiceUnavailable)
+
+			return
+		}
+
+		for _, reply := range replies {
+			if reply.err != nil || reply.response.IsError() {
+				writeReply(rw, reply)
+
+				return
+			}
+		}
+
+		if duplicate(replies) {
+			rw.Header().Set(batch.DuplicateHeader, "true")
+		}
+
+		if !partial {
+			rw.WriteHeader(http.StatusOK)
+			rw.Write([]byte(http.StatusText(http.StatusOK)))
+
+			return
+		}
+
+		result, err := mergeResults(parts, replies)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadGateway)
+
+			return
+		}
+
+		response, err := json.Marshal(result)
+		if err != nil {
+			http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+
+			return
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(response)
+	}
+}
+
+// part Часть пакета для одного сервера
+type part struct {
+	backend *Backend
+	indexes []int           // Номера метрик части в исходном пакете
+	metrics []types.Metrics // Метрики части
+}
+
+// reply Ответ сервера
+type reply struct {
+	backend  *Backend
+	response *resty.Response
+	err      error // Ошибка соединения с сервером
+}
+
+// push Отправка частей пакета серверам, которым принадлежат серии, и отметка записанных Gauge.
+// Ответы возвращаются в порядке частей
+func (p *Proxy) push(header http.Header, partial bool, metrics []types.Metrics) ([]*part, []reply, error) {
+	parts, err := p.split(metrics)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	backends := make([]*Backend, 0, len(parts))
+	for _, part := range parts {
+		backends = append(backends, part.backend)
+	}
+
+	replies := fanOut(backends, func(i int, backend *Backend) (*resty.Response, error) {
+		req := request(backend, header).SetBody(parts[i].metrics)
+		if partial {
+			req.SetQueryParam(validation.PartialQuery, "true")
+		}
+
+		return req.Post("/updates/")
+	})
+
+	for i, reply := range replies {
+		if reply.err != nil || reply.response.IsError() {
+			continue
+		}
+
+		for _, metric := range parts[i].metrics {
+			if metric.MType == dictionaries.GaugeType {
+				p.pool.Written(tenantKey(header), metric.ID, reply.backend)
+			}
+		}
+	}
+
+	return parts, replies, nil
+}
+
+// split Распределение метрик пакета по серверам. Пустой пакет отправляется одному серверу,
+// чтобы ответ совпадал с ответом сервера
+func (p *Proxy) split(metrics []types.Metrics) ([]*part, error) {
+	if len(metrics) == 0 {
+		backend, ok := p.pool.Owner("")
+		if !ok {
+			return nil, ErrNoBackends
+		}
+
+		return []*part{{backend: backend, metrics: metrics}}, nil
+	}
+
+	byBackend := map[*Backend]*part{}
+	var parts []*part
+
+	for i, metric := range metrics {
+		backend, ok := p.pool.Owner(metric.ID)
+		if !ok {
+			return nil, ErrNoBackends
+		}
+
+		current, ok := byBackend[backend]
+		if !ok {
+			current = &part{backend: backend}
+			byBackend[backend] = current
+			parts = append(parts, current)
+		}
+
+		current.indexes = append(current.indexes, i)
+		current.metrics = append(current.metrics, metric)
+	}
+
+	return parts, nil
+}
+
+// mergeResults Объединение результатов частичной записи частей пакета с номерами метрик исходного пакета
+func mergeResults(parts []*part, replies []reply) (*validation.Result, error) {
+	merged := &validation.Result{Rejected: []validation.Violation{}, Duplicate: true}
+
+	for i, reply := range replies {
+		var result validation.Result
+		if err := json.Unmarshal(reply.response.Body(), &result); err != nil {
+			return nil, fmt.Errorf("invalid response of %s: %w", reply.backend.Address, err)
+		}
+
+		merged.Accepted += result.Accepted
+		merged.Duplicate = merged.Duplicate && result.Duplicate
+
+		for _, violation := range result.Rejected {
+			if violation.Index >= 0 && violation.Index < len(parts[i].indexes) {
+				violation.Index = parts[i].indexes[violation.Index]
+			}
+
+			merged.Rejected = append(merged.Rejected, violation)
+		}
+	}
+
+	sort.SliceStable(merged.Rejected, func(i, j int) bool { return merged.Rejected[i].Index < merged.Rejected[j].Index })
+
+	return merged, nil
+}
+
+// duplicate Пакет уже был применен всеми серверами
+func duplicate(replies []reply) bool {
+	for _, reply := range replies {
+		if reply.response.Header().Get(batch.DuplicateHeader) != "true" {
+			return false
+		}
+	}
+
+	return len(replies) > 0
+}
+
+// fanOut Параллельная отправка запросов серверам. Ответы возвращаются в порядке backends
+func fanOut(backends []*Backend, send func(i int, backend *Backend) (*resty.Response, error)) []reply {
+	replies := make([]reply, len(backends))
+
+	var wg sync.WaitGroup
+	for i, backend := range backends {
+		wg.Add(1)
+
+		go func(i int, backend *Backend) {
+			defer wg.Done()
+
+			response, err := send(i, backend)
+			replies[i] = reply{backend: backend, response: response, err: err}
+		}(i, backend)
+	}
+
+	wg.Wait()
+
+	return replies
+}
+
+// body Тело запроса, расшифрованное при включенном шифровании. false - ответ с ошибкой уже отправлен
+func (p *Proxy) body(rw http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	content, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(rw, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+		return nil, false
+	}
+
+	if p.decrypt != nil {
+		content, err = p.decrypt.Decrypt(content)
+		if err != nil {
+			http.Error(rw, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+			return nil, false
+		}
+	}
+
+	return content, true
+}
+
+// forwarded Заголовки запроса для серверов. Без X-Real-IP передается адрес клиента прокси
+func forwarded(r *http.Request) http.Header {
+	header := http.Header{}
+	for _, key := range forwardHeaders {
+		if value := r.Header.Get(key); value != "" {
+			header.Set(key, value)
+		}
+	}
+
+	if header.Get("X-Real-IP") == "" {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+
+		header.Set("X-Real-IP", host)
+	}
+
+	return header
+}
+
+// tenantKey Арендатор запроса для отметок перемещенных серий: заголовок арендатора, а без него - токен,
+// к которому арендатор может быть привязан
+func tenantKey(header http.Header) string {
+	if name := header.Get(tenant.Header); name != "" {
+		return name
+	}
+
+	if token := header.Get("Authorization"); token != "" {
+		return "token " + token
+	}
+
+	return ""
+}
+
+// request Запрос к серверу с заголовками header
+func request(backend *Backend, header http.Header) *resty.Request {
+	req := backend.client.R().SetHeader("Content-Type", "application/json")
+	for key := range header {
+		req.SetHeader(key, header.Get(key))
+	}
+
+	return req
+}
+
+// writeReply Передача ответа сервера клиенту. Ошибка соединения с сервером - 502
+func writeReply(rw http.ResponseWriter, reply reply) {
+	if reply.err != nil {
+		http.Error(rw, fmt.Sprintf("%s: %v", reply.backend.Address, reply.err), http.StatusBadGateway)
+
+		return
+	}
+
+	for _, key := range []string{"Content-Type", batch.DuplicateHeader} {
+		if value := reply.response.Header().Get(key); value != "" {
+			rw.Header().Set(key, value)
+		}
+	}
+
+	rw.WriteHeader(reply.response.StatusCode())
+	rw.Write(reply.response.Body())
+}
@@ -0,0 +1,435 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/vllvll/devops/internal/auth"
+	"github.com/vllvll/devops/internal/batch"
+	"github.com/vllvll/devops/internal/dictionaries"
+	"github.com/vllvll/devops/internal/handlers"
+	"github.com/vllvll/devops/internal/middlewares"
+	"github.com/vllvll/devops/internal/repositories"
+	"github.com/vllvll/devops/internal/services"
+	"github.com/vllvll/devops/internal/tenant"
+	"github.com/vllvll/devops/internal/types"
+	"github.com/vllvll/devops/internal/validation"
+	pb "github.com/vllvll/devops/proto"
+)
+
+// testBackend Сервер метрик за прокси
+type testBackend struct {
+	address string
+	tenants repositories.TenantRepository
+	down    int32 // 1 - /readyz отвечает 503
+	failing int32 // 1 - запросы метрик отвечают 500
+}
+
+// testSecret Секрет токена администратора, который принимают все тестовые серверы и который может выбрать арендатора
+const testSecret = "proxy-test-secret"
+
+func newTestBackend(t *testing.T) *testBackend {
+	backend := &testBackend{tenants: repositories.NewTenantMemoryRepository()}
+	handler := handlers.NewHandler(backend.tenants, services.NewMetricSigner(""), nil, nil, handlers.HandlerOptions{Batches: batch.NewMemoryStore(time.Minute, 0)})
+
+	store := auth.NewFileStore(filepath.Join(t.TempDir(), "tokens.json"))
+	require.NoError(t, store.Save(auth.Token{ID: "proxy", Hash: auth.HashSecret(testSecret), Scopes: []string{auth.ScopeAdmin}}))
+
+	r := chi.NewRouter()
+	r.Get("/readyz", func(rw http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&backend.down) == 1 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				if atomic.LoadInt32(&backend.failing) == 1 {
+					http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+
+					return
+				}
+
+				next.ServeHTTP(rw, r)
+			})
+		})
+		r.Use(middlewares.Authenticate(store), middlewares.Tenant)
+		r.Get("/", handler.GetAll())
+		r.Post("/value/", handler.GetMetricJSON())
+		r.Post("/update/", handler.SaveMetricJSON())
+		r.Post("/update/{format:[A-Za-z]+}/{key:[A-Za-z0-9]+}/{value:[A-Za-z0-9.]+}", handler.SaveMetric())
+		r.Post("/updates/", handler.BulkSaveMetricJSON())
+	})
+
+	ts := httptest.NewServer(r)
+	t.Cleanup(ts.Close)
+	backend.address = ts.URL
+
+	return backend
+}
+
+// newTestProxy Прокси для трех серверов
+func newTestProxy(t *testing.T) (*resty.Client, *Pool, []*testBackend) {
+	backends := []*testBackend{newTestBackend(t), newTestBackend(t), newTestBackend(t)}
+
+	addresses := make([]string, 0, len(backends))
+	for _, backend := range backends {
+		addresses = append(addresses, backend.address)
+	}
+
+	pool := NewPool(addresses, 0)
+	ts := httptest.NewServer(NewRouter(NewProxy(pool, services.NewMetricSigner(""), nil), zap.NewNop()))
+	t.Cleanup(ts.Close)
+
+	return resty.New().SetBaseURL(ts.URL).SetAuthToken(testSecret), pool, backends
+}
+
+// series Серии, сохраненные на сервере для арендатора
+func (b *testBackend) series(tenantName string) int {
+	gauges, counters := b.tenants.Tenant(tenantName).GetAll()
+
+	return len(gauges) + len(counters)
+}
+
+func TestProxy_BulkSaveMetricJSON(t *testing.T) {
+	client, pool, backends := newTestProxy(t)
+
+	metrics := make([]types.Metrics, 0, 60)
+	for i := 0; i < 30; i++ {
+		value, delta := float64(i), int64(i)
+		metrics = append(metrics,
+			types.Metrics{ID: fmt.Sprintf("Gauge%d", i), MType: dictionaries.GaugeType, Value: &value},
+			types.Metrics{ID: fmt.Sprintf("Counter%d", i), MType: dictionaries.CounterType, Delta: &delta},
+		)
+	}
+
+	response, err := client.R().
+		SetHeader(tenant.Header, "team-a").
+		SetHeader(batch.Header, "batch-1").
+		SetBody(metrics).
+		Post("/updates/")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode(), string(response.Body()))
+
+	// Каждая серия сохранена только на сервере, которому она принадлежит
+	total := 0
+	for _, backend := range backends {
+		gauges, counters := backend.tenants.Tenant("team-a").GetAll()
+		for key := range gauges {
+			owner, _ := pool.Owner(key)
+			assert.Equal(t, backend.address, owner.Address, key)
+		}
+		for key := range counters {
+			owner, _ := pool.Owner(key)
+			assert.Equal(t, backend.address, owner.Address, key)
+		}
+
+		assert.NotZero(t, len(gauges)+len(counters), "series are spread across backends")
+		total += len(gauges) + len(counters)
+	}
+	assert.Equal(t, 60, total)
+
+	// Повтор пакета не применяется ни одним сервером
+	response, err = client.R().
+		SetHeader(tenant.Header, "team-a").
+		SetHeader(batch.Header, "batch-1").
+		SetBody(metrics).
+		Post("/updates/")
+	require.NoError(t, err)
+	assert.Equal(t, "true", response.Header().Get(batch.DuplicateHeader))
+
+	// Чтение объединяет метрики всех серверов
+	var all []types.Metrics
+	response, err = client.R().
+		SetHeader(tenant.Header, "team-a").
+		SetHeader("Accept", "application/json").
+		SetResult(&all).
+		Get("/")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode())
+	require.Len(t, all, 60)
+	assert.Equal(t, "Counter9", all[len(all)-1].ID)
+	assert.Equal(t, int64(9), *all[len(all)-1].Delta)
+
+	response, err = client.R().SetHeader(tenant.Header, "team-a").Get("/value/counter/Counter7")
+	require.NoError(t, err)
+	assert.Equal(t, "7", string(response.Body()))
+
+	response, err = client.R().SetHeader(tenant.Header, "team-a").Get("/value/gauge/Unknown")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, response.StatusCode())
+
+	// Метрики других арендаторов не видны
+	response, err = client.R().SetHeader("Accept", "text/plain").Get("/")
+	require.NoError(t, err)
+	assert.Equal(t, "Gauges:\nCounters:\n", string(response.Body()))
+}
+
+func TestProxy_BulkSaveMetricJSONPartial(t *testing.T) {
+	client, _, _ := newTestProxy(t)
+
+	metrics := make([]types.Metrics, 0, 20)
+	for i := 0; i < 20; i++ {
+		value := float64(i)
+		metric := types.Metrics{ID: fmt.Sprintf("Gauge%d", i), MType: dictionaries.GaugeType, Value: &value}
+		if i%5 == 0 {
+			metric.Value = nil
+		}
+
+		metrics = append(metrics, metric)
+	}
+
+	var result validation.Result
+	response, err := client.R().
+		SetQueryParam(validation.PartialQuery, "true").
+		SetBody(metrics).
+		SetResult(&result).
+		Post("/updates/")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode(), string(response.Body()))
+
+	// Номера отклоненных метрик соответствуют исходному пакету
+	assert.Equal(t, 16, result.Accepted)
+
+	indexes := make([]int, 0, len(result.Rejected))
+	for _, violation := range result.Rejected {
+		indexes = append(indexes, violation.Index)
+		assert.Equal(t, fmt.Sprintf("Gauge%d", violation.Index), violation.ID)
+	}
+	assert.Equal(t, []int{0, 5, 10, 15}, indexes)
+}
+
+func TestProxy_Rebalance(t *testing.T) {
+	client, pool, backends := newTestProxy(t)
+
+	owner, _ := pool.Owner("PollCount")
+	var down *testBackend
+	for _, backend := range backends {
+		if backend.address == owner.Address {
+			down = backend
+		}
+	}
+
+	response, err := client.R().Post("/update/counter/PollCount/5")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode())
+	assert.Equal(t, 1, down.series(""))
+
+	// Недоступный сервер исключается из кольца, его серии записываются на другой сервер
+	atomic.StoreInt32(&down.down, 1)
+	pool.Check(context.Background())
+	assert.Len(t, pool.Healthy(), 2)
+
+	delta := int64(3)
+	response, err = client.R().SetBody(types.Metrics{ID: "PollCount", MType: dictionaries.CounterType, Delta: &delta}).Post("/update/")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode())
+
+	moved, _ := pool.Owner("PollCount")
+	assert.NotEqual(t, owner.Address, moved.Address)
+
+	// После восстановления значения Counter с обоих серверов суммируются
+	atomic.StoreInt32(&down.down, 0)
+	pool.Check(context.Background())
+
+	var metric types.Metrics
+	response, err = client.R().
+		SetBody(types.Metrics{ID: "PollCount", MType: dictionaries.CounterType}).
+		SetResult(&metric).
+		Post("/value/")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode())
+	assert.Equal(t, int64(8), *metric.Delta)
+}
+
+func TestProxy_MovedGauge(t *testing.T) {
+	client, pool, backends := newTestProxy(t)
+
+	owner, _ := pool.Owner("Alloc")
+	var down *testBackend
+	for _, backend := range backends {
+		if backend.address == owner.Address {
+			down = backend
+		}
+	}
+
+	gauge := func(value string) string {
+		response, err := client.R().Post("/update/gauge/Alloc/" + value)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, response.StatusCode())
+
+		response, err = client.R().Get("/value/gauge/Alloc")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, response.StatusCode())
+
+		return string(response.Body())
+	}
+
+	assert.Equal(t, "1.000", gauge("1"))
+
+	atomic.StoreInt32(&down.down, 1)
+	pool.Check(context.Background())
+	assert.Equal(t, "2.000", gauge("2"))
+
+	// После восстановления сервера серии значение читается с сервера последней записи, а не устаревшее
+	atomic.StoreInt32(&down.down, 0)
+	pool.Check(context.Background())
+
+	response, err := client.R().Get("/value/gauge/Alloc")
+	require.NoError(t, err)
+	assert.Equal(t, "2.000", string(response.Body()))
+
+	var all []types.Metrics
+	_, err = client.R().SetHeader("Accept", "application/json").SetResult(&all).Get("/")
+	require.NoError(t, err)
+	require.Len(t, all, 1)
+	assert.Equal(t, 2.0, *all[0].Value)
+
+	// Следующая запись возвращает серию на ее сервер
+	assert.Equal(t, "3.000", gauge("3"))
+	assert.Empty(t, pool.moved)
+}
+
+func TestProxy_PartialRead(t *testing.T) {
+	client, _, backends := newTestProxy(t)
+
+	metrics := make([]types.Metrics, 0, 30)
+	for i := 0; i < 30; i++ {
+		delta := int64(i)
+		metrics = append(metrics, types.Metrics{ID: fmt.Sprintf("Counter%d", i), MType: dictionaries.CounterType, Delta: &delta})
+	}
+
+	response, err := client.R().SetBody(metrics).Post("/updates/")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode())
+
+	// Сервер отвечает ошибкой, но еще не исключен из кольца
+	failing := backends[0]
+	atomic.StoreInt32(&failing.failing, 1)
+
+	var all []types.Metrics
+	response, err = client.R().SetHeader("Accept", "application/json").SetResult(&all).Get("/")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode())
+	assert.Len(t, all, 30-failing.series(""))
+	assert.Contains(t, response.Header().Get("Warning"), failing.address)
+
+	// Серия на ответившем сервере читается с предупреждением, серия на сервере с ошибкой - 502
+	gauges, counters := backends[1].tenants.Tenant("").GetAll()
+	require.Empty(t, gauges)
+	for key := range counters {
+		response, err = client.R().Get("/value/counter/" + key)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.StatusCode())
+		assert.Contains(t, response.Header().Get("Warning"), failing.address)
+	}
+
+	_, counters = failing.tenants.Tenant("").GetAll()
+	for key := range counters {
+		response, err = client.R().Get("/value/counter/" + key)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadGateway, response.StatusCode())
+	}
+
+	// Без ответов всех серверов чтение завершается ошибкой
+	for _, backend := range backends {
+		atomic.StoreInt32(&backend.failing, 1)
+	}
+
+	response, err = client.R().Get("/")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadGateway, response.StatusCode())
+}
+
+func TestProxy_NoBackends(t *testing.T) {
+	client, pool, backends := newTestProxy(t)
+
+	for _, backend := range backends {
+		atomic.StoreInt32(&backend.down, 1)
+	}
+	pool.Check(context.Background())
+
+	response, err := client.R().Get("/readyz")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, response.StatusCode())
+
+	response, err = client.R().SetBody([]types.Metrics{}).Post("/updates/")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, response.StatusCode())
+}
+
+func TestGRPCServer_BulkSaveMetrics(t *testing.T) {
+	backends := []*testBackend{newTestBackend(t), newTestBackend(t)}
+	pool := NewPool([]string{backends[0].address, backends[1].address}, 0)
+	server := NewGRPCServer(NewProxy(pool, services.NewMetricSigner(""), nil))
+
+	bulk := make([]*pb.Metric, 0, 20)
+	for i := 0; i < 20; i++ {
+		delta := int64(i)
+		bulk = append(bulk, &pb.Metric{Id: fmt.Sprintf("Counter%d", i), Type: pb.Metric_COUNTER, Delta: &delta})
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(tenant.MetadataKey, "team-a", "authorization", "Bearer "+testSecret))
+	_, err := server.BulkSaveMetrics(ctx, &pb.AddBulkMetricsRequest{Metrics: &pb.BulkMetrics{Metrics: bulk}})
+	require.NoError(t, err)
+
+	assert.Equal(t, 20, backends[0].series("team-a")+backends[1].series("team-a"))
+	assert.NotZero(t, backends[0].series("team-a"))
+	assert.NotZero(t, backends[1].series("team-a"))
+
+	// Ошибка сервера передается клиенту с кодом gRPC
+	bulk = append(bulk, &pb.Metric{Id: "Broken", Type: pb.Metric_COUNTER})
+	_, err = server.BulkSaveMetrics(ctx, &pb.AddBulkMetricsRequest{Metrics: &pb.BulkMetrics{Metrics: bulk}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestGRPCServer_BulkSaveMetricsPartial(t *testing.T) {
+	backends := []*testBackend{newTestBackend(t), newTestBackend(t)}
+	pool := NewPool([]string{backends[0].address, backends[1].address}, 0)
+	server := NewGRPCServer(NewProxy(pool, services.NewMetricSigner(""), nil))
+
+	bulk := make([]*pb.Metric, 0, 20)
+	for i := 0; i < 20; i++ {
+		delta := int64(i)
+		metric := &pb.Metric{Id: fmt.Sprintf("Counter%d", i), Type: pb.Metric_COUNTER, Delta: &delta}
+		if i%5 == 0 {
+			metric.Delta = nil
+		}
+
+		bulk = append(bulk, metric)
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+testSecret))
+	response, err := server.BulkSaveMetricsPartial(ctx, &pb.AddBulkMetricsRequest{Metrics: &pb.BulkMetrics{Metrics: bulk}, BatchId: "batch-1"})
+	require.NoError(t, err)
+
+	// Номера отклоненных метрик соответствуют исходному пакету
+	assert.Equal(t, int32(16), response.GetAccepted())
+	assert.False(t, response.GetDuplicate())
+
+	indexes := make([]int32, 0, len(response.GetRejected()))
+	for _, rejected := range response.GetRejected() {
+		indexes = append(indexes, rejected.GetIndex())
+	}
+	assert.Equal(t, []int32{0, 5, 10, 15}, indexes)
+	assert.Equal(t, 16, backends[0].series("")+backends[1].series(""))
+
+	response, err = server.BulkSaveMetricsPartial(ctx, &pb.AddBulkMetricsRequest{Metrics: &pb.BulkMetrics{Metrics: bulk}, BatchId: "batch-1"})
+	require.NoError(t, err)
+	assert.True(t, response.GetDuplicate())
+}
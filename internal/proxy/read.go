@@ -0,0 +1,315 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-resty/resty/v2"
+
+	"github.com/vllvll/devops/internal/dictionaries"
+	"github.com/vllvll/devops/internal/types"
+	"github.com/vllvll/devops/internal/urlparam"
+)
+
+// GetAll Получение метрик всех серверов. JSON при запросе с заголовком Accept: application/json,
+// иначе текст в формате сервера
+func (p *Proxy) GetAll() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		backends := p.pool.Healthy()
+		if len(backends) == 0 {
+			http.Error(rw, ErrNoBackends.Error(), http.StatusServiceUnavailable)
+
+			return
+		}
+
+		header := forwarded(r)
+		replies := fanOut(backends, func(i int, backend *Backend) (*resty.Response, error) {
+			return request(backend, header).SetHeader("Accept", "application/json").Get("/")
+		})
+
+		results := make([][]types.Metrics, len(replies))
+		var warnings []string
+		answered := false
+
+		for i, reply := range replies {
+			if failed(reply) {
+				warnings = append(warnings, warning(reply))
+
+				continue
+			}
+
+			if reply.response.IsError() {
+				writeReply(rw, reply)
+
+				return
+			}
+
+			if err := json.Unmarshal(reply.response.Body(), &results[i]); err != nil {
+				warnings = append(warnings, fmt.Sprintf("%s: invalid response: %v", reply.backend.Address, err))
+
+				continue
+			}
+
+			answered = true
+		}
+
+		if !answered {
+			http.Error(rw, strings.Join(warnings, "; "), http.StatusBadGateway)
+
+			return
+		}
+
+		setWarnings(rw, warnings)
+
+		gauges, counters := p.merge(tenantKey(header), backends, results)
+
+		gaugeKeys := make([]string, 0, len(gauges))
+		for key := range gauges {
+			gaugeKeys = append(gaugeKeys, key)
+		}
+		sort.Strings(gaugeKeys)
+
+		counterKeys := make([]string, 0, len(counters))
+		for key := range counters {
+			counterKeys = append(counterKeys, key)
+		}
+		sort.Strings(counterKeys)
+
+		if strings.Contains(r.Header.Get("Accept"), "application/json") {
+			metrics := make([]types.Metrics, 0, len(gauges)+len(counters))
+
+			for _, key := range gaugeKeys {
+				value := float64(gauges[key])
+				metrics = append(metrics, types.Metrics{ID: key, MType: dictionaries.GaugeType, Value: &value})
+			}
+
+			for _, key := range counterKeys {
+				delta := int64(counters[key])
+				metrics = append(metrics, types.Metrics{ID: key, MType: dictionaries.CounterType, Delta: &delta})
+			}
+
+			response, err := json.Marshal(metrics)
+			if err != nil {
+				http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+
+				return
+			}
+
+			rw.Header().Set("Content-Type", "application/json")
+			rw.WriteHeader(http.StatusOK)
+			rw.Write(response)
+
+			return
+		}
+
+		var answer strings.Builder
+
+		answer.WriteString("Gauges:\n")
+		for _, key := range gaugeKeys {
+			fmt.Fprintf(&answer, "%s - %s\n", key, strconv.FormatFloat(float64(gauges[key]), 'f', 3, 64))
+		}
+
+		answer.WriteString("Counters:\n")
+		for _, key := range counterKeys {
+			fmt.Fprintf(&answer, "%s - %s\n", key, strconv.FormatInt(int64(counters[key]), 10))
+		}
+
+		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		rw.WriteHeader(http.StatusOK)
+		rw.Write([]byte(answer.String()))
+	}
+}
+
+// GetMetricJSON Получение метрики в формате JSON с хешем. Значение объединяется из ответов всех серверов
+func (p *Proxy) GetMetricJSON() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		var metric types.Metrics
+
+		if err := json.NewDecoder(r.Body).Decode(&metric); err != nil {
+			http.Error(rw, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+
+			return
+		}
+
+		metric, warnings, status := p.lookup(r, metric.MType, metric.ID)
+		setWarnings(rw, warnings)
+
+		if status != http.StatusOK {
+			http.Error(rw, http.StatusText(status), status)
+
+			return
+		}
+
+		switch metric.MType {
+		case dictionaries.GaugeType:
+			metric.Hash = p.signer.GetHashGauge(metric.ID, *metric.Value)
+		case dictionaries.CounterType:
+			metric.Hash = p.signer.GetHashCounter(metric.ID, *metric.Delta)
+		}
+
+		response, err := json.Marshal(metric)
+		if err != nil {
+			http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+
+			return
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(response)
+	}
+}
+
+// GetValue Получение значения метрики по типу и ключу в текстовом формате сервера
+func (p *Proxy) GetValue() http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		metric, warnings, status := p.lookup(r, chi.URLParam(r, "format"), urlparam.Key(r))
+		setWarnings(rw, warnings)
+
+		if status != http.StatusOK {
+			http.Error(rw, http.StatusText(status), status)
+
+			return
+		}
+
+		var value string
+		switch metric.MType {
+		case dictionaries.GaugeType:
+			value = strconv.FormatFloat(*metric.Value, 'f', 3, 64)
+		case dictionaries.CounterType:
+			value = strconv.FormatInt(*metric.Delta, 10)
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		rw.Write([]byte(value))
+	}
+}
+
+// lookup Получение метрики со всех серверов и объединение значений. Серверы, которые не ответили, пропускаются
+// и перечисляются в предупреждениях. Код ответа отличается от 200, если метрика не найдена ни на одном ответившем
+// сервере, ни один сервер не ответил или сервер отклонил запрос
+func (p *Proxy) lookup(r *http.Request, mType string, id string) (types.Metrics, []string, int) {
+	metric := types.Metrics{ID: id, MType: mType}
+	if mType != dictionaries.GaugeType && mType != dictionaries.CounterType {
+		return metric, nil, http.StatusNotFound
+	}
+
+	backends := p.pool.Healthy()
+	if len(backends) == 0 {
+		return metric, nil, http.StatusServiceUnavailable
+	}
+
+	header := forwarded(r)
+	replies := fanOut(backends, func(i int, backend *Backend) (*resty.Response, error) {
+		return request(backend, header).SetBody(types.Metrics{ID: id, MType: mType}).Post("/value/")
+	})
+
+	results := make([][]types.Metrics, len(replies))
+	var warnings []string
+	found := false
+
+	for i, reply := range replies {
+		if failed(reply) {
+			warnings = append(warnings, warning(reply))
+
+			continue
+		}
+
+		if reply.response.StatusCode() == http.StatusNotFound {
+			continue
+		}
+
+		if reply.response.IsError() {
+			return metric, warnings, reply.response.StatusCode()
+		}
+
+		var value types.Metrics
+		if err := json.Unmarshal(reply.response.Body(), &value); err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: invalid response: %v", reply.backend.Address, err))
+
+			continue
+		}
+
+		results[i] = []types.Metrics{value}
+		found = true
+	}
+
+	// Метрика может быть на сервере, который не ответил
+	if !found && len(warnings) > 0 {
+		return metric, warnings, http.StatusBadGateway
+	}
+
+	if !found {
+		return metric, warnings, http.StatusNotFound
+	}
+
+	gauges, counters := p.merge(tenantKey(header), backends, results)
+
+	switch mType {
+	case dictionaries.GaugeType:
+		value := float64(gauges[id])
+		metric.Value = &value
+	case dictionaries.CounterType:
+		delta := int64(counters[id])
+		metric.Delta = &delta
+	}
+
+	return metric, warnings, http.StatusOK
+}
+
+// failed Сервер не ответил на запрос чтения: ошибка соединения или внутренняя ошибка сервера
+func failed(reply reply) bool {
+	return reply.err != nil || reply.response.StatusCode() >= http.StatusInternalServerError
+}
+
+// warning Предупреждение о сервере, который не ответил на запрос чтения
+func warning(reply reply) string {
+	if reply.err != nil {
+		return fmt.Sprintf("%s: %v", reply.backend.Address, reply.err)
+	}
+
+	return fmt.Sprintf("%s: %s", reply.backend.Address, http.StatusText(reply.response.StatusCode()))
+}
+
+// setWarnings Заголовки Warning с кодом 199 для неполного ответа
+func setWarnings(rw http.ResponseWriter, warnings []string) {
+	for _, text := range warnings {
+		rw.Header().Add("Warning", "199 - "+strconv.Quote(text))
+	}
+}
+
+// merge Объединение метрик серверов для арендатора tenantName: значения Counter суммируются, для Gauge используется
+// значение сервера с последним значением серии, а если его нет - значение первого сервера, где серия найдена
+func (p *Proxy) merge(tenantName string, backends []*Backend, results [][]types.Metrics) (types.Gauges, types.Counters) {
+	gauges, counters := types.Gauges{}, types.Counters{}
+	owned := map[string]bool{}
+
+	for i, metrics := range results {
+		for _, metric := range metrics {
+			switch metric.MType {
+			case dictionaries.GaugeType:
+				if metric.Value == nil || owned[metric.ID] {
+					continue
+				}
+
+				owner, _ := p.pool.Holder(tenantName, metric.ID)
+				if _, ok := gauges[metric.ID]; !ok || owner == backends[i] {
+					gauges[metric.ID] = types.Gauge(*metric.Value)
+				}
+
+				owned[metric.ID] = owner == backends[i]
+			case dictionaries.CounterType:
+				if metric.Delta != nil {
+					counters[metric.ID] += types.Counter(*metric.Delta)
+				}
+			}
+		}
+	}
+
+	return gauges, counters
+}
@@ -0,0 +1,150 @@
+// Package proxy Распределение метрик между несколькими серверами.
+//
+// Прокси принимает те же запросы записи, что и сервер (/update/, /updates/ и gRPC BulkSaveMetrics), и отправляет
+// каждую метрику серверу, который выбирается согласованным хешированием имени серии. Запросы чтения (/ и /value/)
+// отправляются всем доступным серверам, ответы объединяются.
+//
+// Доступность серверов проверяется запросом /readyz. Недоступный сервер исключается из кольца, и его серии
+// записываются на следующие по кольцу серверы; после восстановления серии возвращаются на него. Метрики между
+// серверами не переносятся, поэтому при объединении ответов значения Counter суммируются. Для Gauge прокси
+// запоминает серии, записанные не на свой сервер кольца всех серверов, и читает их значение с сервера последней
+// записи, пока следующая запись не вернет серию на ее сервер. Для остальных Gauge используется значение сервера,
+// которому серия принадлежит сейчас.
+//
+// Если сервер не ответил на запрос чтения, прокси возвращает объединенный ответ остальных серверов с заголовком
+// Warning для каждого такого сервера.
+package proxy
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// DefaultReplicas Количество виртуальных узлов сервера в кольце по умолчанию
+const DefaultReplicas = 100
+
+// Ring Кольцо согласованного хеширования: при изменении состава серверов меняет владельца
+// только у серий, которые принадлежали удаленному серверу или переходят к добавленному
+type Ring struct {
+	replicas int // Количество виртуальных узлов одного сервера
+
+	mu      sync.RWMutex
+	hashes  []uint64          // Хеши виртуальных узлов по возрастанию
+	owners  map[uint64]string // Сервер виртуального узла
+	members []string          // Серверы кольца по возрастанию
+}
+
+// NewRing Создание пустого кольца с replicas виртуальными узлами на сервер.
+// Значение меньше или равное нулю заменяется значением по умолчанию
+func NewRing(replicas int) *Ring {
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+
+	return &Ring{
+		replicas: replicas,
+		owners:   map[uint64]string{},
+	}
+}
+
+// Set Замена состава серверов кольца. false - состав не изменился
+func (r *Ring) Set(members []string) bool {
+	sorted := append([]string(nil), members...)
+	sort.Strings(sorted)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if equalStrings(r.members, sorted) {
+		return false
+	}
+
+	r.members = sorted
+	r.hashes = make([]uint64, 0, len(sorted)*r.replicas)
+	r.owners = make(map[uint64]string, len(sorted)*r.replicas)
+
+	for _, member := range sorted {
+		for i := 0; i < r.replicas; i++ {
+			hash := hashKey(member + "#" + strconv.Itoa(i))
+			if _, ok := r.owners[hash]; ok {
+				continue
+			}
+
+			r.owners[hash] = member
+			r.hashes = append(r.hashes, hash)
+		}
+	}
+
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+
+	return true
+}
+
+// Get Сервер, которому принадлежит ключ. false - кольцо пустое
+func (r *Ring) Get(key string) (string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.hashes) == 0 {
+		return "", false
+	}
+
+	hash := hashKey(key)
+
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= hash })
+	if i == len(r.hashes) {
+		i = 0
+	}
+
+	return r.owners[r.hashes[i]], true
+}
+
+// Members Серверы кольца по возрастанию
+func (r *Ring) Members() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return append([]string(nil), r.members...)
+}
+
+// Contains Сервер входит в кольцо
+func (r *Ring) Contains(member string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	i := sort.SearchStrings(r.members, member)
+
+	return i < len(r.members) && r.members[i] == member
+}
+
+// hashKey Хеш ключа: FNV-1a с перемешиванием битов, чтобы близкие ключи равномерно распределялись по кольцу
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	hash := h.Sum64()
+
+	hash ^= hash >> 33
+	hash *= 0xff51afd7ed558ccd
+	hash ^= hash >> 33
+	hash *= 0xc4ceb9fe1a85ec53
+	hash ^= hash >> 33
+
+	return hash
+}
+
+// equalStrings Совпадение отсортированных списков
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// owners Владельцы ключей key-0..key-(n-1)
+func owners(r *Ring, n int) map[string]string {
+	result := make(map[string]string, n)
+	for i := 0; i < n; i++ {
+		key := "key-" + strconv.Itoa(i)
+		result[key], _ = r.Get(key)
+	}
+
+	return result
+}
+
+func TestRing(t *testing.T) {
+	ring := NewRing(0)
+
+	_, ok := ring.Get("Alloc")
+	assert.False(t, ok)
+
+	assert.True(t, ring.Set([]string{"c", "a", "b"}))
+	assert.False(t, ring.Set([]string{"a", "b", "c"}), "membership did not change")
+	assert.Equal(t, []string{"a", "b", "c"}, ring.Members())
+
+	before := owners(ring, 3000)
+
+	counts := map[string]int{}
+	for _, owner := range before {
+		counts[owner]++
+	}
+
+	// Ключи распределяются между серверами примерно поровну
+	require.Len(t, counts, 3)
+	for member, count := range counts {
+		assert.InDelta(t, 1000, count, 300, member)
+	}
+
+	// При добавлении сервера владелец меняется только у ключей, которые переходят к нему
+	ring.Set([]string{"a", "b", "c", "d"})
+	moved := 0
+	for key, owner := range owners(ring, 3000) {
+		if owner != before[key] {
+			assert.Equal(t, "d", owner)
+			moved++
+		}
+	}
+	assert.InDelta(t, 750, moved, 250)
+
+	// При удалении сервера владелец меняется только у его ключей
+	ring.Set([]string{"a", "c"})
+	for key, owner := range owners(ring, 3000) {
+		if before[key] != "b" {
+			assert.Equal(t, before[key], owner)
+		}
+	}
+}
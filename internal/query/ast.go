@@ -0,0 +1,112 @@
+package query
+
+import (
+	"regexp"
+	"time"
+)
+
+// Функции агрегации
+const (
+	AggregateSum   = "sum"
+	AggregateAvg   = "avg"
+	AggregateMin   = "min"
+	AggregateMax   = "max"
+	AggregateCount = "count"
+)
+
+// Функции над счетчиками
+const (
+	FunctionRate     = "rate"
+	FunctionIncrease = "increase"
+)
+
+// Арифметические операции
+const (
+	OpAdd = "+"
+	OpSub = "-"
+	OpMul = "*"
+	OpDiv = "/"
+)
+
+// NameLabel Метка, по которой сравнивается имя метрики
+const NameLabel = "__name__"
+
+// Типы сравнения меток
+const (
+	MatchEqual     = "="
+	MatchNotEqual  = "!="
+	MatchRegexp    = "=~"
+	MatchNotRegexp = "!~"
+)
+
+// Expr Выражение языка запросов
+type Expr interface {
+	expr()
+}
+
+// Matcher Условие на значение метки
+type Matcher struct {
+	Label string
+	Type  string
+	Value string
+	re    *regexp.Regexp
+}
+
+// Matches Проверка значения метки
+func (m Matcher) Matches(value string) bool {
+	switch m.Type {
+	case MatchEqual:
+		return value == m.Value
+	case MatchNotEqual:
+		return value != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(value)
+	case MatchNotRegexp:
+		return !m.re.MatchString(value)
+	}
+
+	return false
+}
+
+// Selector Выбор серий по имени и меткам
+type Selector struct {
+	Matchers []Matcher
+}
+
+// RangeSelector Выбор значений серий за период
+type RangeSelector struct {
+	Selector *Selector
+	Range    time.Duration
+}
+
+// Call Вызов функции над счетчиками: rate, increase
+type Call struct {
+	Func string
+	Arg  *RangeSelector
+}
+
+// Aggregate Агрегация: sum, avg, min, max, count с группировкой по меткам
+type Aggregate struct {
+	Op       string
+	Grouping []string
+	Arg      Expr
+}
+
+// NumberLiteral Числовая константа
+type NumberLiteral struct {
+	Value float64
+}
+
+// BinaryExpr Арифметическая операция над сериями или константами: +, -, *, /
+type BinaryExpr struct {
+	Op  string
+	LHS Expr
+	RHS Expr
+}
+
+func (*Selector) expr()      {}
+func (*RangeSelector) expr() {}
+func (*Call) expr()          {}
+func (*Aggregate) expr()     {}
+func (*NumberLiteral) expr() {}
+func (*BinaryExpr) expr()    {}
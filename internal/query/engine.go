@@ -0,0 +1,397 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vllvll/devops/internal/dictionaries"
+	"github.com/vllvll/devops/internal/repositories"
+	"github.com/vllvll/devops/internal/types"
+)
+
+// Series Результат вычисления для одной серии
+type Series struct {
+	Metric map[string]string `json:"metric"`         // Имя (__name__) и метки серии
+	Type   string            `json:"type,omitempty"` // Тип исходной метрики: gauge или counter
+	Value  float64           `json:"value"`          // Значение
+}
+
+// MarshalJSON Сохранение серии в JSON. JSON не поддерживает NaN и ±Inf, поэтому такие значения
+// записываются строками "NaN", "+Inf" и "-Inf", как в Prometheus
+func (s Series) MarshalJSON() ([]byte, error) {
+	type series Series
+
+	if !math.IsNaN(s.Value) && !math.IsInf(s.Value, 0) {
+		return json.Marshal(series(s))
+	}
+
+	return json.Marshal(struct {
+		Metric map[string]string `json:"metric"`
+		Type   string            `json:"type,omitempty"`
+		Value  string            `json:"value"`
+	}{
+		Metric: s.Metric,
+		Type:   s.Type,
+		Value:  strconv.FormatFloat(s.Value, 'f', -1, 64),
+	})
+}
+
+type Engine struct {
+	repository repositories.StatsRepository // Сервис для чтения данных метрик
+	history    repositories.HistoryRepository
+	now        func() time.Time
+}
+
+// NewEngine Создание сервиса для вычисления запросов по метрикам из репозитория
+func NewEngine(repository repositories.StatsRepository, history repositories.HistoryRepository) *Engine {
+	return &Engine{
+		repository: repository,
+		history:    history,
+		now:        time.Now,
+	}
+}
+
+// Query Разбор и вычисление запроса
+func (e *Engine) Query(input string) ([]Series, error) {
+	expr, err := Parse(input)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.Eval(expr)
+}
+
+// Eval Вычисление выражения. Серии результата отсортированы по меткам
+func (e *Engine) Eval(expr Expr) ([]Series, error) {
+	result, err := e.eval(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return seriesKey(result[i].Metric) < seriesKey(result[j].Metric)
+	})
+
+	return result, nil
+}
+
+func (e *Engine) eval(expr Expr) ([]Series, error) {
+	switch expr := expr.(type) {
+	case *Selector:
+		return e.selectSeries(expr), nil
+	case *Call:
+		return e.evalCall(expr)
+	case *Aggregate:
+		return e.evalAggregate(expr)
+	case *BinaryExpr:
+		return e.evalBinary(expr)
+	case *NumberLiteral:
+		return []Series{{Metric: map[string]string{}, Value: expr.Value}}, nil
+	case *RangeSelector:
+		return nil, fmt.Errorf("range selector is allowed only inside rate() or increase()")
+	}
+
+	return nil, fmt.Errorf("unsupported expression %T", expr)
+}
+
+// selectSeries Выбор текущих значений серий, подходящих под условия
+func (e *Engine) selectSeries(selector *Selector) []Series {
+	var result []Series
+
+	gauges, counters := e.repository.GetAll()
+
+	for key, value := range gauges {
+		if metric, ok := match(selector, key); ok {
+			result = append(result, Series{Metric: metric, Type: dictionaries.GaugeType, Value: float64(value)})
+		}
+	}
+
+	for key, value := range counters {
+		if metric, ok := match(selector, key); ok {
+			result = append(result, Series{Metric: metric, Type: dictionaries.CounterType, Value: float64(value)})
+		}
+	}
+
+	return result
+}
+
+// evalCall Вычисление rate() и increase() по истории значений счетчиков
+func (e *Engine) evalCall(call *Call) ([]Series, error) {
+	if e.history == nil {
+		return nil, fmt.Errorf("%s() requires history to be enabled", call.Func)
+	}
+
+	var result []Series
+
+	to := e.now()
+	from := to.Add(-call.Arg.Range)
+
+	for _, series := range e.selectSeries(call.Arg.Selector) {
+		if series.Type != dictionaries.CounterType {
+			continue
+		}
+
+		key := types.SeriesName(series.Metric[NameLabel], withoutName(series.Metric))
+		samples := append(
+			e.history.Range(dictionaries.CounterType, key, from, to),
+			repositories.Sample{Time: to, Value: series.Value},
+		)
+
+		increase := counterIncrease(samples)
+
+		value := increase
+		if call.Func == FunctionRate {
+			value = increase / call.Arg.Range.Seconds()
+		}
+
+		delete(series.Metric, NameLabel)
+		result = append(result, Series{Metric: series.Metric, Value: value})
+	}
+
+	return result, nil
+}
+
+// evalAggregate Вычисление функции агрегации с группировкой по меткам
+func (e *Engine) evalAggregate(aggregate *Aggregate) ([]Series, error) {
+	input, err := e.eval(aggregate.Arg)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := map[string][]Series{}
+	labels := map[string]map[string]string{}
+
+	for _, series := range input {
+		group := map[string]string{}
+		for _, label := range aggregate.Grouping {
+			if value, ok := series.Metric[label]; ok {
+				group[label] = value
+			}
+		}
+
+		key := seriesKey(group)
+		groups[key] = append(groups[key], series)
+		labels[key] = group
+	}
+
+	result := make([]Series, 0, len(groups))
+	for key, series := range groups {
+		result = append(result, Series{Metric: labels[key], Value: aggregateValues(aggregate.Op, series)})
+	}
+
+	return result, nil
+}
+
+// evalBinary Вычисление арифметической операции. Константа применяется к каждой серии, серии двух
+// выражений сопоставляются один к одному по меткам без __name__. Имя метрики в результате не сохраняется
+func (e *Engine) evalBinary(binary *BinaryExpr) ([]Series, error) {
+	lhsScalar, lhsIsScalar := scalar(binary.LHS)
+	rhsScalar, rhsIsScalar := scalar(binary.RHS)
+
+	if lhsIsScalar && rhsIsScalar {
+		return []Series{{Metric: map[string]string{}, Value: arithmetic(binary.Op, lhsScalar, rhsScalar)}}, nil
+	}
+
+	var lhs, rhs []Series
+	var err error
+
+	if !lhsIsScalar {
+		if lhs, err = e.eval(binary.LHS); err != nil {
+			return nil, err
+		}
+	}
+
+	if !rhsIsScalar {
+		if rhs, err = e.eval(binary.RHS); err != nil {
+			return nil, err
+		}
+	}
+
+	if rhsIsScalar {
+		result := make([]Series, 0, len(lhs))
+		for _, series := range lhs {
+			result = append(result, Series{Metric: withoutName(series.Metric), Value: arithmetic(binary.Op, series.Value, rhsScalar)})
+		}
+
+		return result, nil
+	}
+
+	if lhsIsScalar {
+		result := make([]Series, 0, len(rhs))
+		for _, series := range rhs {
+			result = append(result, Series{Metric: withoutName(series.Metric), Value: arithmetic(binary.Op, lhsScalar, series.Value)})
+		}
+
+		return result, nil
+	}
+
+	right := make(map[string]Series, len(rhs))
+	for _, series := range rhs {
+		labels := withoutName(series.Metric)
+
+		key := seriesKey(labels)
+		if _, ok := right[key]; ok {
+			return nil, fmt.Errorf("many-to-one matching is not supported: right side has several series with labels %v", labels)
+		}
+
+		right[key] = series
+	}
+
+	matched := map[string]bool{}
+	result := make([]Series, 0, len(lhs))
+
+	for _, series := range lhs {
+		labels := withoutName(series.Metric)
+
+		key := seriesKey(labels)
+		other, ok := right[key]
+		if !ok {
+			continue
+		}
+
+		if matched[key] {
+			return nil, fmt.Errorf("one-to-many matching is not supported: left side has several series with labels %v", labels)
+		}
+		matched[key] = true
+
+		result = append(result, Series{Metric: labels, Value: arithmetic(binary.Op, series.Value, other.Value)})
+	}
+
+	return result, nil
+}
+
+// scalar Значение выражения из одних констант. false - выражение содержит серии
+func scalar(expr Expr) (float64, bool) {
+	switch expr := expr.(type) {
+	case *NumberLiteral:
+		return expr.Value, true
+	case *BinaryExpr:
+		lhs, ok := scalar(expr.LHS)
+		if !ok {
+			return 0, false
+		}
+
+		rhs, ok := scalar(expr.RHS)
+		if !ok {
+			return 0, false
+		}
+
+		return arithmetic(expr.Op, lhs, rhs), true
+	}
+
+	return 0, false
+}
+
+// arithmetic Применение арифметической операции. Деление на ноль дает бесконечность или NaN
+func arithmetic(op string, lhs float64, rhs float64) float64 {
+	switch op {
+	case OpAdd:
+		return lhs + rhs
+	case OpSub:
+		return lhs - rhs
+	case OpMul:
+		return lhs * rhs
+	case OpDiv:
+		return lhs / rhs
+	}
+
+	return math.NaN()
+}
+
+// aggregateValues Применение функции агрегации к значениям группы
+func aggregateValues(op string, series []Series) float64 {
+	switch op {
+	case AggregateCount:
+		return float64(len(series))
+	case AggregateMin:
+		value := math.Inf(1)
+		for _, s := range series {
+			value = math.Min(value, s.Value)
+		}
+
+		return value
+	case AggregateMax:
+		value := math.Inf(-1)
+		for _, s := range series {
+			value = math.Max(value, s.Value)
+		}
+
+		return value
+	}
+
+	var sum float64
+	for _, s := range series {
+		sum += s.Value
+	}
+
+	if op == AggregateAvg {
+		return sum / float64(len(series))
+	}
+
+	return sum
+}
+
+// counterIncrease Прирост счетчика с учетом сбросов значения
+func counterIncrease(samples []repositories.Sample) float64 {
+	var increase float64
+
+	for i := 1; i < len(samples); i++ {
+		if samples[i].Value >= samples[i-1].Value {
+			increase += samples[i].Value - samples[i-1].Value
+		} else {
+			increase += samples[i].Value
+		}
+	}
+
+	return increase
+}
+
+// match Проверка серии на соответствие условиям. Возвращает метки серии вместе с __name__
+func match(selector *Selector, key string) (map[string]string, bool) {
+	name, metric := types.ParseSeriesName(key)
+	metric[NameLabel] = name
+
+	for _, matcher := range selector.Matchers {
+		if !matcher.Matches(metric[matcher.Label]) {
+			return nil, false
+		}
+	}
+
+	return metric, true
+}
+
+// withoutName Метки серии без __name__
+func withoutName(metric map[string]string) map[string]string {
+	labels := make(map[string]string, len(metric))
+	for key, value := range metric {
+		if key != NameLabel {
+			labels[key] = value
+		}
+	}
+
+	return labels
+}
+
+// seriesKey Строковый ключ набора меток
+func seriesKey(metric map[string]string) string {
+	keys := make([]string, 0, len(metric))
+	for key := range metric {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, key := range keys {
+		b.WriteString(key)
+		b.WriteString("\xff")
+		b.WriteString(metric[key])
+		b.WriteString("\xff")
+	}
+
+	return b.String()
+}
@@ -0,0 +1,113 @@
+package query
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vllvll/devops/internal/repositories"
+	"github.com/vllvll/devops/internal/types"
+)
+
+func TestEngine_Query(t *testing.T) {
+	now := time.Now()
+
+	repository := repositories.NewStatsMemoryRepository()
+	_ = repository.UpdateAll(types.Gauges{
+		"HeapAlloc;host=a;dc=eu": 10,
+		"HeapAlloc;host=b;dc=eu": 30,
+		"HeapAlloc;host=c;dc=us": 50,
+		"HeapSys;host=a;dc=eu":   100,
+	}, types.Counters{
+		"PollCount;host=a": 25,
+	})
+
+	history := repositories.NewStatsHistoryRepository([]repositories.Tier{{Retention: time.Hour}})
+	history.Record(now.Add(-4*time.Minute), nil, types.Counters{"PollCount;host=a": 10})
+	history.Record(now.Add(-2*time.Minute), nil, types.Counters{"PollCount;host=a": 2})
+
+	engine := NewEngine(repository, history)
+	engine.now = func() time.Time { return now }
+
+	tests := []struct {
+		name  string
+		input string
+		want  []Series
+	}{
+		{
+			name:  "sum by name",
+			input: "sum(HeapAlloc)",
+			want:  []Series{{Metric: map[string]string{}, Value: 90}},
+		},
+		{
+			name:  "avg by label",
+			input: `avg by (dc) ({__name__=~"Heap.*", host=~"a|b|c"})`,
+			want: []Series{
+				{Metric: map[string]string{"dc": "eu"}, Value: 140.0 / 3},
+				{Metric: map[string]string{"dc": "us"}, Value: 50},
+			},
+		},
+		{
+			name:  "min and max",
+			input: `max(HeapAlloc{dc="eu"})`,
+			want:  []Series{{Metric: map[string]string{}, Value: 30}},
+		},
+		{
+			name:  "count",
+			input: `count({__name__!="PollCount"})`,
+			want:  []Series{{Metric: map[string]string{}, Value: 4}},
+		},
+		{
+			name:  "selector",
+			input: `HeapAlloc{host="c"}`,
+			want:  []Series{{Metric: map[string]string{NameLabel: "HeapAlloc", "host": "c", "dc": "us"}, Type: "gauge", Value: 50}},
+		},
+		{
+			name:  "increase with reset",
+			input: "increase(PollCount[5m])",
+			want:  []Series{{Metric: map[string]string{"host": "a"}, Value: 25}},
+		},
+		{
+			name:  "rate",
+			input: "rate(PollCount[5m])",
+			want:  []Series{{Metric: map[string]string{"host": "a"}, Value: 25.0 / 300}},
+		},
+		{
+			name:  "division matched by labels",
+			input: `HeapAlloc / HeapSys`,
+			want:  []Series{{Metric: map[string]string{"host": "a", "dc": "eu"}, Value: 0.1}},
+		},
+		{
+			name:  "scalar and precedence",
+			input: `100 - HeapAlloc{dc="eu"} * 2`,
+			want: []Series{
+				{Metric: map[string]string{"host": "a", "dc": "eu"}, Value: 80},
+				{Metric: map[string]string{"host": "b", "dc": "eu"}, Value: 40},
+			},
+		},
+		{
+			name:  "aggregates and parentheses",
+			input: `(sum(HeapAlloc) - sum(HeapSys)) / 2`,
+			want:  []Series{{Metric: map[string]string{}, Value: -5}},
+		},
+		{
+			name:  "constants",
+			input: `1.5 * 4`,
+			want:  []Series{{Metric: map[string]string{}, Value: 6}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := engine.Query(tt.input)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, result)
+		})
+	}
+
+	// Несколько серий с одинаковыми метками с одной стороны операции не сопоставляются
+	_, err := engine.Query(`{__name__=~"Heap.*", host="a"} - HeapSys`)
+	assert.Error(t, err)
+}
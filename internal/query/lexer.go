@@ -0,0 +1,161 @@
+// Package query Язык запросов с функциями агрегации по сохраненным метрикам
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tokenType int
+
+const (
+	tokenEOF tokenType = iota
+	tokenIdent
+	tokenString
+	tokenDuration
+	tokenNumber
+	tokenLeftParen
+	tokenRightParen
+	tokenLeftBrace
+	tokenRightBrace
+	tokenLeftBracket
+	tokenRightBracket
+	tokenComma
+	tokenEqual
+	tokenNotEqual
+	tokenRegexMatch
+	tokenRegexNotMatch
+	tokenAdd
+	tokenSub
+	tokenMul
+	tokenDiv
+)
+
+type token struct {
+	typ   tokenType
+	value string
+	pos   int
+}
+
+// lex Разбиение запроса на токены
+func lex(input string) ([]token, error) {
+	var tokens []token
+
+	for pos := 0; pos < len(input); {
+		c := input[pos]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			pos++
+
+		case c == '(':
+			tokens = append(tokens, token{typ: tokenLeftParen, value: "(", pos: pos})
+			pos++
+		case c == ')':
+			tokens = append(tokens, token{typ: tokenRightParen, value: ")", pos: pos})
+			pos++
+		case c == '{':
+			tokens = append(tokens, token{typ: tokenLeftBrace, value: "{", pos: pos})
+			pos++
+		case c == '}':
+			tokens = append(tokens, token{typ: tokenRightBrace, value: "}", pos: pos})
+			pos++
+		case c == '[':
+			tokens = append(tokens, token{typ: tokenLeftBracket, value: "[", pos: pos})
+			pos++
+		case c == ']':
+			tokens = append(tokens, token{typ: tokenRightBracket, value: "]", pos: pos})
+			pos++
+		case c == ',':
+			tokens = append(tokens, token{typ: tokenComma, value: ",", pos: pos})
+			pos++
+		case c == '+':
+			tokens = append(tokens, token{typ: tokenAdd, value: "+", pos: pos})
+			pos++
+		case c == '-':
+			tokens = append(tokens, token{typ: tokenSub, value: "-", pos: pos})
+			pos++
+		case c == '*':
+			tokens = append(tokens, token{typ: tokenMul, value: "*", pos: pos})
+			pos++
+		case c == '/':
+			tokens = append(tokens, token{typ: tokenDiv, value: "/", pos: pos})
+			pos++
+
+		case strings.HasPrefix(input[pos:], "=~"):
+			tokens = append(tokens, token{typ: tokenRegexMatch, value: "=~", pos: pos})
+			pos += 2
+		case strings.HasPrefix(input[pos:], "!~"):
+			tokens = append(tokens, token{typ: tokenRegexNotMatch, value: "!~", pos: pos})
+			pos += 2
+		case strings.HasPrefix(input[pos:], "!="):
+			tokens = append(tokens, token{typ: tokenNotEqual, value: "!=", pos: pos})
+			pos += 2
+		case c == '=':
+			tokens = append(tokens, token{typ: tokenEqual, value: "=", pos: pos})
+			pos++
+
+		case c == '"' || c == '\'':
+			end := pos + 1
+			for end < len(input) && input[end] != c {
+				if input[end] == '\\' {
+					end++
+				}
+				end++
+			}
+
+			if end >= len(input) {
+				return nil, fmt.Errorf("unterminated string at position %d", pos)
+			}
+
+			raw := input[pos : end+1]
+			if c == '\'' {
+				raw = `"` + strings.ReplaceAll(raw[1:len(raw)-1], `"`, `\"`) + `"`
+			}
+
+			value, err := strconv.Unquote(raw)
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at position %d", pos)
+			}
+
+			tokens = append(tokens, token{typ: tokenString, value: value, pos: pos})
+			pos = end + 1
+
+		case c >= '0' && c <= '9':
+			end, typ := pos, tokenNumber
+			for end < len(input) && (isDigit(input[end]) || isLetter(input[end]) || input[end] == '.') {
+				if isLetter(input[end]) {
+					typ = tokenDuration
+				}
+				end++
+			}
+
+			tokens = append(tokens, token{typ: typ, value: input[pos:end], pos: pos})
+			pos = end
+
+		case isLetter(c) || c == '_':
+			end := pos
+			for end < len(input) && (isLetter(input[end]) || isDigit(input[end]) || strings.IndexByte("_.:", input[end]) >= 0) {
+				end++
+			}
+
+			tokens = append(tokens, token{typ: tokenIdent, value: input[pos:end], pos: pos})
+			pos = end
+
+		default:
+			return nil, fmt.Errorf("unexpected character %q at position %d", c, pos)
+		}
+	}
+
+	return append(tokens, token{typ: tokenEOF, pos: len(input)}), nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return c < unicode.MaxASCII && unicode.IsLetter(rune(c))
+}
@@ -0,0 +1,354 @@
+package query
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Максимальная глубина вложенности выражений
+const maxDepth = 32
+
+type parser struct {
+	tokens []token
+	pos    int
+	depth  int
+}
+
+// Parse Разбор запроса, например: sum by (host) ({__name__=~"Heap.*", dc="eu"}) или HeapInuse / HeapSys
+func Parse(input string) (Expr, error) {
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.typ != tokenEOF {
+		return nil, p.errorf(t, "unexpected %q", t.value)
+	}
+
+	return expr, nil
+}
+
+func (p *parser) parseExpr() (Expr, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+
+	if p.depth > maxDepth {
+		return nil, fmt.Errorf("expression is too deep")
+	}
+
+	return p.parseAdditive()
+}
+
+// parseAdditive Сложение и вычитание: операции с меньшим приоритетом, выполняются слева направо
+func (p *parser) parseAdditive() (Expr, error) {
+	lhs, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().typ == tokenAdd || p.peek().typ == tokenSub {
+		op := p.next().value
+
+		rhs, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+
+		lhs = &BinaryExpr{Op: op, LHS: lhs, RHS: rhs}
+	}
+
+	return lhs, nil
+}
+
+// parseMultiplicative Умножение и деление
+func (p *parser) parseMultiplicative() (Expr, error) {
+	lhs, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().typ == tokenMul || p.peek().typ == tokenDiv {
+		op := p.next().value
+
+		rhs, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+
+		lhs = &BinaryExpr{Op: op, LHS: lhs, RHS: rhs}
+	}
+
+	return lhs, nil
+}
+
+func (p *parser) parsePrimary() (Expr, error) {
+	t := p.peek()
+
+	switch t.typ {
+	case tokenLeftParen:
+		p.next()
+
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+
+		return expr, p.expect(tokenRightParen)
+	case tokenNumber:
+		p.next()
+
+		value, err := strconv.ParseFloat(t.value, 64)
+		if err != nil {
+			return nil, p.errorf(t, "invalid number %q", t.value)
+		}
+
+		return &NumberLiteral{Value: value}, nil
+	}
+
+	if t.typ == tokenIdent {
+		next := p.tokens[p.pos+1]
+
+		switch t.value {
+		case AggregateSum, AggregateAvg, AggregateMin, AggregateMax, AggregateCount:
+			if next.typ == tokenLeftParen || (next.typ == tokenIdent && next.value == "by") {
+				return p.parseAggregate()
+			}
+		case FunctionRate, FunctionIncrease:
+			if next.typ == tokenLeftParen {
+				return p.parseCall()
+			}
+		}
+	}
+
+	return p.parseSelector()
+}
+
+func (p *parser) parseAggregate() (Expr, error) {
+	aggregate := &Aggregate{Op: p.next().value}
+
+	var err error
+
+	if p.peekIdent("by") {
+		if aggregate.Grouping, err = p.parseGrouping(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = p.expect(tokenLeftParen); err != nil {
+		return nil, err
+	}
+
+	if aggregate.Arg, err = p.parseExpr(); err != nil {
+		return nil, err
+	}
+
+	if err = p.expect(tokenRightParen); err != nil {
+		return nil, err
+	}
+
+	if aggregate.Grouping == nil && p.peekIdent("by") {
+		if aggregate.Grouping, err = p.parseGrouping(); err != nil {
+			return nil, err
+		}
+	}
+
+	return aggregate, nil
+}
+
+func (p *parser) parseGrouping() ([]string, error) {
+	p.next()
+
+	if err := p.expect(tokenLeftParen); err != nil {
+		return nil, err
+	}
+
+	grouping := []string{}
+	for p.peek().typ != tokenRightParen {
+		t := p.next()
+		if t.typ != tokenIdent {
+			return nil, p.errorf(t, "expected label name, got %q", t.value)
+		}
+
+		grouping = append(grouping, t.value)
+
+		if p.peek().typ == tokenComma {
+			p.next()
+		} else if p.peek().typ != tokenRightParen {
+			return nil, p.errorf(p.peek(), "expected \",\" or \")\"")
+		}
+	}
+
+	return grouping, p.expect(tokenRightParen)
+}
+
+func (p *parser) parseCall() (Expr, error) {
+	call := &Call{Func: p.next().value}
+
+	if err := p.expect(tokenLeftParen); err != nil {
+		return nil, err
+	}
+
+	arg, err := p.parseSelector()
+	if err != nil {
+		return nil, err
+	}
+
+	rangeSelector, ok := arg.(*RangeSelector)
+	if !ok {
+		return nil, fmt.Errorf("%s() expects a range selector, for example: %s(PollCount[5m])", call.Func, call.Func)
+	}
+
+	call.Arg = rangeSelector
+
+	return call, p.expect(tokenRightParen)
+}
+
+func (p *parser) parseSelector() (Expr, error) {
+	selector := &Selector{}
+
+	t := p.peek()
+	if t.typ == tokenIdent {
+		p.next()
+		selector.Matchers = append(selector.Matchers, Matcher{Label: NameLabel, Type: MatchEqual, Value: t.value})
+	}
+
+	if p.peek().typ == tokenLeftBrace {
+		p.next()
+
+		for p.peek().typ != tokenRightBrace {
+			matcher, err := p.parseMatcher()
+			if err != nil {
+				return nil, err
+			}
+
+			selector.Matchers = append(selector.Matchers, matcher)
+
+			if p.peek().typ == tokenComma {
+				p.next()
+			} else if p.peek().typ != tokenRightBrace {
+				return nil, p.errorf(p.peek(), "expected \",\" or \"}\"")
+			}
+		}
+
+		p.next()
+	}
+
+	if len(selector.Matchers) == 0 {
+		return nil, p.errorf(t, "expected selector, got %q", t.value)
+	}
+
+	if p.peek().typ != tokenLeftBracket {
+		return selector, nil
+	}
+
+	p.next()
+
+	t = p.next()
+	if t.typ != tokenDuration {
+		return nil, p.errorf(t, "expected duration, got %q", t.value)
+	}
+
+	duration, err := parseDuration(t.value)
+	if err != nil {
+		return nil, p.errorf(t, "%v", err)
+	}
+
+	return &RangeSelector{Selector: selector, Range: duration}, p.expect(tokenRightBracket)
+}
+
+func (p *parser) parseMatcher() (Matcher, error) {
+	label := p.next()
+	if label.typ != tokenIdent {
+		return Matcher{}, p.errorf(label, "expected label name, got %q", label.value)
+	}
+
+	op := p.next()
+	switch op.typ {
+	case tokenEqual, tokenNotEqual, tokenRegexMatch, tokenRegexNotMatch:
+	default:
+		return Matcher{}, p.errorf(op, "expected matcher operator, got %q", op.value)
+	}
+
+	value := p.next()
+	if value.typ != tokenString {
+		return Matcher{}, p.errorf(value, "expected string, got %q", value.value)
+	}
+
+	matcher := Matcher{Label: label.value, Type: op.value, Value: value.value}
+
+	if op.typ == tokenRegexMatch || op.typ == tokenRegexNotMatch {
+		re, err := regexp.Compile("^(?:" + value.value + ")$")
+		if err != nil {
+			return Matcher{}, p.errorf(value, "invalid regexp: %v", err)
+		}
+
+		matcher.re = re
+	}
+
+	return matcher, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) peekIdent(value string) bool {
+	t := p.peek()
+
+	return t.typ == tokenIdent && t.value == value
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.typ != tokenEOF {
+		p.pos++
+	}
+
+	return t
+}
+
+func (p *parser) expect(typ tokenType) error {
+	t := p.next()
+	if t.typ != typ {
+		return p.errorf(t, "unexpected %q", t.value)
+	}
+
+	return nil
+}
+
+func (p *parser) errorf(t token, format string, args ...interface{}) error {
+	if t.typ == tokenEOF {
+		return fmt.Errorf("unexpected end of query")
+	}
+
+	return fmt.Errorf("position %d: %s", t.pos, fmt.Sprintf(format, args...))
+}
+
+// parseDuration Разбор длительности с поддержкой дней: 30s, 5m, 1h, 7d
+func parseDuration(value string) (time.Duration, error) {
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+		if err != nil || days <= 0 {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+
+	return duration, nil
+}
@@ -0,0 +1,167 @@
+package query
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    Expr
+		wantErr bool
+	}{
+		{
+			name:  "selector by name",
+			input: "HeapAlloc",
+			want: &Selector{Matchers: []Matcher{
+				{Label: NameLabel, Type: MatchEqual, Value: "HeapAlloc"},
+			}},
+		},
+		{
+			name:  "selector with labels",
+			input: `HeapAlloc{host="a", dc!='eu'}`,
+			want: &Selector{Matchers: []Matcher{
+				{Label: NameLabel, Type: MatchEqual, Value: "HeapAlloc"},
+				{Label: "host", Type: MatchEqual, Value: "a"},
+				{Label: "dc", Type: MatchNotEqual, Value: "eu"},
+			}},
+		},
+		{
+			name:  "aggregate with grouping",
+			input: `sum by (host) ({__name__="Alloc"})`,
+			want: &Aggregate{
+				Op:       AggregateSum,
+				Grouping: []string{"host"},
+				Arg: &Selector{Matchers: []Matcher{
+					{Label: NameLabel, Type: MatchEqual, Value: "Alloc"},
+				}},
+			},
+		},
+		{
+			name:  "aggregate with trailing grouping",
+			input: `max(rate(PollCount[5m])) by (host, dc)`,
+			want: &Aggregate{
+				Op:       AggregateMax,
+				Grouping: []string{"host", "dc"},
+				Arg: &Call{
+					Func: FunctionRate,
+					Arg: &RangeSelector{
+						Selector: &Selector{Matchers: []Matcher{{Label: NameLabel, Type: MatchEqual, Value: "PollCount"}}},
+						Range:    5 * time.Minute,
+					},
+				},
+			},
+		},
+		{
+			name:  "increase over days",
+			input: `increase(PollCount[1d])`,
+			want: &Call{
+				Func: FunctionIncrease,
+				Arg: &RangeSelector{
+					Selector: &Selector{Matchers: []Matcher{{Label: NameLabel, Type: MatchEqual, Value: "PollCount"}}},
+					Range:    24 * time.Hour,
+				},
+			},
+		},
+		{
+			name:  "arithmetic precedence",
+			input: "TotalMemory - FreeMemory / 2",
+			want: &BinaryExpr{
+				Op:  OpSub,
+				LHS: &Selector{Matchers: []Matcher{{Label: NameLabel, Type: MatchEqual, Value: "TotalMemory"}}},
+				RHS: &BinaryExpr{
+					Op:  OpDiv,
+					LHS: &Selector{Matchers: []Matcher{{Label: NameLabel, Type: MatchEqual, Value: "FreeMemory"}}},
+					RHS: &NumberLiteral{Value: 2},
+				},
+			},
+		},
+		{
+			name:  "parentheses",
+			input: "(HeapInuse - 0.5) * 100",
+			want: &BinaryExpr{
+				Op: OpMul,
+				LHS: &BinaryExpr{
+					Op:  OpSub,
+					LHS: &Selector{Matchers: []Matcher{{Label: NameLabel, Type: MatchEqual, Value: "HeapInuse"}}},
+					RHS: &NumberLiteral{Value: 0.5},
+				},
+				RHS: &NumberLiteral{Value: 100},
+			},
+		},
+		{
+			name:    "missing operand",
+			input:   "HeapInuse /",
+			wantErr: true,
+		},
+		{
+			name:    "rate without range",
+			input:   "rate(PollCount)",
+			wantErr: true,
+		},
+		{
+			name:    "invalid regexp",
+			input:   `{__name__=~"("}`,
+			wantErr: true,
+		},
+		{
+			name:    "empty selector",
+			input:   "sum({})",
+			wantErr: true,
+		},
+		{
+			name:    "unterminated string",
+			input:   `{host="a}`,
+			wantErr: true,
+		},
+		{
+			name:    "trailing tokens",
+			input:   "Alloc Alloc",
+			wantErr: true,
+		},
+		{
+			name:    "empty query",
+			input:   "",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := Parse(tt.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, expr)
+		})
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{
+		"HeapAlloc",
+		`sum by (host) ({__name__=~"Heap.*", dc="eu"})`,
+		`avg(Alloc{host!~'a|b'}) by (dc)`,
+		"rate(PollCount[5m])",
+		"count(increase({__name__=~\".*\"}[1h]))",
+		"(HeapInuse / HeapSys) * 100",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		expr, err := Parse(input)
+		if err == nil && expr == nil {
+			t.Fatalf("nil expression without error for %q", input)
+		}
+	})
+}
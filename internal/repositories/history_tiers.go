@@ -0,0 +1,255 @@
+package repositories
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vllvll/devops/internal/dictionaries"
+)
+
+// ErrInvalidTiers Неправильное описание уровней хранения истории
+var ErrInvalidTiers = errors.New("invalid history tiers")
+
+// Tier Уровень хранения истории: значения, сгруппированные по интервалу Resolution, хранятся Retention
+type Tier struct {
+	Resolution time.Duration // Интервал группировки значений (0 - сырые значения)
+	Retention  time.Duration // Время хранения значений
+}
+
+// Aggregate Агрегированные значения метрики за интервал уровня хранения
+type Aggregate struct {
+	Min   float64 `json:"min"`   // Минимальное значение
+	Max   float64 `json:"max"`   // Максимальное значение
+	Avg   float64 `json:"avg"`   // Среднее значение
+	Last  float64 `json:"last"`  // Последнее значение
+	Sum   float64 `json:"sum"`   // Прирост значения Counter за интервал
+	Count int64   `json:"count"` // Количество сырых значений за интервал
+}
+
+// ParseTiers Разбор уровней хранения истории в формате "raw:6h,1m:7d,1h:90d".
+// Первый уровень хранит сырые значения, интервал каждого следующего уровня кратен интервалу предыдущего.
+// Пустая строка - только сырые значения со временем хранения retention
+func ParseTiers(value string, retention time.Duration) ([]Tier, error) {
+	if strings.TrimSpace(value) == "" {
+		return []Tier{{Retention: retention}}, nil
+	}
+
+	var tiers []Tier
+
+	for _, part := range strings.Split(value, ",") {
+		resolution, keep, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			return nil, fmt.Errorf("%w: %q must be resolution:retention", ErrInvalidTiers, part)
+		}
+
+		var tier Tier
+		var err error
+
+		if resolution != "raw" {
+			if tier.Resolution, err = parseTierDuration(resolution); err != nil || tier.Resolution < time.Second || tier.Resolution%time.Second != 0 {
+				return nil, fmt.Errorf("%w: bad resolution %q", ErrInvalidTiers, resolution)
+			}
+		}
+
+		if tier.Retention, err = parseTierDuration(keep); err != nil || tier.Retention <= tier.Resolution {
+			return nil, fmt.Errorf("%w: bad retention %q", ErrInvalidTiers, keep)
+		}
+
+		tiers = append(tiers, tier)
+	}
+
+	if tiers[0].Resolution != 0 {
+		return nil, fmt.Errorf("%w: first tier must be raw", ErrInvalidTiers)
+	}
+
+	for i := 1; i < len(tiers); i++ {
+		previous := tiers[i-1].Resolution
+		if tiers[i].Resolution <= previous || (previous > 0 && tiers[i].Resolution%previous != 0) {
+			return nil, fmt.Errorf("%w: resolution %s must be a multiple of %s", ErrInvalidTiers, tiers[i].Resolution, previous)
+		}
+	}
+
+	return tiers, nil
+}
+
+// parseTierDuration Разбор длительности с поддержкой суток: 7d, 90d
+func parseTierDuration(value string) (time.Duration, error) {
+	if strings.HasSuffix(value, "d") {
+		count, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+		if err != nil {
+			return 0, err
+		}
+
+		return time.Duration(count) * 24 * time.Hour, nil
+	}
+
+	return time.ParseDuration(value)
+}
+
+// selectTier Выбор самого подробного уровня, который еще хранит значения начиная с from
+func selectTier(tiers []Tier, from time.Time, now time.Time) int {
+	for i, tier := range tiers {
+		if !from.Before(now.Add(-tier.Retention)) {
+			return i
+		}
+	}
+
+	return len(tiers) - 1
+}
+
+// rangeTiers Получение значений за период [from, to] с уровня, выбранного по from. Интервал после последнего
+// агрегированного значения дополняется значениями более подробных уровней
+func rangeTiers(tiers []Tier, from time.Time, to time.Time, now time.Time, fetch func(level int, from time.Time, to time.Time) []Sample) []Sample {
+	var result []Sample
+
+	cursor := from
+	for level := selectTier(tiers, from, now); level >= 0; level-- {
+		if cursor.After(to) {
+			break
+		}
+
+		samples := fetch(level, cursor, to)
+		if len(samples) == 0 {
+			continue
+		}
+
+		result = append(result, samples...)
+		cursor = samples[len(samples)-1].Time.Add(tiers[level].Resolution)
+		if tiers[level].Resolution == 0 {
+			break
+		}
+	}
+
+	return result
+}
+
+// rangeAllTiers Получение значений всех серий за период [from, to], как в rangeTiers. Значения каждого уровня
+// читаются через fetch один раз для всех серий
+func rangeAllTiers(tiers []Tier, from time.Time, to time.Time, now time.Time, fetch func(level int, from time.Time, to time.Time) map[string][]Sample) map[string][]Sample {
+	levels := make(map[int]map[string][]Sample, len(tiers))
+	for level := selectTier(tiers, from, now); level >= 0; level-- {
+		levels[level] = fetch(level, from, to)
+	}
+
+	result := map[string][]Sample{}
+	for _, series := range levels {
+		for key := range series {
+			if _, ok := result[key]; ok {
+				continue
+			}
+
+			samples := rangeTiers(tiers, from, to, now, func(level int, from time.Time, to time.Time) []Sample {
+				return samplesBetween(levels[level][key], from, to)
+			})
+			if len(samples) > 0 {
+				result[key] = samples
+			}
+		}
+	}
+
+	return result
+}
+
+// samplesBetween Значения за период [from, to] из значений, упорядоченных по времени
+func samplesBetween(samples []Sample, from time.Time, to time.Time) []Sample {
+	var result []Sample
+	for _, sample := range samples {
+		if !sample.Time.Before(from) && !sample.Time.After(to) {
+			result = append(result, sample)
+		}
+	}
+
+	return result
+}
+
+// compactSamples Группировка значений source по интервалу resolution. Учитываются только значения не раньше
+// since из интервалов, которые полностью закончились к until
+func compactSamples(mType string, source []Sample, resolution time.Duration, since time.Time, until time.Time) []Sample {
+	boundary := until.Truncate(resolution)
+
+	var result []Sample
+	var current *Sample
+
+	for i, sample := range source {
+		if sample.Time.Before(since) {
+			continue
+		}
+
+		bucket := sample.Time.Truncate(resolution)
+		if !bucket.Before(boundary) {
+			break
+		}
+
+		var previous *Sample
+		if i > 0 {
+			previous = &source[i-1]
+		}
+
+		aggregate := toAggregate(mType, sample, previous)
+
+		if current != nil && current.Time.Equal(bucket) {
+			merged := mergeAggregates(*current.Aggregate, aggregate)
+			current.Aggregate = &merged
+			current.Value = aggregateValue(mType, merged)
+
+			continue
+		}
+
+		result = append(result, Sample{Time: bucket, Value: aggregateValue(mType, aggregate), Aggregate: &aggregate})
+		current = &result[len(result)-1]
+	}
+
+	return result
+}
+
+// toAggregate Приведение значения к агрегату. Для сырых значений Counter прирост считается от предыдущего значения,
+// уменьшение значения считается сбросом счетчика
+func toAggregate(mType string, sample Sample, previous *Sample) Aggregate {
+	if sample.Aggregate != nil {
+		return *sample.Aggregate
+	}
+
+	aggregate := Aggregate{
+		Min:   sample.Value,
+		Max:   sample.Value,
+		Avg:   sample.Value,
+		Last:  sample.Value,
+		Count: 1,
+	}
+
+	if mType == dictionaries.CounterType && previous != nil {
+		aggregate.Sum = sample.Value - previous.Value
+		if sample.Value < previous.Value {
+			aggregate.Sum = sample.Value
+		}
+	}
+
+	return aggregate
+}
+
+// mergeAggregates Объединение агрегата a с более поздним агрегатом b
+func mergeAggregates(a Aggregate, b Aggregate) Aggregate {
+	count := a.Count + b.Count
+
+	return Aggregate{
+		Min:   math.Min(a.Min, b.Min),
+		Max:   math.Max(a.Max, b.Max),
+		Avg:   (a.Avg*float64(a.Count) + b.Avg*float64(b.Count)) / float64(count),
+		Last:  b.Last,
+		Sum:   a.Sum + b.Sum,
+		Count: count,
+	}
+}
+
+// aggregateValue Значение агрегата для графиков и запросов: среднее для Gauge, последнее для Counter
+func aggregateValue(mType string, aggregate Aggregate) float64 {
+	if mType == dictionaries.CounterType {
+		return aggregate.Last
+	}
+
+	return aggregate.Avg
+}
@@ -0,0 +1,470 @@
+package repositories
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/vllvll/devops/internal/types"
+)
+
+// Гарантии записи буфера
+const (
+	DurabilitySync  = "sync"  // Запрос завершается после записи его изменений в хранилище, ошибка записи возвращается запросу
+	DurabilityAsync = "async" // Запрос завершается сразу, изменения последнего интервала теряются при аварийной остановке
+)
+
+// ErrBufferFull Буфер накопил максимальное количество незаписанных серий, запрос нужно повторить позже
+var ErrBufferFull = errors.New("write buffer is full, retry later")
+
+// SeriesKey Серия арендатора в накопленных изменениях
+type SeriesKey struct {
+	Tenant string
+	Name   string
+}
+
+// BatchWrite Подготовленная запись пакета: изменения становятся видны при чтении только после Commit
+type BatchWrite interface {
+	Commit() error
+	Rollback() error
+}
+
+// BatchRepository Хранилище арендаторов, которое записывает изменения всех арендаторов одним пакетом
+type BatchRepository interface {
+	TenantRepository
+	WriteBatch(gauges map[SeriesKey]types.Gauge, counters map[SeriesKey]types.Counter) error
+	PrepareBatch(gauges map[SeriesKey]types.Gauge, counters map[SeriesKey]types.Counter) (BatchWrite, error)
+}
+
+// pendingBatch Накопленные изменения: последние значения Gauge и суммы приращений Counter
+type pendingBatch struct {
+	gauges   map[SeriesKey]types.Gauge
+	counters map[SeriesKey]types.Counter
+	waiters  []chan error // Запросы, ожидающие записи пакета (только для DurabilitySync)
+}
+
+func newPendingBatch() *pendingBatch {
+	return &pendingBatch{
+		gauges:   map[SeriesKey]types.Gauge{},
+		counters: map[SeriesKey]types.Counter{},
+	}
+}
+
+// size Количество серий в пакете
+func (p *pendingBatch) size() int {
+	return len(p.gauges) + len(p.counters)
+}
+
+type TenantBuffered struct {
+	base       BatchRepository
+	interval   time.Duration // Интервал записи накопленных изменений
+	size       int           // Количество серий, при котором запись выполняется до истечения интервала
+	maxPending int           // Количество незаписанных серий, после которого новые изменения отклоняются (0 - без ограничения)
+	durability string        // Гарантия записи: DurabilitySync или DurabilityAsync
+
+	writing  sync.Mutex   // Пакеты записываются по одному
+	flushing sync.RWMutex // Чтение из base не пересекается с подтверждением записи пакета, чтобы изменения не учитывались дважды
+	mu       sync.Mutex
+	pending  *pendingBatch
+	inflight *pendingBatch // Записываемый пакет: виден при чтении до подтверждения записи
+	closed   bool
+
+	full    chan struct{} // Сигнал о превышении size
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// NewTenantBufferedRepository Создание репозитория, который накапливает изменения в оперативной памяти
+// и записывает их в base одним пакетом раз в interval или при накоплении size серий. При накоплении maxPending
+// незаписанных серий (например, пока бд недоступна) новые изменения отклоняются с ErrBufferFull
+func NewTenantBufferedRepository(base BatchRepository, interval time.Duration, size int, maxPending int, durability string) (*TenantBuffered, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("write interval must be positive")
+	}
+
+	if durability != DurabilitySync && durability != DurabilityAsync {
+		return nil, fmt.Errorf("unknown write durability %q, expected %s or %s", durability, DurabilitySync, DurabilityAsync)
+	}
+
+	t := &TenantBuffered{
+		base:       base,
+		interval:   interval,
+		size:       size,
+		maxPending: maxPending,
+		durability: durability,
+		pending:    newPendingBatch(),
+		full:       make(chan struct{}, 1),
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+
+	go t.run()
+
+	return t, nil
+}
+
+// Tenant Получение метрик арендатора с учетом накопленных изменений
+func (t *TenantBuffered) Tenant(name string) StatsRepository {
+	return &StatsBuffered{
+		buffer: t,
+		base:   t.base.Tenant(name),
+		tenant: name,
+	}
+}
+
+// Tenants Получение списка арендаторов, включая арендаторов, изменения которых еще не записаны
+func (t *TenantBuffered) Tenants() ([]string, error) {
+	t.flushing.RLock()
+	defer t.flushing.RUnlock()
+
+	names, err := t.base.Tenants()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		seen[name] = true
+	}
+
+	t.mu.Lock()
+	for _, batch := range t.unwritten() {
+		for key := range batch.gauges {
+			seen[key.Tenant] = true
+		}
+		for key := range batch.counters {
+			seen[key.Tenant] = true
+		}
+	}
+	t.mu.Unlock()
+
+	names = names[:0]
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// Flush Запись накопленных изменений. Чтение ожидает только подтверждения записи, а не всю запись пакета
+func (t *TenantBuffered) Flush() error {
+	t.writing.Lock()
+	defer t.writing.Unlock()
+
+	t.mu.Lock()
+	batch := t.pending
+	t.pending = newPendingBatch()
+	t.inflight = batch
+	t.mu.Unlock()
+
+	var err error
+	if batch.size() > 0 {
+		err = t.write(batch)
+	} else {
+		t.mu.Lock()
+		t.inflight = nil
+		t.mu.Unlock()
+	}
+
+	for _, waiter := range batch.waiters {
+		waiter <- err
+	}
+
+	return err
+}
+
+// write Запись пакета. Пакет остается видимым при чтении, пока запись не подтверждена; подтверждение и снятие
+// пакета выполняются под блокировкой flushing, поэтому чтение не учитывает изменения дважды
+func (t *TenantBuffered) write(batch *pendingBatch) error {
+	prepared, err := t.base.PrepareBatch(batch.gauges, batch.counters)
+
+	t.flushing.Lock()
+	defer t.flushing.Unlock()
+
+	if err == nil {
+		err = prepared.Commit()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.inflight = nil
+
+	if err != nil && t.durability == DurabilityAsync {
+		// Запрос уже завершен, поэтому изменения возвращаются в буфер и записываются в следующий раз
+		t.requeue(batch)
+	}
+
+	return err
+}
+
+// Close Остановка записи по интервалу и запись накопленных изменений
+func (t *TenantBuffered) Close() error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+
+		return nil
+	}
+	t.closed = true
+	t.mu.Unlock()
+
+	close(t.done)
+	<-t.stopped
+
+	return t.Flush()
+}
+
+// run Запись накопленных изменений по интервалу и при накоплении size серий
+func (t *TenantBuffered) run() {
+	defer close(t.stopped)
+
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.done:
+			return
+		case <-ticker.C:
+		case <-t.full:
+		}
+
+		if err := t.Flush(); err != nil {
+			zap.S().Errorf("Error with write buffered metrics: %v", err)
+		}
+	}
+}
+
+// add Добавление изменений арендатора в буфер. Для DurabilitySync возвращает канал с результатом записи
+func (t *TenantBuffered) add(tenant string, gauges types.Gauges, counters types.Counters) (<-chan error, error) {
+	t.mu.Lock()
+
+	if t.closed {
+		t.mu.Unlock()
+
+		return nil, t.writeDirect(tenant, gauges, counters)
+	}
+
+	if t.maxPending > 0 && t.pending.size() >= t.maxPending {
+		t.mu.Unlock()
+
+		return nil, ErrBufferFull
+	}
+
+	for name, value := range gauges {
+		t.pending.gauges[SeriesKey{Tenant: tenant, Name: name}] = value
+	}
+
+	for name, value := range counters {
+		t.pending.counters[SeriesKey{Tenant: tenant, Name: name}] += value
+	}
+
+	var result chan error
+	if t.durability == DurabilitySync {
+		result = make(chan error, 1)
+		t.pending.waiters = append(t.pending.waiters, result)
+	}
+
+	full := t.size > 0 && t.pending.size() >= t.size
+
+	t.mu.Unlock()
+
+	if full {
+		select {
+		case t.full <- struct{}{}:
+		default:
+		}
+	}
+
+	return result, nil
+}
+
+// writeDirect Запись изменений арендатора без буфера после Close
+func (t *TenantBuffered) writeDirect(tenant string, gauges types.Gauges, counters types.Counters) error {
+	batch := newPendingBatch()
+	for name, value := range gauges {
+		batch.gauges[SeriesKey{Tenant: tenant, Name: name}] = value
+	}
+
+	for name, value := range counters {
+		batch.counters[SeriesKey{Tenant: tenant, Name: name}] = value
+	}
+
+	t.flushing.Lock()
+	defer t.flushing.Unlock()
+
+	return t.base.WriteBatch(batch.gauges, batch.counters)
+}
+
+// requeue Возврат незаписанного пакета в буфер. Более новые значения Gauge не перезаписываются.
+// Вызывается под блокировкой mu. Пока буфер переполнен, add отклоняет новые изменения, поэтому буфер
+// не превышает maxPending серий больше чем на размер возвращенного пакета
+func (t *TenantBuffered) requeue(batch *pendingBatch) {
+	for key, value := range batch.gauges {
+		if _, ok := t.pending.gauges[key]; !ok {
+			t.pending.gauges[key] = value
+		}
+	}
+
+	for key, value := range batch.counters {
+		t.pending.counters[key] += value
+	}
+}
+
+// unwritten Незаписанные изменения от более старых к более новым: записываемый пакет и накопленные изменения.
+// Вызывается под блокировкой mu
+func (t *TenantBuffered) unwritten() []*pendingBatch {
+	if t.inflight == nil {
+		return []*pendingBatch{t.pending}
+	}
+
+	return []*pendingBatch{t.inflight, t.pending}
+}
+
+type StatsBuffered struct {
+	buffer *TenantBuffered
+	base   StatsRepository // Записанные метрики арендатора
+	tenant string
+}
+
+// UpdateGauge Обновить значение метрики с типом Gauge через буфер
+func (s *StatsBuffered) UpdateGauge(key string, value types.Gauge) {
+	if err := s.UpdateAll(types.Gauges{key: value}, types.Counters{}); err != nil {
+		zap.S().Errorf("Error with update gauge: %v", err)
+	}
+}
+
+// UpdateCount Обновить значение метрики с типом Counter через буфер
+func (s *StatsBuffered) UpdateCount(key string, value types.Counter) {
+	if err := s.UpdateAll(types.Gauges{}, types.Counters{key: value}); err != nil {
+		zap.S().Errorf("Error with update counter: %v", err)
+	}
+}
+
+// UpdateAll Добавление значений в буфер. Для DurabilitySync ожидает записи в хранилище
+func (s *StatsBuffered) UpdateAll(gauges types.Gauges, counters types.Counters) error {
+	result, err := s.buffer.add(s.tenant, gauges, counters)
+	if err != nil || result == nil {
+		return err
+	}
+
+	return <-result
+}
+
+// GetAll Получение всех метрик с учетом накопленных изменений
+func (s *StatsBuffered) GetAll() (map[string]types.Gauge, map[string]types.Counter) {
+	s.buffer.flushing.RLock()
+	defer s.buffer.flushing.RUnlock()
+
+	gauges, counters := s.base.GetAll()
+
+	s.buffer.mu.Lock()
+	defer s.buffer.mu.Unlock()
+
+	for _, batch := range s.buffer.unwritten() {
+		for key, value := range batch.gauges {
+			if key.Tenant == s.tenant {
+				gauges[key.Name] = value
+			}
+		}
+
+		for key, value := range batch.counters {
+			if key.Tenant == s.tenant {
+				counters[key.Name] += value
+			}
+		}
+	}
+
+	return gauges, counters
+}
+
+// GetGaugeByKey Получить значение метрики типа Gauge с учетом накопленных изменений
+func (s *StatsBuffered) GetGaugeByKey(key string) (types.Gauge, error) {
+	s.buffer.flushing.RLock()
+	defer s.buffer.flushing.RUnlock()
+
+	var value types.Gauge
+	var ok bool
+
+	// Более новые изменения находятся в конце списка
+	s.buffer.mu.Lock()
+	for _, batch := range s.buffer.unwritten() {
+		if batchValue, found := batch.gauges[SeriesKey{Tenant: s.tenant, Name: key}]; found {
+			value, ok = batchValue, true
+		}
+	}
+	s.buffer.mu.Unlock()
+
+	if ok {
+		return value, nil
+	}
+
+	return s.base.GetGaugeByKey(key)
+}
+
+// GetCounterByKey Получить значение метрики типа Counter с учетом накопленных изменений
+func (s *StatsBuffered) GetCounterByKey(key string) (types.Counter, error) {
+	s.buffer.flushing.RLock()
+	defer s.buffer.flushing.RUnlock()
+
+	value, err := s.base.GetCounterByKey(key)
+
+	var delta types.Counter
+	var ok bool
+
+	s.buffer.mu.Lock()
+	for _, batch := range s.buffer.unwritten() {
+		if batchDelta, found := batch.counters[SeriesKey{Tenant: s.tenant, Name: key}]; found {
+			delta, ok = delta+batchDelta, true
+		}
+	}
+	s.buffer.mu.Unlock()
+
+	if err != nil && !ok {
+		return value, err
+	}
+
+	return value + delta, nil
+}
+
+// ReplaceAll Запись значений Gauge и замена значений Counter после записи накопленных изменений
+func (s *StatsBuffered) ReplaceAll(gauges types.Gauges, counters types.Counters) error {
+	if err := s.buffer.Flush(); err != nil {
+		return err
+	}
+
+	return s.base.ReplaceAll(gauges, counters)
+}
+
+// DeleteGauge Удаление метрики типа Gauge после записи накопленных изменений
+func (s *StatsBuffered) DeleteGauge(key string) error {
+	if err := s.buffer.Flush(); err != nil {
+		return err
+	}
+
+	return s.base.DeleteGauge(key)
+}
+
+// DeleteCounter Удаление метрики типа Counter после записи накопленных изменений
+func (s *StatsBuffered) DeleteCounter(key string) error {
+	if err := s.buffer.Flush(); err != nil {
+		return err
+	}
+
+	return s.base.DeleteCounter(key)
+}
+
+// ResetCounter Сброс значения метрики типа Counter после записи накопленных изменений
+func (s *StatsBuffered) ResetCounter(key string) error {
+	if err := s.buffer.Flush(); err != nil {
+		return err
+	}
+
+	return s.base.ResetCounter(key)
+}
@@ -0,0 +1,521 @@
+package repositories
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/jackc/pgx/v4/stdlib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vllvll/devops/internal/types"
+)
+
+// recordingRepository Хранилище в памяти, которое считает записи пакетов и может возвращать ошибку
+type recordingRepository struct {
+	*TenantMemory
+
+	mu      sync.Mutex
+	batches int
+	fail    error
+}
+
+func newRecordingRepository() *recordingRepository {
+	return &recordingRepository{TenantMemory: NewTenantMemoryRepository().(*TenantMemory)}
+}
+
+func (r *recordingRepository) WriteBatch(gauges map[SeriesKey]types.Gauge, counters map[SeriesKey]types.Counter) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.fail != nil {
+		err := r.fail
+		r.fail = nil
+
+		return err
+	}
+
+	r.batches++
+
+	return r.TenantMemory.WriteBatch(gauges, counters)
+}
+
+func (r *recordingRepository) PrepareBatch(gauges map[SeriesKey]types.Gauge, counters map[SeriesKey]types.Counter) (BatchWrite, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.fail != nil {
+		err := r.fail
+		r.fail = nil
+
+		return nil, err
+	}
+
+	r.batches++
+
+	return r.TenantMemory.PrepareBatch(gauges, counters)
+}
+
+func TestTenantBuffered_Coalesce(t *testing.T) {
+	base := newRecordingRepository()
+	require.NoError(t, base.Tenant("team-a").UpdateAll(types.Gauges{}, types.Counters{"PollCount": 10}))
+
+	buffer, err := NewTenantBufferedRepository(base, time.Hour, 0, 0, DurabilityAsync)
+	require.NoError(t, err)
+	defer buffer.Close()
+
+	stats := buffer.Tenant("team-a")
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, stats.UpdateAll(types.Gauges{"Alloc": types.Gauge(i)}, types.Counters{"PollCount": 1}))
+	}
+	stats.UpdateGauge("HeapAlloc", 7)
+	buffer.Tenant("team-b").UpdateCount("PollCount", 5)
+
+	// Изменения еще не записаны, но видны при чтении
+	assert.Equal(t, 0, base.batches)
+
+	gauges, counters := stats.GetAll()
+	assert.Equal(t, map[string]types.Gauge{"Alloc": 3, "HeapAlloc": 7}, gauges)
+	assert.Equal(t, map[string]types.Counter{"PollCount": 13}, counters)
+
+	counter, err := stats.GetCounterByKey("PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, types.Counter(13), counter)
+
+	gauge, err := stats.GetGaugeByKey("Alloc")
+	require.NoError(t, err)
+	assert.Equal(t, types.Gauge(3), gauge)
+
+	tenants, err := buffer.Tenants()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"team-a", "team-b"}, tenants)
+
+	require.NoError(t, buffer.Flush())
+	assert.Equal(t, 1, base.batches)
+
+	gauges, counters = base.Tenant("team-a").GetAll()
+	assert.Equal(t, map[string]types.Gauge{"Alloc": 3, "HeapAlloc": 7}, gauges)
+	assert.Equal(t, map[string]types.Counter{"PollCount": 13}, counters)
+
+	_, counters = stats.GetAll()
+	assert.Equal(t, map[string]types.Counter{"PollCount": 13}, counters, "flushed changes are not counted twice")
+}
+
+func TestTenantBuffered_Sync(t *testing.T) {
+	base := newRecordingRepository()
+
+	buffer, err := NewTenantBufferedRepository(base, time.Hour, 2, 0, DurabilitySync)
+	require.NoError(t, err)
+	defer buffer.Close()
+
+	// Запрос завершается только после записи, которую запускает накопление двух серий
+	var wg sync.WaitGroup
+	for _, name := range []string{"Alloc", "HeapAlloc"} {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			assert.NoError(t, buffer.Tenant("").UpdateAll(types.Gauges{name: 1}, types.Counters{}))
+		}(name)
+	}
+	wg.Wait()
+
+	gauges, _ := base.Tenant("").GetAll()
+	assert.Equal(t, map[string]types.Gauge{"Alloc": 1, "HeapAlloc": 1}, gauges)
+
+	// Ошибка записи возвращается запросу, изменения не остаются в буфере
+	base.fail = errors.New("connection refused")
+
+	done := make(chan error)
+	go func() {
+		done <- buffer.Tenant("").UpdateAll(types.Gauges{}, types.Counters{"PollCount": 1})
+	}()
+
+	require.Eventually(t, func() bool {
+		buffer.mu.Lock()
+		defer buffer.mu.Unlock()
+
+		return buffer.pending.size() == 1
+	}, time.Second, time.Millisecond)
+
+	assert.Error(t, buffer.Flush())
+	assert.EqualError(t, <-done, "connection refused")
+
+	require.NoError(t, buffer.Flush())
+	_, counters := buffer.Tenant("").GetAll()
+	assert.Empty(t, counters)
+}
+
+func TestTenantBuffered_AsyncRetry(t *testing.T) {
+	base := newRecordingRepository()
+
+	buffer, err := NewTenantBufferedRepository(base, time.Hour, 0, 0, DurabilityAsync)
+	require.NoError(t, err)
+	defer buffer.Close()
+
+	stats := buffer.Tenant("")
+	require.NoError(t, stats.UpdateAll(types.Gauges{"Alloc": 1}, types.Counters{"PollCount": 2}))
+
+	base.fail = errors.New("connection refused")
+	assert.Error(t, buffer.Flush())
+
+	// Незаписанные изменения возвращены в буфер, более новое значение Gauge не перезаписывается
+	require.NoError(t, stats.UpdateAll(types.Gauges{"Alloc": 5}, types.Counters{"PollCount": 3}))
+
+	gauges, counters := stats.GetAll()
+	assert.Equal(t, map[string]types.Gauge{"Alloc": 5}, gauges)
+	assert.Equal(t, map[string]types.Counter{"PollCount": 5}, counters)
+
+	require.NoError(t, buffer.Flush())
+
+	gauges, counters = base.Tenant("").GetAll()
+	assert.Equal(t, map[string]types.Gauge{"Alloc": 5}, gauges)
+	assert.Equal(t, map[string]types.Counter{"PollCount": 5}, counters)
+}
+
+// blockingRepository Хранилище в памяти, подготовка записи пакета в котором ожидает сигнала release
+type blockingRepository struct {
+	*TenantMemory
+
+	prepared chan struct{}
+	release  chan struct{}
+}
+
+func (r *blockingRepository) PrepareBatch(gauges map[SeriesKey]types.Gauge, counters map[SeriesKey]types.Counter) (BatchWrite, error) {
+	select {
+	case r.prepared <- struct{}{}:
+	default:
+	}
+	<-r.release
+
+	return r.TenantMemory.PrepareBatch(gauges, counters)
+}
+
+func TestTenantBuffered_ReadDuringWrite(t *testing.T) {
+	base := &blockingRepository{
+		TenantMemory: NewTenantMemoryRepository().(*TenantMemory),
+		prepared:     make(chan struct{}, 1),
+		release:      make(chan struct{}),
+	}
+	require.NoError(t, base.Tenant("").UpdateAll(types.Gauges{}, types.Counters{"PollCount": 10}))
+
+	buffer, err := NewTenantBufferedRepository(base, time.Hour, 0, 0, DurabilityAsync)
+	require.NoError(t, err)
+	defer buffer.Close()
+
+	stats := buffer.Tenant("")
+	require.NoError(t, stats.UpdateAll(types.Gauges{"Alloc": 1}, types.Counters{"PollCount": 2}))
+
+	flushed := make(chan error)
+	go func() {
+		flushed <- buffer.Flush()
+	}()
+	<-base.prepared
+
+	// Пока пакет записывается, чтение не блокируется и видит его изменения вместе с новыми
+	require.NoError(t, stats.UpdateAll(types.Gauges{}, types.Counters{"PollCount": 3}))
+
+	gauges, counters := stats.GetAll()
+	assert.Equal(t, map[string]types.Gauge{"Alloc": 1}, gauges)
+	assert.Equal(t, map[string]types.Counter{"PollCount": 15}, counters)
+
+	gauge, err := stats.GetGaugeByKey("Alloc")
+	require.NoError(t, err)
+	assert.Equal(t, types.Gauge(1), gauge)
+
+	close(base.release)
+	require.NoError(t, <-flushed)
+
+	counter, err := stats.GetCounterByKey("PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, types.Counter(15), counter, "committed batch is not counted twice")
+}
+
+func TestTenantBuffered_MaxPending(t *testing.T) {
+	base := newRecordingRepository()
+
+	buffer, err := NewTenantBufferedRepository(base, time.Hour, 0, 2, DurabilityAsync)
+	require.NoError(t, err)
+	defer buffer.Close()
+
+	stats := buffer.Tenant("")
+	require.NoError(t, stats.UpdateAll(types.Gauges{"Alloc": 1}, types.Counters{"PollCount": 2}))
+
+	// Бд недоступна: изменения возвращены в буфер, новые изменения отклоняются до успешной записи
+	base.fail = errors.New("connection refused")
+	assert.Error(t, buffer.Flush())
+	assert.ErrorIs(t, stats.UpdateAll(types.Gauges{"HeapAlloc": 1}, types.Counters{}), ErrBufferFull)
+
+	require.NoError(t, buffer.Flush())
+	require.NoError(t, stats.UpdateAll(types.Gauges{"HeapAlloc": 1}, types.Counters{}))
+
+	gauges, counters := stats.GetAll()
+	assert.Equal(t, map[string]types.Gauge{"Alloc": 1, "HeapAlloc": 1}, gauges)
+	assert.Equal(t, map[string]types.Counter{"PollCount": 2}, counters)
+}
+
+func TestTenantBuffered_Close(t *testing.T) {
+	base := newRecordingRepository()
+
+	buffer, err := NewTenantBufferedRepository(base, time.Hour, 0, 0, DurabilityAsync)
+	require.NoError(t, err)
+
+	require.NoError(t, buffer.Tenant("").UpdateAll(types.Gauges{}, types.Counters{"PollCount": 2}))
+	require.NoError(t, buffer.Close())
+
+	// Накопленные изменения записаны при остановке, после остановки запись выполняется сразу
+	require.NoError(t, buffer.Tenant("").UpdateAll(types.Gauges{}, types.Counters{"PollCount": 3}))
+
+	_, counters := base.Tenant("").GetAll()
+	assert.Equal(t, map[string]types.Counter{"PollCount": 5}, counters)
+	assert.Equal(t, 2, base.batches)
+}
+
+func TestTenantBuffered_Interval(t *testing.T) {
+	base := newRecordingRepository()
+
+	buffer, err := NewTenantBufferedRepository(base, 10*time.Millisecond, 0, 0, DurabilitySync)
+	require.NoError(t, err)
+	defer buffer.Close()
+
+	require.NoError(t, buffer.Tenant("").UpdateAll(types.Gauges{"Alloc": 1}, types.Counters{}))
+
+	gauge, err := base.Tenant("").GetGaugeByKey("Alloc")
+	require.NoError(t, err)
+	assert.Equal(t, types.Gauge(1), gauge)
+}
+
+func TestNewTenantBufferedRepository(t *testing.T) {
+	_, err := NewTenantBufferedRepository(newRecordingRepository(), 0, 0, 0, DurabilitySync)
+	assert.Error(t, err)
+
+	_, err = NewTenantBufferedRepository(newRecordingRepository(), time.Second, 0, 0, "fsync")
+	assert.Error(t, err)
+}
+
+func TestTenantDatabase_WriteBatch(t *testing.T) {
+	db, recorder := openFakeDB(t, 0)
+
+	gauges := map[SeriesKey]types.Gauge{}
+	for i := 0; i < batchRows+1; i++ {
+		gauges[SeriesKey{Tenant: "team-a", Name: fmt.Sprintf("g%04d", i)}] = types.Gauge(i)
+	}
+
+	counters := map[SeriesKey]types.Counter{
+		{Tenant: "team-b", Name: "PollCount"}: 3,
+		{Tenant: "team-a", Name: "PollCount"}: 2,
+	}
+
+	require.NoError(t, NewTenantDatabaseRepository(db).WriteBatch(gauges, counters))
+
+	queries := recorder.queries()
+	require.Len(t, queries, 3)
+	assert.True(t, strings.HasPrefix(queries[0].query, "INSERT INTO gauges (id, tenant, name, value) VALUES ($1, $2, $3, $4), ($5, $6, $7, $8)"))
+	assert.Len(t, queries[0].args, batchRows*4)
+	assert.Len(t, queries[1].args, 4)
+	assert.Equal(t, "g1000", queries[1].args[2])
+
+	assert.Equal(t, "INSERT INTO counters (id, tenant, name, value) VALUES ($1, $2, $3, $4), ($5, $6, $7, $8) ON CONFLICT (tenant, name) DO UPDATE SET value = counters.value + excluded.value", queries[2].query)
+	assert.Equal(t, []driver.Value{"team-a", "PollCount", "2"}, queries[2].args[1:4])
+	assert.Equal(t, []driver.Value{"team-b", "PollCount", "3"}, queries[2].args[5:8])
+	assert.Equal(t, 1, recorder.commits)
+}
+
+func TestStatsDatabase_ReplaceAll(t *testing.T) {
+	db, recorder := openFakeDB(t, 0)
+
+	require.NoError(t, NewTenantDatabaseRepository(db).Tenant("team-a").ReplaceAll(types.Gauges{"Alloc": 1}, types.Counters{"PollCount": 10}))
+
+	// Значения Counter заменяются в той же транзакции, что и Gauge
+	queries := recorder.queries()
+	require.Len(t, queries, 2)
+	assert.Equal(t, "INSERT INTO counters (id, tenant, name, value) VALUES ($1, $2, $3, $4) ON CONFLICT (tenant, name) DO UPDATE SET value = excluded.value", queries[1].query)
+	assert.Equal(t, []driver.Value{"team-a", "PollCount", "10"}, queries[1].args[1:4])
+	assert.Equal(t, 1, recorder.commits)
+}
+
+// BenchmarkStatsDatabase_UpdateAll Текущая запись: транзакция с отдельным INSERT для каждой метрики
+func BenchmarkStatsDatabase_UpdateAll(b *testing.B) {
+	db := benchmarkDB(b)
+	repository := NewTenantDatabaseRepository(db)
+
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			if err := repository.Tenant("").UpdateAll(benchmarkMetrics(i)); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+}
+
+// BenchmarkTenantBuffered_UpdateAll Запись через буфер: sync - запросы ожидают общей записи пакета, async - не ожидают
+func BenchmarkTenantBuffered_UpdateAll(b *testing.B) {
+	for _, durability := range []string{DurabilitySync, DurabilityAsync} {
+		b.Run(durability, func(b *testing.B) {
+			buffer, err := NewTenantBufferedRepository(NewTenantDatabaseRepository(benchmarkDB(b)), 5*time.Millisecond, 1000, 0, durability)
+			require.NoError(b, err)
+
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					if err := buffer.Tenant("").UpdateAll(benchmarkMetrics(i)); err != nil {
+						b.Error(err)
+					}
+				}
+			})
+
+			require.NoError(b, buffer.Close())
+		})
+	}
+}
+
+// benchmarkMetrics Пакет агента: 30 Gauge и 2 Counter из набора в 1000 серий
+func benchmarkMetrics(i int) (types.Gauges, types.Counters) {
+	gauges := make(types.Gauges, 30)
+	for j := 0; j < 30; j++ {
+		gauges[fmt.Sprintf("gauge%d", (i*30+j)%1000)] = types.Gauge(i)
+	}
+
+	return gauges, types.Counters{"PollCount": 1, "Requests": 2}
+}
+
+// benchmarkDB База для бенчмарков: PostgreSQL из BENCHMARK_DATABASE_DSN или имитация с задержкой 100мкс на запрос
+func benchmarkDB(b *testing.B) *sql.DB {
+	if dsn := os.Getenv("BENCHMARK_DATABASE_DSN"); dsn != "" {
+		db, err := sql.Open("pgx", dsn)
+		require.NoError(b, err)
+		b.Cleanup(func() { db.Close() })
+
+		return db
+	}
+
+	db, _ := openFakeDB(b, 100*time.Microsecond)
+
+	return db
+}
+
+// fakeQuery Выполненный запрос
+type fakeQuery struct {
+	query string
+	args  []driver.Value
+}
+
+// fakeRecorder Запросы к имитации бд
+type fakeRecorder struct {
+	mu       sync.Mutex
+	latency  time.Duration // Задержка сети на каждый запрос
+	executed []fakeQuery
+	commits  int
+}
+
+func (r *fakeRecorder) queries() []fakeQuery {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]fakeQuery(nil), r.executed...)
+}
+
+var (
+	fakeMu        sync.Mutex
+	fakeRecorders = map[string]*fakeRecorder{}
+)
+
+func init() {
+	sql.Register("fake", fakeDriver{})
+}
+
+// openFakeDB Имитация бд, которая записывает запросы и отвечает с задержкой latency
+func openFakeDB(tb testing.TB, latency time.Duration) (*sql.DB, *fakeRecorder) {
+	recorder := &fakeRecorder{latency: latency}
+
+	fakeMu.Lock()
+	name := fmt.Sprintf("%s-%d", tb.Name(), len(fakeRecorders))
+	fakeRecorders[name] = recorder
+	fakeMu.Unlock()
+
+	db, err := sql.Open("fake", name)
+	require.NoError(tb, err)
+	tb.Cleanup(func() { db.Close() })
+
+	return db, recorder
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	fakeMu.Lock()
+	defer fakeMu.Unlock()
+
+	return &fakeConn{recorder: fakeRecorders[name]}, nil
+}
+
+type fakeConn struct {
+	recorder *fakeRecorder
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{recorder: c.recorder, query: query}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	time.Sleep(c.recorder.latency)
+
+	return &fakeTx{recorder: c.recorder}, nil
+}
+
+type fakeTx struct {
+	recorder *fakeRecorder
+}
+
+func (t *fakeTx) Commit() error {
+	time.Sleep(t.recorder.latency)
+
+	t.recorder.mu.Lock()
+	t.recorder.commits++
+	t.recorder.mu.Unlock()
+
+	return nil
+}
+
+func (t *fakeTx) Rollback() error {
+	return nil
+}
+
+type fakeStmt struct {
+	recorder *fakeRecorder
+	query    string
+}
+
+func (s *fakeStmt) Close() error {
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	time.Sleep(s.recorder.latency)
+
+	s.recorder.mu.Lock()
+	// Для бенчмарков запросы не сохраняются, чтобы не расходовать память
+	if s.recorder.latency == 0 {
+		s.recorder.executed = append(s.recorder.executed, fakeQuery{query: s.query, args: args})
+	}
+	s.recorder.mu.Unlock()
+
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("fake database does not support queries")
+}
@@ -0,0 +1,319 @@
+package repositories
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/vllvll/devops/internal/types"
+)
+
+// Notifier Оповещение других экземпляров сервера об изменении метрик арендатора
+type Notifier interface {
+	Notify(tenant string)
+}
+
+// cachedTenant Копия метрик арендатора
+type cachedTenant struct {
+	gauges     types.Gauges   // nil - метрики не загружены
+	counters   types.Counters // nil - метрики не загружены
+	generation uint64         // Увеличивается при каждом изменении и сбросе копии
+	writing    int            // Количество выполняющихся записей
+	loaded     time.Time      // Время загрузки копии из base
+}
+
+type TenantCached struct {
+	base     TenantRepository
+	notifier Notifier      // nil - другие экземпляры не оповещаются
+	ttl      time.Duration // Время, после которого копия загружается заново (0 - без ограничения)
+
+	mu      sync.Mutex
+	tenants map[string]*cachedTenant
+	paused  bool // Оповещения не принимаются, поэтому чтение выполняется из base без сохранения копии
+	now     func() time.Time
+}
+
+// NewTenantCachedRepository Создание репозитория, который хранит копию метрик base в оперативной памяти.
+// Запись выполняется в base и в копию, изменения других экземпляров сервера сбрасывают копию через Invalidate.
+// С notifier копия используется после Warm, который вызывается после подписки на оповещения.
+// Изменения в обход сервера (SQL, metricsctl) не оповещаются, поэтому копия старше ttl загружается заново
+func NewTenantCachedRepository(base TenantRepository, notifier Notifier, ttl time.Duration) *TenantCached {
+	return &TenantCached{
+		base:     base,
+		notifier: notifier,
+		ttl:      ttl,
+		tenants:  map[string]*cachedTenant{},
+		paused:   notifier != nil,
+		now:      time.Now,
+	}
+}
+
+// Tenant Получение метрик арендатора из копии
+func (t *TenantCached) Tenant(name string) StatsRepository {
+	return &StatsCached{
+		cache:  t,
+		base:   t.base.Tenant(name),
+		tenant: name,
+	}
+}
+
+// Tenants Получение списка арендаторов
+func (t *TenantCached) Tenants() ([]string, error) {
+	return t.base.Tenants()
+}
+
+// Warm Загрузка метрик всех арендаторов в копию заново
+func (t *TenantCached) Warm() error {
+	names, err := t.base.Tenants()
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	t.paused = false
+	t.invalidateAll()
+	t.mu.Unlock()
+
+	for _, name := range names {
+		t.load(name, t.base.Tenant(name))
+	}
+
+	return nil
+}
+
+// Invalidate Сброс копии метрик арендатора. Метрики будут загружены из base при следующем чтении
+func (t *TenantCached) Invalidate(tenant string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry := t.entry(tenant)
+	entry.generation++
+	entry.gauges, entry.counters = nil, nil
+}
+
+// Pause Сброс копий метрик всех арендаторов и чтение из base до следующего Warm.
+// Вызывается, когда оповещения об изменениях других экземпляров могут быть потеряны
+func (t *TenantCached) Pause() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.paused = true
+	t.invalidateAll()
+}
+
+// invalidateAll Сброс копий метрик всех арендаторов. Вызывается под t.mu
+func (t *TenantCached) invalidateAll() {
+	for _, entry := range t.tenants {
+		entry.generation++
+		entry.gauges, entry.counters = nil, nil
+	}
+}
+
+// entry Копия метрик арендатора. Вызывается под t.mu
+func (t *TenantCached) entry(tenant string) *cachedTenant {
+	entry, ok := t.tenants[tenant]
+	if !ok {
+		entry = &cachedTenant{}
+		t.tenants[tenant] = entry
+	}
+
+	return entry
+}
+
+// load Получение метрик арендатора из копии или из base. Загруженные метрики сохраняются в копию,
+// если во время загрузки не было записей и сбросов, иначе копия могла бы учесть запись дважды
+func (t *TenantCached) load(tenant string, base StatsRepository) (types.Gauges, types.Counters) {
+	t.mu.Lock()
+	entry := t.entry(tenant)
+	if entry.gauges != nil && !t.expired(entry) {
+		defer t.mu.Unlock()
+
+		return entry.gauges, entry.counters
+	}
+
+	generation, writing := entry.generation, entry.writing
+	loaded := t.now()
+	t.mu.Unlock()
+
+	gauges, counters := base.GetAll()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.paused && writing == 0 && entry.generation == generation {
+		entry.gauges, entry.counters = gauges, counters
+		entry.loaded = loaded
+	}
+
+	return gauges, counters
+}
+
+// expired Копия загружена раньше ttl и могла пропустить изменения в обход сервера. Вызывается под t.mu
+func (t *TenantCached) expired(entry *cachedTenant) bool {
+	return t.ttl > 0 && t.now().Sub(entry.loaded) >= t.ttl
+}
+
+// write Запись в base и применение изменения apply к копии. При ошибке копия сбрасывается.
+// Оповещение отправляется после завершения write, поэтому base должен завершать запись только после записи в бд
+// (буфер TenantBuffered - с гарантией DurabilitySync)
+func (t *TenantCached) write(tenant string, write func() error, apply func(entry *cachedTenant)) error {
+	t.mu.Lock()
+	entry := t.entry(tenant)
+	entry.writing++
+	entry.generation++
+	t.mu.Unlock()
+
+	err := write()
+
+	t.mu.Lock()
+	entry.writing--
+	entry.generation++
+	switch {
+	case err != nil:
+		entry.gauges, entry.counters = nil, nil
+	case entry.gauges != nil:
+		apply(entry)
+	}
+	t.mu.Unlock()
+
+	if t.notifier != nil {
+		t.notifier.Notify(tenant)
+	}
+
+	return err
+}
+
+type StatsCached struct {
+	cache  *TenantCached
+	base   StatsRepository // Метрики арендатора в хранилище
+	tenant string
+}
+
+// UpdateGauge Обновить значение метрики с типом Gauge в хранилище и в копии
+func (s *StatsCached) UpdateGauge(key string, value types.Gauge) {
+	s.cache.write(s.tenant, func() error {
+		s.base.UpdateGauge(key, value)
+
+		return nil
+	}, func(entry *cachedTenant) {
+		entry.gauges[key] = value
+	})
+}
+
+// UpdateCount Обновить значение метрики с типом Counter в хранилище и в копии
+func (s *StatsCached) UpdateCount(key string, value types.Counter) {
+	s.cache.write(s.tenant, func() error {
+		s.base.UpdateCount(key, value)
+
+		return nil
+	}, func(entry *cachedTenant) {
+		entry.counters[key] += value
+	})
+}
+
+// UpdateAll Обновление всех значений типов Gauge и Counter в хранилище и в копии
+func (s *StatsCached) UpdateAll(gauges types.Gauges, counters types.Counters) error {
+	return s.cache.write(s.tenant, func() error {
+		return s.base.UpdateAll(gauges, counters)
+	}, func(entry *cachedTenant) {
+		for key, value := range gauges {
+			entry.gauges[key] = value
+		}
+
+		for key, value := range counters {
+			entry.counters[key] += value
+		}
+	})
+}
+
+// ReplaceAll Запись значений Gauge и замена значений Counter в хранилище и в копии
+func (s *StatsCached) ReplaceAll(gauges types.Gauges, counters types.Counters) error {
+	return s.cache.write(s.tenant, func() error {
+		return s.base.ReplaceAll(gauges, counters)
+	}, func(entry *cachedTenant) {
+		for key, value := range gauges {
+			entry.gauges[key] = value
+		}
+
+		for key, value := range counters {
+			entry.counters[key] = value
+		}
+	})
+}
+
+// GetAll Получение всех метрик из копии
+func (s *StatsCached) GetAll() (map[string]types.Gauge, map[string]types.Counter) {
+	gauges, counters := s.cache.load(s.tenant, s.base)
+
+	s.cache.mu.Lock()
+	defer s.cache.mu.Unlock()
+
+	gaugesCopy := make(map[string]types.Gauge, len(gauges))
+	for key, value := range gauges {
+		gaugesCopy[key] = value
+	}
+
+	countersCopy := make(map[string]types.Counter, len(counters))
+	for key, value := range counters {
+		countersCopy[key] = value
+	}
+
+	return gaugesCopy, countersCopy
+}
+
+// GetGaugeByKey Получить значение метрики типа Gauge по ключу из копии
+func (s *StatsCached) GetGaugeByKey(key string) (types.Gauge, error) {
+	gauges, _ := s.cache.load(s.tenant, s.base)
+
+	s.cache.mu.Lock()
+	defer s.cache.mu.Unlock()
+
+	value, ok := gauges[key]
+	if !ok {
+		return types.Gauge(0), fmt.Errorf("%s key doesn't exists", key)
+	}
+
+	return value, nil
+}
+
+// GetCounterByKey Получить значение метрики типа Counter по ключу из копии
+func (s *StatsCached) GetCounterByKey(key string) (types.Counter, error) {
+	_, counters := s.cache.load(s.tenant, s.base)
+
+	s.cache.mu.Lock()
+	defer s.cache.mu.Unlock()
+
+	value, ok := counters[key]
+	if !ok {
+		return types.Counter(0), fmt.Errorf("%s key doesn't exists", key)
+	}
+
+	return value, nil
+}
+
+// DeleteGauge Удаление метрики типа Gauge из хранилища и из копии
+func (s *StatsCached) DeleteGauge(key string) error {
+	return s.cache.write(s.tenant, func() error {
+		return s.base.DeleteGauge(key)
+	}, func(entry *cachedTenant) {
+		delete(entry.gauges, key)
+	})
+}
+
+// DeleteCounter Удаление метрики типа Counter из хранилища и из копии
+func (s *StatsCached) DeleteCounter(key string) error {
+	return s.cache.write(s.tenant, func() error {
+		return s.base.DeleteCounter(key)
+	}, func(entry *cachedTenant) {
+		delete(entry.counters, key)
+	})
+}
+
+// ResetCounter Сброс значения метрики типа Counter в хранилище и в копии
+func (s *StatsCached) ResetCounter(key string) error {
+	return s.cache.write(s.tenant, func() error {
+		return s.base.ResetCounter(key)
+	}, func(entry *cachedTenant) {
+		entry.counters[key] = 0
+	})
+}
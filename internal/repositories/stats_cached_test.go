@@ -0,0 +1,202 @@
+package repositories
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vllvll/devops/internal/types"
+)
+
+// countingRepository Хранилище в памяти, которое считает чтения всех метрик и может задерживать запись
+type countingRepository struct {
+	TenantRepository
+
+	mu    sync.Mutex
+	reads int
+	block chan struct{} // Запись ожидает закрытия канала после изменения метрик
+}
+
+func (c *countingRepository) Tenant(name string) StatsRepository {
+	return &countingStats{StatsRepository: c.TenantRepository.Tenant(name), repository: c}
+}
+
+func (c *countingRepository) readCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.reads
+}
+
+type countingStats struct {
+	StatsRepository
+
+	repository *countingRepository
+}
+
+func (c *countingStats) GetAll() (map[string]types.Gauge, map[string]types.Counter) {
+	c.repository.mu.Lock()
+	c.repository.reads++
+	c.repository.mu.Unlock()
+
+	return c.StatsRepository.GetAll()
+}
+
+func (c *countingStats) UpdateAll(gauges types.Gauges, counters types.Counters) error {
+	err := c.StatsRepository.UpdateAll(gauges, counters)
+
+	if c.repository.block != nil {
+		<-c.repository.block
+	}
+
+	return err
+}
+
+// recordingNotifier Оповещения об изменениях
+type recordingNotifier struct {
+	mu      sync.Mutex
+	tenants []string
+}
+
+func (r *recordingNotifier) Notify(tenant string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.tenants = append(r.tenants, tenant)
+}
+
+func TestTenantCached(t *testing.T) {
+	base := &countingRepository{TenantRepository: NewTenantMemoryRepository()}
+	require.NoError(t, base.Tenant("team-a").UpdateAll(types.Gauges{"Alloc": 1}, types.Counters{"PollCount": 10}))
+
+	notifier := &recordingNotifier{}
+	cache := NewTenantCachedRepository(base, notifier, 0)
+	require.NoError(t, cache.Warm())
+	assert.Equal(t, 1, base.readCount())
+
+	stats := cache.Tenant("team-a")
+
+	// Чтение из копии без обращения к хранилищу
+	gauge, err := stats.GetGaugeByKey("Alloc")
+	require.NoError(t, err)
+	assert.Equal(t, types.Gauge(1), gauge)
+
+	_, err = stats.GetCounterByKey("Unknown")
+	assert.Error(t, err)
+
+	require.NoError(t, stats.UpdateAll(types.Gauges{"Alloc": 2}, types.Counters{"PollCount": 5}))
+	stats.UpdateCount("PollCount", 1)
+	require.NoError(t, stats.DeleteGauge("Alloc"))
+
+	gauges, counters := stats.GetAll()
+	assert.Equal(t, map[string]types.Gauge{}, gauges)
+	assert.Equal(t, map[string]types.Counter{"PollCount": 16}, counters)
+	assert.Equal(t, 1, base.readCount())
+
+	gauges, counters = base.Tenant("team-a").GetAll()
+	assert.Equal(t, map[string]types.Gauge{}, gauges)
+	assert.Equal(t, map[string]types.Counter{"PollCount": 16}, counters)
+	assert.Equal(t, []string{"team-a", "team-a", "team-a"}, notifier.tenants)
+
+	// Изменение другого экземпляра видно после оповещения
+	base.TenantRepository.Tenant("team-a").UpdateCount("PollCount", 4)
+
+	counter, err := stats.GetCounterByKey("PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, types.Counter(16), counter)
+
+	cache.Invalidate("team-a")
+
+	counter, err = stats.GetCounterByKey("PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, types.Counter(20), counter)
+}
+
+func TestTenantCached_TTL(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	base := &countingRepository{TenantRepository: NewTenantMemoryRepository()}
+	require.NoError(t, base.Tenant("").UpdateAll(types.Gauges{}, types.Counters{"PollCount": 1}))
+
+	cache := NewTenantCachedRepository(base, &recordingNotifier{}, time.Minute)
+	cache.now = func() time.Time { return now }
+	require.NoError(t, cache.Warm())
+
+	// Изменение в обход сервера без оповещения
+	base.TenantRepository.Tenant("").UpdateCount("PollCount", 4)
+
+	now = now.Add(30 * time.Second)
+	counter, err := cache.Tenant("").GetCounterByKey("PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, types.Counter(1), counter)
+
+	now = now.Add(30 * time.Second)
+	counter, err = cache.Tenant("").GetCounterByKey("PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, types.Counter(5), counter, "expired copy is loaded again")
+	assert.Equal(t, 2, base.readCount())
+}
+
+func TestTenantCached_Pause(t *testing.T) {
+	base := &countingRepository{TenantRepository: NewTenantMemoryRepository()}
+
+	// С оповещениями копия не используется до подписки и Warm
+	cache := NewTenantCachedRepository(base, &recordingNotifier{}, 0)
+
+	cache.Tenant("").GetAll()
+	cache.Tenant("").GetAll()
+	assert.Equal(t, 2, base.readCount())
+
+	require.NoError(t, cache.Warm())
+	cache.Tenant("").GetAll()
+	assert.Equal(t, 3, base.readCount())
+
+	cache.Pause()
+	cache.Tenant("").GetAll()
+	cache.Tenant("").GetAll()
+	assert.Equal(t, 5, base.readCount())
+}
+
+func TestTenantCached_LoadDuringWrite(t *testing.T) {
+	base := &countingRepository{TenantRepository: NewTenantMemoryRepository(), block: make(chan struct{})}
+	cache := NewTenantCachedRepository(base, nil, 0)
+
+	done := make(chan error)
+	go func() {
+		done <- cache.Tenant("").UpdateAll(types.Gauges{}, types.Counters{"PollCount": 5})
+	}()
+
+	// Запись уже применена в хранилище, но еще не завершена: загруженные метрики не сохраняются в копию
+	require.Eventually(t, func() bool {
+		_, counters := cache.Tenant("").GetAll()
+
+		return counters["PollCount"] == 5
+	}, time.Second, time.Millisecond)
+
+	close(base.block)
+	require.NoError(t, <-done)
+
+	_, counters := cache.Tenant("").GetAll()
+	assert.Equal(t, map[string]types.Counter{"PollCount": 5}, counters)
+}
+
+func TestDatabaseNotifier_parse(t *testing.T) {
+	notifier := &DatabaseNotifier{instance: "instance-a"}
+
+	tenant, ok := notifier.parse("instance-b:team-a")
+	assert.True(t, ok)
+	assert.Equal(t, "team-a", tenant)
+
+	tenant, ok = notifier.parse("instance-b:")
+	assert.True(t, ok)
+	assert.Equal(t, "", tenant)
+
+	_, ok = notifier.parse("instance-a:team-a")
+	assert.False(t, ok)
+
+	_, ok = notifier.parse("team-a")
+	assert.False(t, ok)
+}
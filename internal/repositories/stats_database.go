@@ -3,15 +3,16 @@ package repositories
 import (
 	"database/sql"
 	"fmt"
-	"log"
 
 	"github.com/gofrs/uuid"
+	"go.uber.org/zap"
 
 	"github.com/vllvll/devops/internal/types"
 )
 
 type StatsDatabase struct {
-	db *sql.DB
+	db     *sql.DB
+	tenant string // Арендатор, метрики которого читаются и записываются
 }
 
 // NewStatsDatabaseRepository Создание репозитория, который отвечает за работу с бд
@@ -26,22 +27,23 @@ func (s *StatsDatabase) UpdateGauge(key string, value types.Gauge) {
 	id, _ := uuid.NewV4()
 
 	result, err := s.db.Exec(
-		"INSERT INTO gauges (id, name, value) VALUES ($1, $2, $3) ON CONFLICT (name) DO UPDATE SET value = excluded.value",
+		"INSERT INTO gauges (id, tenant, name, value) VALUES ($1, $2, $3, $4) ON CONFLICT (tenant, name) DO UPDATE SET value = excluded.value",
 		id.String(),
+		s.tenant,
 		key,
 		value,
 	)
 	if err != nil {
-		log.Fatalf("Error with update gauge result: %v", err)
+		zap.S().Fatalf("Error with update gauge result: %v", err)
 	}
 
 	rows, err := result.RowsAffected()
 	if err != nil {
-		log.Fatalf("Error with affected gauge rows: %v", err)
+		zap.S().Fatalf("Error with affected gauge rows: %v", err)
 	}
 
 	if rows != 1 {
-		log.Fatalf("Error with expected single row affected, got %d rows affected", rows)
+		zap.S().Fatalf("Error with expected single row affected, got %d rows affected", rows)
 	}
 }
 
@@ -50,22 +52,23 @@ func (s *StatsDatabase) UpdateCount(key string, value types.Counter) {
 	id, _ := uuid.NewV4()
 
 	result, err := s.db.Exec(
-		"INSERT INTO counters (id, name, value) VALUES ($1, $2, $3) ON CONFLICT (name) DO UPDATE SET value = counters.value + excluded.value;",
+		"INSERT INTO counters (id, tenant, name, value) VALUES ($1, $2, $3, $4) ON CONFLICT (tenant, name) DO UPDATE SET value = counters.value + excluded.value;",
 		id.String(),
+		s.tenant,
 		key,
 		value,
 	)
 	if err != nil {
-		log.Fatalf("Error with update counter result: %v", err)
+		zap.S().Fatalf("Error with update counter result: %v", err)
 	}
 
 	rows, err := result.RowsAffected()
 	if err != nil {
-		log.Fatalf("Error with affected counter rows: %v", err)
+		zap.S().Fatalf("Error with affected counter rows: %v", err)
 	}
 
 	if rows != 1 {
-		log.Fatalf("Error with expected single row affected, got %d rows affected", rows)
+		zap.S().Fatalf("Error with expected single row affected, got %d rows affected", rows)
 	}
 }
 
@@ -78,7 +81,7 @@ func (s *StatsDatabase) GetAll() (map[string]types.Gauge, map[string]types.Count
 func (s *StatsDatabase) GetGaugeByKey(key string) (types.Gauge, error) {
 	var gauge types.Gauge
 
-	row := s.db.QueryRow("SELECT value FROM gauges WHERE name = $1 LIMIT 1", key)
+	row := s.db.QueryRow("SELECT value FROM gauges WHERE tenant = $1 AND name = $2 LIMIT 1", s.tenant, key)
 	err := row.Scan(&gauge)
 	if err != nil {
 		return types.Gauge(0), fmt.Errorf("%s key doesn't exists", key)
@@ -91,7 +94,7 @@ func (s *StatsDatabase) GetGaugeByKey(key string) (types.Gauge, error) {
 func (s *StatsDatabase) GetCounterByKey(key string) (types.Counter, error) {
 	var counter types.Counter
 
-	row := s.db.QueryRow("SELECT value FROM counters WHERE name = $1 LIMIT 1", key)
+	row := s.db.QueryRow("SELECT value FROM counters WHERE tenant = $1 AND name = $2 LIMIT 1", s.tenant, key)
 	err := row.Scan(&counter)
 	if err != nil {
 		return types.Counter(0), fmt.Errorf("%s key doesn't exists", key)
@@ -104,19 +107,19 @@ func (s *StatsDatabase) GetCounterByKey(key string) (types.Counter, error) {
 func (s *StatsDatabase) UpdateAll(gauges types.Gauges, counters types.Counters) error {
 	tx, err := s.db.Begin()
 	if err != nil {
-		log.Printf("Error with open transaction: %v\n", err)
+		zap.S().Errorf("Error with open transaction: %v", err)
 
 		return err
 	}
 
-	stmtGauges, err := tx.Prepare("INSERT INTO gauges (id, name, value) VALUES ($1, $2, $3) ON CONFLICT (name) DO UPDATE SET value = excluded.value")
+	stmtGauges, err := tx.Prepare("INSERT INTO gauges (id, tenant, name, value) VALUES ($1, $2, $3, $4) ON CONFLICT (tenant, name) DO UPDATE SET value = excluded.value")
 	if err != nil {
-		log.Printf("Error with create prepared statement for gauge: %v\n", err)
+		zap.S().Errorf("Error with create prepared statement for gauge: %v", err)
 
 		return err
 	}
 
-	stmtCounters, err := tx.Prepare("INSERT INTO counters (id, name, value) VALUES ($1, $2, $3) ON CONFLICT (name) DO UPDATE SET value = counters.value + excluded.value")
+	stmtCounters, err := tx.Prepare("INSERT INTO counters (id, tenant, name, value) VALUES ($1, $2, $3, $4) ON CONFLICT (tenant, name) DO UPDATE SET value = counters.value + excluded.value")
 	if err != nil {
 		return err
 	}
@@ -124,9 +127,9 @@ func (s *StatsDatabase) UpdateAll(gauges types.Gauges, counters types.Counters)
 	for key, value := range gauges {
 		id, _ := uuid.NewV4()
 
-		if _, err = stmtGauges.Exec(id.String(), key, value); err != nil {
+		if _, err = stmtGauges.Exec(id.String(), s.tenant, key, value); err != nil {
 			if err = tx.Rollback(); err != nil {
-				log.Fatalf("Error with unable to rollback: %v", err)
+				zap.S().Fatalf("Error with unable to rollback: %v", err)
 			}
 
 			return err
@@ -136,9 +139,9 @@ func (s *StatsDatabase) UpdateAll(gauges types.Gauges, counters types.Counters)
 	for key, value := range counters {
 		id, _ := uuid.NewV4()
 
-		if _, err = stmtCounters.Exec(id.String(), key, value); err != nil {
+		if _, err = stmtCounters.Exec(id.String(), s.tenant, key, value); err != nil {
 			if err = tx.Rollback(); err != nil {
-				log.Fatalf("Error with unable to rollback: %v", err)
+				zap.S().Fatalf("Error with unable to rollback: %v", err)
 			}
 
 			return err
@@ -146,7 +149,56 @@ func (s *StatsDatabase) UpdateAll(gauges types.Gauges, counters types.Counters)
 	}
 
 	if err := tx.Commit(); err != nil {
-		log.Fatalf("Erro with unable to commit: %v", err)
+		zap.S().Fatalf("Erro with unable to commit: %v", err)
+	}
+
+	return nil
+}
+
+// ReplaceAll Запись значений Gauge и замена значений Counter в бд в одной транзакции
+func (s *StatsDatabase) ReplaceAll(gauges types.Gauges, counters types.Counters) error {
+	tenantGauges := make(map[SeriesKey]types.Gauge, len(gauges))
+	for key, value := range gauges {
+		tenantGauges[SeriesKey{Tenant: s.tenant, Name: key}] = value
+	}
+
+	tenantCounters := make(map[SeriesKey]types.Counter, len(counters))
+	for key, value := range counters {
+		tenantCounters[SeriesKey{Tenant: s.tenant, Name: key}] = value
+	}
+
+	return writeRows(s.db, tenantGauges, tenantCounters, "excluded.value")
+}
+
+// DeleteGauge Удаление метрики типа Gauge из бд
+func (s *StatsDatabase) DeleteGauge(key string) error {
+	return s.execSingle("DELETE FROM gauges WHERE tenant = $1 AND name = $2", key)
+}
+
+// DeleteCounter Удаление метрики типа Counter из бд
+func (s *StatsDatabase) DeleteCounter(key string) error {
+	return s.execSingle("DELETE FROM counters WHERE tenant = $1 AND name = $2", key)
+}
+
+// ResetCounter Сброс значения метрики типа Counter в бд
+func (s *StatsDatabase) ResetCounter(key string) error {
+	return s.execSingle("UPDATE counters SET value = 0 WHERE tenant = $1 AND name = $2", key)
+}
+
+// execSingle Выполнение запроса, который должен затронуть одну строку с ключом key
+func (s *StatsDatabase) execSingle(query string, key string) error {
+	result, err := s.db.Exec(query, s.tenant, key)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return fmt.Errorf("%s key doesn't exists", key)
 	}
 
 	return nil
@@ -156,17 +208,17 @@ func (s *StatsDatabase) UpdateAll(gauges types.Gauges, counters types.Counters)
 func (s *StatsDatabase) getGauges() map[string]types.Gauge {
 	var gaugeCount int64
 
-	row := s.db.QueryRow("SELECT COUNT(*) as count FROM gauges")
+	row := s.db.QueryRow("SELECT COUNT(*) as count FROM gauges WHERE tenant = $1", s.tenant)
 	err := row.Scan(&gaugeCount)
 	if err != nil {
-		log.Fatalf("Error with get gauge count: %v", err)
+		zap.S().Fatalf("Error with get gauge count: %v", err)
 	}
 
 	gauges := make(map[string]types.Gauge, gaugeCount)
 
-	rows, err := s.db.Query("SELECT name, value FROM gauges")
+	rows, err := s.db.Query("SELECT name, value FROM gauges WHERE tenant = $1", s.tenant)
 	if err != nil || rows.Err() != nil {
-		log.Fatalf("Error with get gauge name and value: %v", err)
+		zap.S().Fatalf("Error with get gauge name and value: %v", err)
 	}
 	defer rows.Close()
 
@@ -176,7 +228,7 @@ func (s *StatsDatabase) getGauges() map[string]types.Gauge {
 
 		err = rows.Scan(&name, &value)
 		if err != nil {
-			log.Fatalf("Error with scan gauge: %v", err)
+			zap.S().Fatalf("Error with scan gauge: %v", err)
 		}
 
 		gauges[name] = value
@@ -189,17 +241,17 @@ func (s *StatsDatabase) getGauges() map[string]types.Gauge {
 func (s *StatsDatabase) getCounters() map[string]types.Counter {
 	var counterCount int64
 
-	row := s.db.QueryRow("SELECT COUNT(*) as count FROM counters")
+	row := s.db.QueryRow("SELECT COUNT(*) as count FROM counters WHERE tenant = $1", s.tenant)
 	err := row.Scan(&counterCount)
 	if err != nil {
-		log.Fatalf("Error with get counter count: %v", err)
+		zap.S().Fatalf("Error with get counter count: %v", err)
 	}
 
 	counters := make(map[string]types.Counter, counterCount)
 
-	rows, err := s.db.Query("SELECT name, value FROM counters")
+	rows, err := s.db.Query("SELECT name, value FROM counters WHERE tenant = $1", s.tenant)
 	if err != nil || rows.Err() != nil {
-		log.Fatalf("Error with get counter name and value: %v", err)
+		zap.S().Fatalf("Error with get counter name and value: %v", err)
 	}
 	defer rows.Close()
 
@@ -209,7 +261,7 @@ func (s *StatsDatabase) getCounters() map[string]types.Counter {
 
 		err = rows.Scan(&name, &value)
 		if err != nil {
-			log.Fatalf("Error with scan counter: %v", err)
+			zap.S().Fatalf("Error with scan counter: %v", err)
 		}
 
 		counters[name] = value
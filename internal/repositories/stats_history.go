@@ -0,0 +1,208 @@
+package repositories
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/vllvll/devops/internal/dictionaries"
+	"github.com/vllvll/devops/internal/types"
+)
+
+// Sample Значение метрики в момент времени. Для агрегированных уровней - значение за интервал, начинающийся в Time
+type Sample struct {
+	Time      time.Time  `json:"time"`
+	Value     float64    `json:"value"`
+	Aggregate *Aggregate `json:"aggregate,omitempty"` // Агрегированные значения (nil - сырое значение)
+}
+
+// historyLevel Значения одного уровня хранения
+type historyLevel struct {
+	Gauges   map[string][]Sample `json:"gauges"`   // История значений Gauge
+	Counters map[string][]Sample `json:"counters"` // История значений Counter
+}
+
+type StatsHistory struct {
+	mu     sync.RWMutex
+	tiers  []Tier          // Уровни хранения
+	levels []*historyLevel // Значения каждого уровня хранения
+	latest time.Time       // Время последнего сохранения значений
+}
+
+type HistoryRepository interface {
+	Record(at time.Time, gauges types.Gauges, counters types.Counters)
+	Range(mType string, key string, from time.Time, to time.Time) []Sample
+	RangeAll(mType string, from time.Time, to time.Time) map[string][]Sample
+	Compact(at time.Time) error
+}
+
+// NewStatsHistoryRepository Создание репозитория, который хранит историю значений метрик в оперативной памяти
+// с уровнями хранения tiers
+func NewStatsHistoryRepository(tiers []Tier) HistoryRepository {
+	return newStatsHistory(tiers)
+}
+
+func newStatsHistory(tiers []Tier) *StatsHistory {
+	levels := make([]*historyLevel, len(tiers))
+	for i := range levels {
+		levels[i] = &historyLevel{
+			Gauges:   map[string][]Sample{},
+			Counters: map[string][]Sample{},
+		}
+	}
+
+	return &StatsHistory{
+		tiers:  tiers,
+		levels: levels,
+	}
+}
+
+// Record Сохранение текущих значений метрик и удаление устаревших сырых значений
+func (s *StatsHistory) Record(at time.Time, gauges types.Gauges, counters types.Counters) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	raw := s.levels[0]
+
+	for key, value := range gauges {
+		raw.Gauges[key] = append(raw.Gauges[key], Sample{Time: at, Value: float64(value)})
+	}
+
+	for key, value := range counters {
+		raw.Counters[key] = append(raw.Counters[key], Sample{Time: at, Value: float64(value)})
+	}
+
+	if at.After(s.latest) {
+		s.latest = at
+	}
+
+	expired := at.Add(-s.tiers[0].Retention)
+	trimSamples(raw.Gauges, expired)
+	trimSamples(raw.Counters, expired)
+}
+
+// Range Получение значений метрики за период [from, to]. Уровень хранения выбирается по началу периода
+func (s *StatsHistory) Range(mType string, key string, from time.Time, to time.Time) []Sample {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return rangeTiers(s.tiers, from, to, s.latest, func(level int, from time.Time, to time.Time) []Sample {
+		return samplesBetween(s.series(level, mType)[key], from, to)
+	})
+}
+
+// RangeAll Получение значений всех метрик типа mType за период [from, to]
+func (s *StatsHistory) RangeAll(mType string, from time.Time, to time.Time) map[string][]Sample {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return rangeAllTiers(s.tiers, from, to, s.latest, func(level int, from time.Time, to time.Time) map[string][]Sample {
+		return s.series(level, mType)
+	})
+}
+
+// Compact Агрегация значений каждого уровня в следующий уровень и удаление устаревших значений
+func (s *StatsHistory) Compact(at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for level := 1; level < len(s.tiers); level++ {
+		for _, mType := range []string{dictionaries.GaugeType, dictionaries.CounterType} {
+			source := s.series(level-1, mType)
+			target := s.series(level, mType)
+
+			for key, samples := range source {
+				// Агрегируются только полностью закончившиеся интервалы, поэтому уже агрегированные не пересчитываются
+				var since time.Time
+				if compacted := target[key]; len(compacted) > 0 {
+					since = compacted[len(compacted)-1].Time.Add(s.tiers[level].Resolution)
+				}
+
+				if fresh := compactSamples(mType, samples, s.tiers[level].Resolution, since, at); len(fresh) > 0 {
+					target[key] = append(target[key], fresh...)
+				}
+			}
+		}
+	}
+
+	for level, tier := range s.tiers {
+		expired := at.Add(-tier.Retention)
+		trimSamples(s.levels[level].Gauges, expired)
+		trimSamples(s.levels[level].Counters, expired)
+	}
+
+	return nil
+}
+
+// MarshalJSON Сохранение истории всех уровней в JSON
+func (s *StatsHistory) MarshalJSON() ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return json.Marshal(s.levels)
+}
+
+// UnmarshalJSON Восстановление истории из JSON. Уровни, которых нет в текущей конфигурации, пропускаются
+func (s *StatsHistory) UnmarshalJSON(data []byte) error {
+	var levels []*historyLevel
+	if err := json.Unmarshal(data, &levels); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, level := range levels {
+		if i >= len(s.levels) || level == nil {
+			break
+		}
+
+		if level.Gauges != nil {
+			s.levels[i].Gauges = level.Gauges
+		}
+
+		if level.Counters != nil {
+			s.levels[i].Counters = level.Counters
+		}
+	}
+
+	for _, series := range []map[string][]Sample{s.levels[0].Gauges, s.levels[0].Counters} {
+		for _, samples := range series {
+			if n := len(samples); n > 0 && samples[n-1].Time.After(s.latest) {
+				s.latest = samples[n-1].Time
+			}
+		}
+	}
+
+	return nil
+}
+
+// series Значения уровня level для типа mType
+func (s *StatsHistory) series(level int, mType string) map[string][]Sample {
+	switch mType {
+	case dictionaries.GaugeType:
+		return s.levels[level].Gauges
+	case dictionaries.CounterType:
+		return s.levels[level].Counters
+	}
+
+	return nil
+}
+
+// trimSamples Удаление значений старше expired
+func trimSamples(series map[string][]Sample, expired time.Time) {
+	for key, samples := range series {
+		i := 0
+		for i < len(samples) && samples[i].Time.Before(expired) {
+			i++
+		}
+
+		if i == len(samples) {
+			delete(series, key)
+
+			continue
+		}
+
+		series[key] = samples[i:]
+	}
+}
@@ -0,0 +1,237 @@
+package repositories
+
+import (
+	"database/sql"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/vllvll/devops/internal/dictionaries"
+	"github.com/vllvll/devops/internal/types"
+)
+
+// compactQuery Агрегация значений уровня $3 в уровень с интервалом $2 секунд для арендатора $1.
+// Агрегируются только интервалы, которые закончились к $4 и еще не были агрегированы.
+// Прирост Counter для сырых значений считается от предыдущего значения, уменьшение считается сбросом счетчика
+const compactQuery = `
+	INSERT INTO history (tenant, mtype, name, resolution, ts, value, min, max, avg, last, sum, count)
+	SELECT tenant, mtype, name, $2::bigint, bucket,
+		CASE WHEN mtype = 'counter' THEN last ELSE avg END, min, max, avg, last, sum, count
+	FROM (
+		SELECT tenant, mtype, name, bucket,
+			min(min) AS min,
+			max(max) AS max,
+			sum(avg * count) / sum(count) AS avg,
+			(array_agg(last ORDER BY ts DESC))[1] AS last,
+			sum(increase) AS sum,
+			sum(count) AS count
+		FROM (
+			SELECT source.*,
+				to_timestamp(floor(extract(epoch FROM ts) / $2::bigint) * $2::bigint) AS bucket,
+				CASE
+					WHEN mtype <> 'counter' THEN 0
+					WHEN resolution > 0 THEN sum
+					WHEN prev IS NULL THEN 0
+					WHEN last >= prev THEN last - prev
+					ELSE last
+				END AS increase
+			FROM (
+				SELECT *, lag(last) OVER (PARTITION BY mtype, name ORDER BY ts) AS prev
+				FROM history
+				WHERE tenant = $1 AND resolution = $3
+			) source
+		) buckets
+		WHERE bucket < to_timestamp(floor(extract(epoch FROM $4::timestamptz) / $2::bigint) * $2::bigint)
+			AND bucket >= COALESCE((
+				SELECT max(target.ts) + make_interval(secs => $2::bigint)
+				FROM history target
+				WHERE target.tenant = buckets.tenant
+					AND target.mtype = buckets.mtype
+					AND target.name = buckets.name
+					AND target.resolution = $2::bigint
+			), '-infinity')
+		GROUP BY tenant, mtype, name, bucket
+	) aggregated
+	ON CONFLICT (tenant, mtype, name, resolution, ts) DO NOTHING`
+
+type StatsHistoryDatabase struct {
+	db     *sql.DB
+	tenant string // Арендатор, история которого читается и записывается
+	tiers  []Tier // Уровни хранения
+}
+
+// NewStatsHistoryDatabaseRepository Создание репозитория, который хранит историю значений метрик в бд
+// с уровнями хранения tiers
+func NewStatsHistoryDatabaseRepository(db *sql.DB, tiers []Tier) HistoryRepository {
+	return &StatsHistoryDatabase{
+		db:    db,
+		tiers: tiers,
+	}
+}
+
+// Record Сохранение текущих значений метрик в бд
+func (s *StatsHistoryDatabase) Record(at time.Time, gauges types.Gauges, counters types.Counters) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		zap.S().Errorf("Error with open transaction: %v", err)
+
+		return
+	}
+
+	stmt, err := tx.Prepare("INSERT INTO history (tenant, mtype, name, resolution, ts, value, min, max, avg, last) VALUES ($1, $2, $3, 0, $4, $5, $5, $5, $5, $5) ON CONFLICT (tenant, mtype, name, resolution, ts) DO NOTHING")
+	if err != nil {
+		zap.S().Errorf("Error with create prepared statement for history: %v", err)
+		_ = tx.Rollback()
+
+		return
+	}
+
+	for key, value := range gauges {
+		if _, err = stmt.Exec(s.tenant, dictionaries.GaugeType, key, at, float64(value)); err != nil {
+			zap.S().Errorf("Error with record gauge history: %v", err)
+			_ = tx.Rollback()
+
+			return
+		}
+	}
+
+	for key, value := range counters {
+		if _, err = stmt.Exec(s.tenant, dictionaries.CounterType, key, at, float64(value)); err != nil {
+			zap.S().Errorf("Error with record counter history: %v", err)
+			_ = tx.Rollback()
+
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		zap.S().Errorf("Error with commit history: %v", err)
+	}
+}
+
+// Range Получение значений метрики за период [from, to] из бд. Уровень хранения выбирается по началу периода
+func (s *StatsHistoryDatabase) Range(mType string, key string, from time.Time, to time.Time) []Sample {
+	return rangeTiers(s.tiers, from, to, time.Now(), func(level int, from time.Time, to time.Time) []Sample {
+		rows, err := s.db.Query(
+			"SELECT ts, value, min, max, avg, last, sum, count FROM history WHERE tenant = $1 AND mtype = $2 AND name = $3 AND resolution = $4 AND ts BETWEEN $5 AND $6 ORDER BY ts",
+			s.tenant,
+			mType,
+			key,
+			resolutionSeconds(s.tiers[level]),
+			from,
+			to,
+		)
+		if err != nil {
+			zap.S().Errorf("Error with get history: %v", err)
+
+			return nil
+		}
+		defer rows.Close()
+
+		var samples []Sample
+
+		for rows.Next() {
+			var sample Sample
+			var aggregate Aggregate
+
+			err = rows.Scan(&sample.Time, &sample.Value, &aggregate.Min, &aggregate.Max, &aggregate.Avg, &aggregate.Last, &aggregate.Sum, &aggregate.Count)
+			if err != nil {
+				zap.S().Errorf("Error with scan history: %v", err)
+
+				return nil
+			}
+
+			if level > 0 {
+				sample.Aggregate = &aggregate
+			}
+
+			samples = append(samples, sample)
+		}
+
+		if err := rows.Err(); err != nil {
+			zap.S().Errorf("Error with get history: %v", err)
+
+			return nil
+		}
+
+		return samples
+	})
+}
+
+// RangeAll Получение значений всех метрик типа mType за период [from, to] из бд одним запросом на уровень хранения
+func (s *StatsHistoryDatabase) RangeAll(mType string, from time.Time, to time.Time) map[string][]Sample {
+	return rangeAllTiers(s.tiers, from, to, time.Now(), func(level int, from time.Time, to time.Time) map[string][]Sample {
+		rows, err := s.db.Query(
+			"SELECT name, ts, value, min, max, avg, last, sum, count FROM history WHERE tenant = $1 AND mtype = $2 AND resolution = $3 AND ts BETWEEN $4 AND $5 ORDER BY name, ts",
+			s.tenant,
+			mType,
+			resolutionSeconds(s.tiers[level]),
+			from,
+			to,
+		)
+		if err != nil {
+			zap.S().Errorf("Error with get history: %v", err)
+
+			return nil
+		}
+		defer rows.Close()
+
+		series := map[string][]Sample{}
+
+		for rows.Next() {
+			var name string
+			var sample Sample
+			var aggregate Aggregate
+
+			err = rows.Scan(&name, &sample.Time, &sample.Value, &aggregate.Min, &aggregate.Max, &aggregate.Avg, &aggregate.Last, &aggregate.Sum, &aggregate.Count)
+			if err != nil {
+				zap.S().Errorf("Error with scan history: %v", err)
+
+				return nil
+			}
+
+			if level > 0 {
+				sample.Aggregate = &aggregate
+			}
+
+			series[name] = append(series[name], sample)
+		}
+
+		if err := rows.Err(); err != nil {
+			zap.S().Errorf("Error with get history: %v", err)
+
+			return nil
+		}
+
+		return series
+	})
+}
+
+// Compact Агрегация значений каждого уровня в следующий уровень и удаление устаревших значений в бд
+func (s *StatsHistoryDatabase) Compact(at time.Time) error {
+	for level := 1; level < len(s.tiers); level++ {
+		_, err := s.db.Exec(compactQuery, s.tenant, resolutionSeconds(s.tiers[level]), resolutionSeconds(s.tiers[level-1]), at)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, tier := range s.tiers {
+		_, err := s.db.Exec(
+			"DELETE FROM history WHERE tenant = $1 AND resolution = $2 AND ts < $3",
+			s.tenant,
+			resolutionSeconds(tier),
+			at.Add(-tier.Retention),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// resolutionSeconds Интервал уровня хранения в секундах для бд (0 - сырые значения)
+func resolutionSeconds(tier Tier) int64 {
+	return int64(tier.Resolution / time.Second)
+}
@@ -0,0 +1,181 @@
+package repositories
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vllvll/devops/internal/dictionaries"
+	"github.com/vllvll/devops/internal/types"
+)
+
+func TestParseTiers(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []Tier
+		wantErr bool
+	}{
+		{
+			name:  "empty",
+			value: "",
+			want:  []Tier{{Retention: time.Hour}},
+		},
+		{
+			name:  "tiers",
+			value: "raw:6h, 1m:7d, 1h:90d",
+			want: []Tier{
+				{Retention: 6 * time.Hour},
+				{Resolution: time.Minute, Retention: 7 * 24 * time.Hour},
+				{Resolution: time.Hour, Retention: 90 * 24 * time.Hour},
+			},
+		},
+		{
+			name:    "first tier is not raw",
+			value:   "1m:7d",
+			wantErr: true,
+		},
+		{
+			name:    "resolution is not a multiple",
+			value:   "raw:6h,1m:7d,90s:30d",
+			wantErr: true,
+		},
+		{
+			name:    "retention shorter than resolution",
+			value:   "raw:6h,1h:30m",
+			wantErr: true,
+		},
+		{
+			name:    "sub-second resolution",
+			value:   "raw:6h,500ms:1h",
+			wantErr: true,
+		},
+		{
+			name:    "bad format",
+			value:   "raw",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tiers, err := ParseTiers(tt.value, time.Hour)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidTiers)
+
+				return
+			}
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.want, tiers)
+		})
+	}
+}
+
+func TestStatsHistory_Compact(t *testing.T) {
+	start := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	tiers := []Tier{
+		{Retention: time.Hour},
+		{Resolution: time.Minute, Retention: 24 * time.Hour},
+	}
+
+	history := NewStatsHistoryRepository(tiers)
+
+	gauges := []types.Gauge{1, 5, 3, 10, 20}
+	counters := []types.Counter{10, 15, 20, 2, 7}
+	for i := range gauges {
+		history.Record(start.Add(time.Duration(i)*20*time.Second), types.Gauges{"Alloc": gauges[i]}, types.Counters{"PollCount": counters[i]})
+	}
+
+	require.NoError(t, history.Compact(start.Add(90*time.Second)))
+
+	// Второй интервал еще не закончился и не агрегируется
+	gauge := history.(*StatsHistory).levels[1].Gauges["Alloc"]
+	require.Len(t, gauge, 1)
+	assert.Equal(t, start, gauge[0].Time)
+	assert.Equal(t, Aggregate{Min: 1, Max: 5, Avg: 3, Last: 3, Count: 3}, *gauge[0].Aggregate)
+	assert.Equal(t, 3.0, gauge[0].Value)
+
+	require.NoError(t, history.Compact(start.Add(2*time.Minute)))
+
+	counter := history.(*StatsHistory).levels[1].Counters["PollCount"]
+	require.Len(t, counter, 2)
+	assert.Equal(t, 10.0, counter[0].Aggregate.Sum)
+	assert.Equal(t, 20.0, counter[0].Value)
+	// Уменьшение значения считается сбросом счетчика: 2 + 5
+	assert.Equal(t, 7.0, counter[1].Aggregate.Sum)
+	assert.Equal(t, 7.0, counter[1].Value)
+
+	// Повторная агрегация не дублирует интервалы
+	require.NoError(t, history.Compact(start.Add(2*time.Minute)))
+	assert.Len(t, history.(*StatsHistory).levels[1].Counters["PollCount"], 2)
+}
+
+func TestStatsHistory_Range(t *testing.T) {
+	start := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	tiers := []Tier{
+		{Retention: 10 * time.Minute},
+		{Resolution: time.Minute, Retention: 24 * time.Hour},
+	}
+
+	history := NewStatsHistoryRepository(tiers)
+	for i := 0; i < 60; i++ {
+		at := start.Add(time.Duration(i) * 30 * time.Second)
+
+		history.Record(at, types.Gauges{"Alloc": types.Gauge(i), "Sys": types.Gauge(2 * i)}, nil)
+		require.NoError(t, history.Compact(at))
+	}
+
+	end := start.Add(29*time.Minute + 30*time.Second)
+
+	t.Run("raw tier for recent range", func(t *testing.T) {
+		samples := history.Range(dictionaries.GaugeType, "Alloc", end.Add(-5*time.Minute), end)
+
+		require.Len(t, samples, 11)
+		for _, sample := range samples {
+			assert.Nil(t, sample.Aggregate)
+		}
+	})
+
+	t.Run("aggregated tier for old range", func(t *testing.T) {
+		samples := history.Range(dictionaries.GaugeType, "Alloc", start, end)
+
+		// 29 агрегированных интервалов и сырые значения после последнего из них
+		require.Len(t, samples, 31)
+		assert.NotNil(t, samples[0].Aggregate)
+		assert.Equal(t, 0.5, samples[0].Value)
+		assert.Nil(t, samples[len(samples)-1].Aggregate)
+		assert.Equal(t, end, samples[len(samples)-1].Time)
+	})
+
+	t.Run("all series", func(t *testing.T) {
+		all := history.RangeAll(dictionaries.GaugeType, start, end)
+
+		require.Len(t, all, 2)
+		assert.Equal(t, history.Range(dictionaries.GaugeType, "Alloc", start, end), all["Alloc"])
+		assert.Equal(t, history.Range(dictionaries.GaugeType, "Sys", start, end), all["Sys"])
+		assert.Empty(t, history.RangeAll(dictionaries.CounterType, start, end))
+	})
+}
+
+func TestTenantHistory_JSON(t *testing.T) {
+	at := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	tiers := []Tier{{Retention: time.Hour}}
+
+	history := NewTenantHistoryRepository(tiers)
+	history.Tenant("team-a").Record(at, types.Gauges{"Alloc": 1}, types.Counters{"PollCount": 2})
+
+	data, err := json.Marshal(history)
+	require.NoError(t, err)
+
+	restored := NewTenantHistoryRepository(tiers)
+	require.NoError(t, json.Unmarshal(data, restored))
+
+	samples := restored.Tenant("team-a").Range(dictionaries.CounterType, "PollCount", at, at)
+	require.Len(t, samples, 1)
+	assert.Equal(t, 2.0, samples[0].Value)
+	assert.Empty(t, restored.Tenant("").Range(dictionaries.CounterType, "PollCount", at, at))
+}
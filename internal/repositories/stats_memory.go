@@ -2,11 +2,13 @@ package repositories
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/vllvll/devops/internal/types"
 )
 
 type StatsMemory struct {
+	mu       sync.RWMutex
 	Gauges   types.Gauges
 	Counters types.Counters
 }
@@ -18,6 +20,10 @@ type StatsRepository interface {
 	GetGaugeByKey(key string) (types.Gauge, error)
 	GetCounterByKey(key string) (types.Counter, error)
 	UpdateAll(gauges types.Gauges, counters types.Counters) error
+	ReplaceAll(gauges types.Gauges, counters types.Counters) error
+	DeleteGauge(key string) error
+	DeleteCounter(key string) error
+	ResetCounter(key string) error
 }
 
 // NewStatsMemoryRepository Создание репозитория, который отвечает за хранение метрик в оперативной памяти
@@ -30,21 +36,43 @@ func NewStatsMemoryRepository() StatsRepository {
 
 // UpdateGauge Обновить значение метрики с типом Gauge в оперативной памяти
 func (s *StatsMemory) UpdateGauge(key string, value types.Gauge) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	s.Gauges[key] = value
 }
 
 // UpdateCount Обновить значение метрики с типом Counter в оперативной памяти
 func (s *StatsMemory) UpdateCount(key string, value types.Counter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	s.Counters[key] += value
 }
 
 // GetAll Получение всех метрик из оперативной памяти
 func (s *StatsMemory) GetAll() (map[string]types.Gauge, map[string]types.Counter) {
-	return s.Gauges, s.Counters
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	gauges := make(map[string]types.Gauge, len(s.Gauges))
+	for key, value := range s.Gauges {
+		gauges[key] = value
+	}
+
+	counters := make(map[string]types.Counter, len(s.Counters))
+	for key, value := range s.Counters {
+		counters[key] = value
+	}
+
+	return gauges, counters
 }
 
 // GetGaugeByKey Получить значение метрики типа Gauge по ключу из оперативной памяти
 func (s *StatsMemory) GetGaugeByKey(key string) (types.Gauge, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	if value, ok := s.Gauges[key]; ok {
 		return value, nil
 	}
@@ -54,6 +82,9 @@ func (s *StatsMemory) GetGaugeByKey(key string) (types.Gauge, error) {
 
 // GetCounterByKey Получить значение метрики типа Counter по ключу из оперативной памяти
 func (s *StatsMemory) GetCounterByKey(key string) (types.Counter, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	if value, ok := s.Counters[key]; ok {
 		return value, nil
 	}
@@ -63,6 +94,9 @@ func (s *StatsMemory) GetCounterByKey(key string) (types.Counter, error) {
 
 // UpdateAll Обновление всех значений типов Gauge и Counter в оперативной памяти
 func (s *StatsMemory) UpdateAll(gauges types.Gauges, counters types.Counters) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	for key, value := range gauges {
 		s.Gauges[key] = value
 	}
@@ -73,3 +107,61 @@ func (s *StatsMemory) UpdateAll(gauges types.Gauges, counters types.Counters) er
 
 	return nil
 }
+
+// ReplaceAll Запись значений Gauge и замена значений Counter в оперативной памяти
+func (s *StatsMemory) ReplaceAll(gauges types.Gauges, counters types.Counters) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, value := range gauges {
+		s.Gauges[key] = value
+	}
+
+	for key, value := range counters {
+		s.Counters[key] = value
+	}
+
+	return nil
+}
+
+// DeleteGauge Удаление метрики типа Gauge из оперативной памяти
+func (s *StatsMemory) DeleteGauge(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.Gauges[key]; !ok {
+		return fmt.Errorf("%s key doesn't exists", key)
+	}
+
+	delete(s.Gauges, key)
+
+	return nil
+}
+
+// DeleteCounter Удаление метрики типа Counter из оперативной памяти
+func (s *StatsMemory) DeleteCounter(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.Counters[key]; !ok {
+		return fmt.Errorf("%s key doesn't exists", key)
+	}
+
+	delete(s.Counters, key)
+
+	return nil
+}
+
+// ResetCounter Сброс значения метрики типа Counter в оперативной памяти
+func (s *StatsMemory) ResetCounter(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.Counters[key]; !ok {
+		return fmt.Errorf("%s key doesn't exists", key)
+	}
+
+	s.Counters[key] = 0
+
+	return nil
+}
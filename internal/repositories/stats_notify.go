@@ -0,0 +1,145 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v4/stdlib"
+	"go.uber.org/zap"
+)
+
+// NotifyChannel Канал PostgreSQL для оповещений об изменении метрик
+const NotifyChannel = "devops_metrics_changed"
+
+// listenRetry Пауза перед повторным подключением слушателя оповещений
+const listenRetry = time.Second
+
+// DatabaseNotifier Оповещение экземпляров сервера об изменении метрик через LISTEN/NOTIFY.
+// Оповещения отправляются в фоне, несколько изменений одного арендатора объединяются в одно оповещение
+type DatabaseNotifier struct {
+	db       *sql.DB
+	instance string // Идентификатор экземпляра сервера, собственные оповещения не обрабатываются
+
+	mu      sync.Mutex
+	changed map[string]bool // Арендаторы, оповещения о которых еще не отправлены
+	signal  chan struct{}
+}
+
+// NewDatabaseNotifier Создание сервиса оповещений через PostgreSQL
+func NewDatabaseNotifier(db *sql.DB) *DatabaseNotifier {
+	instance, _ := uuid.NewV4()
+
+	return &DatabaseNotifier{
+		db:       db,
+		instance: instance.String(),
+		changed:  map[string]bool{},
+		signal:   make(chan struct{}, 1),
+	}
+}
+
+// Notify Оповещение об изменении метрик арендатора
+func (n *DatabaseNotifier) Notify(tenant string) {
+	n.mu.Lock()
+	n.changed[tenant] = true
+	n.mu.Unlock()
+
+	select {
+	case n.signal <- struct{}{}:
+	default:
+	}
+}
+
+// Run Загрузка копии cache после подписки, отправка оповещений и сброс копии по оповещениям других экземпляров до отмены ctx
+func (n *DatabaseNotifier) Run(ctx context.Context, cache *TenantCached) {
+	go n.send(ctx)
+
+	for {
+		err := n.listen(ctx, cache)
+		if ctx.Err() != nil {
+			return
+		}
+
+		// Оповещения, отправленные без подключения, потеряны, поэтому до повторной подписки копия не используется
+		zap.S().Errorf("Error with listen for metric changes: %v", err)
+		cache.Pause()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(listenRetry):
+		}
+	}
+}
+
+// send Отправка накопленных оповещений
+func (n *DatabaseNotifier) send(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-n.signal:
+		}
+
+		n.mu.Lock()
+		changed := n.changed
+		n.changed = map[string]bool{}
+		n.mu.Unlock()
+
+		for tenant := range changed {
+			if _, err := n.db.ExecContext(ctx, "SELECT pg_notify($1, $2)", NotifyChannel, n.instance+":"+tenant); err != nil {
+				zap.S().Errorf("Error with notify metric changes: %v", err)
+			}
+		}
+	}
+}
+
+// listen Получение оповещений на отдельном подключении до ошибки или отмены ctx
+func (n *DatabaseNotifier) listen(ctx context.Context, cache *TenantCached) error {
+	conn, err := n.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "LISTEN "+NotifyChannel); err != nil {
+		return err
+	}
+
+	// Изменения, сделанные до подписки, не пришли бы оповещением, поэтому копия загружается заново
+	if err := cache.Warm(); err != nil {
+		return err
+	}
+
+	return conn.Raw(func(driverConn interface{}) error {
+		stdlibConn, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return errors.New("LISTEN requires the pgx driver")
+		}
+
+		for {
+			notification, err := stdlibConn.Conn().WaitForNotification(ctx)
+			if err != nil {
+				return err
+			}
+
+			if tenant, ok := n.parse(notification.Payload); ok {
+				cache.Invalidate(tenant)
+			}
+		}
+	})
+}
+
+// parse Арендатор из оповещения другого экземпляра. false - собственное оповещение
+func (n *DatabaseNotifier) parse(payload string) (string, bool) {
+	instance, tenant, found := strings.Cut(payload, ":")
+	if !found || instance == n.instance {
+		return "", false
+	}
+
+	return tenant, true
+}
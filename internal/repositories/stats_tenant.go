@@ -0,0 +1,346 @@
+package repositories
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gofrs/uuid"
+	"go.uber.org/zap"
+
+	"github.com/vllvll/devops/internal/types"
+)
+
+type TenantRepository interface {
+	Tenant(name string) StatsRepository
+	Tenants() ([]string, error)
+}
+
+type TenantHistoryRepository interface {
+	Tenant(name string) HistoryRepository
+}
+
+type TenantMemory struct {
+	mu      sync.Mutex
+	tenants map[string]StatsRepository // Метрики каждого арендатора хранятся в отдельных картах
+}
+
+// NewTenantMemoryRepository Создание репозитория, который хранит метрики арендаторов в оперативной памяти
+func NewTenantMemoryRepository() TenantRepository {
+	return &TenantMemory{
+		tenants: map[string]StatsRepository{},
+	}
+}
+
+// Tenant Получение метрик арендатора. Хранилище создается при первом обращении
+func (t *TenantMemory) Tenant(name string) StatsRepository {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	repository, ok := t.tenants[name]
+	if !ok {
+		repository = NewStatsMemoryRepository()
+		t.tenants[name] = repository
+	}
+
+	return repository
+}
+
+// Tenants Получение списка арендаторов
+func (t *TenantMemory) Tenants() ([]string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	names := make([]string, 0, len(t.tenants))
+	for name := range t.tenants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// PrepareBatch Подготовка записи изменений всех арендаторов в оперативную память. Изменения применяются при Commit
+func (t *TenantMemory) PrepareBatch(gauges map[SeriesKey]types.Gauge, counters map[SeriesKey]types.Counter) (BatchWrite, error) {
+	return &memoryBatch{tenants: t, gauges: gauges, counters: counters}, nil
+}
+
+// WriteBatch Запись изменений всех арендаторов в оперативную память
+func (t *TenantMemory) WriteBatch(gauges map[SeriesKey]types.Gauge, counters map[SeriesKey]types.Counter) error {
+	names := map[string]bool{}
+
+	tenantGauges := map[string]types.Gauges{}
+	for key, value := range gauges {
+		if tenantGauges[key.Tenant] == nil {
+			tenantGauges[key.Tenant] = types.Gauges{}
+		}
+		tenantGauges[key.Tenant][key.Name] = value
+		names[key.Tenant] = true
+	}
+
+	tenantCounters := map[string]types.Counters{}
+	for key, value := range counters {
+		if tenantCounters[key.Tenant] == nil {
+			tenantCounters[key.Tenant] = types.Counters{}
+		}
+		tenantCounters[key.Tenant][key.Name] = value
+		names[key.Tenant] = true
+	}
+
+	for name := range names {
+		if err := t.Tenant(name).UpdateAll(tenantGauges[name], tenantCounters[name]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// memoryBatch Подготовленная запись пакета в оперативную память
+type memoryBatch struct {
+	tenants  *TenantMemory
+	gauges   map[SeriesKey]types.Gauge
+	counters map[SeriesKey]types.Counter
+}
+
+// Commit Применение изменений пакета
+func (b *memoryBatch) Commit() error {
+	return b.tenants.WriteBatch(b.gauges, b.counters)
+}
+
+// Rollback Отмена записи пакета
+func (b *memoryBatch) Rollback() error {
+	return nil
+}
+
+// batchRows Количество строк в одном INSERT: 4 параметра на строку при ограничении PostgreSQL в 65535 параметров
+const batchRows = 1000
+
+type TenantDatabase struct {
+	db *sql.DB
+}
+
+// NewTenantDatabaseRepository Создание репозитория, который хранит метрики арендаторов в бд (колонка tenant)
+func NewTenantDatabaseRepository(db *sql.DB) BatchRepository {
+	return &TenantDatabase{
+		db: db,
+	}
+}
+
+// Tenant Получение метрик арендатора
+func (t *TenantDatabase) Tenant(name string) StatsRepository {
+	return &StatsDatabase{
+		db:     t.db,
+		tenant: name,
+	}
+}
+
+// Tenants Получение списка арендаторов, у которых есть метрики
+func (t *TenantDatabase) Tenants() ([]string, error) {
+	rows, err := t.db.Query("SELECT tenant FROM gauges UNION SELECT tenant FROM counters ORDER BY tenant")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+
+		names = append(names, name)
+	}
+
+	return names, rows.Err()
+}
+
+// PrepareBatch Запись изменений всех арендаторов в транзакцию многострочными INSERT ... ON CONFLICT.
+// Изменения видны другим соединениям после Commit
+func (t *TenantDatabase) PrepareBatch(gauges map[SeriesKey]types.Gauge, counters map[SeriesKey]types.Counter) (BatchWrite, error) {
+	tx, err := prepareRows(t.db, gauges, counters, "counters.value + excluded.value")
+	if err != nil {
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+// WriteBatch Запись изменений всех арендаторов в одной транзакции многострочными INSERT ... ON CONFLICT
+func (t *TenantDatabase) WriteBatch(gauges map[SeriesKey]types.Gauge, counters map[SeriesKey]types.Counter) error {
+	return writeRows(t.db, gauges, counters, "counters.value + excluded.value")
+}
+
+// writeRows Запись значений Gauge и Counter в одной транзакции. counterValue - выражение нового значения Counter
+// при конфликте: сумма с приращением или замена
+func writeRows(db *sql.DB, gauges map[SeriesKey]types.Gauge, counters map[SeriesKey]types.Counter, counterValue string) error {
+	tx, err := prepareRows(db, gauges, counters, counterValue)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// prepareRows Запись значений Gauge и Counter в открытую транзакцию. При ошибке транзакция отменяется
+func prepareRows(db *sql.DB, gauges map[SeriesKey]types.Gauge, counters map[SeriesKey]types.Counter, counterValue string) (*sql.Tx, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	gaugeKeys := make([]SeriesKey, 0, len(gauges))
+	for key := range gauges {
+		gaugeKeys = append(gaugeKeys, key)
+	}
+	sortKeys(gaugeKeys)
+
+	counterKeys := make([]SeriesKey, 0, len(counters))
+	for key := range counters {
+		counterKeys = append(counterKeys, key)
+	}
+	sortKeys(counterKeys)
+
+	err = upsertRows(tx, "gauges", "excluded.value", gaugeKeys, func(key SeriesKey) interface{} { return gauges[key] })
+	if err == nil {
+		err = upsertRows(tx, "counters", counterValue, counterKeys, func(key SeriesKey) interface{} { return counters[key] })
+	}
+
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			zap.S().Errorf("Error with unable to rollback: %v", rollbackErr)
+		}
+
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+// upsertRows Запись строк в таблицу table частями по batchRows строк. value - выражение нового значения при конфликте
+func upsertRows(tx *sql.Tx, table string, value string, keys []SeriesKey, rowValue func(SeriesKey) interface{}) error {
+	for start := 0; start < len(keys); start += batchRows {
+		end := start + batchRows
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		var query strings.Builder
+		args := make([]interface{}, 0, (end-start)*4)
+
+		fmt.Fprintf(&query, "INSERT INTO %s (id, tenant, name, value) VALUES ", table)
+		for i, key := range keys[start:end] {
+			if i > 0 {
+				query.WriteString(", ")
+			}
+			fmt.Fprintf(&query, "($%d, $%d, $%d, $%d)", i*4+1, i*4+2, i*4+3, i*4+4)
+
+			id, _ := uuid.NewV4()
+			args = append(args, id.String(), key.Tenant, key.Name, rowValue(key))
+		}
+		fmt.Fprintf(&query, " ON CONFLICT (tenant, name) DO UPDATE SET value = %s", value)
+
+		if _, err := tx.Exec(query.String(), args...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sortKeys Сортировка серий по арендатору и имени, чтобы параллельные записи блокировали строки в одном порядке
+func sortKeys(keys []SeriesKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Tenant != keys[j].Tenant {
+			return keys[i].Tenant < keys[j].Tenant
+		}
+
+		return keys[i].Name < keys[j].Name
+	})
+}
+
+type TenantHistory struct {
+	mu      sync.Mutex
+	tiers   []Tier                   // Уровни хранения
+	tenants map[string]*StatsHistory // История значений каждого арендатора
+}
+
+// NewTenantHistoryRepository Создание репозитория, который хранит историю значений метрик арендаторов в оперативной памяти
+func NewTenantHistoryRepository(tiers []Tier) TenantHistoryRepository {
+	return &TenantHistory{
+		tiers:   tiers,
+		tenants: map[string]*StatsHistory{},
+	}
+}
+
+// Tenant Получение истории значений арендатора. Хранилище создается при первом обращении
+func (t *TenantHistory) Tenant(name string) HistoryRepository {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.tenant(name)
+}
+
+// MarshalJSON Сохранение истории всех арендаторов в JSON
+func (t *TenantHistory) MarshalJSON() ([]byte, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return json.Marshal(t.tenants)
+}
+
+// UnmarshalJSON Восстановление истории всех арендаторов из JSON
+func (t *TenantHistory) UnmarshalJSON(data []byte) error {
+	var tenants map[string]json.RawMessage
+	if err := json.Unmarshal(data, &tenants); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for name, history := range tenants {
+		if err := json.Unmarshal(history, t.tenant(name)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// tenant Получение истории значений арендатора без блокировки
+func (t *TenantHistory) tenant(name string) *StatsHistory {
+	history, ok := t.tenants[name]
+	if !ok {
+		history = newStatsHistory(t.tiers)
+		t.tenants[name] = history
+	}
+
+	return history
+}
+
+type TenantHistoryDatabase struct {
+	db    *sql.DB
+	tiers []Tier // Уровни хранения
+}
+
+// NewTenantHistoryDatabaseRepository Создание репозитория, который хранит историю значений метрик арендаторов в бд
+func NewTenantHistoryDatabaseRepository(db *sql.DB, tiers []Tier) TenantHistoryRepository {
+	return &TenantHistoryDatabase{
+		db:    db,
+		tiers: tiers,
+	}
+}
+
+// Tenant Получение истории значений арендатора из бд
+func (t *TenantHistoryDatabase) Tenant(name string) HistoryRepository {
+	return &StatsHistoryDatabase{
+		db:     t.db,
+		tenant: name,
+		tiers:  t.tiers,
+	}
+}
@@ -0,0 +1,343 @@
+package repositories
+
+import (
+	"database/sql"
+	"encoding/json"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/vllvll/devops/internal/types"
+)
+
+// Режим записи значений Counter
+const (
+	CounterModeHeader     = "X-Counter-Mode" // HTTP заголовок с режимом записи Counter
+	CounterModeMetadata   = "x-counter-mode" // Ключ метаданных gRPC с режимом записи Counter
+	CounterModeCumulative = "cumulative"     // Агент присылает накопленные значения счетчиков вместо приращений
+)
+
+// TotalsRepository Последние накопленные значения счетчиков, присланные агентами арендатора
+type TotalsRepository interface {
+	// Increase Приращения счетчиков по новым накопленным значениям агента. Новые значения запоминаются только
+	// вызовом Commit после записи приращений, до Commit или Rollback следующие значения агента ожидают.
+	// Значение меньше предыдущего считается сбросом счетчика (перезапуском агента), приращение равно новому значению.
+	// Первое значение серии учитывается полностью: счетчик агента начинается с нуля
+	Increase(agent string, totals types.Counters) (TotalsIncrease, error)
+}
+
+// TotalsIncrease Приращения счетчиков, вычисленные по накопленным значениям агента
+type TotalsIncrease interface {
+	// Counters Приращения счетчиков
+	Counters() types.Counters
+	// Commit Сохранение новых накопленных значений после записи приращений
+	Commit() error
+	// Rollback Отмена без сохранения значений: повтор запроса вычислит те же приращения
+	Rollback() error
+}
+
+type TenantTotalsRepository interface {
+	Tenant(name string) TotalsRepository
+}
+
+type StatsTotals struct {
+	mu     sync.Mutex
+	agents map[string]types.Counters // Последние накопленные значения счетчиков каждого агента
+	locks  map[string]*sync.Mutex    // Блокировки агентов от Increase до Commit или Rollback
+}
+
+// NewStatsTotalsRepository Создание репозитория, который хранит накопленные значения счетчиков агентов в оперативной памяти
+func NewStatsTotalsRepository() TotalsRepository {
+	return newStatsTotals()
+}
+
+func newStatsTotals() *StatsTotals {
+	return &StatsTotals{
+		agents: map[string]types.Counters{},
+		locks:  map[string]*sync.Mutex{},
+	}
+}
+
+// Increase Приращения счетчиков агента по накопленным значениям в оперативной памяти
+func (s *StatsTotals) Increase(agent string, totals types.Counters) (TotalsIncrease, error) {
+	s.mu.Lock()
+	lock, ok := s.locks[agent]
+	if !ok {
+		lock = &sync.Mutex{}
+		s.locks[agent] = lock
+	}
+	s.mu.Unlock()
+
+	lock.Lock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	last := s.agents[agent]
+
+	increases := make(types.Counters, len(totals))
+	for key, total := range totals {
+		previous, ok := last[key]
+		increases[key] = counterIncrease(previous, ok, total)
+	}
+
+	return &memoryIncrease{totals: s, agent: agent, values: totals, increases: increases, lock: lock}, nil
+}
+
+// memoryIncrease Приращения агента, накопленные значения которого хранятся в оперативной памяти
+type memoryIncrease struct {
+	totals    *StatsTotals
+	agent     string
+	values    types.Counters // Новые накопленные значения
+	increases types.Counters
+	lock      *sync.Mutex // Блокировка агента, снимается при Commit или Rollback
+	once      sync.Once
+}
+
+// Counters Приращения счетчиков
+func (i *memoryIncrease) Counters() types.Counters {
+	return i.increases
+}
+
+// Commit Сохранение новых накопленных значений агента
+func (i *memoryIncrease) Commit() error {
+	i.once.Do(func() {
+		i.totals.mu.Lock()
+		last, ok := i.totals.agents[i.agent]
+		if !ok {
+			last = types.Counters{}
+			i.totals.agents[i.agent] = last
+		}
+
+		for key, total := range i.values {
+			last[key] = total
+		}
+		i.totals.mu.Unlock()
+
+		i.lock.Unlock()
+	})
+
+	return nil
+}
+
+// Rollback Снятие блокировки агента без сохранения значений
+func (i *memoryIncrease) Rollback() error {
+	i.once.Do(i.lock.Unlock)
+
+	return nil
+}
+
+// MarshalJSON Сохранение накопленных значений всех агентов в JSON
+func (s *StatsTotals) MarshalJSON() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return json.Marshal(s.agents)
+}
+
+// UnmarshalJSON Восстановление накопленных значений агентов из JSON
+func (s *StatsTotals) UnmarshalJSON(data []byte) error {
+	agents := map[string]types.Counters{}
+	if err := json.Unmarshal(data, &agents); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.agents = agents
+
+	return nil
+}
+
+type TenantTotals struct {
+	mu      sync.Mutex
+	tenants map[string]*StatsTotals // Накопленные значения каждого арендатора хранятся отдельно
+}
+
+// NewTenantTotalsRepository Создание репозитория, который хранит накопленные значения счетчиков агентов
+// всех арендаторов в оперативной памяти
+func NewTenantTotalsRepository() TenantTotalsRepository {
+	return &TenantTotals{
+		tenants: map[string]*StatsTotals{},
+	}
+}
+
+// Tenant Получение накопленных значений арендатора. Хранилище создается при первом обращении
+func (t *TenantTotals) Tenant(name string) TotalsRepository {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.tenant(name)
+}
+
+// MarshalJSON Сохранение накопленных значений всех арендаторов в JSON
+func (t *TenantTotals) MarshalJSON() ([]byte, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return json.Marshal(t.tenants)
+}
+
+// UnmarshalJSON Восстановление накопленных значений всех арендаторов из JSON
+func (t *TenantTotals) UnmarshalJSON(data []byte) error {
+	var tenants map[string]json.RawMessage
+	if err := json.Unmarshal(data, &tenants); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for name, totals := range tenants {
+		if err := json.Unmarshal(totals, t.tenant(name)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// tenant Хранилище арендатора. Вызывается под t.mu
+func (t *TenantTotals) tenant(name string) *StatsTotals {
+	totals, ok := t.tenants[name]
+	if !ok {
+		totals = newStatsTotals()
+		t.tenants[name] = totals
+	}
+
+	return totals
+}
+
+type StatsTotalsDatabase struct {
+	db     *sql.DB
+	tenant string // Арендатор, значения которого читаются и записываются
+}
+
+// Increase Приращения счетчиков агента по накопленным значениям в бд. Транзакция держит рекомендательную
+// блокировку агента до Commit или Rollback, поэтому несколько экземпляров сервера не учитывают значение дважды
+func (s *StatsTotalsDatabase) Increase(agent string, totals types.Counters) (TotalsIncrease, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	increases, err := s.increase(tx, agent, totals)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			zap.S().Errorf("Error with unable to rollback: %v", rollbackErr)
+		}
+
+		return nil, err
+	}
+
+	return &databaseIncrease{tx: tx, tenant: s.tenant, agent: agent, values: totals, increases: increases}, nil
+}
+
+func (s *StatsTotalsDatabase) increase(tx *sql.Tx, agent string, totals types.Counters) (types.Counters, error) {
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext($1), hashtext($2))", s.tenant, agent); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query("SELECT name, value FROM counter_totals WHERE tenant = $1 AND agent = $2", s.tenant, agent)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	last := types.Counters{}
+	for rows.Next() {
+		var name string
+		var value int64
+
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+
+		last[name] = types.Counter(value)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	increases := make(types.Counters, len(totals))
+	for key, total := range totals {
+		previous, ok := last[key]
+		increases[key] = counterIncrease(previous, ok, total)
+	}
+
+	return increases, nil
+}
+
+// databaseIncrease Приращения агента в открытой транзакции с блокировкой агента
+type databaseIncrease struct {
+	tx        *sql.Tx
+	tenant    string
+	agent     string
+	values    types.Counters // Новые накопленные значения
+	increases types.Counters
+}
+
+// Counters Приращения счетчиков
+func (i *databaseIncrease) Counters() types.Counters {
+	return i.increases
+}
+
+// Commit Запись новых накопленных значений агента и завершение транзакции
+func (i *databaseIncrease) Commit() error {
+	stmt, err := i.tx.Prepare(`
+		INSERT INTO counter_totals (tenant, agent, name, value, updated_at) VALUES ($1, $2, $3, $4, now())
+		ON CONFLICT (tenant, agent, name) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at`)
+	if err != nil {
+		i.tx.Rollback()
+
+		return err
+	}
+	defer stmt.Close()
+
+	for key, total := range i.values {
+		if _, err := stmt.Exec(i.tenant, i.agent, key, int64(total)); err != nil {
+			i.tx.Rollback()
+
+			return err
+		}
+	}
+
+	return i.tx.Commit()
+}
+
+// Rollback Отмена транзакции без записи значений
+func (i *databaseIncrease) Rollback() error {
+	return i.tx.Rollback()
+}
+
+type TenantTotalsDatabase struct {
+	db *sql.DB
+}
+
+// NewTenantTotalsDatabaseRepository Создание репозитория, который хранит накопленные значения счетчиков агентов
+// всех арендаторов в бд
+func NewTenantTotalsDatabaseRepository(db *sql.DB) TenantTotalsRepository {
+	return &TenantTotalsDatabase{
+		db: db,
+	}
+}
+
+// Tenant Получение накопленных значений арендатора из бд
+func (t *TenantTotalsDatabase) Tenant(name string) TotalsRepository {
+	return &StatsTotalsDatabase{
+		db:     t.db,
+		tenant: name,
+	}
+}
+
+// counterIncrease Приращение счетчика от предыдущего накопленного значения. Без предыдущего значения
+// и после сброса (новое значение меньше предыдущего) приращение равно новому значению
+func counterIncrease(previous types.Counter, known bool, total types.Counter) types.Counter {
+	if !known || total < previous {
+		return total
+	}
+
+	return total - previous
+}
@@ -0,0 +1,116 @@
+package repositories
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vllvll/devops/internal/types"
+)
+
+func TestStatsTotals_Increase(t *testing.T) {
+	tenants := NewTenantTotalsRepository()
+	totals := tenants.Tenant("team-a")
+
+	steps := []struct {
+		name   string
+		agent  string
+		totals types.Counters
+		want   types.Counters
+	}{
+		{
+			name:   "first value counts in full",
+			agent:  "web-1",
+			totals: types.Counters{"PollCount": 5, "Requests": 100},
+			want:   types.Counters{"PollCount": 5, "Requests": 100},
+		},
+		{
+			name:   "increase from previous value",
+			agent:  "web-1",
+			totals: types.Counters{"PollCount": 12, "Requests": 100},
+			want:   types.Counters{"PollCount": 7, "Requests": 0},
+		},
+		{
+			name:   "reset after agent restart",
+			agent:  "web-1",
+			totals: types.Counters{"PollCount": 3},
+			want:   types.Counters{"PollCount": 3},
+		},
+		{
+			name:   "agents are tracked separately",
+			agent:  "web-2",
+			totals: types.Counters{"PollCount": 4},
+			want:   types.Counters{"PollCount": 4},
+		},
+		{
+			name:   "increase after reset",
+			agent:  "web-1",
+			totals: types.Counters{"PollCount": 10, "Requests": 150},
+			want:   types.Counters{"PollCount": 7, "Requests": 50},
+		},
+	}
+
+	for _, step := range steps {
+		increase, err := totals.Increase(step.agent, step.totals)
+		require.NoError(t, err)
+		assert.Equal(t, step.want, increase.Counters(), step.name)
+		require.NoError(t, increase.Commit())
+	}
+
+	// Значения других арендаторов не учитываются
+	increase, err := tenants.Tenant("").Increase("web-1", types.Counters{"PollCount": 10})
+	require.NoError(t, err)
+	assert.Equal(t, types.Counters{"PollCount": 10}, increase.Counters())
+	require.NoError(t, increase.Commit())
+
+	// Накопленные значения восстанавливаются из JSON
+	data, err := json.Marshal(tenants)
+	require.NoError(t, err)
+
+	restored := NewTenantTotalsRepository()
+	require.NoError(t, json.Unmarshal(data, restored))
+
+	increase, err = restored.Tenant("team-a").Increase("web-1", types.Counters{"PollCount": 11})
+	require.NoError(t, err)
+	assert.Equal(t, types.Counters{"PollCount": 1}, increase.Counters())
+	require.NoError(t, increase.Commit())
+}
+
+func TestStatsTotals_Rollback(t *testing.T) {
+	totals := NewStatsTotalsRepository()
+
+	increase, err := totals.Increase("web-1", types.Counters{"PollCount": 5})
+	require.NoError(t, err)
+	require.NoError(t, increase.Commit())
+
+	// Без Commit значения не сохраняются: повтор после ошибки записи вычисляет те же приращения
+	increase, err = totals.Increase("web-1", types.Counters{"PollCount": 12})
+	require.NoError(t, err)
+	assert.Equal(t, types.Counters{"PollCount": 7}, increase.Counters())
+	require.NoError(t, increase.Rollback())
+
+	increase, err = totals.Increase("web-1", types.Counters{"PollCount": 12})
+	require.NoError(t, err)
+	assert.Equal(t, types.Counters{"PollCount": 7}, increase.Counters())
+
+	// Значения агента вычисляются по очереди: следующий запрос ждет Commit предыдущего
+	next := make(chan types.Counters)
+	go func() {
+		increase, err := totals.Increase("web-1", types.Counters{"PollCount": 15})
+		assert.NoError(t, err)
+		next <- increase.Counters()
+		assert.NoError(t, increase.Commit())
+	}()
+
+	select {
+	case <-next:
+		t.Fatal("increase of the same agent does not wait for commit")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	require.NoError(t, increase.Commit())
+	assert.Equal(t, types.Counters{"PollCount": 3}, <-next)
+}
@@ -0,0 +1,287 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/vllvll/devops/internal/types"
+)
+
+// Типы метрик протокола StatsD
+const (
+	StatsDCounter   = "c"
+	StatsDGauge     = "g"
+	StatsDTiming    = "ms"
+	StatsDHistogram = "h"
+)
+
+// Размер буфера для чтения UDP пакета
+const statsDPacketSize = 65535
+
+// StatsDMetric Метрика, полученная из строки протокола StatsD
+type StatsDMetric struct {
+	Name       string  // Имя метрики
+	Type       string  // Тип метрики: c, g, ms, h
+	Value      float64 // Значение метрики
+	SampleRate float64 // Частота семплирования (@0.1)
+	Relative   bool    // Относительное изменение gauge (+1, -1)
+}
+
+type StatsD struct {
+	mu         sync.Mutex
+	address    string               // Адрес UDP для получения пакетов
+	counters   map[string]float64   // Накопленные значения счетчиков за интервал
+	remainders map[string]float64   // Дробные остатки счетчиков, не отправленные в предыдущих интервалах
+	gauges     types.Gauges         // Последние значения gauge
+	timings    map[string][]float64 // Значения таймингов за интервал
+	done       chan struct{}        // Закрывается после завершения Listen
+}
+
+type StatsDRepository interface {
+	Listen(ctx context.Context, errCh chan<- error)
+	Done() <-chan struct{}
+	Flush(ctx context.Context, outGauges chan<- types.Gauges, outCounters chan<- types.Counters)
+}
+
+// NewStatsDRepository Создание репозитория, который принимает метрики по протоколу StatsD
+func NewStatsDRepository(address string) StatsDRepository {
+	return &StatsD{
+		address:    address,
+		counters:   map[string]float64{},
+		remainders: map[string]float64{},
+		gauges:     types.Gauges{},
+		timings:    map[string][]float64{},
+		done:       make(chan struct{}),
+	}
+}
+
+// Listen Прием UDP пакетов до завершения контекста. После завершения контекста ошибки в errCh не отправляются,
+// поэтому errCh можно закрыть после Done
+func (s *StatsD) Listen(ctx context.Context, errCh chan<- error) {
+	defer close(s.done)
+
+	conn, err := net.ListenPacket("udp", s.address)
+	if err != nil {
+		s.report(ctx, errCh, err)
+
+		return
+	}
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	buf := make([]byte, statsDPacketSize)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			if !s.report(ctx, errCh, err) {
+				return
+			}
+
+			continue
+		}
+
+		if err := s.Handle(buf[:n]); err != nil {
+			if !s.report(ctx, errCh, err) {
+				return
+			}
+		}
+	}
+}
+
+// Done Канал, который закрывается после завершения Listen
+func (s *StatsD) Done() <-chan struct{} {
+	return s.done
+}
+
+// report Отправка ошибки в errCh. Возвращает false, если контекст завершен и ошибка не отправлена
+func (s *StatsD) report(ctx context.Context, errCh chan<- error, err error) bool {
+	select {
+	case errCh <- err:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Handle Разбор пакета и добавление метрик в агрегатор
+func (s *StatsD) Handle(packet []byte) error {
+	var errs []string
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, line := range strings.Split(string(packet), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		metric, err := ParseStatsDLine(line)
+		if err != nil {
+			errs = append(errs, err.Error())
+
+			continue
+		}
+
+		s.add(metric)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("statsd: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// Flush Отправка агрегированных за интервал значений и сброс счетчиков и таймингов
+func (s *StatsD) Flush(ctx context.Context, outGauges chan<- types.Gauges, outCounters chan<- types.Counters) {
+	gauges, counters := s.collect()
+
+	if len(gauges) > 0 {
+		select {
+		case outGauges <- gauges:
+		case <-ctx.Done():
+			return
+		}
+	}
+
+	if len(counters) > 0 {
+		select {
+		case outCounters <- counters:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// collect Получение агрегированных значений со сбросом состояния интервала
+func (s *StatsD) collect() (types.Gauges, types.Counters) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	gauges := make(types.Gauges, len(s.gauges))
+	counters := make(types.Counters, len(s.counters))
+
+	for key, value := range s.gauges {
+		gauges[key] = value
+	}
+
+	// Целая часть отправляется, дробная (от частоты семплирования) переносится в следующий интервал
+	for key, value := range s.counters {
+		value += s.remainders[key]
+		whole := math.Trunc(value)
+
+		counters[key] = types.Counter(whole)
+		s.remainders[key] = value - whole
+	}
+
+	for key, values := range s.timings {
+		sort.Float64s(values)
+
+		var sum float64
+		for _, value := range values {
+			sum += value
+		}
+
+		gauges[key+".min"] = types.Gauge(values[0])
+		gauges[key+".max"] = types.Gauge(values[len(values)-1])
+		gauges[key+".mean"] = types.Gauge(sum / float64(len(values)))
+		gauges[key+".p95"] = types.Gauge(percentile(values, 0.95))
+		counters[key+".count"] = types.Counter(len(values))
+	}
+
+	s.counters = map[string]float64{}
+	s.timings = map[string][]float64{}
+
+	return gauges, counters
+}
+
+// add Добавление метрики в агрегатор
+func (s *StatsD) add(metric StatsDMetric) {
+	switch metric.Type {
+	case StatsDCounter:
+		s.counters[metric.Name] += metric.Value / metric.SampleRate
+	case StatsDGauge:
+		if metric.Relative {
+			s.gauges[metric.Name] += types.Gauge(metric.Value)
+		} else {
+			s.gauges[metric.Name] = types.Gauge(metric.Value)
+		}
+	case StatsDTiming, StatsDHistogram:
+		s.timings[metric.Name] = append(s.timings[metric.Name], metric.Value)
+	}
+}
+
+// ParseStatsDLine Разбор строки формата name:value|type[|@rate][|#tags]
+func ParseStatsDLine(line string) (StatsDMetric, error) {
+	metric := StatsDMetric{SampleRate: 1}
+
+	parts := strings.Split(line, "|")
+
+	colon := strings.LastIndex(parts[0], ":")
+	if colon <= 0 {
+		return metric, fmt.Errorf("invalid line %q: missing name", line)
+	}
+
+	metric.Name = parts[0][:colon]
+	parts[0] = parts[0][colon+1:]
+
+	if len(parts) < 2 {
+		return metric, fmt.Errorf("invalid line %q: missing type", line)
+	}
+
+	metric.Type = parts[1]
+	switch metric.Type {
+	case StatsDCounter, StatsDGauge, StatsDTiming, StatsDHistogram:
+	default:
+		return metric, fmt.Errorf("invalid line %q: unsupported type %s", line, metric.Type)
+	}
+
+	rawValue := parts[0]
+	if metric.Type == StatsDGauge && (strings.HasPrefix(rawValue, "+") || strings.HasPrefix(rawValue, "-")) {
+		metric.Relative = true
+	}
+
+	value, err := strconv.ParseFloat(rawValue, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return metric, fmt.Errorf("invalid line %q: bad value", line)
+	}
+
+	metric.Value = value
+
+	for _, part := range parts[2:] {
+		if strings.HasPrefix(part, "@") {
+			rate, err := strconv.ParseFloat(part[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return metric, fmt.Errorf("invalid line %q: bad sample rate", line)
+			}
+
+			metric.SampleRate = rate
+		}
+	}
+
+	return metric, nil
+}
+
+// percentile Получение перцентиля из отсортированного списка значений
+func percentile(sorted []float64, p float64) float64 {
+	index := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if index < 0 {
+		index = 0
+	}
+
+	return sorted[index]
+}
@@ -0,0 +1,117 @@
+package repositories
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vllvll/devops/internal/types"
+)
+
+func TestParseStatsDLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    StatsDMetric
+		wantErr bool
+	}{
+		{
+			name: "counter",
+			line: "requests:1|c",
+			want: StatsDMetric{Name: "requests", Type: StatsDCounter, Value: 1, SampleRate: 1},
+		},
+		{
+			name: "counter with sample rate",
+			line: "requests:2|c|@0.5",
+			want: StatsDMetric{Name: "requests", Type: StatsDCounter, Value: 2, SampleRate: 0.5},
+		},
+		{
+			name: "gauge",
+			line: "temperature:3.2|g",
+			want: StatsDMetric{Name: "temperature", Type: StatsDGauge, Value: 3.2, SampleRate: 1},
+		},
+		{
+			name: "relative gauge",
+			line: "temperature:-1|g",
+			want: StatsDMetric{Name: "temperature", Type: StatsDGauge, Value: -1, SampleRate: 1, Relative: true},
+		},
+		{
+			name: "timing with tags",
+			line: "db.query:120|ms|#env:prod",
+			want: StatsDMetric{Name: "db.query", Type: StatsDTiming, Value: 120, SampleRate: 1},
+		},
+		{
+			name:    "missing type",
+			line:    "requests:1",
+			wantErr: true,
+		},
+		{
+			name:    "unsupported type",
+			line:    "users:1|s",
+			wantErr: true,
+		},
+		{
+			name:    "bad value",
+			line:    "requests:abc|c",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metric, err := ParseStatsDLine(tt.line)
+			if tt.wantErr {
+				assert.Error(t, err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, metric)
+		})
+	}
+}
+
+func TestStatsD_Flush(t *testing.T) {
+	statsD := NewStatsDRepository("").(*StatsD)
+
+	err := statsD.Handle([]byte("requests:1|c\nrequests:1|c|@0.5\ntemperature:3.5|g\ndb.query:100|ms\ndb.query:300|ms"))
+	require.NoError(t, err)
+
+	gaugesCh := make(chan types.Gauges, 1)
+	countersCh := make(chan types.Counters, 1)
+
+	statsD.Flush(context.Background(), gaugesCh, countersCh)
+
+	assert.Equal(t, types.Gauges{
+		"temperature":   3.5,
+		"db.query.min":  100,
+		"db.query.max":  300,
+		"db.query.mean": 200,
+		"db.query.p95":  300,
+	}, <-gaugesCh)
+	assert.Equal(t, types.Counters{"requests": 3, "db.query.count": 2}, <-countersCh)
+
+	statsD.Flush(context.Background(), gaugesCh, countersCh)
+
+	assert.Equal(t, types.Gauges{"temperature": 3.5}, <-gaugesCh)
+	assert.Empty(t, countersCh)
+}
+
+func TestStatsD_FlushSampleRateRemainder(t *testing.T) {
+	statsD := NewStatsDRepository("").(*StatsD)
+
+	gaugesCh := make(chan types.Gauges, 1)
+	countersCh := make(chan types.Counters, 1)
+
+	// Каждая строка с частотой 0.3 дает 3.33 события, дробная часть переносится в следующий интервал
+	require.NoError(t, statsD.Handle([]byte("requests:1|c|@0.3")))
+	statsD.Flush(context.Background(), gaugesCh, countersCh)
+	assert.Equal(t, types.Counters{"requests": 3}, <-countersCh)
+
+	require.NoError(t, statsD.Handle([]byte("requests:1|c|@0.3\nrequests:1|c|@0.3")))
+	statsD.Flush(context.Background(), gaugesCh, countersCh)
+	assert.Equal(t, types.Counters{"requests": 7}, <-countersCh)
+}
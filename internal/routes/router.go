@@ -2,47 +2,107 @@
 package routes
 
 import (
+	"net/http"
+
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"go.uber.org/zap"
 
+	"github.com/vllvll/devops/internal/auth"
 	"github.com/vllvll/devops/internal/handlers"
 	"github.com/vllvll/devops/internal/middlewares"
+	"github.com/vllvll/devops/internal/telemetry"
 )
 
 type Router struct {
-	Router   chi.Router       // Роутер
-	handlers handlers.Handler // Обработчики
+	Router   chi.Router             // Роутер
+	handlers handlers.Handler       // Обработчики
+	admin    handlers.AdminHandler  // Обработчики администрирования
+	health   handlers.HealthHandler // Обработчики проверок живости и готовности
+	adminKey string                 // Ключ администратора
+	tokens   auth.Store             // Хранилище токенов (nil - аутентификация выключена)
+	subnet   string                 // Доверенная подсеть (пустая - без ограничения)
 }
 
 // NewRouter Регистрируем middleware и возвращаем роутер
-func NewRouter(handlers handlers.Handler, trustedSubnet string) Router {
+func NewRouter(handlers handlers.Handler, admin handlers.AdminHandler, health handlers.HealthHandler, trustedSubnet string, adminKey string, tokens auth.Store, registry *telemetry.Registry, log *zap.Logger) Router {
 	r := chi.NewRouter()
 
 	r.Use(middleware.RequestID)
 	r.Use(middleware.RealIP)
-	r.Use(middleware.Logger)
+	r.Use(middlewares.Logger(log))
 	r.Use(middleware.Recoverer)
+	r.Use(middlewares.Telemetry(registry))
 	r.Use(middleware.Compress(5))
-	r.Use(middlewares.TrustedSubnet(trustedSubnet))
 
 	// r.Mount("/debug", middleware.Profiler())
 
 	return Router{
 		Router:   r,
 		handlers: handlers,
+		admin:    admin,
+		health:   health,
+		adminKey: adminKey,
+		tokens:   tokens,
+		subnet:   trustedSubnet,
 	}
 }
 
-// RegisterHandlers Регистрируем обработчики
+// RegisterHandlers Регистрируем обработчики. Проверки живости и готовности доступны вне доверенной подсети,
+// чтобы их могли вызывать балансировщик и прокси
 func (ro *Router) RegisterHandlers() {
-	ro.Router.Get("/", ro.handlers.GetAll())
-	ro.Router.Get("/ping", ro.handlers.Ping())
-	ro.Router.Route("/value/", func(r chi.Router) {
-		r.Post("/", ro.handlers.GetMetricJSON())
-		r.Get("/gauge/{key:[A-Za-z0-9]+}", ro.handlers.GetGauge())
-		r.Get("/counter/{key:[A-Za-z0-9]+}", ro.handlers.GetCounter())
+	ro.Router.Get("/healthz", ro.health.Healthz())
+	ro.Router.Get("/readyz", ro.health.Readyz())
+
+	ro.Router.Group(func(r chi.Router) {
+		r.Use(middlewares.TrustedSubnet(ro.subnet))
+
+		r.Get("/static/*", ro.handlers.Static())
+		r.Get("/ping", ro.handlers.Ping())
+
+		r.Group(func(r chi.Router) {
+			r.Use(ro.scope(auth.ScopeRead)...)
+			r.Get("/", ro.handlers.GetAll())
+			r.Route("/value/", func(r chi.Router) {
+				r.Post("/", ro.handlers.GetMetricJSON())
+				r.Get("/gauge/{key}", ro.handlers.GetGauge())
+				r.Get("/counter/{key}", ro.handlers.GetCounter())
+			})
+			r.Get("/api/v1/query", ro.handlers.Query())
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(ro.scope(auth.ScopeWrite)...)
+			r.Post("/update/{format:[A-Za-z]+}/{key:[A-Za-z0-9]+}/{value:[A-Za-z0-9.]+}", ro.handlers.SaveMetric())
+			r.Post("/update/", ro.handlers.SaveMetricJSON())
+			r.Post("/updates/", ro.handlers.BulkSaveMetricJSON())
+			r.Post("/write", ro.handlers.WriteLineProtocol())
+		})
+
+		r.Route("/admin/", func(r chi.Router) {
+			if ro.tokens == nil {
+				r.Use(middlewares.AdminKey(ro.adminKey), middlewares.Tenant)
+			} else {
+				r.Use(ro.scope(auth.ScopeAdmin)...)
+			}
+
+			r.Delete("/metric/{format:[A-Za-z]+}/{key}", ro.admin.DeleteMetric())
+			r.Delete("/metrics", ro.admin.DeleteMetrics())
+			r.Post("/counter/{key}/reset", ro.admin.ResetCounter())
+		})
 	})
-	ro.Router.Post("/update/{format:[A-Za-z]+}/{key:[A-Za-z0-9]+}/{value:[A-Za-z0-9.]+}", ro.handlers.SaveMetric())
-	ro.Router.Post("/update/", ro.handlers.SaveMetricJSON())
-	ro.Router.Post("/updates/", ro.handlers.BulkSaveMetricJSON())
+}
+
+// scope Middleware для проверки токена, права доступа и определения арендатора.
+// Без хранилища токенов арендатор определяется только по заголовку
+func (ro *Router) scope(scope string) []func(http.Handler) http.Handler {
+	if ro.tokens == nil {
+		return []func(http.Handler) http.Handler{middlewares.Tenant}
+	}
+
+	return []func(http.Handler) http.Handler{
+		middlewares.Authenticate(ro.tokens),
+		middlewares.RequireScope(scope),
+		middlewares.Tenant,
+	}
 }
@@ -0,0 +1,242 @@
+package rules
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	conf "github.com/vllvll/devops/internal/config"
+	"github.com/vllvll/devops/internal/leader"
+	"github.com/vllvll/devops/internal/query"
+	"github.com/vllvll/devops/internal/repositories"
+	"github.com/vllvll/devops/internal/types"
+)
+
+type Evaluator struct {
+	tenants repositories.TenantRepository        // Хранилище метрик арендаторов
+	history repositories.TenantHistoryRepository // История значений для rate() и increase() (nil - функции недоступны)
+	rules   []Rule                               // Правила в порядке вычисления
+	elector *leader.Elector                      // Выбор ведущего: по расписанию правила вычисляет только ведущий (nil - всегда)
+
+	mu     sync.Mutex
+	states map[string]*tenantState // Состояние вычислений по арендатору
+}
+
+// tenantState Состояние вычислений правил арендатора. Вычисления арендатора выполняются по очереди под mu,
+// а результаты записываются после вычисления под write, чтобы запись не задерживала следующие вычисления
+type tenantState struct {
+	mu      sync.Mutex
+	pending types.Gauges // Результаты, которые еще не записаны в хранилище
+
+	write sync.Mutex // Запись результатов: результаты записываются в порядке вычисления
+}
+
+// NewEvaluator Создание сервиса, который вычисляет правила по метрикам арендаторов и записывает результаты в хранилище
+func NewEvaluator(tenants repositories.TenantRepository, history repositories.TenantHistoryRepository, rules []Rule, elector *leader.Elector) *Evaluator {
+	return &Evaluator{
+		tenants: tenants,
+		history: history,
+		rules:   rules,
+		elector: elector,
+		states:  map[string]*tenantState{},
+	}
+}
+
+// NewFromConfig Создание сервиса вычисления правил из файла конфига сервера. Если файл не задан, возвращается nil
+func NewFromConfig(config *conf.ServerConfig, tenants repositories.TenantRepository, history repositories.TenantHistoryRepository, elector *leader.Elector) (*Evaluator, error) {
+	if config.RulesFile == "" {
+		return nil, nil
+	}
+
+	rules, err := LoadRules(config.RulesFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewEvaluator(tenants, history, rules, elector), nil
+}
+
+// Run Вычисление правил всех арендаторов каждые interval до отмены ctx
+func (e *Evaluator) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !e.elector.IsLeader() {
+				continue
+			}
+
+			if err := e.Evaluate(); err != nil {
+				zap.S().Errorf("Error with recording rules: %v", err)
+			}
+		}
+	}
+}
+
+// Evaluate Вычисление всех правил для всех арендаторов
+func (e *Evaluator) Evaluate() error {
+	names, err := e.tenants.Tenants()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		e.EvaluateTenant(name, nil)
+	}
+
+	return nil
+}
+
+// EvaluateTenant Вычисление правил арендатора, которые используют метрики changed (nil - всех правил).
+// Правила, использующие результат вычисленного правила, тоже вычисляются. Результаты всех правил записываются
+// одним UpdateAll после вычисления
+func (e *Evaluator) EvaluateTenant(tenantName string, changed map[string]bool) {
+	state := e.state(tenantName)
+	repository := e.tenants.Tenant(tenantName)
+
+	state.mu.Lock()
+
+	var history repositories.HistoryRepository
+	if e.history != nil {
+		history = e.history.Tenant(tenantName)
+	}
+
+	// Правило видит исходные метрики и результаты предыдущих правил, в том числе еще не записанные
+	view := &ruleView{StatsRepository: repository, results: types.Gauges{}, hidden: make(map[string]bool, len(e.rules))}
+	for key, value := range state.pending {
+		view.results[key] = value
+	}
+
+	for _, rule := range e.rules {
+		view.hidden[rule.Record] = true
+	}
+
+	engine := query.NewEngine(view, history)
+	results := types.Gauges{}
+
+	for _, rule := range e.rules {
+		if !rule.dependsOn(changed) {
+			delete(view.hidden, rule.Record)
+
+			continue
+		}
+
+		result, err := engine.Eval(rule.expr)
+		delete(view.hidden, rule.Record)
+
+		if err != nil {
+			zap.S().Warnf("Error with recording rule %q of tenant %q: %v", rule.Record, tenantName, err)
+
+			continue
+		}
+
+		for _, series := range result {
+			if math.IsNaN(series.Value) || math.IsInf(series.Value, 0) {
+				continue
+			}
+
+			labels := make(map[string]string, len(series.Metric))
+			for key, value := range series.Metric {
+				if key != query.NameLabel {
+					labels[key] = value
+				}
+			}
+
+			key := types.SeriesName(rule.Record, labels)
+			results[key] = types.Gauge(series.Value)
+			view.results[key] = types.Gauge(series.Value)
+		}
+
+		if changed != nil {
+			changed[rule.Record] = true
+		}
+	}
+
+	if state.pending == nil {
+		state.pending = types.Gauges{}
+	}
+
+	for key, value := range results {
+		state.pending[key] = value
+	}
+
+	state.mu.Unlock()
+
+	e.flush(tenantName, state, repository)
+}
+
+// flush Запись результатов арендатора, которые еще не записаны. Результаты, вычисленные во время записи,
+// записывает следующий вызов
+func (e *Evaluator) flush(tenantName string, state *tenantState, repository repositories.StatsRepository) {
+	state.write.Lock()
+	defer state.write.Unlock()
+
+	state.mu.Lock()
+	pending := state.pending
+	state.pending = nil
+	state.mu.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+
+	if err := repository.UpdateAll(pending, types.Counters{}); err != nil {
+		zap.S().Errorf("Error with recording rules of tenant %q: %v", tenantName, err)
+	}
+}
+
+// state Состояние вычислений арендатора
+func (e *Evaluator) state(tenantName string) *tenantState {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	state, ok := e.states[tenantName]
+	if !ok {
+		state = &tenantState{}
+		e.states[tenantName] = state
+	}
+
+	return state
+}
+
+// ruleView Метрики арендатора для вычисления правила: результаты правил этого вычисления заменяют сохраненные
+// значения, а результаты правила и следующих правил скрыты, чтобы результат не участвовал в своем вычислении.
+// Движок запросов читает метрики только через GetAll
+type ruleView struct {
+	repositories.StatsRepository
+
+	results types.Gauges    // Результаты предыдущих правил
+	hidden  map[string]bool // Имена результатов, скрытых от правила
+}
+
+// GetAll Получение метрик без скрытых результатов
+func (v *ruleView) GetAll() (map[string]types.Gauge, map[string]types.Counter) {
+	gauges, counters := v.StatsRepository.GetAll()
+
+	visible := make(map[string]types.Gauge, len(gauges)+len(v.results))
+	for key, value := range gauges {
+		if !v.isHidden(key) {
+			visible[key] = value
+		}
+	}
+
+	for key, value := range v.results {
+		visible[key] = value
+	}
+
+	return visible, counters
+}
+
+// isHidden Серия принадлежит скрытому результату
+func (v *ruleView) isHidden(key string) bool {
+	name, _ := types.ParseSeriesName(key)
+
+	return v.hidden[name]
+}
@@ -0,0 +1,130 @@
+package rules
+
+import (
+	"github.com/vllvll/devops/internal/repositories"
+	"github.com/vllvll/devops/internal/types"
+)
+
+type evaluatedTenants struct {
+	tenants   repositories.TenantRepository
+	evaluator *Evaluator
+}
+
+type evaluatedRepository struct {
+	repository repositories.StatsRepository
+	evaluator  *Evaluator
+	tenant     string
+}
+
+// NewTenantRepository Обертка над хранилищем метрик арендаторов, которая после каждой записи вычисляет правила,
+// использующие записанные метрики. Результаты правил записываются в хранилище evaluator, минуя обертку
+func NewTenantRepository(tenants repositories.TenantRepository, evaluator *Evaluator) repositories.TenantRepository {
+	return &evaluatedTenants{
+		tenants:   tenants,
+		evaluator: evaluator,
+	}
+}
+
+// Tenant Получение метрик арендатора
+func (t *evaluatedTenants) Tenant(name string) repositories.StatsRepository {
+	return &evaluatedRepository{
+		repository: t.tenants.Tenant(name),
+		evaluator:  t.evaluator,
+		tenant:     name,
+	}
+}
+
+// Tenants Получение списка арендаторов
+func (t *evaluatedTenants) Tenants() ([]string, error) {
+	return t.tenants.Tenants()
+}
+
+// UpdateGauge Обновить значение метрики с типом Gauge и вычислить зависящие от нее правила
+func (s *evaluatedRepository) UpdateGauge(key string, value types.Gauge) {
+	s.repository.UpdateGauge(key, value)
+
+	s.evaluate([]string{key})
+}
+
+// UpdateCount Обновить значение метрики с типом Counter и вычислить зависящие от нее правила
+func (s *evaluatedRepository) UpdateCount(key string, value types.Counter) {
+	s.repository.UpdateCount(key, value)
+
+	s.evaluate([]string{key})
+}
+
+// GetAll Получение всех метрик
+func (s *evaluatedRepository) GetAll() (map[string]types.Gauge, map[string]types.Counter) {
+	return s.repository.GetAll()
+}
+
+// GetGaugeByKey Получить значение метрики типа Gauge по ключу
+func (s *evaluatedRepository) GetGaugeByKey(key string) (types.Gauge, error) {
+	return s.repository.GetGaugeByKey(key)
+}
+
+// GetCounterByKey Получить значение метрики типа Counter по ключу
+func (s *evaluatedRepository) GetCounterByKey(key string) (types.Counter, error) {
+	return s.repository.GetCounterByKey(key)
+}
+
+// UpdateAll Обновление всех значений типов Gauge и Counter и вычисление зависящих от них правил
+func (s *evaluatedRepository) UpdateAll(gauges types.Gauges, counters types.Counters) error {
+	if err := s.repository.UpdateAll(gauges, counters); err != nil {
+		return err
+	}
+
+	s.evaluate(seriesKeys(gauges, counters))
+
+	return nil
+}
+
+// ReplaceAll Запись значений Gauge, замена значений Counter и вычисление зависящих от них правил
+func (s *evaluatedRepository) ReplaceAll(gauges types.Gauges, counters types.Counters) error {
+	if err := s.repository.ReplaceAll(gauges, counters); err != nil {
+		return err
+	}
+
+	s.evaluate(seriesKeys(gauges, counters))
+
+	return nil
+}
+
+// DeleteGauge Удаление метрики типа Gauge
+func (s *evaluatedRepository) DeleteGauge(key string) error {
+	return s.repository.DeleteGauge(key)
+}
+
+// DeleteCounter Удаление метрики типа Counter
+func (s *evaluatedRepository) DeleteCounter(key string) error {
+	return s.repository.DeleteCounter(key)
+}
+
+// ResetCounter Сброс значения метрики типа Counter
+func (s *evaluatedRepository) ResetCounter(key string) error {
+	return s.repository.ResetCounter(key)
+}
+
+// seriesKeys Имена записанных серий
+func seriesKeys(gauges types.Gauges, counters types.Counters) []string {
+	keys := make([]string, 0, len(gauges)+len(counters))
+	for key := range gauges {
+		keys = append(keys, key)
+	}
+	for key := range counters {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// evaluate Вычисление правил, которые используют метрики записанных серий
+func (s *evaluatedRepository) evaluate(keys []string) {
+	changed := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		name, _ := types.ParseSeriesName(key)
+		changed[name] = true
+	}
+
+	s.evaluator.EvaluateTenant(s.tenant, changed)
+}
@@ -0,0 +1,154 @@
+// Package rules Правила записи вычисляемых метрик.
+//
+// Правило задает имя новой метрики Gauge и выражение языка запросов над сохраненными метриками, например
+// HeapInuse / HeapSys или TotalMemory - FreeMemory. Результаты вычисления записываются в хранилище арендатора
+// одним StatsRepository.UpdateAll, поэтому вычисляемые метрики доступны в GET /, /value/ и во всех форматах
+// экспорта наравне с остальными. Если выражение возвращает несколько серий, каждая записывается с метками серии.
+//
+// Правила вычисляются по расписанию для всех арендаторов и (или) при записи исходных метрик арендатора.
+// Правила вычисляются в порядке файла, поэтому правило может использовать результат предыдущего правила.
+// Собственный результат правила и результаты следующих правил скрыты от его выражения, в том числе от селекторов
+// с регулярным выражением, поэтому повторное вычисление не накапливает результат.
+// Результаты NaN и ±Inf (например, при делении на ноль) не записываются.
+package rules
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/vllvll/devops/internal/dictionaries"
+	"github.com/vllvll/devops/internal/query"
+	"github.com/vllvll/devops/internal/types"
+	"github.com/vllvll/devops/internal/validation"
+)
+
+// Rule Правило записи вычисляемой метрики
+type Rule struct {
+	Record string `json:"record"` // Имя вычисляемой метрики Gauge
+	Expr   string `json:"expr"`   // Выражение языка запросов
+
+	expr    query.Expr      // Разобранное выражение
+	sources map[string]bool // Имена исходных метрик (nil - выражение может использовать любую метрику)
+}
+
+// File Содержимое файла с правилами
+type File struct {
+	Rules []Rule `json:"rules"`
+}
+
+// LoadRules Чтение и проверка правил из JSON файла
+func LoadRules(path string) ([]Rule, error) {
+	var file File
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	return Compile(file.Rules)
+}
+
+// Compile Разбор выражений правил. Имена вычисляемых метрик должны быть уникальными,
+// а выражение правила не может использовать собственный результат или результат следующего правила
+func Compile(rules []Rule) ([]Rule, error) {
+	compiled := make([]Rule, 0, len(rules))
+	records := map[string]bool{}
+
+	positions := make(map[string]int, len(rules))
+	for i := len(rules) - 1; i >= 0; i-- {
+		positions[rules[i].Record] = i
+	}
+
+	for i, rule := range rules {
+		value := float64(0)
+		if err := validation.NewRules(0, 0, false).Metric(types.Metrics{ID: rule.Record, MType: dictionaries.GaugeType, Value: &value}); err != nil {
+			return nil, fmt.Errorf("rule %d: record %q: %v", i, rule.Record, err)
+		}
+
+		if records[rule.Record] {
+			return nil, fmt.Errorf("rule %d: duplicate record %q", i, rule.Record)
+		}
+		records[rule.Record] = true
+
+		expr, err := query.Parse(rule.Expr)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %v", rule.Record, err)
+		}
+
+		rule.expr = expr
+		rule.sources = sources(expr)
+
+		if rule.sources[rule.Record] {
+			return nil, fmt.Errorf("rule %q: expression uses its own result", rule.Record)
+		}
+
+		for name := range rule.sources {
+			if position, ok := positions[name]; ok && position > i {
+				return nil, fmt.Errorf("rule %q: expression uses the result of later rule %q", rule.Record, name)
+			}
+		}
+
+		compiled = append(compiled, rule)
+	}
+
+	return compiled, nil
+}
+
+// dependsOn Проверка, что выражение правила использует хотя бы одну из метрик names (nil - любую)
+func (r Rule) dependsOn(names map[string]bool) bool {
+	if names == nil || r.sources == nil {
+		return true
+	}
+
+	for name := range names {
+		if r.sources[name] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sources Имена метрик, которые выбирает выражение. nil - условие на имя не задано или задано не равенством
+func sources(expr query.Expr) map[string]bool {
+	names := map[string]bool{}
+
+	var walk func(expr query.Expr) bool
+	walk = func(expr query.Expr) bool {
+		switch expr := expr.(type) {
+		case *query.Selector:
+			for _, matcher := range expr.Matchers {
+				if matcher.Label == query.NameLabel && matcher.Type == query.MatchEqual {
+					names[matcher.Value] = true
+
+					return true
+				}
+			}
+
+			return false
+		case *query.RangeSelector:
+			return walk(expr.Selector)
+		case *query.Call:
+			return walk(expr.Arg)
+		case *query.Aggregate:
+			return walk(expr.Arg)
+		case *query.BinaryExpr:
+			return walk(expr.LHS) && walk(expr.RHS)
+		case *query.NumberLiteral:
+			return true
+		}
+
+		return false
+	}
+
+	if !walk(expr) {
+		return nil
+	}
+
+	return names
+}
@@ -0,0 +1,224 @@
+package rules
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vllvll/devops/internal/repositories"
+	"github.com/vllvll/devops/internal/types"
+)
+
+func TestLoadRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"rules": [
+		{"record": "HeapUsage", "expr": "HeapInuse / HeapSys"},
+		{"record": "UsedMemory", "expr": "TotalMemory - FreeMemory"},
+		{"record": "HeapUsagePercent", "expr": "HeapUsage * 100"},
+		{"record": "HeapTotal", "expr": "sum({__name__=~\"Heap.*\"})"}
+	]}`), 0o600))
+
+	rules, err := LoadRules(path)
+	require.NoError(t, err)
+	require.Len(t, rules, 4)
+
+	assert.Equal(t, map[string]bool{"HeapInuse": true, "HeapSys": true}, rules[0].sources)
+	assert.Equal(t, map[string]bool{"HeapUsage": true}, rules[2].sources)
+	assert.Nil(t, rules[3].sources, "regexp selector may use any metric")
+
+	assert.True(t, rules[0].dependsOn(map[string]bool{"HeapSys": true}))
+	assert.False(t, rules[0].dependsOn(map[string]bool{"PollCount": true}))
+	assert.True(t, rules[3].dependsOn(map[string]bool{"PollCount": true}))
+}
+
+func TestCompile_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []Rule
+	}{
+		{name: "empty record", rules: []Rule{{Expr: "HeapInuse"}}},
+		{name: "invalid record", rules: []Rule{{Record: "Heap Usage", Expr: "HeapInuse"}}},
+		{name: "duplicate record", rules: []Rule{{Record: "A", Expr: "B"}, {Record: "A", Expr: "C"}}},
+		{name: "invalid expression", rules: []Rule{{Record: "A", Expr: "B /"}}},
+		{name: "own result", rules: []Rule{{Record: "A", Expr: "A + 1"}}},
+		{name: "later result", rules: []Rule{{Record: "A", Expr: "B"}, {Record: "B", Expr: "C"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Compile(tt.rules)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestEvaluator_Evaluate(t *testing.T) {
+	tenants := repositories.NewTenantMemoryRepository()
+	_ = tenants.Tenant("").UpdateAll(types.Gauges{
+		"HeapInuse": 25,
+		"HeapSys":   100,
+	}, nil)
+	_ = tenants.Tenant("team-a").UpdateAll(types.Gauges{
+		"TotalMemory;host=a": 16,
+		"FreeMemory;host=a":  4,
+		"TotalMemory;host=b": 8,
+		"FreeMemory;host=b":  6,
+		"Zero":               0,
+	}, nil)
+
+	rules, err := Compile([]Rule{
+		{Record: "HeapUsage", Expr: "HeapInuse / HeapSys"},
+		{Record: "HeapUsagePercent", Expr: "HeapUsage * 100"},
+		{Record: "UsedMemory", Expr: "TotalMemory - FreeMemory"},
+		{Record: "Infinite", Expr: "1 / Zero"},
+		{Record: "Rate", Expr: "rate(PollCount[1m])"},
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, NewEvaluator(tenants, nil, rules, nil).Evaluate())
+
+	gauges, _ := tenants.Tenant("").GetAll()
+	assert.Equal(t, types.Gauge(0.25), gauges["HeapUsage"])
+	assert.Equal(t, types.Gauge(25), gauges["HeapUsagePercent"], "rule uses the result of the previous rule")
+	assert.NotContains(t, gauges, "UsedMemory")
+
+	// Результаты с несколькими сериями записываются с метками, нечисловые результаты пропускаются
+	gauges, _ = tenants.Tenant("team-a").GetAll()
+	assert.Equal(t, types.Gauge(12), gauges["UsedMemory;host=a"])
+	assert.Equal(t, types.Gauge(2), gauges["UsedMemory;host=b"])
+	assert.NotContains(t, gauges, "Infinite")
+	assert.NotContains(t, gauges, "HeapUsage")
+}
+
+func TestEvaluator_Stable(t *testing.T) {
+	tenants := repositories.NewTenantMemoryRepository()
+	_ = tenants.Tenant("").UpdateAll(types.Gauges{
+		"HeapInuse": 25,
+		"HeapSys":   100,
+	}, nil)
+
+	rules, err := Compile([]Rule{
+		{Record: "HeapUsage", Expr: "HeapInuse / HeapSys"},
+		{Record: "HeapTotal", Expr: `sum({__name__=~"Heap.*"})`},
+		{Record: "HeapDouble", Expr: "HeapTotal * 2"},
+	})
+	require.NoError(t, err)
+
+	evaluator := NewEvaluator(tenants, nil, rules, nil)
+
+	// Селектор видит результат предыдущего правила, но не свой результат и не результаты следующих правил
+	for i := 0; i < 3; i++ {
+		require.NoError(t, evaluator.Evaluate())
+
+		gauges, _ := tenants.Tenant("").GetAll()
+		assert.Equal(t, types.Gauge(125.25), gauges["HeapTotal"], "evaluation %d", i)
+		assert.Equal(t, types.Gauge(250.5), gauges["HeapDouble"], "evaluation %d", i)
+	}
+}
+
+// blockingTenants Хранилище, в котором запись арендатора slow ждет закрытия release
+type blockingTenants struct {
+	repositories.TenantRepository
+
+	slow    string
+	release chan struct{}
+}
+
+func (t *blockingTenants) Tenant(name string) repositories.StatsRepository {
+	if name != t.slow {
+		return t.TenantRepository.Tenant(name)
+	}
+
+	return &blockingStats{StatsRepository: t.TenantRepository.Tenant(name), release: t.release}
+}
+
+type blockingStats struct {
+	repositories.StatsRepository
+
+	release chan struct{}
+}
+
+func (s *blockingStats) UpdateAll(gauges types.Gauges, counters types.Counters) error {
+	<-s.release
+
+	return s.StatsRepository.UpdateAll(gauges, counters)
+}
+
+func TestEvaluator_EvaluateTenantConcurrent(t *testing.T) {
+	memory := repositories.NewTenantMemoryRepository()
+	_ = memory.Tenant("slow").UpdateAll(types.Gauges{"HeapInuse": 25, "HeapSys": 100}, nil)
+	_ = memory.Tenant("fast").UpdateAll(types.Gauges{"HeapInuse": 50, "HeapSys": 100}, nil)
+
+	rules, err := Compile([]Rule{{Record: "HeapUsage", Expr: "HeapInuse / HeapSys"}})
+	require.NoError(t, err)
+
+	tenants := &blockingTenants{TenantRepository: memory, slow: "slow", release: make(chan struct{})}
+	evaluator := NewEvaluator(tenants, nil, rules, nil)
+
+	slow := make(chan struct{})
+	go func() {
+		evaluator.EvaluateTenant("slow", nil)
+		close(slow)
+	}()
+
+	// Запись результатов одного арендатора не задерживает вычисления другого
+	fast := make(chan struct{})
+	go func() {
+		evaluator.EvaluateTenant("fast", nil)
+		close(fast)
+	}()
+
+	select {
+	case <-fast:
+	case <-time.After(time.Second):
+		t.Fatal("evaluation of another tenant waits for a slow write")
+	}
+
+	value, err := memory.Tenant("fast").GetGaugeByKey("HeapUsage")
+	require.NoError(t, err)
+	assert.Equal(t, types.Gauge(0.5), value)
+
+	close(tenants.release)
+	<-slow
+
+	value, err = memory.Tenant("slow").GetGaugeByKey("HeapUsage")
+	require.NoError(t, err)
+	assert.Equal(t, types.Gauge(0.25), value)
+}
+
+func TestNewTenantRepository(t *testing.T) {
+	tenants := repositories.NewTenantMemoryRepository()
+
+	rules, err := Compile([]Rule{
+		{Record: "HeapUsage", Expr: "HeapInuse / HeapSys"},
+		{Record: "HeapUsagePercent", Expr: "HeapUsage * 100"},
+		{Record: "Polls", Expr: "PollCount * 1"},
+	})
+	require.NoError(t, err)
+
+	repository := NewTenantRepository(tenants, NewEvaluator(tenants, nil, rules, nil)).Tenant("team-a")
+
+	require.NoError(t, repository.UpdateAll(types.Gauges{"HeapInuse": 50, "HeapSys": 200}, nil))
+
+	gauges, _ := repository.GetAll()
+	assert.Equal(t, types.Gauge(0.25), gauges["HeapUsage"])
+	assert.Equal(t, types.Gauge(25), gauges["HeapUsagePercent"])
+	assert.NotContains(t, gauges, "Polls", "rules of other metrics are not evaluated")
+
+	repository.UpdateGauge("HeapInuse", 100)
+
+	value, err := repository.GetGaugeByKey("HeapUsagePercent")
+	require.NoError(t, err)
+	assert.Equal(t, types.Gauge(50), value)
+
+	repository.UpdateCount("PollCount", 3)
+	repository.UpdateCount("PollCount", 4)
+
+	value, err = repository.GetGaugeByKey("Polls")
+	require.NoError(t, err)
+	assert.Equal(t, types.Gauge(7), value)
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/vllvll/devops/internal/dictionaries"
+	"github.com/vllvll/devops/internal/limits"
+	"github.com/vllvll/devops/internal/repositories"
+)
+
+// ErrUnknownMetricType Неизвестный тип метрики
+var ErrUnknownMetricType = errors.New("metric type does not exist")
+
+type MetricAdmin struct {
+	tenants repositories.TenantRepository // Сервис для чтения и записи данных метрик арендаторов
+	audit   Audit                         // Журнал действий администратора
+	limits  *limits.Limits                // Ограничения, в которых освобождаются удаленные серии (nil - без ограничений)
+}
+
+type Admin interface {
+	DeleteMetric(actor string, tenant string, mType string, key string) error
+	DeleteMetrics(actor string, tenant string, mType string, pattern string) (int, error)
+	ResetCounter(actor string, tenant string, key string) error
+}
+
+// NewMetricAdmin Создание сервиса для удаления и сброса метрик с записью в журнал
+func NewMetricAdmin(tenants repositories.TenantRepository, audit Audit, limiter *limits.Limits) Admin {
+	return &MetricAdmin{
+		tenants: tenants,
+		audit:   audit,
+		limits:  limiter,
+	}
+}
+
+// DeleteMetric Удаление метрики арендатора по типу и ключу
+func (a *MetricAdmin) DeleteMetric(actor string, tenant string, mType string, key string) error {
+	err := a.deleteMetric(a.tenants.Tenant(tenant), mType, key)
+	if err == nil {
+		a.limits.Forget(tenant, mType, key)
+	}
+
+	a.record(AuditEntry{Action: AuditDeleteMetric, Actor: actor, Tenant: tenant, Type: mType, Key: key, Count: countOf(err)}, err)
+
+	return err
+}
+
+// DeleteMetrics Удаление метрик арендатора, имя которых подходит под регулярное выражение.
+// Пустой тип - удаление метрик всех типов
+func (a *MetricAdmin) DeleteMetrics(actor string, tenant string, mType string, pattern string) (int, error) {
+	var deleted int
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		a.record(AuditEntry{Action: AuditDeleteMetrics, Actor: actor, Tenant: tenant, Type: mType, Pattern: pattern}, err)
+
+		return 0, fmt.Errorf("invalid pattern: %w", err)
+	}
+
+	if mType != "" && mType != dictionaries.GaugeType && mType != dictionaries.CounterType {
+		a.record(AuditEntry{Action: AuditDeleteMetrics, Actor: actor, Tenant: tenant, Type: mType, Pattern: pattern}, ErrUnknownMetricType)
+
+		return 0, ErrUnknownMetricType
+	}
+
+	repository := a.tenants.Tenant(tenant)
+	gauges, counters := repository.GetAll()
+
+	if mType == "" || mType == dictionaries.GaugeType {
+		for key := range gauges {
+			if re.MatchString(key) && repository.DeleteGauge(key) == nil {
+				a.limits.Forget(tenant, dictionaries.GaugeType, key)
+				deleted++
+			}
+		}
+	}
+
+	if mType == "" || mType == dictionaries.CounterType {
+		for key := range counters {
+			if re.MatchString(key) && repository.DeleteCounter(key) == nil {
+				a.limits.Forget(tenant, dictionaries.CounterType, key)
+				deleted++
+			}
+		}
+	}
+
+	a.record(AuditEntry{Action: AuditDeleteMetrics, Actor: actor, Tenant: tenant, Type: mType, Pattern: pattern, Count: deleted}, nil)
+
+	return deleted, nil
+}
+
+// ResetCounter Сброс значения счетчика арендатора
+func (a *MetricAdmin) ResetCounter(actor string, tenant string, key string) error {
+	err := a.tenants.Tenant(tenant).ResetCounter(key)
+
+	a.record(AuditEntry{Action: AuditResetCounter, Actor: actor, Tenant: tenant, Type: dictionaries.CounterType, Key: key, Count: countOf(err)}, err)
+
+	return err
+}
+
+func (a *MetricAdmin) deleteMetric(repository repositories.StatsRepository, mType string, key string) error {
+	switch mType {
+	case dictionaries.GaugeType:
+		return repository.DeleteGauge(key)
+	case dictionaries.CounterType:
+		return repository.DeleteCounter(key)
+	}
+
+	return ErrUnknownMetricType
+}
+
+func (a *MetricAdmin) record(entry AuditEntry, err error) {
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	a.audit.Record(entry)
+}
+
+func countOf(err error) int {
+	if err != nil {
+		return 0
+	}
+
+	return 1
+}
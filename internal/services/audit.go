@@ -0,0 +1,94 @@
+package services
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Действия администратора
+const (
+	AuditDeleteMetric  = "delete_metric"
+	AuditDeleteMetrics = "delete_metrics"
+	AuditResetCounter  = "reset_counter"
+)
+
+// AuditEntry Запись журнала действий администратора
+type AuditEntry struct {
+	Time    time.Time `json:"time"`              // Время действия
+	Action  string    `json:"action"`            // Действие
+	Actor   string    `json:"actor"`             // Кто выполнил действие (IP или идентификатор токена)
+	Tenant  string    `json:"tenant,omitempty"`  // Арендатор, метрики которого затронуты
+	Type    string    `json:"type,omitempty"`    // Тип метрики
+	Key     string    `json:"key,omitempty"`     // Имя метрики
+	Pattern string    `json:"pattern,omitempty"` // Регулярное выражение для массового удаления
+	Count   int       `json:"count"`             // Количество затронутых метрик
+	Error   string    `json:"error,omitempty"`   // Ошибка выполнения
+}
+
+type AuditLogger struct {
+	mu      sync.Mutex
+	writer  io.Writer     // Куда пишется журнал
+	file    *os.File      // Файл журнала (nil - запись в stderr)
+	encoder *json.Encoder // Кодирование записей в JSON
+}
+
+type Audit interface {
+	Record(entry AuditEntry)
+	Close() error
+}
+
+// NewAuditLogger Создание журнала действий администратора. Пустой путь - запись в stderr
+func NewAuditLogger(path string) (Audit, error) {
+	var writer io.Writer = os.Stderr
+	var file *os.File
+
+	if path != "" {
+		var err error
+
+		file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+
+		writer = file
+	}
+
+	return &AuditLogger{
+		writer:  writer,
+		file:    file,
+		encoder: json.NewEncoder(writer),
+	}, nil
+}
+
+// Record Запись действия в журнал
+func (a *AuditLogger) Record(entry AuditEntry) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	_ = a.encoder.Encode(entry)
+}
+
+// Close Сброс журнала на диск и закрытие файла. Stderr не закрывается
+func (a *AuditLogger) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.file == nil {
+		return nil
+	}
+
+	if err := a.file.Sync(); err != nil {
+		a.file.Close()
+
+		return err
+	}
+
+	return a.file.Close()
+}
@@ -0,0 +1,34 @@
+package services
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLogger_Close(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	audit, err := NewAuditLogger(path)
+	require.NoError(t, err)
+
+	audit.Record(AuditEntry{Action: AuditResetCounter, Actor: "10.0.0.1", Key: "PollCount", Count: 1})
+	require.NoError(t, audit.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	var entry AuditEntry
+	require.NoError(t, json.Unmarshal(data, &entry))
+	assert.Equal(t, AuditResetCounter, entry.Action)
+	assert.Equal(t, "PollCount", entry.Key)
+
+	// Журнал в stderr не закрывается
+	stderr, err := NewAuditLogger("")
+	require.NoError(t, err)
+	assert.NoError(t, stderr.Close())
+}
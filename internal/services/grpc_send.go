@@ -4,25 +4,36 @@ package services
 import (
 	"context"
 	"fmt"
-	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 
 	conf "github.com/vllvll/devops/internal/config"
 	"github.com/vllvll/devops/internal/dictionaries"
+	"github.com/vllvll/devops/internal/logger"
+	"github.com/vllvll/devops/internal/repositories"
+	"github.com/vllvll/devops/internal/tenant"
 	"github.com/vllvll/devops/internal/types"
+	"github.com/vllvll/devops/internal/validation"
 	pb "github.com/vllvll/devops/proto"
 )
 
 type GRPCSender struct {
-	Client  pb.MetricsClient
-	signer  Signer  // Сервис для подписи данных
-	encrypt Encrypt // Сервис для ассиметричного шифрования
-	ip      string  // IP клиента
+	Client   pb.MetricsClient
+	signer   Signer         // Сервис для подписи данных
+	encrypt  Encrypt        // Сервис для ассиметричного шифрования
+	ip       string         // IP клиента
+	token    string         // Токен доступа к серверу
+	tenant   string         // Арендатор, от имени которого отправляются метрики
+	agentID  string         // Идентификатор агента
+	rejected *int64         // Количество метрик, отклоненных сервером
+	totals   *counterTotals // Накопленные значения счетчиков (nil - отправляются приращения)
 }
 
 // NewGRPCSendClient Создание сервиса для отправки данных из агента на сервер
@@ -35,16 +46,21 @@ func NewGRPCSendClient(AgentConfig *conf.AgentConfig, signer Signer, encrypt Enc
 	// устанавливаем соединение с сервером
 	conn, err := grpc.Dial(AgentConfig.Address, grpc.WithTransportCredentials(insecure.NewCredentials()))
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	c := pb.NewMetricsClient(conn)
 
 	return &GRPCSender{
-		Client:  c,
-		signer:  signer,
-		encrypt: encrypt,
-		ip:      ip,
+		Client:   c,
+		signer:   signer,
+		encrypt:  encrypt,
+		ip:       ip,
+		token:    AgentConfig.Token,
+		tenant:   AgentConfig.Tenant,
+		agentID:  AgentConfig.AgentID,
+		rejected: new(int64),
+		totals:   newCounterTotals(AgentConfig.Cumulative),
 	}, nil
 }
 
@@ -99,7 +115,7 @@ func (c GRPCSender) Prepare(ctx context.Context, gaugesIn <-chan types.Gauges, c
 					}
 
 					for key, value := range counters {
-						var counterValue = int64(value)
+						var counterValue = int64(c.totals.add(key, value))
 
 						metricCh <- types.Metrics{
 							ID:    key,
@@ -153,7 +169,12 @@ func (c GRPCSender) Send(ctx context.Context, metricCh <-chan types.Metrics, rep
 	}
 }
 
-// Внутренний метод для отправки метрик на сервер
+// Rejected Количество метрик, отклоненных сервером с момента запуска агента
+func (c GRPCSender) Rejected() int64 {
+	return atomic.LoadInt64(c.rejected)
+}
+
+// Внутренний метод для отправки метрик на сервер. Сервер сохраняет допустимые метрики и возвращает отклоненные
 func (c GRPCSender) push(metrics *[]types.Metrics) error {
 	var bulkMetrics []*pb.Metric
 
@@ -175,16 +196,62 @@ func (c GRPCSender) push(metrics *[]types.Metrics) error {
 		})
 	}
 
-	request := pb.AddBulkMetricsRequest{}
-	request.Metrics = &pb.BulkMetrics{Metrics: bulkMetrics}
+	request := pb.AddBulkMetricsRequest{
+		Metrics: &pb.BulkMetrics{Metrics: bulkMetrics},
+		BatchId: newRequestID(),
+	}
 
-	md := metadata.New(map[string]string{"ip": c.ip})
+	requestID := newRequestID()
+
+	md := metadata.New(map[string]string{
+		"ip":                     c.ip,
+		logger.AgentIDMetadata:   c.agentID,
+		logger.RequestIDMetadata: requestID,
+	})
+	if c.token != "" {
+		md.Set("authorization", "Bearer "+c.token)
+	}
+	if c.tenant != "" {
+		md.Set(tenant.MetadataKey, c.tenant)
+	}
+	if c.totals != nil {
+		md.Set(repositories.CounterModeMetadata, repositories.CounterModeCumulative)
+	}
 	ctx := metadata.NewOutgoingContext(context.Background(), md)
 
-	_, err := c.Client.BulkSaveMetrics(ctx, &request)
+	// Повтор безопасен для Counter: сервер не применяет пакет с тем же идентификатором дважды
+	response, err := c.Client.BulkSaveMetricsPartial(ctx, &request)
+	for attempt := 0; attempt < pushRetries && retryable(err); attempt++ {
+		time.Sleep(retryWait)
+
+		response, err = c.Client.BulkSaveMetricsPartial(ctx, &request)
+	}
+
 	if err != nil {
-		return err
+		return fmt.Errorf("request %s: %w", requestID, err)
 	}
 
+	rejected := make([]validation.Violation, 0, len(response.GetRejected()))
+	for _, metric := range response.GetRejected() {
+		rejected = append(rejected, validation.Violation{
+			Index:  int(metric.GetIndex()),
+			ID:     metric.GetId(),
+			Field:  metric.GetField(),
+			Reason: metric.GetReason(),
+		})
+	}
+
+	logRejected(requestID, rejected, c.rejected)
+
 	return nil
 }
+
+// retryable Ошибка, после которой пакет можно отправить повторно
+func retryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Internal, codes.Aborted:
+		return true
+	}
+
+	return false
+}
@@ -5,20 +5,38 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-resty/resty/v2"
+	"github.com/gofrs/uuid"
+	"go.uber.org/zap"
 
+	"github.com/vllvll/devops/internal/batch"
 	conf "github.com/vllvll/devops/internal/config"
 	"github.com/vllvll/devops/internal/dictionaries"
+	"github.com/vllvll/devops/internal/logger"
+	"github.com/vllvll/devops/internal/repositories"
+	"github.com/vllvll/devops/internal/tenant"
 	"github.com/vllvll/devops/internal/types"
+	"github.com/vllvll/devops/internal/validation"
+)
+
+// Повторная отправка пакета при сетевых ошибках и ошибках сервера
+const (
+	pushRetries = 2
+	retryWait   = time.Second
 )
 
 type Sender struct {
-	Client  *resty.Client // HTTP клиент
-	signer  Signer        // Сервис для подписи данных
-	encrypt Encrypt       // Сервис для ассиметричного шифрования
+	Client   *resty.Client  // HTTP клиент
+	signer   Signer         // Сервис для подписи данных
+	encrypt  Encrypt        // Сервис для ассиметричного шифрования
+	rejected *int64         // Количество метрик, отклоненных сервером
+	totals   *counterTotals // Накопленные значения счетчиков (nil - отправляются приращения)
 }
 
 // NewSendClient Создание сервиса для отправки данных из агента на сервер
@@ -28,15 +46,36 @@ func NewSendClient(AgentConfig *conf.AgentConfig, signer Signer, encrypt Encrypt
 		return nil, fmt.Errorf("IP адрес не найден")
 	}
 
+	// Повтор безопасен для Counter: сервер не применяет пакет с тем же идентификатором дважды
 	client := resty.New().
 		SetBaseURL(AgentConfig.AddressWithHTTP()).
 		SetHeader("Content-Type", "application/json").
-		SetHeader("X-Real-IP", ip)
+		SetHeader("X-Real-IP", ip).
+		SetHeader(logger.AgentIDHeader, AgentConfig.AgentID).
+		SetRetryCount(pushRetries).
+		SetRetryWaitTime(retryWait).
+		AddRetryCondition(func(response *resty.Response, err error) bool {
+			return err != nil || response.StatusCode() >= http.StatusInternalServerError || response.StatusCode() == http.StatusConflict
+		})
+
+	if AgentConfig.Token != "" {
+		client.SetAuthToken(AgentConfig.Token)
+	}
+
+	if AgentConfig.Tenant != "" {
+		client.SetHeader(tenant.Header, AgentConfig.Tenant)
+	}
+
+	if AgentConfig.Cumulative {
+		client.SetHeader(repositories.CounterModeHeader, repositories.CounterModeCumulative)
+	}
 
 	return &Sender{
-		Client:  client,
-		signer:  signer,
-		encrypt: encrypt,
+		Client:   client,
+		signer:   signer,
+		encrypt:  encrypt,
+		rejected: new(int64),
+		totals:   newCounterTotals(AgentConfig.Cumulative),
 	}, nil
 }
 
@@ -92,7 +131,7 @@ func (c Sender) Prepare(ctx context.Context, gaugesIn <-chan types.Gauges, count
 					}
 
 					for key, value := range counters {
-						var counterValue = int64(value)
+						var counterValue = int64(c.totals.add(key, value))
 
 						metricCh <- types.Metrics{
 							ID:    key,
@@ -146,7 +185,13 @@ func (c Sender) Send(ctx context.Context, metricCh <-chan types.Metrics, reportT
 	}
 }
 
-// Внутренний метод для отправки метрик на сервер
+// Rejected Количество метрик, отклоненных сервером с момента запуска агента
+func (c Sender) Rejected() int64 {
+	return atomic.LoadInt64(c.rejected)
+}
+
+// Внутренний метод для отправки метрик на сервер. Сервер сохраняет допустимые метрики и возвращает отклоненные,
+// а сервер без частичной записи принимает пакет целиком
 func (c Sender) push(metrics *[]types.Metrics) error {
 	content, err := json.Marshal(*metrics)
 	if err != nil {
@@ -160,13 +205,69 @@ func (c Sender) push(metrics *[]types.Metrics) error {
 		}
 	}
 
-	_, err = c.Client.R().
+	requestID := newRequestID()
+
+	response, err := c.Client.R().
+		SetHeader(logger.RequestIDHeader, requestID).
+		SetHeader(batch.Header, newRequestID()).
+		SetQueryParam(validation.PartialQuery, "true").
 		SetBody(content).
 		Post("/updates/")
 
 	if err != nil {
-		return err
+		return fmt.Errorf("request %s: %w", requestID, err)
+	}
+
+	if response.IsError() {
+		return fmt.Errorf("request %s: %s: %s", requestID, response.Status(), strings.TrimSpace(string(response.Body())))
+	}
+
+	// Сервер без частичной записи не знает параметр validation.PartialQuery и отвечает текстом после записи всего пакета
+	if !strings.HasPrefix(response.Header().Get("Content-Type"), "application/json") {
+		return nil
 	}
 
+	var result validation.Result
+	if err := json.Unmarshal(response.Body(), &result); err != nil {
+		return fmt.Errorf("request %s: %w", requestID, err)
+	}
+
+	logRejected(requestID, result.Rejected, c.rejected)
+
 	return nil
 }
+
+// logRejected Логирование метрик, отклоненных сервером, и учет их количества в total
+func logRejected(requestID string, rejected []validation.Violation, total *int64) {
+	if len(rejected) == 0 {
+		return
+	}
+
+	count := make(map[int]bool, len(rejected))
+	for _, violation := range rejected {
+		count[violation.Index] = true
+
+		zap.S().Warnw("metric rejected",
+			"request_id", requestID,
+			"metric", violation.ID,
+			"field", violation.Field,
+			"reason", violation.Reason,
+		)
+	}
+
+	zap.S().Warnw("metrics rejected by server",
+		"request_id", requestID,
+		"rejected", len(count),
+		"total", atomic.AddInt64(total, int64(len(count))),
+	)
+}
+
+// newRequestID Идентификатор запроса для сопоставления логов агента и сервера
+func newRequestID() string {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return ""
+	}
+
+	return id.String()
+}
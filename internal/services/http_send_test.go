@@ -0,0 +1,121 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vllvll/devops/internal/batch"
+	"github.com/vllvll/devops/internal/types"
+	"github.com/vllvll/devops/internal/validation"
+)
+
+func TestSender_push(t *testing.T) {
+	var batchIDs []string
+
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "true", r.URL.Query().Get(validation.PartialQuery))
+		batchIDs = append(batchIDs, r.Header.Get(batch.Header))
+
+		var metrics []types.Metrics
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&metrics))
+
+		result := validation.Result{Accepted: len(metrics) - 1}
+		result.Reject(1, metrics[1].ID, validation.FieldHash, "hash does not match")
+
+		rw.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(rw).Encode(result)
+	}))
+	defer ts.Close()
+
+	sender := Sender{
+		Client:   resty.New().SetBaseURL(ts.URL),
+		signer:   NewMetricSigner(""),
+		rejected: new(int64),
+	}
+
+	value := 1.5
+	delta := int64(1)
+	metrics := []types.Metrics{
+		{ID: "Alloc", MType: "gauge", Value: &value},
+		{ID: "PollCount", MType: "counter", Delta: &delta},
+	}
+
+	require.NoError(t, sender.push(&metrics))
+	require.NoError(t, sender.push(&metrics))
+	assert.Equal(t, int64(2), sender.Rejected())
+
+	require.Len(t, batchIDs, 2)
+	assert.NotEmpty(t, batchIDs[0])
+	assert.NotEqual(t, batchIDs[0], batchIDs[1])
+}
+
+func TestSender_pushError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		http.Error(rw, "quota exceeded", http.StatusTooManyRequests)
+	}))
+	defer ts.Close()
+
+	sender := Sender{
+		Client:   resty.New().SetBaseURL(ts.URL),
+		signer:   NewMetricSigner(""),
+		rejected: new(int64),
+	}
+
+	metrics := []types.Metrics{}
+
+	err := sender.push(&metrics)
+	assert.ErrorContains(t, err, "429")
+	assert.Equal(t, int64(0), sender.Rejected())
+}
+
+func TestSender_pushWithoutPartial(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+		rw.Write([]byte(http.StatusText(http.StatusOK)))
+	}))
+	defer ts.Close()
+
+	sender := Sender{
+		Client:   resty.New().SetBaseURL(ts.URL),
+		signer:   NewMetricSigner(""),
+		rejected: new(int64),
+	}
+
+	value := 1.5
+	metrics := []types.Metrics{{ID: "Alloc", MType: "gauge", Value: &value}}
+
+	// Текстовый ответ сервера без частичной записи означает, что принят весь пакет
+	require.NoError(t, sender.push(&metrics))
+	assert.Equal(t, int64(0), sender.Rejected())
+}
+
+func TestSender_PrepareCumulative(t *testing.T) {
+	sender := Sender{
+		signer: NewMetricSigner(""),
+		totals: newCounterTotals(true),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	countersCh := make(chan types.Counters)
+	metricCh := make(chan types.Metrics)
+
+	sender.Prepare(ctx, make(chan types.Gauges), countersCh, metricCh, make(chan error))
+
+	// Приращения агента отправляются накопленными с запуска значениями
+	for i, delta := range []types.Counter{3, 2, 4} {
+		countersCh <- types.Counters{"PollCount": delta}
+
+		metric := <-metricCh
+		assert.Equal(t, "PollCount", metric.ID)
+		assert.Equal(t, []int64{3, 5, 9}[i], *metric.Delta)
+	}
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"context"
+	"errors"
+
+	"go.uber.org/zap"
+
+	"github.com/vllvll/devops/internal/batch"
+	"github.com/vllvll/devops/internal/dictionaries"
+	"github.com/vllvll/devops/internal/limits"
+	"github.com/vllvll/devops/internal/logger"
+	"github.com/vllvll/devops/internal/repositories"
+	"github.com/vllvll/devops/internal/telemetry"
+	"github.com/vllvll/devops/internal/types"
+)
+
+// ErrReservedName Имя метрики занято метриками самого сервера
+var ErrReservedName = errors.New("metric name prefix " + telemetry.Prefix + " is reserved")
+
+// ErrCounterMode Неизвестный режим записи Counter или режим накопленных значений выключен
+var ErrCounterMode = errors.New("unsupported counter mode, expected " + repositories.CounterModeCumulative)
+
+// IngestBatch Метрики арендатора, которые записываются одним пакетом
+type IngestBatch struct {
+	Tenant      string         // Арендатор
+	ID          string         // Идентификатор пакета (пустой - без защиты от повторов)
+	Agent       string         // Агент для ограничений: токен или IP клиента
+	TotalsAgent string         // Агент, накопленные значения которого сравниваются с предыдущими
+	CounterMode string         // Режим записи Counter (пустой - приращения)
+	Gauges      types.Gauges   // Значения Gauge
+	Counters    types.Counters // Приращения или накопленные значения Counter
+}
+
+type MetricIngest struct {
+	tenants   repositories.TenantRepository       // Сервис для чтения и записи данных метрик арендаторов
+	totals    repositories.TenantTotalsRepository // Накопленные значения счетчиков агентов (nil - режим накопленных значений выключен)
+	limits    *limits.Limits                      // Ограничения арендаторов и агентов (nil - без ограничений)
+	batches   batch.Store                         // Идентификаторы примененных пакетов (nil - без защиты от повторов)
+	telemetry *telemetry.Registry                 // Метрики сервера (nil - не собираются)
+}
+
+type Ingest interface {
+	Save(ctx context.Context, metrics IngestBatch) error
+}
+
+// NewMetricIngest Создание сервиса записи метрик, общего для HTTP и gRPC серверов
+func NewMetricIngest(tenants repositories.TenantRepository, totals repositories.TenantTotalsRepository, limiter *limits.Limits, batches batch.Store, registry *telemetry.Registry) Ingest {
+	return &MetricIngest{
+		tenants:   tenants,
+		totals:    totals,
+		limits:    limiter,
+		batches:   batches,
+		telemetry: registry,
+	}
+}
+
+// Save Сохранение метрик арендатора с проверкой ограничений. Пакет с идентификатором применяется один раз,
+// для повтора возвращается batch.ErrDuplicate, а пока первая попытка не записана - batch.ErrInProgress.
+// В режиме накопленных значений значения Counter заменяются приращениями от предыдущих значений агента
+func (i *MetricIngest) Save(ctx context.Context, metrics IngestBatch) error {
+	cumulative := metrics.CounterMode == repositories.CounterModeCumulative
+	if (metrics.CounterMode != "" && !cumulative) || (cumulative && i.totals == nil) {
+		return ErrCounterMode
+	}
+
+	for key := range metrics.Gauges {
+		if telemetry.IsReserved(key) {
+			return ErrReservedName
+		}
+	}
+
+	for key := range metrics.Counters {
+		if telemetry.IsReserved(key) {
+			return ErrReservedName
+		}
+	}
+
+	repository := i.tenants.Tenant(metrics.Tenant)
+	gauges, counters := metrics.Gauges, metrics.Counters
+
+	if err := batch.Claim(i.batches, metrics.Tenant, metrics.ID); err != nil {
+		return err
+	}
+
+	reservation, err := i.limits.Allow(metrics.Agent, metrics.Tenant, repository, gauges, counters)
+	if err != nil {
+		i.release(ctx, metrics)
+
+		return err
+	}
+
+	// Накопленные значения агента сохраняются только после записи приращений, чтобы повтор после ошибки
+	// вычислил те же приращения
+	var increase repositories.TotalsIncrease
+	if cumulative {
+		increase, err = i.totals.Tenant(metrics.Tenant).Increase(metrics.TotalsAgent, counters)
+		if err != nil {
+			reservation.Release()
+			i.release(ctx, metrics)
+
+			return err
+		}
+
+		counters = increase.Counters()
+	}
+
+	if err := repository.UpdateAll(gauges, counters); err != nil {
+		if increase != nil {
+			increase.Rollback()
+		}
+
+		reservation.Release()
+		i.release(ctx, metrics)
+
+		return err
+	}
+
+	if increase != nil {
+		if err := increase.Commit(); err != nil {
+			logger.FromContext(ctx).Error("commit counter totals", zap.String("agent", metrics.TotalsAgent), zap.Error(err))
+		}
+	}
+
+	if err := batch.Apply(i.batches, metrics.Tenant, metrics.ID); err != nil {
+		logger.FromContext(ctx).Error("apply batch", zap.String("batch_id", metrics.ID), zap.Error(err))
+	}
+
+	i.telemetry.Histogram(telemetry.BatchSize, nil, telemetry.SizeBuckets).Observe(float64(len(gauges) + len(counters)))
+	i.telemetry.Counter(telemetry.IngestedMetrics, map[string]string{"type": dictionaries.GaugeType}).Add(len(gauges))
+	i.telemetry.Counter(telemetry.IngestedMetrics, map[string]string{"type": dictionaries.CounterType}).Add(len(counters))
+
+	return nil
+}
+
+// release Снятие отметки пакета, который не удалось применить, чтобы его можно было отправить повторно
+func (i *MetricIngest) release(ctx context.Context, metrics IngestBatch) {
+	if err := batch.Release(i.batches, metrics.Tenant, metrics.ID); err != nil {
+		logger.FromContext(ctx).Error("release batch", zap.String("batch_id", metrics.ID), zap.Error(err))
+	}
+}
@@ -19,6 +19,8 @@ type Signer interface {
 	GetHashCounter(name string, value int64) string
 	IsEqualHashGauge(name string, value float64, compareSum string) bool
 	IsEqualHashCounter(name string, value int64, compareSum string) bool
+	GetHashContent(content []byte) string
+	IsEqualHashContent(content []byte, compareSum string) bool
 }
 
 // NewMetricSigner Создание сервиса для работы с подписью
@@ -56,6 +58,20 @@ func (s MetricSigner) IsEqualHashCounter(name string, value int64, compareSum st
 	return s.IsEqual(fmt.Sprintf("%s:counter:%d", name, value), s.key, compareSum)
 }
 
+// GetHashContent Получение хеша для тела запроса
+func (s MetricSigner) GetHashContent(content []byte) string {
+	return s.Hash(string(content), s.key)
+}
+
+// IsEqualHashContent Проверка хеша для тела запроса
+func (s MetricSigner) IsEqualHashContent(content []byte, compareSum string) bool {
+	if string(s.key) == "" {
+		return true
+	}
+
+	return s.IsEqual(string(content), s.key, compareSum)
+}
+
 // Hash Создание хеша
 func (s MetricSigner) Hash(content string, key []byte) string {
 	sign := hmac.New(sha256.New, key)
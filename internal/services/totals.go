@@ -0,0 +1,39 @@
+package services
+
+import (
+	"sync"
+
+	"github.com/vllvll/devops/internal/types"
+)
+
+// counterTotals Накопленные с запуска агента значения счетчиков. Используются вместо приращений,
+// чтобы сервер восстанавливал пропущенные при ошибках отправки приращения по следующему значению
+type counterTotals struct {
+	mu     sync.Mutex
+	values types.Counters
+}
+
+// newCounterTotals Создание накопленных значений для режима cumulative. Без режима возвращается nil
+func newCounterTotals(cumulative bool) *counterTotals {
+	if !cumulative {
+		return nil
+	}
+
+	return &counterTotals{
+		values: types.Counters{},
+	}
+}
+
+// add Накопленное значение счетчика key после приращения delta. Для nil возвращается delta
+func (t *counterTotals) add(key string, delta types.Counter) types.Counter {
+	if t == nil {
+		return delta
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.values[key] += delta
+
+	return t.values[key]
+}
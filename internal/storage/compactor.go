@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/vllvll/devops/internal/leader"
+	"github.com/vllvll/devops/internal/repositories"
+)
+
+type compactor struct {
+	tenants repositories.TenantRepository        // Список арендаторов
+	history repositories.TenantHistoryRepository // История значений метрик арендаторов
+	elector *leader.Elector                      // Выбор ведущего: агрегацию выполняет только ведущий (nil - всегда)
+}
+
+// NewCompactor Создание фонового обработчика, который агрегирует историю значений по уровням хранения
+func NewCompactor(tenants repositories.TenantRepository, history repositories.TenantHistoryRepository, elector *leader.Elector) *compactor {
+	return &compactor{
+		tenants: tenants,
+		history: history,
+		elector: elector,
+	}
+}
+
+// Run Агрегация истории каждые interval до отмены ctx. Интервал должен быть больше нуля
+func (c *compactor) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case at := <-ticker.C:
+			if !c.elector.IsLeader() {
+				continue
+			}
+
+			if err := c.Compact(at); err != nil {
+				zap.S().Errorf("Error with history compaction: %v", err)
+			}
+		}
+	}
+}
+
+// Compact Агрегация истории всех арендаторов на момент at
+func (c *compactor) Compact(at time.Time) error {
+	names, err := c.tenants.Tenants()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if err := c.history.Tenant(name).Compact(at); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
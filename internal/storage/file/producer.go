@@ -2,6 +2,7 @@ package file
 
 import (
 	"encoding/json"
+	"io"
 	"os"
 
 	"github.com/vllvll/devops/internal/types"
@@ -14,6 +15,7 @@ type Producer struct {
 
 type ProducerFile interface {
 	WriteMetric(metrics *types.Metrics) error
+	Reset() error
 	Close() error
 }
 
@@ -35,6 +37,17 @@ func (p *Producer) WriteMetric(metrics *types.Metrics) error {
 	return p.encoder.Encode(&metrics)
 }
 
+// Reset Очистка файла перед записью нового снимка метрик
+func (p *Producer) Reset() error {
+	if err := p.file.Truncate(0); err != nil {
+		return err
+	}
+
+	_, err := p.file.Seek(0, io.SeekStart)
+
+	return err
+}
+
 // Close Закрытие файла
 func (p *Producer) Close() error {
 	return p.file.Close()
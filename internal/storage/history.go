@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/vllvll/devops/internal/repositories"
+)
+
+type historyStorage struct {
+	path string // Имя файла с историей
+}
+
+// NewHistoryStorage Создание обработчика для сохранения истории значений метрик в файл и ее восстановления.
+// Пустое имя файла отключает сохранение
+func NewHistoryStorage(path string) *historyStorage {
+	return &historyStorage{
+		path: path,
+	}
+}
+
+// Save Сохранение истории в файл. История в бд не сохраняется
+func (s *historyStorage) Save(history repositories.TenantHistoryRepository) error {
+	return saveJSON(s.path, history)
+}
+
+// Restore Восстановление истории из файла. Отсутствие файла не считается ошибкой
+func (s *historyStorage) Restore(history repositories.TenantHistoryRepository) error {
+	return restoreJSON(s.path, history)
+}
+
+// saveJSON Сохранение value в файл path, если value поддерживает json.Marshaler
+func saveJSON(path string, value interface{}) error {
+	marshaler, ok := value.(json.Marshaler)
+	if path == "" || !ok {
+		return nil
+	}
+
+	data, err := marshaler.MarshalJSON()
+	if err != nil {
+		return err
+	}
+
+	// Запись во временный файл и переименование, чтобы не оставить поврежденный файл при сбое
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// restoreJSON Восстановление value из файла path, если value поддерживает json.Unmarshaler
+func restoreJSON(path string, value interface{}) error {
+	unmarshaler, ok := value.(json.Unmarshaler)
+	if path == "" || !ok {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return unmarshaler.UnmarshalJSON(data)
+}
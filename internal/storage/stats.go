@@ -2,82 +2,124 @@
 package storage
 
 import (
+	"sync"
+	"time"
+
 	conf "github.com/vllvll/devops/internal/config"
 	"github.com/vllvll/devops/internal/dictionaries"
 	"github.com/vllvll/devops/internal/repositories"
 	"github.com/vllvll/devops/internal/storage/file"
+	"github.com/vllvll/devops/internal/telemetry"
 	"github.com/vllvll/devops/internal/types"
 )
 
 type statsStorage struct {
-	config   *conf.ServerConfig
-	consumer file.ConsumerFile
-	producer file.ProducerFile
+	config    *conf.ServerConfig
+	consumer  file.ConsumerFile
+	producer  file.ProducerFile
+	telemetry *telemetry.Registry
+
+	mu    sync.RWMutex
+	saved time.Time // Время последнего сохранения в файл
 }
 
 // NewStatsStorage Создание обработчика для восстановления данных в памяти при инициализации
-func NewStatsStorage(serverConfig *conf.ServerConfig, consumer file.ConsumerFile, producer file.ProducerFile) *statsStorage {
+func NewStatsStorage(serverConfig *conf.ServerConfig, consumer file.ConsumerFile, producer file.ProducerFile, registry *telemetry.Registry) *statsStorage {
 	return &statsStorage{
-		config:   serverConfig,
-		consumer: consumer,
-		producer: producer,
+		config:    serverConfig,
+		consumer:  consumer,
+		producer:  producer,
+		telemetry: registry,
+		saved:     time.Now(),
 	}
 }
 
-// Save Сохранение данных метрик перед отключением приложения
-func (s *statsStorage) Save(statsRepository repositories.StatsRepository) {
+// LastSave Время последнего сохранения метрик в файл (до первого сохранения - время запуска)
+func (s *statsStorage) LastSave() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.saved
+}
+
+// Save Сохранение данных метрик всех арендаторов перед отключением приложения
+func (s *statsStorage) Save(tenants repositories.TenantRepository) error {
 	if s.config.DatabaseDsn == "" {
 		var metrics []types.Metrics
 
-		gauges, counters := statsRepository.GetAll()
-
-		for key, value := range gauges {
-			flValue := float64(value)
+		start := time.Now()
+		defer func() {
+			s.telemetry.Histogram(telemetry.SnapshotDuration, nil, telemetry.DurationBuckets).Observe(time.Since(start).Seconds())
+		}()
 
-			metrics = append(metrics, types.Metrics{
-				ID:    key,
-				MType: dictionaries.GaugeType,
-				Value: &flValue,
-			})
+		names, err := tenants.Tenants()
+		if err != nil {
+			return err
 		}
 
-		for key, value := range counters {
-			iValue := int64(value)
+		for _, name := range names {
+			gauges, counters := tenants.Tenant(name).GetAll()
 
-			metrics = append(metrics, types.Metrics{
-				ID:    key,
-				MType: dictionaries.CounterType,
-				Delta: &iValue,
-			})
+			for key, value := range gauges {
+				flValue := float64(value)
+
+				metrics = append(metrics, types.Metrics{
+					ID:     key,
+					MType:  dictionaries.GaugeType,
+					Value:  &flValue,
+					Tenant: name,
+				})
+			}
+
+			for key, value := range counters {
+				iValue := int64(value)
+
+				metrics = append(metrics, types.Metrics{
+					ID:     key,
+					MType:  dictionaries.CounterType,
+					Delta:  &iValue,
+					Tenant: name,
+				})
+			}
+		}
+
+		if err := s.producer.Reset(); err != nil {
+			return err
 		}
 
 		for _, m := range metrics {
 			err := s.producer.WriteMetric(&m)
 			if err != nil {
-				panic(err)
+				return err
 			}
 		}
+
+		s.mu.Lock()
+		s.saved = time.Now()
+		s.mu.Unlock()
 	}
+
+	return nil
 }
 
-// Start Восстановление метрик перед инициализацией приложения
-func (s *statsStorage) Start(statsRepository repositories.StatsRepository) (repositories.StatsRepository, error) {
+// Start Восстановление метрик арендаторов перед инициализацией приложения
+func (s *statsStorage) Start(tenants repositories.TenantRepository) (repositories.TenantRepository, error) {
 	if s.config.DatabaseDsn == "" && s.config.Restore {
 		for {
 			readMetric, err := s.consumer.ReadMetric()
 			if err != nil {
-				return statsRepository, nil
+				return tenants, nil
 			}
 
 			switch readMetric.MType {
 			case dictionaries.GaugeType:
-				statsRepository.UpdateGauge(readMetric.ID, types.Gauge(*readMetric.Value))
+				tenants.Tenant(readMetric.Tenant).UpdateGauge(readMetric.ID, types.Gauge(*readMetric.Value))
 
 			case dictionaries.CounterType:
-				statsRepository.UpdateCount(readMetric.ID, types.Counter(*readMetric.Delta))
+				tenants.Tenant(readMetric.Tenant).UpdateCount(readMetric.ID, types.Counter(*readMetric.Delta))
 			}
 		}
 	}
 
-	return statsRepository, nil
+	return tenants, nil
 }
@@ -0,0 +1,27 @@
+package storage
+
+import (
+	"github.com/vllvll/devops/internal/repositories"
+)
+
+type totalsStorage struct {
+	path string // Имя файла с накопленными значениями счетчиков
+}
+
+// NewTotalsStorage Создание обработчика для сохранения накопленных значений счетчиков агентов в файл
+// и их восстановления. Пустое имя файла отключает сохранение
+func NewTotalsStorage(path string) *totalsStorage {
+	return &totalsStorage{
+		path: path,
+	}
+}
+
+// Save Сохранение накопленных значений в файл. Значения в бд не сохраняются
+func (s *totalsStorage) Save(totals repositories.TenantTotalsRepository) error {
+	return saveJSON(s.path, totals)
+}
+
+// Restore Восстановление накопленных значений из файла. Отсутствие файла не считается ошибкой
+func (s *totalsStorage) Restore(totals repositories.TenantTotalsRepository) error {
+	return restoreJSON(s.path, totals)
+}
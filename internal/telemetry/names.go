@@ -0,0 +1,14 @@
+package telemetry
+
+// Имена метрик сервера (без префикса Prefix)
+const (
+	HTTPRequestDuration = "http_request_duration_seconds" // Длительность обработки HTTP запросов (route, method, code)
+	GRPCRequestDuration = "grpc_request_duration_seconds" // Длительность обработки gRPC запросов (method, code)
+	RepositoryDuration  = "repository_duration_seconds"   // Длительность операций с хранилищем метрик (operation)
+	SnapshotDuration    = "snapshot_duration_seconds"     // Длительность сохранения метрик в файл
+	IngestedMetrics     = "ingested_metrics"              // Количество принятых значений (type)
+	BatchSize           = "batch_size"                    // Количество значений в одном запросе на запись
+	HashFailures        = "hash_failures"                 // Количество запросов с неверной подписью
+	DecryptFailures     = "decrypt_failures"              // Количество запросов, которые не удалось расшифровать
+	RejectedMetrics     = "rejected_metrics"              // Количество значений, отклоненных ограничениями (reason)
+)
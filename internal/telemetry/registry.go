@@ -0,0 +1,304 @@
+// Package telemetry Функционал для сбора метрик работы самого сервера
+package telemetry
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/vllvll/devops/internal/repositories"
+	"github.com/vllvll/devops/internal/types"
+)
+
+// Prefix Зарезервированный префикс имен метрик сервера. Запись метрик с этим префиксом клиентам запрещена
+const Prefix = "devops_server_"
+
+// Границы интервалов гистограмм
+var (
+	DurationBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	SizeBuckets     = []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 5000}
+)
+
+type Counter struct {
+	value uint64
+}
+
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64 // Верхние границы интервалов
+	counts  []uint64  // Количество значений в каждом интервале
+	sum     float64   // Сумма значений
+	count   uint64    // Количество значений
+}
+
+type Registry struct {
+	mu         sync.Mutex
+	counters   map[string]*Counter   // Счетчики по имени серии
+	histograms map[string]*Histogram // Гистограммы по имени серии
+	exported   map[string]float64    // Значения на момент последней записи в хранилище
+}
+
+// NewRegistry Создание реестра метрик сервера
+func NewRegistry() *Registry {
+	return &Registry{
+		counters:   map[string]*Counter{},
+		histograms: map[string]*Histogram{},
+		exported:   map[string]float64{},
+	}
+}
+
+// IsReserved Проверка, что имя метрики относится к метрикам сервера
+func IsReserved(name string) bool {
+	return strings.HasPrefix(name, Prefix)
+}
+
+// Counter Получение счетчика. Для nil реестра возвращается nil счетчик, запись в который игнорируется
+func (r *Registry) Counter(name string, labels map[string]string) *Counter {
+	if r == nil {
+		return nil
+	}
+
+	key := types.SeriesName(Prefix+name, labels)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	counter, ok := r.counters[key]
+	if !ok {
+		counter = &Counter{}
+		r.counters[key] = counter
+	}
+
+	return counter
+}
+
+// Histogram Получение гистограммы. Для nil реестра возвращается nil гистограмма, запись в которую игнорируется
+func (r *Registry) Histogram(name string, labels map[string]string, buckets []float64) *Histogram {
+	if r == nil {
+		return nil
+	}
+
+	key := types.SeriesName(Prefix+name, labels)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	histogram, ok := r.histograms[key]
+	if !ok {
+		histogram = &Histogram{
+			buckets: buckets,
+			counts:  make([]uint64, len(buckets)),
+		}
+		r.histograms[key] = histogram
+	}
+
+	return histogram
+}
+
+// Add Увеличение счетчика на n
+func (c *Counter) Add(n int) {
+	if c == nil || n <= 0 {
+		return
+	}
+
+	atomic.AddUint64(&c.value, uint64(n))
+}
+
+// Inc Увеличение счетчика на единицу
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Value Текущее значение счетчика
+func (c *Counter) Value() uint64 {
+	if c == nil {
+		return 0
+	}
+
+	return atomic.LoadUint64(&c.value)
+}
+
+// Observe Добавление значения в гистограмму
+func (h *Histogram) Observe(value float64) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, bound := range h.buckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+
+	h.sum += value
+	h.count++
+}
+
+// snapshot Копия значений гистограммы
+func (h *Histogram) snapshot() ([]uint64, float64, uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	counts := make([]uint64, len(h.counts))
+	copy(counts, h.counts)
+
+	return counts, h.sum, h.count
+}
+
+// WritePrometheus Вывод метрик в текстовом формате Prometheus
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	r.mu.Lock()
+	counters := make(map[string]*Counter, len(r.counters))
+	counterKeys := make([]string, 0, len(r.counters))
+	for key, counter := range r.counters {
+		counters[key] = counter
+		counterKeys = append(counterKeys, key)
+	}
+
+	histograms := make(map[string]*Histogram, len(r.histograms))
+	histogramKeys := make([]string, 0, len(r.histograms))
+	for key, histogram := range r.histograms {
+		histograms[key] = histogram
+		histogramKeys = append(histogramKeys, key)
+	}
+	r.mu.Unlock()
+
+	sort.Strings(counterKeys)
+	sort.Strings(histogramKeys)
+
+	var b strings.Builder
+	typed := map[string]bool{}
+
+	for _, key := range counterKeys {
+		name, labels := types.ParseSeriesName(key)
+		if !typed[name] {
+			fmt.Fprintf(&b, "# TYPE %s counter\n", name)
+			typed[name] = true
+		}
+
+		fmt.Fprintf(&b, "%s%s %d\n", name, formatLabels(labels), counters[key].Value())
+	}
+
+	for _, key := range histogramKeys {
+		name, labels := types.ParseSeriesName(key)
+		if !typed[name] {
+			fmt.Fprintf(&b, "# TYPE %s histogram\n", name)
+			typed[name] = true
+		}
+
+		histogram := histograms[key]
+		counts, sum, count := histogram.snapshot()
+
+		for i, bound := range histogram.buckets {
+			fmt.Fprintf(&b, "%s_bucket%s %d\n", name, formatLabels(withLabel(labels, "le", formatFloat(bound))), counts[i])
+		}
+
+		fmt.Fprintf(&b, "%s_bucket%s %d\n", name, formatLabels(withLabel(labels, "le", "+Inf")), count)
+		fmt.Fprintf(&b, "%s_sum%s %s\n", name, formatLabels(labels), formatFloat(sum))
+		fmt.Fprintf(&b, "%s_count%s %d\n", name, formatLabels(labels), count)
+	}
+
+	_, err := io.WriteString(w, b.String())
+
+	return err
+}
+
+// Handler Обработчик для отдачи метрик сервера в формате Prometheus
+func (r *Registry) Handler() http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		rw.WriteHeader(http.StatusOK)
+
+		if err := r.WritePrometheus(rw); err != nil {
+			http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+	}
+}
+
+// Export Запись метрик сервера в хранилище: прирост счетчиков с прошлой записи как Counter,
+// для гистограмм - прирост количества (_count) и среднее значение за период (_avg) как Gauge
+func (r *Registry) Export(repository repositories.StatsRepository) error {
+	gauges := types.Gauges{}
+	counters := types.Counters{}
+
+	r.mu.Lock()
+
+	for key, counter := range r.counters {
+		value := float64(counter.Value())
+		if delta := value - r.exported[key]; delta > 0 {
+			counters[key] = types.Counter(delta)
+		}
+		r.exported[key] = value
+	}
+
+	for key, histogram := range r.histograms {
+		name, labels := types.ParseSeriesName(key)
+		countKey := types.SeriesName(name+"_count", labels)
+		sumKey := types.SeriesName(name+"_sum", labels)
+
+		_, sum, count := histogram.snapshot()
+		deltaCount := float64(count) - r.exported[countKey]
+		deltaSum := sum - r.exported[sumKey]
+
+		if deltaCount > 0 {
+			counters[countKey] = types.Counter(deltaCount)
+			gauges[types.SeriesName(name+"_avg", labels)] = types.Gauge(deltaSum / deltaCount)
+		}
+
+		r.exported[countKey] = float64(count)
+		r.exported[sumKey] = sum
+	}
+	r.mu.Unlock()
+
+	if len(gauges) == 0 && len(counters) == 0 {
+		return nil
+	}
+
+	return repository.UpdateAll(gauges, counters)
+}
+
+func withLabel(labels map[string]string, key string, value string) map[string]string {
+	result := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		result[k] = v
+	}
+	result[key] = value
+
+	return result
+}
+
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+"="+strconv.Quote(labels[key]))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,70 @@
+package telemetry
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vllvll/devops/internal/repositories"
+	"github.com/vllvll/devops/internal/types"
+)
+
+func TestRegistry_WritePrometheus(t *testing.T) {
+	registry := NewRegistry()
+	registry.Counter(IngestedMetrics, map[string]string{"type": "gauge"}).Add(3)
+	registry.Counter(HashFailures, nil).Inc()
+
+	histogram := registry.Histogram(BatchSize, nil, []float64{1, 10})
+	histogram.Observe(1)
+	histogram.Observe(5)
+	histogram.Observe(50)
+
+	var b strings.Builder
+	require.NoError(t, registry.WritePrometheus(&b))
+
+	assert.Equal(t, `# TYPE devops_server_hash_failures counter
+devops_server_hash_failures 1
+# TYPE devops_server_ingested_metrics counter
+devops_server_ingested_metrics{type="gauge"} 3
+# TYPE devops_server_batch_size histogram
+devops_server_batch_size_bucket{le="1"} 1
+devops_server_batch_size_bucket{le="10"} 2
+devops_server_batch_size_bucket{le="+Inf"} 3
+devops_server_batch_size_sum 56
+devops_server_batch_size_count 3
+`, b.String())
+}
+
+func TestRegistry_Export(t *testing.T) {
+	registry := NewRegistry()
+	repository := repositories.NewStatsMemoryRepository()
+
+	counter := registry.Counter(HashFailures, nil)
+	histogram := registry.Histogram(SnapshotDuration, nil, DurationBuckets)
+
+	counter.Add(2)
+	histogram.Observe(0.2)
+	histogram.Observe(0.4)
+	require.NoError(t, registry.Export(repository))
+
+	counter.Add(3)
+	histogram.Observe(1)
+	require.NoError(t, registry.Export(repository))
+
+	gauges, counters := repository.GetAll()
+	assert.Equal(t, types.Counter(5), counters["devops_server_hash_failures"])
+	assert.Equal(t, types.Counter(3), counters["devops_server_snapshot_duration_seconds_count"])
+	assert.Equal(t, types.Gauge(1), gauges["devops_server_snapshot_duration_seconds_avg"])
+}
+
+func TestRegistry_Nil(t *testing.T) {
+	var registry *Registry
+
+	assert.NotPanics(t, func() {
+		registry.Counter(HashFailures, nil).Inc()
+		registry.Histogram(BatchSize, nil, SizeBuckets).Observe(1)
+	})
+	assert.Equal(t, uint64(0), registry.Counter(HashFailures, nil).Value())
+}
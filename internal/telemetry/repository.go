@@ -0,0 +1,121 @@
+package telemetry
+
+import (
+	"time"
+
+	"github.com/vllvll/devops/internal/repositories"
+	"github.com/vllvll/devops/internal/types"
+)
+
+type instrumentedTenants struct {
+	tenants  repositories.TenantRepository
+	registry *Registry
+}
+
+type instrumentedRepository struct {
+	repository repositories.StatsRepository
+	registry   *Registry
+}
+
+// NewTenantRepository Обертка над хранилищем метрик арендаторов, которая измеряет длительность операций
+func NewTenantRepository(tenants repositories.TenantRepository, registry *Registry) repositories.TenantRepository {
+	return &instrumentedTenants{
+		tenants:  tenants,
+		registry: registry,
+	}
+}
+
+// Tenant Получение метрик арендатора
+func (t *instrumentedTenants) Tenant(name string) repositories.StatsRepository {
+	return &instrumentedRepository{
+		repository: t.tenants.Tenant(name),
+		registry:   t.registry,
+	}
+}
+
+// Tenants Получение списка арендаторов
+func (t *instrumentedTenants) Tenants() ([]string, error) {
+	defer t.observe("tenants", time.Now())
+
+	return t.tenants.Tenants()
+}
+
+func (t *instrumentedTenants) observe(operation string, start time.Time) {
+	t.registry.Histogram(RepositoryDuration, map[string]string{"operation": operation}, DurationBuckets).
+		Observe(time.Since(start).Seconds())
+}
+
+// UpdateGauge Обновить значение метрики с типом Gauge
+func (s *instrumentedRepository) UpdateGauge(key string, value types.Gauge) {
+	defer s.observe("update_gauge", time.Now())
+
+	s.repository.UpdateGauge(key, value)
+}
+
+// UpdateCount Обновить значение метрики с типом Counter
+func (s *instrumentedRepository) UpdateCount(key string, value types.Counter) {
+	defer s.observe("update_count", time.Now())
+
+	s.repository.UpdateCount(key, value)
+}
+
+// GetAll Получение всех метрик
+func (s *instrumentedRepository) GetAll() (map[string]types.Gauge, map[string]types.Counter) {
+	defer s.observe("get_all", time.Now())
+
+	return s.repository.GetAll()
+}
+
+// GetGaugeByKey Получить значение метрики типа Gauge по ключу
+func (s *instrumentedRepository) GetGaugeByKey(key string) (types.Gauge, error) {
+	defer s.observe("get_gauge", time.Now())
+
+	return s.repository.GetGaugeByKey(key)
+}
+
+// GetCounterByKey Получить значение метрики типа Counter по ключу
+func (s *instrumentedRepository) GetCounterByKey(key string) (types.Counter, error) {
+	defer s.observe("get_counter", time.Now())
+
+	return s.repository.GetCounterByKey(key)
+}
+
+// UpdateAll Обновление всех значений типов Gauge и Counter
+func (s *instrumentedRepository) UpdateAll(gauges types.Gauges, counters types.Counters) error {
+	defer s.observe("update_all", time.Now())
+
+	return s.repository.UpdateAll(gauges, counters)
+}
+
+// ReplaceAll Запись значений Gauge и замена значений Counter
+func (s *instrumentedRepository) ReplaceAll(gauges types.Gauges, counters types.Counters) error {
+	defer s.observe("replace_all", time.Now())
+
+	return s.repository.ReplaceAll(gauges, counters)
+}
+
+// DeleteGauge Удаление метрики типа Gauge
+func (s *instrumentedRepository) DeleteGauge(key string) error {
+	defer s.observe("delete_gauge", time.Now())
+
+	return s.repository.DeleteGauge(key)
+}
+
+// DeleteCounter Удаление метрики типа Counter
+func (s *instrumentedRepository) DeleteCounter(key string) error {
+	defer s.observe("delete_counter", time.Now())
+
+	return s.repository.DeleteCounter(key)
+}
+
+// ResetCounter Сброс значения метрики типа Counter
+func (s *instrumentedRepository) ResetCounter(key string) error {
+	defer s.observe("reset_counter", time.Now())
+
+	return s.repository.ResetCounter(key)
+}
+
+func (s *instrumentedRepository) observe(operation string, start time.Time) {
+	s.registry.Histogram(RepositoryDuration, map[string]string{"operation": operation}, DurationBuckets).
+		Observe(time.Since(start).Seconds())
+}
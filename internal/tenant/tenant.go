@@ -0,0 +1,92 @@
+// Package tenant Функционал для определения арендатора (команды), которому принадлежат метрики
+package tenant
+
+import (
+	"context"
+	"errors"
+	"regexp"
+
+	"github.com/vllvll/devops/internal/auth"
+)
+
+// Источники арендатора в запросе
+const (
+	Header      = "X-Tenant" // HTTP заголовок
+	MetadataKey = "tenant"   // Ключ метаданных gRPC
+	Default     = ""         // Арендатор по умолчанию
+)
+
+var (
+	ErrInvalidTenant  = errors.New("invalid tenant")                         // Недопустимое имя арендатора
+	ErrTenantMismatch = errors.New("tenant is not allowed for access token") // Арендатор не совпадает с арендатором токена или недоступен запросу
+)
+
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{0,64}$`)
+
+type contextKey struct{}
+
+type overrideKey struct{}
+
+// Validate Проверка имени арендатора
+func Validate(name string) error {
+	if !namePattern.MatchString(name) {
+		return ErrInvalidTenant
+	}
+
+	return nil
+}
+
+// Resolve Определение арендатора по токену и переданному значению. Арендатор токена имеет приоритет.
+// Выбрать любого арендатора может только токен с правом auth.ScopeAdmin или запрос с проверенным ключом
+// администратора (WithOverride). Остальные запросы без привязанного арендатора работают только с Default
+func Resolve(ctx context.Context, requested string) (string, error) {
+	if err := Validate(requested); err != nil {
+		return "", err
+	}
+
+	token, ok := auth.FromContext(ctx)
+	if ok && token.Tenant != "" {
+		if requested != "" && requested != token.Tenant {
+			return "", ErrTenantMismatch
+		}
+
+		return token.Tenant, nil
+	}
+
+	if (ok && token.HasScope(auth.ScopeAdmin)) || overridden(ctx) {
+		return requested, nil
+	}
+
+	if requested != Default {
+		return "", ErrTenantMismatch
+	}
+
+	return Default, nil
+}
+
+// WithOverride Разрешение выбора арендатора без токена, например после проверки ключа администратора
+func WithOverride(ctx context.Context) context.Context {
+	return context.WithValue(ctx, overrideKey{}, true)
+}
+
+// overridden Проверка, что выбор арендатора разрешен через WithOverride
+func overridden(ctx context.Context) bool {
+	allowed, _ := ctx.Value(overrideKey{}).(bool)
+
+	return allowed
+}
+
+// NewContext Сохранение арендатора в контексте запроса
+func NewContext(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, contextKey{}, name)
+}
+
+// FromContext Получение арендатора из контекста запроса. Без арендатора возвращается Default
+func FromContext(ctx context.Context) string {
+	name, ok := ctx.Value(contextKey{}).(string)
+	if !ok {
+		return Default
+	}
+
+	return name
+}
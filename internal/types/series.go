@@ -0,0 +1,54 @@
+package types
+
+import (
+	"sort"
+	"strings"
+)
+
+// Разделители имени серии в формате name;label=value;label=value
+const (
+	labelSeparator      = ";"
+	labelValueSeparator = "="
+)
+
+// SeriesName Формирование имени серии из имени метрики и меток. Метки сортируются по ключу
+func SeriesName(name string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return name
+	}
+
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(name)
+
+	for _, key := range keys {
+		b.WriteString(labelSeparator)
+		b.WriteString(key)
+		b.WriteString(labelValueSeparator)
+		b.WriteString(labels[key])
+	}
+
+	return b.String()
+}
+
+// ParseSeriesName Получение имени метрики и меток из имени серии
+func ParseSeriesName(series string) (string, map[string]string) {
+	parts := strings.Split(series, labelSeparator)
+	labels := make(map[string]string, len(parts)-1)
+
+	for _, part := range parts[1:] {
+		pair := strings.SplitN(part, labelValueSeparator, 2)
+		if len(pair) != 2 {
+			continue
+		}
+
+		labels[pair[0]] = pair[1]
+	}
+
+	return parts[0], labels
+}
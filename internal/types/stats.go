@@ -61,9 +61,10 @@ type Gauges map[string]Gauge
 
 // Metrics тип метрики
 type Metrics struct {
-	ID    string   `json:"id"`              // Имя метрики
-	MType string   `json:"type"`            // Параметр, принимающий значение gauge или counter
-	Delta *int64   `json:"delta,omitempty"` // Значение метрики в случае передачи counter
-	Value *float64 `json:"value,omitempty"` // Значение метрики в случае передачи gauge
-	Hash  string   `json:"hash,omitempty"`  // Значение хеш-функции
+	ID     string   `json:"id"`               // Имя метрики
+	MType  string   `json:"type"`             // Параметр, принимающий значение gauge или counter
+	Delta  *int64   `json:"delta,omitempty"`  // Значение метрики в случае передачи counter
+	Value  *float64 `json:"value,omitempty"`  // Значение метрики в случае передачи gauge
+	Hash   string   `json:"hash,omitempty"`   // Значение хеш-функции
+	Tenant string   `json:"tenant,omitempty"` // Арендатор (используется при сохранении в файл)
 }
@@ -0,0 +1,24 @@
+// Package urlparam Функционал для чтения параметров из адреса HTTP-запроса
+package urlparam
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Key Ключ серии из адреса запроса. Метки серии (name;key=value) могут содержать экранированные символы
+func Key(r *http.Request) string {
+	key := chi.URLParam(r, "key")
+	if r.URL.RawPath == "" {
+		return key
+	}
+
+	unescaped, err := url.PathUnescape(key)
+	if err != nil {
+		return key
+	}
+
+	return unescaped
+}
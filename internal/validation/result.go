@@ -0,0 +1,75 @@
+package validation
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/vllvll/devops/internal/types"
+)
+
+// PartialQuery Параметр запроса, включающий частичную запись пакета: допустимые метрики сохраняются,
+// недопустимые возвращаются в списке отклоненных
+const PartialQuery = "partial"
+
+// ReasonInvalid Причина отказа в метрике сервера telemetry.RejectedMetrics для метрик, отклоненных при частичной записи
+const ReasonInvalid = "invalid"
+
+// Result Результат частичной записи пакета метрик
+type Result struct {
+	Accepted  int         `json:"accepted"`            // Количество сохраненных метрик
+	Rejected  []Violation `json:"rejected"`            // Нарушения отклоненных метрик, по возрастанию номера
+	Duplicate bool        `json:"duplicate,omitempty"` // Пакет уже был применен ранее и повторно не применялся
+
+	rejected map[int]bool // Номера отклоненных метрик
+}
+
+// Partial Проверка пакета для частичной записи. Метрики с нарушениями отмечаются в результате отклоненными.
+// Ошибка возвращается, только если отклонить нужно весь пакет
+func (r *Rules) Partial(metrics []types.Metrics) (*Result, error) {
+	result := &Result{Rejected: []Violation{}}
+
+	err := r.Batch(metrics)
+
+	var validationErr *Error
+	if errors.As(err, &validationErr) && !validationErr.TooLarge {
+		for _, violation := range validationErr.Violations {
+			result.Reject(violation.Index, violation.ID, violation.Field, violation.Reason)
+		}
+
+		return result, nil
+	}
+
+	return result, err
+}
+
+// Reject Отметка метрики с номером index отклоненной
+func (r *Result) Reject(index int, id string, field string, reason string) {
+	if r.rejected == nil {
+		r.rejected = make(map[int]bool)
+	}
+
+	r.rejected[index] = true
+
+	// Нарушения остаются упорядоченными по номеру метрики, нарушения одной метрики - по порядку добавления
+	position := sort.Search(len(r.Rejected), func(i int) bool {
+		return r.Rejected[i].Index > index
+	})
+
+	r.Rejected = append(r.Rejected, Violation{})
+	copy(r.Rejected[position+1:], r.Rejected[position:])
+	r.Rejected[position] = Violation{Index: index, ID: id, Field: field, Reason: reason}
+}
+
+// Rejects Метрика с номером index отклонена
+func (r *Result) Rejects(index int) bool {
+	return r.rejected[index]
+}
+
+// RejectedCount Количество отклоненных метрик
+func (r *Result) RejectedCount() int {
+	if r.rejected != nil {
+		return len(r.rejected)
+	}
+
+	return countMetrics(r.Rejected)
+}
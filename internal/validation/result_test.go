@@ -0,0 +1,44 @@
+package validation
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vllvll/devops/internal/types"
+)
+
+func TestRules_Partial(t *testing.T) {
+	value := 1.5
+
+	metrics := []types.Metrics{
+		{ID: "Alloc", MType: "gauge", Value: &value},
+		{ID: "Other", MType: "gauge"},
+		{ID: "PollCount", MType: "counter", Value: &value},
+		{ID: "HeapAlloc", MType: "gauge", Value: &value},
+	}
+
+	result, err := NewRules(0, 0, false).Partial(metrics)
+	require.NoError(t, err)
+	assert.True(t, result.Rejects(1))
+	assert.True(t, result.Rejects(2))
+	assert.False(t, result.Rejects(3))
+
+	result.Reject(0, "Alloc", FieldHash, "hash does not match")
+	result.Reject(3, "HeapAlloc", FieldHash, "hash does not match")
+
+	indexes := make([]int, 0, len(result.Rejected))
+	for _, violation := range result.Rejected {
+		indexes = append(indexes, violation.Index)
+	}
+
+	assert.Equal(t, []int{0, 1, 2, 2, 3}, indexes)
+	assert.Equal(t, 4, result.RejectedCount())
+
+	_, err = NewRules(0, 3, false).Partial(metrics)
+
+	var validationErr *Error
+	require.ErrorAs(t, err, &validationErr)
+	assert.True(t, validationErr.TooLarge)
+}
@@ -0,0 +1,222 @@
+// Package validation Проверка метрик, принимаемых сервером: имя, соответствие полей типу, значения и размер пакета
+package validation
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	conf "github.com/vllvll/devops/internal/config"
+	"github.com/vllvll/devops/internal/dictionaries"
+	"github.com/vllvll/devops/internal/types"
+)
+
+// Ограничения по умолчанию
+const (
+	DefaultMaxNameLength = 255   // Максимальная длина имени серии в байтах
+	DefaultMaxBatchSize  = 10000 // Максимальное количество метрик в одном запросе
+)
+
+// Поля метрики в описании нарушений
+const (
+	FieldID    = "id"
+	FieldType  = "type"
+	FieldValue = "value"
+	FieldDelta = "delta"
+	FieldHash  = "hash"
+)
+
+// namePattern Допустимые символы имени метрики и ключей меток
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9_.:-]+$`)
+
+// Violation Нарушение правил одной метрикой пакета
+type Violation struct {
+	Index  int    `json:"index"`        // Номер метрики в пакете
+	ID     string `json:"id,omitempty"` // Имя метрики
+	Field  string `json:"field"`        // Поле метрики
+	Reason string `json:"reason"`       // Описание нарушения
+}
+
+// Error Ошибка проверки пакета метрик
+type Error struct {
+	Detail     string      // Описание ошибки
+	Violations []Violation // Нарушения отдельных метрик (пустой - ошибка относится ко всему пакету)
+	TooLarge   bool        // Превышен размер пакета
+}
+
+func (e *Error) Error() string {
+	if len(e.Violations) == 0 {
+		return e.Detail
+	}
+
+	reasons := make([]string, 0, len(e.Violations))
+	for _, violation := range e.Violations {
+		reasons = append(reasons, fmt.Sprintf("[%d].%s: %s", violation.Index, violation.Field, violation.Reason))
+	}
+
+	return e.Detail + ": " + strings.Join(reasons, "; ")
+}
+
+// Rules Правила проверки метрик. Для nil применяются правила по умолчанию
+type Rules struct {
+	maxNameLength  int  // Максимальная длина имени серии в байтах
+	maxBatchSize   int  // Максимальное количество метрик в одном запросе
+	allowNonFinite bool // Разрешены значения NaN и ±Inf для Gauge
+}
+
+// NewRules Создание правил проверки. Значения меньше или равные нулю заменяются значениями по умолчанию
+func NewRules(maxNameLength int, maxBatchSize int, allowNonFinite bool) *Rules {
+	if maxNameLength <= 0 {
+		maxNameLength = DefaultMaxNameLength
+	}
+
+	if maxBatchSize <= 0 {
+		maxBatchSize = DefaultMaxBatchSize
+	}
+
+	return &Rules{
+		maxNameLength:  maxNameLength,
+		maxBatchSize:   maxBatchSize,
+		allowNonFinite: allowNonFinite,
+	}
+}
+
+// NewFromConfig Создание правил проверки из конфигурации сервера
+func NewFromConfig(config *conf.ServerConfig) *Rules {
+	return NewRules(config.MaxNameLength, config.MaxBatchSize, config.AllowNonFinite)
+}
+
+// Metric Проверка одной метрики
+func (r *Rules) Metric(metric types.Metrics) error {
+	if violations := r.violations(0, metric); len(violations) > 0 {
+		return &Error{Detail: "invalid metric", Violations: violations}
+	}
+
+	return nil
+}
+
+// Batch Проверка пакета метрик. Возвращает все нарушения с номерами метрик в пакете
+func (r *Rules) Batch(metrics []types.Metrics) error {
+	if r == nil {
+		r = NewRules(0, 0, false)
+	}
+
+	if len(metrics) > r.maxBatchSize {
+		return &Error{
+			Detail:   fmt.Sprintf("batch of %d metrics exceeds the limit of %d", len(metrics), r.maxBatchSize),
+			TooLarge: true,
+		}
+	}
+
+	var violations []Violation
+	for i, metric := range metrics {
+		violations = append(violations, r.violations(i, metric)...)
+	}
+
+	if len(violations) > 0 {
+		return &Error{Detail: fmt.Sprintf("%d of %d metrics are invalid", countMetrics(violations), len(metrics)), Violations: violations}
+	}
+
+	return nil
+}
+
+// violations Нарушения правил метрикой с номером index
+func (r *Rules) violations(index int, metric types.Metrics) []Violation {
+	if r == nil {
+		r = NewRules(0, 0, false)
+	}
+
+	var violations []Violation
+
+	violation := func(field string, reason string) {
+		violations = append(violations, Violation{Index: index, ID: metric.ID, Field: field, Reason: reason})
+	}
+
+	if reason := r.nameReason(metric.ID); reason != "" {
+		violation(FieldID, reason)
+	}
+
+	switch metric.MType {
+	case dictionaries.GaugeType:
+		switch {
+		case metric.Value == nil:
+			violation(FieldValue, "value is required for gauge")
+		case !r.allowNonFinite && (math.IsNaN(*metric.Value) || math.IsInf(*metric.Value, 0)):
+			violation(FieldValue, "NaN and Inf values are not allowed")
+		}
+
+		if metric.Delta != nil {
+			violation(FieldDelta, "delta is not allowed for gauge")
+		}
+	case dictionaries.CounterType:
+		if metric.Delta == nil {
+			violation(FieldDelta, "delta is required for counter")
+		}
+
+		if metric.Value != nil {
+			violation(FieldValue, "value is not allowed for counter")
+		}
+	case "":
+		violation(FieldType, "type is required")
+	default:
+		violation(FieldType, fmt.Sprintf("unknown type %q, expected %s or %s", metric.MType, dictionaries.GaugeType, dictionaries.CounterType))
+	}
+
+	return violations
+}
+
+// nameReason Описание нарушения правил для имени серии. Пустая строка - имя допустимо
+func (r *Rules) nameReason(series string) string {
+	if series == "" {
+		return "id is required"
+	}
+
+	if len(series) > r.maxNameLength {
+		return fmt.Sprintf("id is longer than %d bytes", r.maxNameLength)
+	}
+
+	if !utf8.ValidString(series) {
+		return "id is not valid UTF-8"
+	}
+
+	name, labels := types.ParseSeriesName(series)
+	if !namePattern.MatchString(name) {
+		return "name may only contain letters, digits and _.:-"
+	}
+
+	if strings.Count(series, ";") != len(labels) {
+		return "labels must have the form ;key=value"
+	}
+
+	for key, value := range labels {
+		if !namePattern.MatchString(key) {
+			return "label keys may only contain letters, digits and _.:-"
+		}
+
+		for _, char := range value {
+			if unicode.IsControl(char) {
+				return "label values may not contain control characters"
+			}
+		}
+	}
+
+	return ""
+}
+
+// countMetrics Количество метрик с нарушениями
+func countMetrics(violations []Violation) int {
+	var count int
+
+	last := -1
+	for _, violation := range violations {
+		if violation.Index != last {
+			count++
+			last = violation.Index
+		}
+	}
+
+	return count
+}
@@ -0,0 +1,88 @@
+package validation
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vllvll/devops/internal/types"
+)
+
+func TestRules_Metric(t *testing.T) {
+	value := 1.5
+	nan := math.NaN()
+	inf := math.Inf(1)
+	delta := int64(1)
+
+	tests := []struct {
+		name   string
+		rules  *Rules
+		metric types.Metrics
+		field  string
+	}{
+		{name: "gauge", metric: types.Metrics{ID: "Alloc", MType: "gauge", Value: &value}},
+		{name: "counter with labels", metric: types.Metrics{ID: "Requests;path=/api v1", MType: "counter", Delta: &delta}},
+		{name: "empty id", metric: types.Metrics{MType: "gauge", Value: &value}, field: FieldID},
+		{name: "long id", rules: NewRules(5, 0, false), metric: types.Metrics{ID: "Alloc1", MType: "gauge", Value: &value}, field: FieldID},
+		{name: "id charset", metric: types.Metrics{ID: "Alloc bytes", MType: "gauge", Value: &value}, field: FieldID},
+		{name: "label without value", metric: types.Metrics{ID: "Requests;path", MType: "counter", Delta: &delta}, field: FieldID},
+		{name: "label control character", metric: types.Metrics{ID: "Requests;path=/\n", MType: "counter", Delta: &delta}, field: FieldID},
+		{name: "missing type", metric: types.Metrics{ID: "Alloc", Value: &value}, field: FieldType},
+		{name: "unknown type", metric: types.Metrics{ID: "Alloc", MType: "histogram", Value: &value}, field: FieldType},
+		{name: "gauge without value", metric: types.Metrics{ID: "Alloc", MType: "gauge"}, field: FieldValue},
+		{name: "gauge with delta", metric: types.Metrics{ID: "Alloc", MType: "gauge", Value: &value, Delta: &delta}, field: FieldDelta},
+		{name: "counter without delta", metric: types.Metrics{ID: "PollCount", MType: "counter"}, field: FieldDelta},
+		{name: "counter with value", metric: types.Metrics{ID: "PollCount", MType: "counter", Delta: &delta, Value: &value}, field: FieldValue},
+		{name: "NaN", metric: types.Metrics{ID: "Alloc", MType: "gauge", Value: &nan}, field: FieldValue},
+		{name: "Inf", metric: types.Metrics{ID: "Alloc", MType: "gauge", Value: &inf}, field: FieldValue},
+		{name: "Inf allowed", rules: NewRules(0, 0, true), metric: types.Metrics{ID: "Alloc", MType: "gauge", Value: &inf}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.rules.Metric(tt.metric)
+			if tt.field == "" {
+				assert.NoError(t, err)
+
+				return
+			}
+
+			var validationErr *Error
+			require.ErrorAs(t, err, &validationErr)
+			require.Len(t, validationErr.Violations, 1)
+			assert.Equal(t, tt.field, validationErr.Violations[0].Field)
+		})
+	}
+}
+
+func TestRules_Batch(t *testing.T) {
+	value := 1.5
+
+	metrics := []types.Metrics{
+		{ID: "Alloc", MType: "gauge", Value: &value},
+		{ID: "", MType: "gauge"},
+		{ID: "PollCount", MType: "counter", Value: &value},
+	}
+
+	err := NewRules(0, 0, false).Batch(metrics)
+
+	var validationErr *Error
+	require.ErrorAs(t, err, &validationErr)
+	assert.Equal(t, "2 of 3 metrics are invalid", validationErr.Detail)
+	assert.False(t, validationErr.TooLarge)
+	assert.Equal(t, []Violation{
+		{Index: 1, Field: FieldID, Reason: "id is required"},
+		{Index: 1, Field: FieldValue, Reason: "value is required for gauge"},
+		{Index: 2, ID: "PollCount", Field: FieldDelta, Reason: "delta is required for counter"},
+		{Index: 2, ID: "PollCount", Field: FieldValue, Reason: "value is not allowed for counter"},
+	}, validationErr.Violations)
+	assert.True(t, strings.HasPrefix(err.Error(), "2 of 3 metrics are invalid: [1].id: id is required"))
+
+	err = NewRules(0, 2, false).Batch(metrics)
+	require.ErrorAs(t, err, &validationErr)
+	assert.True(t, validationErr.TooLarge)
+	assert.Empty(t, validationErr.Violations)
+}
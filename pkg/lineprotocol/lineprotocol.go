@@ -0,0 +1,230 @@
+// Package lineprotocol Разбор данных в формате InfluxDB line protocol
+package lineprotocol
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Field Поле точки. Value содержит значение типа float64, int64, uint64, bool или string
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Point Точка: measurement[,tag=value...] field=value[,field=value...] [timestamp]
+type Point struct {
+	Measurement string            // Имя измерения
+	Tags        map[string]string // Теги
+	Fields      []Field           // Поля
+	Timestamp   time.Time         // Время точки (нулевое, если не передано)
+}
+
+// Множители для перевода timestamp в наносекунды
+var precisions = map[string]int64{
+	"":   1,
+	"n":  1,
+	"ns": 1,
+	"u":  int64(time.Microsecond),
+	"us": int64(time.Microsecond),
+	"ms": int64(time.Millisecond),
+	"s":  int64(time.Second),
+}
+
+// Parse Разбор всех строк. precision задает единицы timestamp (ns, us, ms, s)
+func Parse(data []byte, precision string) ([]Point, error) {
+	multiplier, ok := precisions[precision]
+	if !ok {
+		return nil, fmt.Errorf("unsupported precision %q", precision)
+	}
+
+	var points []Point
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		point, err := ParseLine(line, multiplier)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+
+		points = append(points, point)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return points, nil
+}
+
+// ParseLine Разбор одной строки. multiplier переводит timestamp в наносекунды
+func ParseLine(line string, multiplier int64) (Point, error) {
+	point := Point{Tags: map[string]string{}}
+
+	sections, err := splitUnescaped(line, ' ', true)
+	if err != nil {
+		return point, err
+	}
+
+	if len(sections) < 2 || len(sections) > 3 {
+		return point, fmt.Errorf("expected measurement, fields and optional timestamp")
+	}
+
+	series, err := splitUnescaped(sections[0], ',', false)
+	if err != nil {
+		return point, err
+	}
+
+	point.Measurement = unescape(series[0])
+	if point.Measurement == "" {
+		return point, fmt.Errorf("missing measurement")
+	}
+
+	for _, tag := range series[1:] {
+		key, value, err := splitPair(tag)
+		if err != nil {
+			return point, fmt.Errorf("tag %q: %w", tag, err)
+		}
+
+		point.Tags[key] = unescape(value)
+	}
+
+	fields, err := splitUnescaped(sections[1], ',', true)
+	if err != nil {
+		return point, err
+	}
+
+	for _, field := range fields {
+		key, raw, err := splitPair(field)
+		if err != nil {
+			return point, fmt.Errorf("field %q: %w", field, err)
+		}
+
+		value, err := parseFieldValue(raw)
+		if err != nil {
+			return point, fmt.Errorf("field %q: %w", key, err)
+		}
+
+		point.Fields = append(point.Fields, Field{Key: key, Value: value})
+	}
+
+	if len(sections) == 3 {
+		timestamp, err := strconv.ParseInt(sections[2], 10, 64)
+		if err != nil {
+			return point, fmt.Errorf("invalid timestamp %q", sections[2])
+		}
+
+		point.Timestamp = time.Unix(0, timestamp*multiplier)
+	}
+
+	return point, nil
+}
+
+// splitUnescaped Разбиение строки по разделителю с учетом экранирования и строк в кавычках
+func splitUnescaped(s string, sep byte, quotes bool) ([]string, error) {
+	var parts []string
+	var inQuotes bool
+
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case quotes && s[i] == '"':
+			inQuotes = !inQuotes
+		case s[i] == sep && !inQuotes:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+
+	if inQuotes {
+		return nil, fmt.Errorf("unterminated string")
+	}
+
+	return append(parts, s[start:]), nil
+}
+
+// splitPair Разбор пары key=value
+func splitPair(s string) (string, string, error) {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '=':
+			key := unescape(s[:i])
+			if key == "" || i == len(s)-1 {
+				return "", "", fmt.Errorf("expected key=value")
+			}
+
+			return key, s[i+1:], nil
+		}
+	}
+
+	return "", "", fmt.Errorf("expected key=value")
+}
+
+// parseFieldValue Разбор значения поля: 1.5, 1i, 1u, true, "string"
+func parseFieldValue(raw string) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(raw, `"`):
+		if len(raw) < 2 || !strings.HasSuffix(raw, `"`) {
+			return nil, fmt.Errorf("invalid string value")
+		}
+
+		return strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(raw[1 : len(raw)-1]), nil
+
+	case strings.HasSuffix(raw, "i"):
+		return strconv.ParseInt(raw[:len(raw)-1], 10, 64)
+
+	case strings.HasSuffix(raw, "u"):
+		return strconv.ParseUint(raw[:len(raw)-1], 10, 64)
+	}
+
+	switch raw {
+	case "t", "T", "true", "True", "TRUE":
+		return true, nil
+	case "f", "F", "false", "False", "FALSE":
+		return false, nil
+	}
+
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil, fmt.Errorf("invalid value %q", raw)
+	}
+
+	return value, nil
+}
+
+// unescape Удаление экранирования
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+
+		b.WriteByte(s[i])
+	}
+
+	return b.String()
+}
@@ -0,0 +1,82 @@
+package lineprotocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		precision string
+		want      []Point
+		wantErr   bool
+	}{
+		{
+			name: "full line",
+			data: "cpu,host=server01,region=us\\ west usage=0.64,count=3i,total=5u,up=t,msg=\"a \\\"b\\\"\" 1465839830100400200",
+			want: []Point{{
+				Measurement: "cpu",
+				Tags:        map[string]string{"host": "server01", "region": "us west"},
+				Fields: []Field{
+					{Key: "usage", Value: 0.64},
+					{Key: "count", Value: int64(3)},
+					{Key: "total", Value: uint64(5)},
+					{Key: "up", Value: true},
+					{Key: "msg", Value: `a "b"`},
+				},
+				Timestamp: time.Unix(0, 1465839830100400200),
+			}},
+		},
+		{
+			name:      "seconds precision and comments",
+			data:      "# comment\n\nmem free=1 1465839830",
+			precision: "s",
+			want: []Point{{
+				Measurement: "mem",
+				Tags:        map[string]string{},
+				Fields:      []Field{{Key: "free", Value: float64(1)}},
+				Timestamp:   time.Unix(1465839830, 0),
+			}},
+		},
+		{
+			name:    "missing fields",
+			data:    "cpu",
+			wantErr: true,
+		},
+		{
+			name:    "bad timestamp",
+			data:    "cpu usage=1 abc",
+			wantErr: true,
+		},
+		{
+			name:    "unterminated string",
+			data:    `cpu msg="abc`,
+			wantErr: true,
+		},
+		{
+			name:      "unknown precision",
+			data:      "cpu usage=1",
+			precision: "h",
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			points, err := Parse([]byte(tt.data), tt.precision)
+			if tt.wantErr {
+				assert.Error(t, err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, points)
+		})
+	}
+}
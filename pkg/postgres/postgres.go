@@ -29,8 +29,12 @@ func ConnectDatabase(dsn string) (*sql.DB, error) {
 		CREATE UNIQUE INDEX IF NOT EXISTS counters_id_uindex
 			ON counters (id);
 	
-		CREATE UNIQUE INDEX IF NOT EXISTS counters_name_uindex
-			ON counters (name);
+		ALTER TABLE counters ADD COLUMN IF NOT EXISTS tenant text NOT NULL DEFAULT '';
+
+		DROP INDEX IF EXISTS counters_name_uindex;
+
+		CREATE UNIQUE INDEX IF NOT EXISTS counters_tenant_name_uindex
+			ON counters (tenant, name);
 	
 		CREATE TABLE IF NOT EXISTS gauges
 		(
@@ -44,8 +48,91 @@ func ConnectDatabase(dsn string) (*sql.DB, error) {
 		CREATE UNIQUE INDEX IF NOT EXISTS gauges_id_uindex
 			ON gauges (id);
 	
-		create unique index if not exists gauges_name_uindex
-			ON gauges (name);
+		ALTER TABLE gauges ADD COLUMN IF NOT EXISTS tenant text NOT NULL DEFAULT '';
+
+		DROP INDEX IF EXISTS gauges_name_uindex;
+
+		CREATE UNIQUE INDEX IF NOT EXISTS gauges_tenant_name_uindex
+			ON gauges (tenant, name);
+
+		CREATE TABLE IF NOT EXISTS tokens
+		(
+			id         text        NOT NULL
+				CONSTRAINT tokens_pk
+					PRIMARY KEY,
+			hash       text        NOT NULL,
+			name       text        NOT NULL DEFAULT '',
+			scopes     text        NOT NULL,
+			prefix     text        NOT NULL DEFAULT '',
+			created_at timestamptz NOT NULL DEFAULT now(),
+			revoked_at timestamptz
+		);
+
+		CREATE UNIQUE INDEX IF NOT EXISTS tokens_hash_uindex
+			ON tokens (hash);
+
+		ALTER TABLE tokens ADD COLUMN IF NOT EXISTS tenant text NOT NULL DEFAULT '';
+
+		CREATE TABLE IF NOT EXISTS history
+		(
+			tenant     text             NOT NULL DEFAULT '',
+			mtype      text             NOT NULL,
+			name       text             NOT NULL,
+			resolution bigint           NOT NULL,
+			ts         timestamptz      NOT NULL,
+			value      double precision NOT NULL,
+			min        double precision NOT NULL,
+			max        double precision NOT NULL,
+			avg        double precision NOT NULL,
+			last       double precision NOT NULL,
+			sum        double precision NOT NULL DEFAULT 0,
+			count      bigint           NOT NULL DEFAULT 1,
+			CONSTRAINT history_pk
+				PRIMARY KEY (tenant, mtype, name, resolution, ts)
+		);
+
+		CREATE TABLE IF NOT EXISTS batches
+		(
+			tenant     text        NOT NULL DEFAULT '',
+			id         text        NOT NULL,
+			claimed_at timestamptz NOT NULL,
+			CONSTRAINT batches_pk
+				PRIMARY KEY (tenant, id)
+		);
+
+		ALTER TABLE batches ADD COLUMN IF NOT EXISTS applied boolean NOT NULL DEFAULT true;
+
+		CREATE TABLE IF NOT EXISTS counter_totals
+		(
+			tenant     text        NOT NULL DEFAULT '',
+			agent      text        NOT NULL,
+			name       text        NOT NULL,
+			value      bigint      NOT NULL,
+			updated_at timestamptz NOT NULL,
+			CONSTRAINT counter_totals_pk
+				PRIMARY KEY (tenant, agent, name)
+		);
+
+		CREATE TABLE IF NOT EXISTS federation_batches
+		(
+			seq     bigserial NOT NULL
+				CONSTRAINT federation_batches_pk
+					PRIMARY KEY,
+			id      text      NOT NULL,
+			tenant  text      NOT NULL DEFAULT '',
+			metrics jsonb     NOT NULL
+		);
+
+		CREATE UNIQUE INDEX IF NOT EXISTS federation_batches_id_uindex
+			ON federation_batches (id);
+
+		CREATE TABLE IF NOT EXISTS federation_sent
+		(
+			id   smallint NOT NULL
+				CONSTRAINT federation_sent_pk
+					PRIMARY KEY,
+			sent jsonb    NOT NULL
+		);
 	`)
 
 	if err != nil {